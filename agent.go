@@ -0,0 +1,428 @@
+package pgtool
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The coordinator and its agents speak JSON over HTTP, authenticated with
+// a shared bearer token. Agents connect out to the coordinator, so database
+// hosts need no open port: each long-polls for its next job and posts the
+// results when the job is done.
+const (
+	agentPollTimeout = 30 * time.Second
+	// An agent not heard from for this long is considered disconnected,
+	// and its scheduled jobs fail instead of waiting for it.
+	agentStaleAfter = 3 * agentPollTimeout
+)
+
+// AgentJob is a backup run the coordinator hands to an agent.
+type AgentJob struct {
+	ID          string         `json:"id"`
+	Databases   []string       `json:"databases,omitempty"` // empty: discover with Discovery
+	Discovery   DiscoveryRules `json:"discovery"`
+	MaxParallel int            `json:"max_parallel"`
+}
+
+// AgentResult is the outcome of backing up one database of an AgentJob.
+type AgentResult struct {
+	Database string `json:"database"`
+	Error    string `json:"error,omitempty"`
+	Skipped  bool   `json:"skipped,omitempty"` // e.g. outside its window
+	Duration string `json:"duration"`
+}
+
+// AgentReport carries the results of a job back to the coordinator.
+type AgentReport struct {
+	Agent   string        `json:"agent"`
+	JobID   string        `json:"job_id"`
+	Results []AgentResult `json:"results"`
+}
+
+// AgentStatus is what the coordinator knows about one agent, as served by
+// GET /v1/status.
+type AgentStatus struct {
+	Name        string        `json:"name"`
+	Hostname    string        `json:"hostname,omitempty"`
+	Connected   bool          `json:"connected"`
+	LastSeen    time.Time     `json:"last_seen,omitzero"`
+	LastJob     string        `json:"last_job,omitempty"`
+	LastResults []AgentResult `json:"last_results,omitempty"`
+}
+
+// Coordinator hands scheduled backup jobs to the agents named in its config
+// and collects their results. It is an http.Handler.
+type Coordinator struct {
+	Token string
+
+	mu     sync.Mutex
+	agents map[string]*coordinatedAgent
+}
+
+type coordinatedAgent struct {
+	status  AgentStatus
+	jobs    chan AgentJob
+	waiting map[string]chan []AgentResult // by job ID
+}
+
+// NewCoordinator returns a coordinator for the agents with the given names.
+func NewCoordinator(token string, agents []string) *Coordinator {
+	c := &Coordinator{Token: token, agents: map[string]*coordinatedAgent{}}
+	for _, name := range agents {
+		c.agents[name] = &coordinatedAgent{
+			status:  AgentStatus{Name: name},
+			jobs:    make(chan AgentJob, 1),
+			waiting: map[string]chan []AgentResult{},
+		}
+	}
+	return c
+}
+
+// Dispatch hands job to the named agent and waits for its results. It
+// fails at once if the agent is not connected or has not yet picked up its
+// previous job.
+func (c *Coordinator) Dispatch(ctx context.Context, agent string, job AgentJob) ([]AgentResult, error) {
+	c.mu.Lock()
+	a, ok := c.agents[agent]
+	if !ok {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("unknown agent '%s'", agent)
+	}
+	if time.Since(a.status.LastSeen) > agentStaleAfter {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("agent '%s' is not connected", agent)
+	}
+	done := make(chan []AgentResult, 1)
+	select {
+	case a.jobs <- job:
+	default:
+		c.mu.Unlock()
+		return nil, fmt.Errorf("agent '%s' has not picked up its previous job", agent)
+	}
+	a.waiting[job.ID] = done
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(a.waiting, job.ID)
+		// Withdraw the job if the agent never picked it up
+		select {
+		case <-a.jobs:
+		default:
+		}
+		c.mu.Unlock()
+	}()
+	select {
+	case results := <-done:
+		return results, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Status returns the state of every agent, by name.
+func (c *Coordinator) Status() []AgentStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []AgentStatus
+	for _, a := range c.agents {
+		s := a.status
+		s.Connected = time.Since(s.LastSeen) <= agentStaleAfter
+		out = append(out, s)
+	}
+	slices.SortFunc(out, func(a, b AgentStatus) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+// seen records that the named agent has been in touch, returning it, or
+// nil if it is not one of the coordinator's agents.
+func (c *Coordinator) seen(name, hostname string) *coordinatedAgent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a, ok := c.agents[name]
+	if !ok {
+		return nil
+	}
+	a.status.LastSeen = time.Now()
+	if hostname != "" {
+		a.status.Hostname = hostname
+	}
+	return a
+}
+
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(auth), []byte(c.Token)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/register":
+		var reg struct{ Name, Hostname string }
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if c.seen(reg.Name, reg.Hostname) == nil {
+			http.Error(w, "unknown agent", http.StatusNotFound)
+			return
+		}
+		log.Printf("Agent '%s' registered from %s.", reg.Name, r.RemoteAddr)
+
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/jobs":
+		a := c.seen(r.URL.Query().Get("agent"), "")
+		if a == nil {
+			http.Error(w, "unknown agent", http.StatusNotFound)
+			return
+		}
+		timer := time.NewTimer(agentPollTimeout)
+		defer timer.Stop()
+		select {
+		case job := <-a.jobs:
+			json.NewEncoder(w).Encode(job)
+		case <-timer.C:
+			w.WriteHeader(http.StatusNoContent)
+		case <-r.Context().Done():
+		}
+
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/results":
+		var rep AgentReport
+		if err := json.NewDecoder(r.Body).Decode(&rep); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a := c.seen(rep.Agent, "")
+		if a == nil {
+			http.Error(w, "unknown agent", http.StatusNotFound)
+			return
+		}
+		c.mu.Lock()
+		a.status.LastJob, a.status.LastResults = rep.JobID, rep.Results
+		if done, ok := a.waiting[rep.JobID]; ok {
+			done <- rep.Results
+		}
+		c.mu.Unlock()
+
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/status":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.Status())
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// coordinatorJobs returns one scheduled job per server of cf, each handing
+// a backup of the server's databases to the agent named after it. Servers
+// without a schedule run on sched.
+func coordinatorJobs(c *Coordinator, cf *ConfigFile, sched Schedule, jitter, timeout time.Duration) ([]Job, error) {
+	var jobs []Job
+	for _, sc := range cf.Servers {
+		job := Job{Name: sc.Name, Schedule: sched, Jitter: jitter}
+		if sc.Schedule != "" {
+			s, err := ParseSchedule(sc.Schedule)
+			if err != nil {
+				return nil, fmt.Errorf("server '%s': %w", sc.Name, err)
+			}
+			job.Schedule = s
+		}
+		rules := cf.Discovery.merge(DiscoveryRules{Include: sc.Include, Exclude: sc.Exclude, IncludeSystem: sc.IncludeSystem})
+		if err := rules.validate(); err != nil {
+			return nil, fmt.Errorf("server '%s': %w", sc.Name, err)
+		}
+		job.Run = func(ctx context.Context) error {
+			ctx, cancel := withTimeout(ctx, timeout)
+			defer cancel()
+			results, err := c.Dispatch(ctx, sc.Name, AgentJob{
+				ID:          sc.Name + "-" + time.Now().UTC().Format("20060102T150405Z"),
+				Databases:   sc.Databases,
+				Discovery:   rules,
+				MaxParallel: cmp.Or(sc.MaxParallel, cf.MaxParallelPerHost, 1),
+			})
+			if err != nil {
+				return err
+			}
+			var failed int
+			for _, r := range results {
+				status := "OK"
+				if r.Skipped {
+					status = "SKIPPED: " + r.Error
+				} else if r.Error != "" {
+					status = "FAILED: " + r.Error
+					failed++
+				}
+				log.Printf("%s/%s: %s (%s)", sc.Name, r.Database, status, r.Duration)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d backups failed", failed, len(results))
+			}
+			return nil
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Agent runs the backup jobs of its coordinator on this host, with Server
+// holding the local settings of every backup.
+type Agent struct {
+	Name   string
+	URL    string // of the coordinator
+	Token  string
+	Server Config
+	apply  func(*Config) error
+	Client *http.Client
+}
+
+// call sends a request to the coordinator and decodes its JSON reply into
+// out, if any. It reports whether there was a reply body.
+func (a Agent) call(ctx context.Context, method, path string, in, out any) (bool, error) {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return false, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(a.URL, "/")+path, &body)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+	req.Header.Set("Content-Type", "application/json")
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: agentPollTimeout + 30*time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return false, nil
+	case resp.StatusCode != http.StatusOK:
+		var msg bytes.Buffer
+		msg.ReadFrom(resp.Body)
+		return false, fmt.Errorf("coordinator: %s: %s", resp.Status, strings.TrimSpace(msg.String()))
+	case out != nil:
+		return true, json.NewDecoder(resp.Body).Decode(out)
+	}
+	return true, nil
+}
+
+// Run registers with the coordinator and runs the jobs it hands out until
+// ctx is cancelled, reconnecting after errors. Jobs run one at a time.
+func (a Agent) Run(ctx context.Context) error {
+	hostname, _ := os.Hostname()
+	backoff := time.Second
+	registered := false
+	for ctx.Err() == nil {
+		var err error
+		if !registered {
+			_, err = a.call(ctx, http.MethodPost, "/v1/register", map[string]string{"Name": a.Name, "Hostname": hostname}, nil)
+			if err == nil {
+				log.Printf("Registered with %s as '%s'.", a.URL, a.Name)
+				registered = true
+			}
+		}
+		if err == nil {
+			err = a.poll(ctx)
+		}
+		if err == nil {
+			backoff = time.Second
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		log.Printf("Lost the coordinator, retrying in %s: %v", backoff, err)
+		registered = false
+		if !sleepUntil(ctx, time.Now().Add(backoff)) {
+			break
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+	log.Println("Agent stopped.")
+	return ctx.Err()
+}
+
+// poll waits for one job and, if one comes, runs it and reports back.
+func (a Agent) poll(ctx context.Context) error {
+	var job AgentJob
+	ok, err := a.call(ctx, http.MethodGet, "/v1/jobs?agent="+a.Name, nil, &job)
+	if err != nil || !ok {
+		return err
+	}
+	log.Printf("Running job %s.", job.ID)
+	fleet := []FleetServer{{
+		Name:        a.Name,
+		MaxParallel: job.MaxParallel,
+		Server:      a.Server,
+		Databases:   job.Databases,
+		Discovery:   job.Discovery,
+		apply:       a.apply,
+		Node:        a.Name,
+	}}
+	results, _ := BackupFleet(ctx, fleet)
+	rep := AgentReport{Agent: a.Name, JobID: job.ID, Results: make([]AgentResult, len(results))}
+	for i, r := range results {
+		rep.Results[i] = AgentResult{Database: r.Database, Duration: r.Duration.Round(time.Second).String()}
+		if r.failed() {
+			rep.Results[i].Error = r.Err.Error()
+		} else if r.Err != nil {
+			rep.Results[i].Error = strings.TrimPrefix(r.status(), "SKIPPED: ")
+			rep.Results[i].Skipped = true
+		}
+	}
+	// Report even if the run was cancelled, so the coordinator is not
+	// left waiting
+	ctx = context.WithoutCancel(ctx)
+	ctx, cancel := context.WithTimeout(ctx, agentPollTimeout)
+	defer cancel()
+	if _, err := a.call(ctx, http.MethodPost, "/v1/results", rep, nil); err != nil {
+		return fmt.Errorf("cannot report job %s: %w", job.ID, err)
+	}
+	log.Printf("Reported job %s.", job.ID)
+	return nil
+}
+
+// serveCoordinator serves c on addr, over TLS if certFile is set, until
+// ctx is cancelled. It returns once the address is bound.
+func serveCoordinator(ctx context.Context, addr, certFile, keyFile string, c *Coordinator) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: c, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		var err error
+		if certFile != "" {
+			err = srv.ServeTLS(ln, certFile, keyFile)
+		} else {
+			err = srv.Serve(ln)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Coordinator stopped serving: %v", err)
+		}
+	}()
+	log.Printf("Coordinator listening on %s.", ln.Addr())
+	return nil
+}
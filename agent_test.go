@@ -0,0 +1,108 @@
+package pgtool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// agentRequest sends a request to the coordinator at url as an agent
+// would, decoding any reply into out.
+func agentRequest(t *testing.T, method, url, token string, in, out any) int {
+	t.Helper()
+	var body bytes.Buffer
+	if in != nil {
+		json.NewEncoder(&body).Encode(in)
+	}
+	req, _ := http.NewRequest(method, url, &body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestCoordinatorDispatch(t *testing.T) {
+	c := NewCoordinator("secret", []string{"db1", "db2"})
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	if code := agentRequest(t, "POST", srv.URL+"/v1/register", "wrong", map[string]string{"Name": "db1"}, nil); code != http.StatusUnauthorized {
+		t.Errorf("register with a wrong token: %d, want 401", code)
+	}
+	if code := agentRequest(t, "POST", srv.URL+"/v1/register", "secret", map[string]string{"Name": "db9"}, nil); code != http.StatusNotFound {
+		t.Errorf("register an unknown agent: %d, want 404", code)
+	}
+	if code := agentRequest(t, "POST", srv.URL+"/v1/register", "secret", map[string]string{"Name": "db1", "Hostname": "db1.internal"}, nil); code != http.StatusOK {
+		t.Fatalf("register: %d", code)
+	}
+
+	ctx := context.Background()
+	errorTests := []struct {
+		agent, want string
+	}{
+		{"db9", "unknown agent"},
+		{"db2", "not connected"},
+	}
+	for _, tt := range errorTests {
+		if _, err := c.Dispatch(ctx, tt.agent, AgentJob{ID: "x"}); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Dispatch to %s: %v, want %q", tt.agent, err, tt.want)
+		}
+	}
+
+	// The agent polls for the job and reports its results
+	want := []AgentResult{{Database: "app", Duration: "1m0s"}, {Database: "billing", Error: "pg_dump: exit status 1", Duration: "2s"}}
+	go func() {
+		var job AgentJob
+		if code := agentRequest(t, "GET", srv.URL+"/v1/jobs?agent=db1", "secret", nil, &job); code != http.StatusOK {
+			t.Errorf("poll: %d", code)
+			return
+		}
+		agentRequest(t, "POST", srv.URL+"/v1/results", "secret", AgentReport{Agent: "db1", JobID: job.ID, Results: want}, nil)
+	}()
+	results, err := c.Dispatch(ctx, "db1", AgentJob{ID: "db1-1", Databases: []string{"app", "billing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0] != want[0] || results[1] != want[1] {
+		t.Errorf("results %+v, want %+v", results, want)
+	}
+
+	status := c.Status()
+	if len(status) != 2 || !status[0].Connected || status[0].Hostname != "db1.internal" || status[0].LastJob != "db1-1" || status[1].Connected {
+		t.Errorf("status %+v", status)
+	}
+}
+
+func TestCoordinatorDispatchNotPickedUp(t *testing.T) {
+	c := NewCoordinator("secret", []string{"db1"})
+	c.seen("db1", "")
+
+	// A job the agent never picks up is withdrawn when the dispatch ends
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Dispatch(ctx, "db1", AgentJob{ID: "db1-1"}); err != context.DeadlineExceeded {
+		t.Errorf("Dispatch: %v, want the context's error", err)
+	}
+	if len(c.agents["db1"].jobs) != 0 {
+		t.Errorf("the job was not withdrawn")
+	}
+
+	// While a job is pending, another one is refused
+	c.agents["db1"].jobs <- AgentJob{ID: "db1-2"}
+	if _, err := c.Dispatch(context.Background(), "db1", AgentJob{ID: "db1-3"}); err == nil || !strings.Contains(err.Error(), "previous job") {
+		t.Errorf("Dispatch with a job pending: %v", err)
+	}
+}
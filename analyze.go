@@ -0,0 +1,30 @@
+package pgtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// analyzeDatabase gathers planner statistics for the restored database,
+// which pg_restore leaves empty. vacuumdb --analyze-in-stages makes rough
+// statistics available within seconds and refines them in two more
+// passes; without vacuumdb a plain ANALYZE is run through psql.
+func analyzeDatabase(ctx context.Context, cfg Config, logger *log.Logger) error {
+	start := time.Now()
+	logger.Printf("INFO: Analyzing database '%s'.", cfg.target())
+	fmt.Println("Analyzing...")
+	args := append(cfg.connArgs(), "--analyze-in-stages")
+	err := runCommand(ctx, cfg.runner(), Command{Name: "vacuumdb", Args: args}, nil)
+	if errors.Is(err, ErrToolMissing) {
+		logger.Printf("WARNING: vacuumdb not found; running ANALYZE instead.")
+		_, err = queryScalar(ctx, cfg, "ANALYZE")
+	}
+	if err != nil {
+		return contextErr(ctx, err)
+	}
+	logger.Printf("SUCCESS: Analyzed database '%s' in %s.", cfg.target(), time.Since(start).Round(time.Second))
+	return nil
+}
@@ -0,0 +1,188 @@
+package pgtool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/user"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// AuditEntry is one line of the audit log. Hash is the SHA-256 of Prev
+// and the entry's JSON without Hash, so that editing or removing a line
+// breaks the chain from there on.
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Op       string    `json:"op"`
+	Database string    `json:"database,omitempty"`
+	User     string    `json:"user"`
+	Host     string    `json:"host"`
+	PID      int       `json:"pid"`
+	Args     []string  `json:"args"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	File     string    `json:"file,omitempty"`
+	Deleted  int       `json:"deleted,omitempty"`
+	Prev     string    `json:"prev"`
+	Hash     string    `json:"hash"`
+}
+
+// hash returns the chain hash of e.
+func (e AuditEntry) hash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditNotifier appends every run result to a hash-chained JSONL audit
+// log, with who ran it and how. It is kept apart from the operational
+// log so that it can be shipped to and checked by compliance tooling.
+type AuditNotifier struct {
+	Path string
+	Args []string // command line arguments; secret values are redacted
+}
+
+func (n AuditNotifier) Name() string { return "audit" }
+
+// redactArgs hides the values of flags that look like they hold secrets.
+func redactArgs(args []string) []string {
+	secret := func(flag string) bool {
+		flag = strings.ToLower(flag)
+		for _, s := range []string{"key", "password", "secret", "token"} {
+			if strings.Contains(flag, s) {
+				return true
+			}
+		}
+		return false
+	}
+	out := make([]string, len(args))
+	for i, a := range args {
+		out[i] = a
+		if !strings.HasPrefix(a, "-") {
+			if i > 0 && strings.HasPrefix(args[i-1], "-") && !strings.Contains(args[i-1], "=") && secret(args[i-1]) {
+				out[i] = "REDACTED"
+			}
+			continue
+		}
+		if name, _, ok := strings.Cut(a, "="); ok && secret(name) {
+			out[i] = name + "=REDACTED"
+		}
+	}
+	for i, a := range out {
+		// Passwords in storage URLs such as webdavs://user:pw@host/
+		if u, err := url.Parse(a); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				out[i] = u.Redacted()
+			}
+		}
+	}
+	return out
+}
+
+func (n AuditNotifier) Notify(ctx context.Context, r RunResult) error {
+	e := AuditEntry{
+		Time:     r.Finished,
+		Op:       r.Op,
+		Database: r.Database,
+		PID:      os.Getpid(),
+		Args:     redactArgs(n.Args),
+		Status:   r.Status,
+		Error:    r.Error,
+		File:     r.File,
+		Deleted:  r.Deleted,
+	}
+	if u, err := user.Current(); err == nil {
+		e.User = u.Username
+	}
+	e.Host, _ = os.Hostname()
+
+	f, err := os.OpenFile(n.Path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Concurrent runs must not both chain onto the same last entry
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	last, err := lastAuditEntry(f)
+	if err != nil {
+		return err
+	}
+	e.Prev = last.Hash
+	if e.Hash, err = e.hash(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// lastAuditEntry returns the last entry of the audit log, or the zero
+// entry if it is empty.
+func lastAuditEntry(f *os.File) (AuditEntry, error) {
+	var last AuditEntry
+	fi, err := f.Stat()
+	if err != nil || fi.Size() == 0 {
+		return last, err
+	}
+	// Entries are small; the last one is within the final 64KiB
+	off := max(0, fi.Size()-64<<10)
+	buf := make([]byte, fi.Size()-off)
+	if _, err := f.ReadAt(buf, off); err != nil {
+		return last, err
+	}
+	lines := bytes.Split(bytes.TrimRight(buf, "\n"), []byte("\n"))
+	if err := json.Unmarshal(lines[len(lines)-1], &last); err != nil {
+		return last, fmt.Errorf("audit log %s: last entry: %w", f.Name(), err)
+	}
+	return last, nil
+}
+
+// checkAuditLog verifies the hash chain of the audit log at path and
+// returns the number of entries. A broken chain fails with
+// ErrVerification naming the first line that does not match.
+func checkAuditLog(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	var prev string
+	n := 0
+	for sc.Scan() {
+		n++
+		var e AuditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return n, fmt.Errorf("%w: %s line %d: %w", ErrVerification, path, n, err)
+		}
+		h, err := e.hash()
+		if err != nil {
+			return n, err
+		}
+		if e.Prev != prev || e.Hash != h {
+			return n, fmt.Errorf("%w: %s line %d does not match the chain; the log was altered at or before it", ErrVerification, path, n)
+		}
+		prev = e.Hash
+	}
+	return n, sc.Err()
+}
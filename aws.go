@@ -0,0 +1,195 @@
+package pgtool
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// awsCredentials are read from the standard AWS environment variables.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+func awsCredentialsFromEnv() (awsCredentials, error) {
+	c := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return c, fmt.Errorf("%w: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set", ErrUsage)
+	}
+	return c, nil
+}
+
+// awsQuery calls an AWS Query API action (SNS, SQS) with a form body
+// signed with Signature Version 4.
+func awsQuery(ctx context.Context, client *http.Client, creds awsCredentials, region, service, endpoint string, form url.Values) error {
+	body := form.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signAWSv4(req, []byte(body), creds, region, service, time.Now())
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", service, form.Get("Action"), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// signAWSv4 adds a Signature Version 4 Authorization header to req.
+func signAWSv4(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	sha256hex := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+	hmacSHA256 := func(key []byte, s string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(s))
+		return mac.Sum(nil)
+	}
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonHeaders.String(), signedHeaders, sha256hex(body),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+// SNSNotifier publishes every run result as JSON to an SNS topic, for
+// downstream automation such as a Lambda that reacts to new backups.
+// Credentials come from the AWS environment variables.
+type SNSNotifier struct {
+	TopicARN string // arn:aws:sns:REGION:ACCOUNT:NAME
+	Endpoint string // "" means https://sns.REGION.amazonaws.com/
+	Client   *http.Client
+}
+
+func (n SNSNotifier) Name() string { return "sns" }
+
+func (n SNSNotifier) Notify(ctx context.Context, r RunResult) error {
+	parts := strings.Split(n.TopicARN, ":")
+	if len(parts) != 6 || parts[2] != "sns" {
+		return fmt.Errorf("%w: invalid SNS topic ARN '%s'", ErrUsage, n.TopicARN)
+	}
+	region := parts[3]
+	creds, err := awsCredentialsFromEnv()
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	endpoint := n.Endpoint
+	if endpoint == "" {
+		endpoint = "https://sns." + region + ".amazonaws.com/"
+	}
+	return awsQuery(ctx, n.Client, creds, region, "sns", endpoint, url.Values{
+		"Action":   {"Publish"},
+		"Version":  {"2010-03-31"},
+		"TopicArn": {n.TopicARN},
+		"Message":  {string(msg)},
+		// Lets subscribers filter, e.g. only successful backups
+		"MessageAttributes.entry.1.Name":              {"op"},
+		"MessageAttributes.entry.1.Value.DataType":    {"String"},
+		"MessageAttributes.entry.1.Value.StringValue": {r.Op},
+		"MessageAttributes.entry.2.Name":              {"status"},
+		"MessageAttributes.entry.2.Value.DataType":    {"String"},
+		"MessageAttributes.entry.2.Value.StringValue": {r.Status},
+	})
+}
+
+// SQSNotifier sends every run result as JSON to an SQS queue.
+// Credentials come from the AWS environment variables.
+type SQSNotifier struct {
+	QueueURL string // https://sqs.REGION.amazonaws.com/ACCOUNT/NAME
+	Client   *http.Client
+}
+
+func (n SQSNotifier) Name() string { return "sqs" }
+
+func (n SQSNotifier) Notify(ctx context.Context, r RunResult) error {
+	u, err := url.Parse(n.QueueURL)
+	if err != nil {
+		return fmt.Errorf("%w: invalid SQS queue URL '%s'", ErrUsage, n.QueueURL)
+	}
+	// sqs.REGION.amazonaws.com, or the legacy REGION.queue.amazonaws.com
+	host := strings.Split(u.Hostname(), ".")
+	if len(host) < 3 {
+		return fmt.Errorf("%w: invalid SQS queue URL '%s'", ErrUsage, n.QueueURL)
+	}
+	region := host[1]
+	if host[0] != "sqs" {
+		region = host[0]
+	}
+	creds, err := awsCredentialsFromEnv()
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return awsQuery(ctx, n.Client, creds, region, "sqs", n.QueueURL, url.Values{
+		"Action":      {"SendMessage"},
+		"Version":     {"2012-11-05"},
+		"MessageBody": {string(msg)},
+	})
+}
@@ -0,0 +1,541 @@
+package pgtool
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// b2APIURL is where B2 accounts are authorized; the response names the
+// API and download hosts to use for everything else.
+const b2APIURL = "https://api.backblazeb2.com/b2api/v2/"
+
+// b2DefaultPartSize is used if the account does not recommend one.
+const b2DefaultPartSize = 100 << 20
+
+// B2Storage is a StorageBackend using Backblaze B2's native API. Files
+// larger than one part are uploaded with the large file API, in parts of
+// the size B2 recommends for the account.
+//
+// With LockMode set, uploads are locked with Object Lock for LockDays, so
+// that not even the key that wrote them can delete them before then. The
+// bucket must have Object Lock enabled. In governance mode a key with the
+// bypassGovernance capability can still lift the lock; in compliance mode
+// nobody can.
+//
+// With Encryption set, uploads are encrypted at rest: SSE-B2 with a key
+// B2 manages, SSE-C with CustomerKey, a 256-bit AES key that B2 does not
+// keep. Downloads of SSE-C files need the same key.
+type B2Storage struct {
+	KeyID    string
+	Key      string
+	Bucket   string
+	Prefix   string // prepended to every file name, e.g. "prod/"
+	LockMode string // "governance", "compliance" or "" for no lock
+	LockDays int
+
+	Encryption  string // b2SSEB2, b2SSEC or "" for the bucket's default
+	CustomerKey []byte
+
+	Client *http.Client
+}
+
+// Server-side encryption modes, as B2 names them.
+const (
+	b2SSEB2 = "SSE-B2"
+	b2SSEC  = "SSE-C"
+)
+
+// b2Session is an authorized B2 account. Tokens expire after a day, so
+// every operation authorizes afresh rather than caching one in a daemon.
+type b2Session struct {
+	s           B2Storage
+	token       string
+	apiURL      string
+	downloadURL string
+	bucketID    string
+	partSize    int64
+}
+
+// b2Error is the body B2 returns with a failed call.
+type b2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *b2Error) Error() string {
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.Status, e.Code)
+}
+
+func (e *b2Error) httpStatus() int { return e.Status }
+
+func (s B2Storage) Name() string { return "b2:" + s.Bucket }
+
+func (s B2Storage) client() *http.Client {
+	if s.Client == nil {
+		return http.DefaultClient
+	}
+	return s.Client
+}
+
+// do sends req and decodes a JSON response into out, or a B2 error.
+func (s B2Storage) do(req *http.Request, out any) error {
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		e := &b2Error{Status: resp.StatusCode}
+		if json.NewDecoder(resp.Body).Decode(e) != nil || e.Message == "" {
+			e.Message = resp.Status
+		}
+		return e
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (s B2Storage) authorize(ctx context.Context) (*b2Session, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b2APIURL+"b2_authorize_account", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(s.KeyID, s.Key)
+	var auth struct {
+		AccountID           string `json:"accountId"`
+		AuthorizationToken  string `json:"authorizationToken"`
+		APIURL              string `json:"apiUrl"`
+		DownloadURL         string `json:"downloadUrl"`
+		RecommendedPartSize int64  `json:"recommendedPartSize"`
+		Allowed             struct {
+			BucketID   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+		} `json:"allowed"`
+	}
+	if err := s.do(req, &auth); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+	b := &b2Session{
+		s:           s,
+		token:       auth.AuthorizationToken,
+		apiURL:      auth.APIURL,
+		downloadURL: auth.DownloadURL,
+		partSize:    auth.RecommendedPartSize,
+	}
+	if b.partSize <= 0 {
+		b.partSize = b2DefaultPartSize
+	}
+
+	// Keys restricted to one bucket say which; others must look it up.
+	if auth.Allowed.BucketName == s.Bucket && auth.Allowed.BucketID != "" {
+		b.bucketID = auth.Allowed.BucketID
+		return b, nil
+	}
+	var buckets struct {
+		Buckets []struct {
+			BucketID string `json:"bucketId"`
+		} `json:"buckets"`
+	}
+	err = b.call(ctx, "b2_list_buckets", map[string]any{"accountId": auth.AccountID, "bucketName": s.Bucket}, &buckets)
+	if err != nil {
+		return nil, err
+	}
+	if len(buckets.Buckets) == 0 {
+		return nil, fmt.Errorf("bucket '%s' not found", s.Bucket)
+	}
+	b.bucketID = buckets.Buckets[0].BucketID
+	return b, nil
+}
+
+// call invokes a B2 API operation with a JSON body.
+func (b *b2Session) call(ctx context.Context, op string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.apiURL+"/b2api/v2/"+op, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", b.token)
+	if err := b.s.do(req, out); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// post uploads size bytes from r, whose SHA-1 is sum, to an upload URL.
+func (b *b2Session) post(ctx context.Context, uploadURL, token string, r io.Reader, size int64, sum string, header map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Authorization", token)
+	req.Header.Set("X-Bz-Content-Sha1", sum)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	return b.s.do(req, nil)
+}
+
+// retainUntil returns when a lock placed now expires, in B2's Unix
+// milliseconds.
+func (s B2Storage) retainUntil() int64 {
+	return time.Now().AddDate(0, 0, s.LockDays).UnixMilli()
+}
+
+// serverSideEncryption returns the serverSideEncryption setting of a
+// b2_start_large_file call, or nil for the bucket's default.
+func (s B2Storage) serverSideEncryption() map[string]any {
+	switch s.Encryption {
+	case b2SSEB2:
+		return map[string]any{"mode": b2SSEB2, "algorithm": "AES256"}
+	case b2SSEC:
+		sum := md5.Sum(s.CustomerKey)
+		return map[string]any{
+			"mode":           b2SSEC,
+			"algorithm":      "AES256",
+			"customerKey":    base64.StdEncoding.EncodeToString(s.CustomerKey),
+			"customerKeyMd5": base64.StdEncoding.EncodeToString(sum[:]),
+		}
+	}
+	return nil
+}
+
+// customerKeyHeaders adds the SSE-C key to the headers of an upload, a
+// part or a download, if s uses one.
+func (s B2Storage) customerKeyHeaders(header map[string]string) {
+	if s.Encryption != b2SSEC {
+		return
+	}
+	sum := md5.Sum(s.CustomerKey)
+	header["X-Bz-Server-Side-Encryption-Customer-Algorithm"] = "AES256"
+	header["X-Bz-Server-Side-Encryption-Customer-Key"] = base64.StdEncoding.EncodeToString(s.CustomerKey)
+	header["X-Bz-Server-Side-Encryption-Customer-Key-Md5"] = base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// b2FileName percent-encodes a file name for B2 headers and URLs.
+func b2FileName(name string) string {
+	return (&url.URL{Path: name}).EscapedPath()
+}
+
+// sha1Section returns the hex SHA-1 of size bytes of f at off.
+func sha1Section(f *os.File, off, size int64) (string, error) {
+	h := sha1.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, off, size)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s B2Storage) Upload(ctx context.Context, localPath, name string) error {
+	b, err := s.authorize(ctx)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() > b.partSize {
+		return b.uploadLarge(ctx, f, fi.Size(), s.Prefix+name)
+	}
+
+	sum, err := sha1Section(f, 0, fi.Size())
+	if err != nil {
+		return err
+	}
+	var up struct {
+		UploadURL          string `json:"uploadUrl"`
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+	if err := b.call(ctx, "b2_get_upload_url", map[string]any{"bucketId": b.bucketID}, &up); err != nil {
+		return err
+	}
+	header := map[string]string{
+		"X-Bz-File-Name": b2FileName(s.Prefix + name),
+		"Content-Type":   "b2/x-auto",
+	}
+	if s.LockMode != "" {
+		header["X-Bz-File-Retention-Mode"] = s.LockMode
+		header["X-Bz-File-Retention-Retain-Until-Timestamp"] = strconv.FormatInt(s.retainUntil(), 10)
+	}
+	if s.Encryption == b2SSEB2 {
+		header["X-Bz-Server-Side-Encryption"] = "AES256"
+	}
+	s.customerKeyHeaders(header)
+	err = b.post(ctx, up.UploadURL, up.AuthorizationToken, io.NewSectionReader(f, 0, fi.Size()), fi.Size(), sum, header)
+	if err != nil {
+		return fmt.Errorf("b2_upload_file: %w", err)
+	}
+	return nil
+}
+
+// uploadLarge uploads f in parts with the large file API, cancelling the
+// unfinished file if a part fails so it does not linger in the bucket.
+func (b *b2Session) uploadLarge(ctx context.Context, f *os.File, size int64, name string) error {
+	var start struct {
+		FileID string `json:"fileId"`
+	}
+	body := map[string]any{
+		"bucketId":    b.bucketID,
+		"fileName":    name,
+		"contentType": "b2/x-auto",
+	}
+	if b.s.LockMode != "" {
+		body["fileRetention"] = map[string]any{"mode": b.s.LockMode, "retainUntilTimestamp": b.s.retainUntil()}
+	}
+	if sse := b.s.serverSideEncryption(); sse != nil {
+		body["serverSideEncryption"] = sse
+	}
+	err := b.call(ctx, "b2_start_large_file", body, &start)
+	if err != nil {
+		return err
+	}
+
+	var part struct {
+		UploadURL          string `json:"uploadUrl"`
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+	err = b.call(ctx, "b2_get_upload_part_url", map[string]any{"fileId": start.FileID}, &part)
+	var sums []string
+	for off, n := int64(0), 1; err == nil && off < size; off, n = off+b.partSize, n+1 {
+		partSize := min(b.partSize, size-off)
+		var sum string
+		if sum, err = sha1Section(f, off, partSize); err != nil {
+			break
+		}
+		header := map[string]string{"X-Bz-Part-Number": strconv.Itoa(n)}
+		b.s.customerKeyHeaders(header)
+		err = b.post(ctx, part.UploadURL, part.AuthorizationToken, io.NewSectionReader(f, off, partSize), partSize, sum, header)
+		if err != nil {
+			err = fmt.Errorf("b2_upload_part %d: %w", n, err)
+		}
+		sums = append(sums, sum)
+	}
+	if err == nil {
+		err = b.call(ctx, "b2_finish_large_file", map[string]any{"fileId": start.FileID, "partSha1Array": sums}, nil)
+	}
+	if err != nil {
+		b.call(context.WithoutCancel(ctx), "b2_cancel_large_file", map[string]any{"fileId": start.FileID}, nil)
+		return err
+	}
+	return nil
+}
+
+func (s B2Storage) Download(ctx context.Context, name, localPath string) error {
+	b, err := s.authorize(ctx)
+	if err != nil {
+		return err
+	}
+	u := b.downloadURL + "/file/" + url.PathEscape(s.Bucket) + "/" + b2FileName(s.Prefix+name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", b.token)
+	header := make(map[string]string)
+	s.customerKeyHeaders(header)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError("download "+name, resp)
+	}
+
+	out, err := os.Create(localPath)
+	if err != nil {
+		return ioError(err)
+	}
+	defer out.Close()
+	if _, err := copyContext(ctx, out, resp.Body); err != nil {
+		os.Remove(localPath)
+		return err
+	}
+	return ioError(out.Close())
+}
+
+// b2File is a file version as listed by B2.
+type b2File struct {
+	FileName      string `json:"fileName"`
+	FileID        string `json:"fileId"`
+	FileRetention struct {
+		Value *struct {
+			Mode                 *string `json:"mode"`
+			RetainUntilTimestamp *int64  `json:"retainUntilTimestamp"`
+		} `json:"value"`
+	} `json:"fileRetention"`
+	ServerSideEncryption struct {
+		Mode *string `json:"mode"`
+	} `json:"serverSideEncryption"`
+}
+
+// list calls a B2 listing operation until all files under prefix are
+// returned. Versions are only listed by b2_list_file_versions.
+func (b *b2Session) list(ctx context.Context, op, prefix string) ([]b2File, error) {
+	var files []b2File
+	body := map[string]any{"bucketId": b.bucketID, "prefix": prefix, "maxFileCount": 1000}
+	for {
+		var page struct {
+			Files        []b2File `json:"files"`
+			NextFileName *string  `json:"nextFileName"`
+			NextFileID   *string  `json:"nextFileId"`
+		}
+		if err := b.call(ctx, op, body, &page); err != nil {
+			return nil, err
+		}
+		files = append(files, page.Files...)
+		if page.NextFileName == nil {
+			return files, nil
+		}
+		body["startFileName"] = *page.NextFileName
+		if page.NextFileID != nil {
+			body["startFileId"] = *page.NextFileID
+		}
+	}
+}
+
+func (s B2Storage) List(ctx context.Context) ([]string, error) {
+	b, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files, err := b.list(ctx, "b2_list_file_names", s.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if name := strings.TrimPrefix(f.FileName, s.Prefix); !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Delete removes every version of name, so that deleted backups stop
+// costing storage even in buckets that keep old versions.
+func (s B2Storage) Delete(ctx context.Context, name string) error {
+	b, err := s.authorize(ctx)
+	if err != nil {
+		return err
+	}
+	files, err := b.list(ctx, "b2_list_file_versions", s.Prefix+name)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.FileName != s.Prefix+name {
+			continue
+		}
+		if err := b.call(ctx, "b2_delete_file_version", map[string]any{"fileName": f.FileName, "fileId": f.FileID}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LockedUntil returns the files whose Object Lock has not expired yet.
+// Listing locks needs a key with the readFileRetentions capability; B2
+// leaves them out of the listing otherwise.
+func (s B2Storage) LockedUntil(ctx context.Context) (map[string]time.Time, error) {
+	b, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files, err := b.list(ctx, "b2_list_file_names", s.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	locked := make(map[string]time.Time)
+	for _, f := range files {
+		v := f.FileRetention.Value
+		if v == nil || v.Mode == nil || v.RetainUntilTimestamp == nil {
+			continue
+		}
+		if until := time.UnixMilli(*v.RetainUntilTimestamp); until.After(time.Now()) {
+			locked[strings.TrimPrefix(f.FileName, s.Prefix)] = until
+		}
+	}
+	return locked, nil
+}
+
+// Unencrypted returns the files not encrypted with s.Encryption, such as
+// those uploaded before it was set. With no Encryption it returns none.
+func (s B2Storage) Unencrypted(ctx context.Context) ([]string, error) {
+	if s.Encryption == "" {
+		return nil, nil
+	}
+	b, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files, err := b.list(ctx, "b2_list_file_names", s.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		name := strings.TrimPrefix(f.FileName, s.Prefix)
+		if mode := f.ServerSideEncryption.Mode; !strings.Contains(name, "/") && (mode == nil || *mode != s.Encryption) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// b2MaxShareDuration is the longest validity B2 allows for a download
+// authorization.
+const b2MaxShareDuration = 7 * 24 * time.Hour
+
+// ShareURL returns a URL that downloads name, without credentials, until
+// expires has passed.
+func (s B2Storage) ShareURL(ctx context.Context, name string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > b2MaxShareDuration {
+		return "", fmt.Errorf("%w: B2 share links must expire within %s", ErrUsage, b2MaxShareDuration)
+	}
+	b, err := s.authorize(ctx)
+	if err != nil {
+		return "", err
+	}
+	var auth struct {
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+	err = b.call(ctx, "b2_get_download_authorization", map[string]any{
+		"bucketId":               b.bucketID,
+		"fileNamePrefix":         s.Prefix + name,
+		"validDurationInSeconds": int64(expires / time.Second),
+	}, &auth)
+	if err != nil {
+		return "", err
+	}
+	return b.downloadURL + "/file/" + url.PathEscape(s.Bucket) + "/" + b2FileName(s.Prefix+name) +
+		"?Authorization=" + url.QueryEscape(auth.AuthorizationToken), nil
+}
@@ -0,0 +1,96 @@
+package pgtool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// fakeB2 answers the B2 calls of a small upload and a listing, keeping
+// the headers of the upload.
+type fakeB2 struct {
+	upload http.Header
+	files  []map[string]any
+}
+
+func (f *fakeB2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var out any
+	switch r.URL.Path {
+	case "/b2api/v2/b2_authorize_account":
+		out = map[string]any{
+			"authorizationToken": "token", "apiUrl": "https://api.b2.test", "downloadUrl": "https://f.b2.test",
+			"allowed": map[string]any{"bucketId": "b1", "bucketName": "backups"},
+		}
+	case "/b2api/v2/b2_get_upload_url":
+		out = map[string]any{"uploadUrl": "https://pod.b2.test/upload", "authorizationToken": "upload-token"}
+	case "/upload":
+		f.upload = r.Header.Clone()
+		out = map[string]any{}
+	case "/b2api/v2/b2_list_file_names":
+		out = map[string]any{"files": f.files}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// handlerTransport sends every request to a handler, whatever its host.
+type handlerTransport struct{ h http.Handler }
+
+func (t handlerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	t.h.ServeHTTP(w, r)
+	return w.Result(), nil
+}
+
+func TestB2Encryption(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	tests := []struct {
+		encryption string
+		key        []byte
+		want       map[string]string
+	}{
+		{"", nil, map[string]string{}},
+		{b2SSEB2, nil, map[string]string{"X-Bz-Server-Side-Encryption": "AES256"}},
+		{b2SSEC, key, map[string]string{
+			"X-Bz-Server-Side-Encryption-Customer-Algorithm": "AES256",
+			"X-Bz-Server-Side-Encryption-Customer-Key":       "BwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwc=",
+			"X-Bz-Server-Side-Encryption-Customer-Key-Md5":   "y4HAEFCYWuvAXWFTtA1Qpg==",
+		}},
+	}
+	path := filepath.Join(t.TempDir(), "app_2026-10-15_020000.dump.gz")
+	if err := os.WriteFile(path, []byte("PGDMP fake archive\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		b2 := &fakeB2{}
+		s := B2Storage{Bucket: "backups", Encryption: tt.encryption, CustomerKey: tt.key, Client: &http.Client{Transport: handlerTransport{b2}}}
+		if err := s.Upload(context.Background(), path, filepath.Base(path)); err != nil {
+			t.Fatalf("%q: Upload: %v", tt.encryption, err)
+		}
+		for _, h := range []string{"X-Bz-Server-Side-Encryption", "X-Bz-Server-Side-Encryption-Customer-Algorithm",
+			"X-Bz-Server-Side-Encryption-Customer-Key", "X-Bz-Server-Side-Encryption-Customer-Key-Md5"} {
+			if got := b2.upload.Get(h); got != tt.want[h] {
+				t.Errorf("%q: %s: %q, want %q", tt.encryption, h, got, tt.want[h])
+			}
+		}
+	}
+
+	b2 := &fakeB2{files: []map[string]any{
+		{"fileName": "app_2026-10-14_020000.dump.gz", "serverSideEncryption": map[string]any{"mode": nil}},
+		{"fileName": "app_2026-10-15_020000.dump.gz", "serverSideEncryption": map[string]any{"mode": "SSE-B2", "algorithm": "AES256"}},
+		{"fileName": "app_2026-10-15_020000.manifest.json", "serverSideEncryption": map[string]any{"mode": "SSE-C", "algorithm": "AES256"}},
+	}}
+	s := B2Storage{Bucket: "backups", Encryption: b2SSEB2, Client: &http.Client{Transport: handlerTransport{b2}}}
+	plain, err := s.Unencrypted(context.Background())
+	if want := []string{"app_2026-10-14_020000.dump.gz", "app_2026-10-15_020000.manifest.json"}; err != nil || !slices.Equal(plain, want) {
+		t.Errorf("Unencrypted: %q, %v, want %q", plain, err, want)
+	}
+}
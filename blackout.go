@@ -0,0 +1,123 @@
+package pgtool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Blackout is a period in which backups are skipped, e.g. a migration
+// weekend. It is declared in the config file, or set on a backup directory
+// with schedule pause.
+type Blackout struct {
+	From   time.Time `json:"from"`
+	Until  time.Time `json:"until"` // exclusive
+	Reason string    `json:"reason,omitempty"`
+}
+
+// BlackoutConfig is a blackout in the config file, with dates or times as
+// accepted by list -since, e.g.
+//
+//	{"from": "2026-10-17", "until": "2026-10-19 06:00", "reason": "PG 17 upgrade"}
+//
+// Durations are not accepted: relative to each run, the blackout would
+// move with it.
+type BlackoutConfig struct {
+	From   string `json:"from"`
+	Until  string `json:"until"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (bc BlackoutConfig) parse() (Blackout, error) {
+	b := Blackout{Reason: bc.Reason}
+	var err error
+	if b.From, err = parseBlackoutTime("from", bc.From); err == nil {
+		b.Until, err = parseBlackoutTime("until", bc.Until)
+	}
+	if err == nil && (b.From.IsZero() || !b.Until.After(b.From)) {
+		err = fmt.Errorf("%w: a blackout needs \"from\" before \"until\"", ErrUsage)
+	}
+	return b, err
+}
+
+// parseBlackoutTime parses the date or time s of a config file blackout.
+func parseBlackoutTime(field, s string) (time.Time, error) {
+	if _, err := time.ParseDuration(s); err == nil {
+		return time.Time{}, fmt.Errorf("%w: blackout \"%s\" is a duration, '%s'; give a date or time, or use schedule pause", ErrUsage, field, s)
+	}
+	return parseListTime(s, time.Now())
+}
+
+// Contains reports whether t falls in the blackout.
+func (b Blackout) Contains(t time.Time) bool {
+	return !t.Before(b.From) && t.Before(b.Until)
+}
+
+// pauseFile, in a backup directory, holds the Blackout set by schedule
+// pause.
+const pauseFile = "pgtool.pause.json"
+
+// readPause returns the pause of dir, or nil if there is none.
+func readPause(dir string) (*Blackout, error) {
+	data, err := os.ReadFile(filepath.Join(dir, pauseFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var b Blackout
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("%s: %w", pauseFile, err)
+	}
+	return &b, nil
+}
+
+// writePause pauses the backups of dir for b.
+func writePause(dir string, b Blackout) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, pauseFile+".tmp")
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, pauseFile))
+}
+
+// removePause resumes the backups of dir, reporting whether they were
+// paused.
+func removePause(dir string) (bool, error) {
+	err := os.Remove(filepath.Join(dir, pauseFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkBlackout returns an ErrSkipped error if cfg's backup falls in one
+// of its blackouts, or its backup directory is paused.
+func (c Config) checkBlackout() error {
+	now := time.Now()
+	blackouts := c.Blackouts
+	pause, err := readPause(c.BackupDir)
+	if err != nil {
+		return err
+	}
+	if pause != nil {
+		blackouts = append(blackouts[:len(blackouts):len(blackouts)], *pause)
+	}
+	for _, b := range blackouts {
+		if b.Contains(now) {
+			msg := "blackout until " + b.Until.Format("2006-01-02 15:04")
+			if b.Reason != "" {
+				msg += " (" + b.Reason + ")"
+			}
+			return fmt.Errorf("%w: %s", ErrSkipped, msg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package pgtool
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBlackoutConfig(t *testing.T) {
+	tests := []struct {
+		from, until string
+		wantErr     bool
+		in, out     time.Time
+	}{
+		{"2026-10-17", "2026-10-19 06:00", false,
+			time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local), time.Date(2026, 10, 19, 6, 0, 0, 0, time.Local)},
+		{"2026-10-17T22:00", "2026-10-18", false,
+			time.Date(2026, 10, 17, 22, 0, 0, 0, time.Local), time.Date(2026, 10, 17, 21, 59, 0, 0, time.Local)},
+		{"2026-10-19", "2026-10-17", true, time.Time{}, time.Time{}},
+		{"2026-10-17", "2026-10-17", true, time.Time{}, time.Time{}},
+		{"", "2026-10-17", true, time.Time{}, time.Time{}},
+		{"next week", "2026-10-17", true, time.Time{}, time.Time{}},
+		{"2026-10-17", "48h", true, time.Time{}, time.Time{}},
+		{"1h", "2099-01-01", true, time.Time{}, time.Time{}},
+	}
+	for _, tt := range tests {
+		b, err := BlackoutConfig{From: tt.from, Until: tt.until}.parse()
+		if tt.wantErr {
+			if !errors.Is(err, ErrUsage) {
+				t.Errorf("%s to %s: %+v, %v, want ErrUsage", tt.from, tt.until, b, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s to %s: %v", tt.from, tt.until, err)
+			continue
+		}
+		if !b.Contains(tt.in) || b.Contains(tt.out) {
+			t.Errorf("%s to %s: Contains(%s) = %v, Contains(%s) = %v", tt.from, tt.until, tt.in, b.Contains(tt.in), tt.out, b.Contains(tt.out))
+		}
+	}
+}
+
+func TestCheckBlackout(t *testing.T) {
+	now := time.Now()
+	current := Blackout{From: now.Add(-time.Hour), Until: now.Add(time.Hour), Reason: "upgrade"}
+	past := Blackout{From: now.Add(-48 * time.Hour), Until: now.Add(-24 * time.Hour)}
+
+	dir := t.TempDir()
+	cfg := Config{BackupDir: dir, Blackouts: []Blackout{past}}
+	if err := cfg.checkBlackout(); err != nil {
+		t.Errorf("past blackout: %v", err)
+	}
+	cfg.Blackouts = append(cfg.Blackouts, current)
+	if err := cfg.checkBlackout(); !errors.Is(err, ErrSkipped) {
+		t.Errorf("current blackout: %v, want ErrSkipped", err)
+	}
+
+	cfg.Blackouts = nil
+	if err := writePause(dir, current); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.checkBlackout(); !errors.Is(err, ErrSkipped) {
+		t.Errorf("paused: %v, want ErrSkipped", err)
+	}
+	if ok, err := removePause(dir); !ok || err != nil {
+		t.Fatalf("removePause: %v, %v", ok, err)
+	}
+	if err := cfg.checkBlackout(); err != nil {
+		t.Errorf("resumed: %v", err)
+	}
+	if ok, err := removePause(dir); ok || err != nil {
+		t.Errorf("removePause when not paused: %v, %v", ok, err)
+	}
+}
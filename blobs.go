@@ -0,0 +1,142 @@
+package pgtool
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// blobEntry matches the pg_restore -l lines of large objects: the objects
+// themselves (BLOB, or BLOB METADATA from PostgreSQL 17), their data
+// (BLOBS, or BLOB DATA) and their ACLs, comments and security labels.
+var blobEntry = regexp.MustCompile(`^\d+; \d+ \d+ (BLOBS? |BLOB METADATA |BLOB DATA |(ACL|COMMENT|SECURITY LABEL) - LARGE OBJECT )`)
+
+// blobOIDEntry captures the OID, or from PostgreSQL 17 the OID range, of
+// each large object entry.
+var blobOIDEntry = regexp.MustCompile(`^\d+; \d+ \d+ BLOB (?:METADATA )?- (\d+(?:\.\.\d+)?) `)
+
+// splitBlobTOC writes entries into two pg_restore -L lists in dir: one of
+// the large object entries and one of everything else.
+func splitBlobTOC(entries []string, dir string) (rest, blobs string, err error) {
+	var restList, blobList strings.Builder
+	for _, e := range entries {
+		if blobEntry.MatchString(e) {
+			fmt.Fprintln(&blobList, e)
+		} else {
+			fmt.Fprintln(&restList, e)
+		}
+	}
+	rest, blobs = filepath.Join(dir, "rest.list"), filepath.Join(dir, "blobs.list")
+	if err := os.WriteFile(rest, []byte(restList.String()), 0600); err != nil {
+		return "", "", ioError(err)
+	}
+	if err := os.WriteFile(blobs, []byte(blobList.String()), 0600); err != nil {
+		return "", "", ioError(err)
+	}
+	return rest, blobs, nil
+}
+
+// restoreBlobList restores just the large object entries listed in list
+// from the archive arg, or stdin, alongside the main restore.
+func restoreBlobList(ctx context.Context, cfg Config, arg string, stdin io.Reader, list string, stderr io.Writer) error {
+	cfg.RestoreArgs = append(cfg.RestoreArgs[:len(cfg.RestoreArgs):len(cfg.RestoreArgs)], "--use-list="+list)
+	cfg.RestoreProgress = false
+	c := Command{Name: "pg_restore", Args: cfg.restoreArgs(arg), Stdin: stdin, Stdout: os.Stdout}
+	return runCommand(ctx, cfg.runner(), c, stderr)
+}
+
+// writeBlobReport writes to path, for each large object in the archive,
+// whether it is new to the target database or replaces one already there.
+// pg_restore keeps the OIDs of large objects, so columns referring to them
+// stay valid; one that already exists under the same OID is overwritten
+// by a cleaning restore and fails any other.
+func writeBlobReport(ctx context.Context, cfg Config, entries []string, path string, logger *log.Logger) error {
+	type span struct{ from, to uint64 }
+	var spans []span
+	var lo, hi uint64
+	for _, e := range entries {
+		m := blobOIDEntry.FindStringSubmatch(e)
+		if m == nil {
+			continue
+		}
+		from, to, _ := strings.Cut(m[1], "..")
+		s := span{}
+		s.from, _ = strconv.ParseUint(from, 10, 32)
+		s.to = s.from
+		if to != "" {
+			s.to, _ = strconv.ParseUint(to, 10, 32)
+		}
+		if len(spans) == 0 || s.from < lo {
+			lo = s.from
+		}
+		hi = max(hi, s.to)
+		spans = append(spans, s)
+	}
+
+	// Large objects already in the target, within the archive's OID range
+	existing := map[uint64]bool{}
+	if len(spans) > 0 {
+		out, err := queryScalar(ctx, cfg, fmt.Sprintf(
+			"SELECT oid FROM pg_catalog.pg_largeobject_metadata WHERE oid BETWEEN %d AND %d", lo, hi))
+		if err != nil {
+			return fmt.Errorf("cannot list existing large objects: %w", err)
+		}
+		for _, line := range strings.Fields(out) {
+			if oid, err := strconv.ParseUint(line, 10, 32); err == nil {
+				existing[oid] = true
+			}
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return ioError(err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "# Large objects keep their OIDs on restore.")
+	fmt.Fprintln(w, "# oid\tstatus (new, or replaced: already in the target)")
+	replaced := 0
+	for _, s := range spans {
+		oid := strconv.FormatUint(s.from, 10)
+		if s.to != s.from {
+			oid += ".." + strconv.FormatUint(s.to, 10)
+		}
+		n := 0
+		if s.to == s.from {
+			if existing[s.from] {
+				n = 1
+			}
+		} else {
+			for o := range existing {
+				if o >= s.from && o <= s.to {
+					n++
+				}
+			}
+		}
+		status := "new"
+		if n > 0 {
+			status = "replaced"
+			if s.to != s.from {
+				status = fmt.Sprintf("replaced %d", n)
+			}
+		}
+		replaced += n
+		fmt.Fprintf(w, "%s\t%s\n", oid, status)
+	}
+	if err := w.Flush(); err != nil {
+		return ioError(err)
+	}
+	logger.Printf("INFO: Wrote large object report '%s': %d entries, %d OID(s) already in the target.", path, len(spans), replaced)
+	if replaced > 0 {
+		logger.Printf("WARNING: %d large object(s) in the archive share their OID with one already in '%s'.", replaced, cfg.target())
+	}
+	return ioError(f.Close())
+}
@@ -0,0 +1,147 @@
+package pgtool
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// CatalogRecord is one backup run in the catalog: the backup directory's
+// manifests, together with the backup runs in the audit log.
+type CatalogRecord struct {
+	// Node is the host that ran the backup, in a shared catalog.
+	Node     string    `json:"node,omitempty"`
+	Database string    `json:"database"`
+	Time     time.Time `json:"time"`
+	// Outcome is "success", "failure", "aborted" or "skipped" as in the
+	// audit log, or "incomplete" for a dump without a manifest.
+	Outcome  string            `json:"outcome"`
+	File     string            `json:"file,omitempty"`
+	Size     int64             `json:"size"`
+	Duration string            `json:"duration,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	// DatabaseSize is the size of the database backed up, if known.
+	DatabaseSize int64 `json:"database_size,omitempty"`
+	// Removed marks a successful backup whose files are no longer in the
+	// backup directory, e.g. pruned.
+	Removed bool `json:"removed,omitempty"`
+	// Slow marks a successful backup that took longer than its
+	// -max-duration.
+	Slow bool `json:"slow,omitempty"`
+}
+
+// backupHistory returns the history of db's backups, or of every
+// database's if db is empty, oldest first. Backups still in dir come from
+// their manifests; failed runs and backups removed since come from the
+// audit log at auditLog, if given.
+func backupHistory(dir, auditLog, db string) ([]CatalogRecord, error) {
+	entries, err := listBackups(dir, ListFilter{Database: db})
+	if err != nil {
+		return nil, err
+	}
+	var records []CatalogRecord
+	inDir := map[string]bool{}
+	for _, e := range entries {
+		outcome := "success"
+		if e.Status != "ok" {
+			outcome = "incomplete"
+		}
+		inDir[e.File] = true
+		records = append(records, CatalogRecord{Database: e.Database, Time: e.Time, Outcome: outcome, File: e.File,
+			Size: e.Size, Duration: e.Duration, DatabaseSize: e.DatabaseSize, Slow: e.Slow, Tags: e.Tags, Labels: e.Labels})
+	}
+
+	if auditLog != "" {
+		f, err := os.Open(auditLog)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64<<10), 1<<20)
+		for n := 1; sc.Scan(); n++ {
+			var a AuditEntry
+			if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+				return nil, fmt.Errorf("%s line %d: %w", auditLog, n, err)
+			}
+			if a.Op != "backup" || (db != "" && a.Database != db) {
+				continue
+			}
+			r := CatalogRecord{Database: a.Database, Time: a.Time, Outcome: a.Status, Error: a.Error}
+			if a.File != "" {
+				r.File = filepath.Base(a.File)
+				if b, err := ParseBackupFilename(r.File); err == nil {
+					r.Time = b.Time
+				}
+			}
+			if a.Status == "success" {
+				if inDir[r.File] {
+					continue
+				}
+				r.Removed = true
+			}
+			records = append(records, r)
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	sortRecords(records)
+	return records, nil
+}
+
+// sortRecords sorts records oldest first.
+func sortRecords(records []CatalogRecord) {
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+}
+
+// writeCatalog writes records to w as a table, CSV or JSON.
+func writeCatalog(w io.Writer, records []CatalogRecord, format string) error {
+	switch format {
+	case "json":
+		if records == nil {
+			records = []CatalogRecord{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"node", "database", "time", "outcome", "size", "duration_seconds", "removed", "tags", "labels", "file", "error", "slow"})
+		for _, r := range records {
+			seconds := ""
+			if d, err := time.ParseDuration(r.Duration); err == nil {
+				seconds = strconv.FormatFloat(d.Seconds(), 'f', 0, 64)
+			}
+			cw.Write([]string{r.Node, r.Database, r.Time.Format(time.RFC3339), r.Outcome, strconv.FormatInt(r.Size, 10),
+				seconds, strconv.FormatBool(r.Removed), strings.Join(r.Tags, ";"), mapFlag(r.Labels).String(), r.File, r.Error, strconv.FormatBool(r.Slow)})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "HOST\tDATABASE\tTIME\tOUTCOME\tSIZE\tDURATION\tFILE\tERROR")
+		for _, r := range records {
+			outcome := r.Outcome
+			if r.Slow {
+				outcome += " (slow)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Node, r.Database, r.Time.Format("2006-01-02 15:04:05"),
+				outcome, formatBytes(r.Size), r.Duration, r.File, r.Error)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("%w: -format must be table, csv or json, not '%s'", ErrUsage, format)
+}
@@ -0,0 +1,117 @@
+package pgtool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// telegramAPIURL is the Telegram Bot API.
+const telegramAPIURL = "https://api.telegram.org"
+
+// runSummary describes r in one line for chat messages.
+func runSummary(r RunResult) string {
+	host, _ := os.Hostname()
+	s := fmt.Sprintf("pgtool %s of %s on %s: %s", r.Op, r.Database, host, r.Status)
+	if r.Database == "" {
+		s = fmt.Sprintf("pgtool %s on %s: %s", r.Op, host, r.Status)
+	}
+	var details []string
+	if r.Status == "success" && r.Bytes > 0 {
+		details = append(details, formatBytes(r.Bytes))
+	}
+	if r.Duration != "" {
+		details = append(details, r.Duration)
+	}
+	if r.Slow {
+		details = append(details, "slow: "+r.Warning)
+	}
+	if len(details) > 0 {
+		s += " (" + strings.Join(details, ", ") + ")"
+	}
+	if r.Error != "" {
+		s += ": " + r.Error
+	}
+	return s
+}
+
+// postJSON sends body as JSON with method to u and fails on any status
+// but 2xx.
+func postJSON(ctx context.Context, method, u string, header map[string]string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return newStatusError(method+" "+req.URL.Redacted(), resp)
+	}
+	return nil
+}
+
+// TelegramNotifier sends a message about every run to a Telegram chat
+// through a bot.
+type TelegramNotifier struct {
+	Token  string // bot token from @BotFather
+	ChatID string // numeric chat ID, or @channelname
+	URL    string // "" means telegramAPIURL
+}
+
+func (n TelegramNotifier) Name() string { return "telegram" }
+
+func (n TelegramNotifier) Notify(ctx context.Context, r RunResult) error {
+	base := n.URL
+	if base == "" {
+		base = telegramAPIURL
+	}
+	err := postJSON(ctx, http.MethodPost, base+"/bot"+n.Token+"/sendMessage", nil, map[string]string{
+		"chat_id": n.ChatID,
+		"text":    runSummary(r),
+	})
+	if err != nil {
+		// The token is part of the URL; keep it out of logs
+		return fmt.Errorf("Telegram: %s", strings.ReplaceAll(err.Error(), n.Token, "REDACTED"))
+	}
+	return nil
+}
+
+// MatrixNotifier sends a message about every run to a Matrix room.
+type MatrixNotifier struct {
+	Homeserver  string // e.g. https://matrix.example.org
+	AccessToken string
+	Room        string // room ID, e.g. !abcdef:example.org
+}
+
+func (n MatrixNotifier) Name() string { return "matrix" }
+
+func (n MatrixNotifier) Notify(ctx context.Context, r RunResult) error {
+	// The transaction ID makes retries of the same message idempotent
+	txn := fmt.Sprintf("pgtool-%s-%s-%d", r.Op, r.Database, r.Finished.UnixNano())
+	if r.Finished.IsZero() {
+		txn = fmt.Sprintf("pgtool-%d", time.Now().UnixNano())
+	}
+	u := strings.TrimSuffix(n.Homeserver, "/") + "/_matrix/client/v3/rooms/" + url.PathEscape(n.Room) +
+		"/send/m.room.message/" + url.PathEscape(txn)
+	return postJSON(ctx, http.MethodPut, u, map[string]string{"Authorization": "Bearer " + n.AccessToken}, map[string]string{
+		"msgtype": "m.text",
+		"body":    runSummary(r),
+	})
+}
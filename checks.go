@@ -0,0 +1,124 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationCheck is a query run against a restored database, with the
+// conditions its single value must meet for the restore to pass.
+//
+//	{"name": "users", "query": "SELECT count(*) FROM users", "min": 1000}
+//	{"name": "fresh orders", "query": "SELECT max(created_at) FROM orders", "max_age": "48h"}
+type ValidationCheck struct {
+	Name  string `json:"name,omitempty"`
+	Query string `json:"query"`
+	// Min and Max bound a numeric result.
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	// Equals is compared with the result as text, e.g. "0" or "t".
+	Equals *string `json:"equals,omitempty"`
+	// MaxAge is the most a timestamp result may lie in the past, as a Go
+	// duration such as "48h".
+	MaxAge string `json:"max_age,omitempty"`
+}
+
+func (vc ValidationCheck) String() string {
+	if vc.Name != "" {
+		return vc.Name
+	}
+	return vc.Query
+}
+
+// validate reports a check that cannot be run or cannot fail.
+func (vc ValidationCheck) validate() error {
+	if strings.TrimSpace(vc.Query) == "" {
+		return fmt.Errorf("%w: check '%s' has no query", ErrUsage, vc)
+	}
+	if vc.Min == nil && vc.Max == nil && vc.Equals == nil && vc.MaxAge == "" {
+		return fmt.Errorf("%w: check '%s' needs min, max, equals or max_age", ErrUsage, vc)
+	}
+	if vc.MaxAge != "" {
+		if _, err := time.ParseDuration(vc.MaxAge); err != nil {
+			return fmt.Errorf("%w: check '%s': invalid max_age: %w", ErrUsage, vc, err)
+		}
+	}
+	return nil
+}
+
+// run runs the check and describes how the result failed it, or returns
+// "" if it passed.
+func (vc ValidationCheck) run(ctx context.Context, cfg Config) (string, error) {
+	query := strings.TrimSuffix(strings.TrimSpace(vc.Query), ";")
+	if vc.MaxAge != "" {
+		// Let the server do the date arithmetic, in its own time zone
+		query = "SELECT extract(epoch FROM now() - (" + query + "))"
+	}
+	out, err := queryScalar(ctx, cfg, query)
+	if err != nil {
+		return "", err
+	}
+	if out == "" {
+		return "returned no value", nil
+	}
+	if vc.Equals != nil && out != *vc.Equals {
+		return fmt.Sprintf("returned %s, want %s", out, *vc.Equals), nil
+	}
+	if vc.MaxAge != "" {
+		secs, err := strconv.ParseFloat(out, 64)
+		if err != nil {
+			return fmt.Sprintf("returned %q, not a timestamp", out), nil
+		}
+		age := time.Duration(secs * float64(time.Second)).Round(time.Second)
+		if maxAge, _ := time.ParseDuration(vc.MaxAge); age > maxAge {
+			return fmt.Sprintf("newest value is %s old, want at most %s", age, vc.MaxAge), nil
+		}
+		return "", nil
+	}
+	if vc.Min != nil || vc.Max != nil {
+		n, err := strconv.ParseFloat(out, 64)
+		if err != nil {
+			return fmt.Sprintf("returned %q, not a number", out), nil
+		}
+		if vc.Min != nil && n < *vc.Min {
+			return fmt.Sprintf("returned %s, want at least %g", out, *vc.Min), nil
+		}
+		if vc.Max != nil && n > *vc.Max {
+			return fmt.Sprintf("returned %s, want at most %g", out, *vc.Max), nil
+		}
+	}
+	return "", nil
+}
+
+// runValidationChecks runs every check against the restored database,
+// logging each result. Failed checks are reported together with
+// ErrVerification.
+func runValidationChecks(ctx context.Context, cfg Config, logger *log.Logger) error {
+	logger.Printf("INFO: Running %d validation check(s).", len(cfg.Checks))
+	fmt.Printf("Running %d validation check(s)...\n", len(cfg.Checks))
+	var failed []string
+	for _, vc := range cfg.Checks {
+		problem, err := vc.run(ctx, cfg)
+		if err != nil {
+			if ctx.Err() != nil {
+				return contextErr(ctx, err)
+			}
+			problem = "query failed: " + err.Error()
+		}
+		if problem != "" {
+			logger.Printf("ERROR: Check '%s' failed: %s", vc, problem)
+			failed = append(failed, fmt.Sprintf("%s: %s", vc, problem))
+			continue
+		}
+		logger.Printf("INFO: Check '%s' passed.", vc)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %d of %d checks failed: %s", ErrVerification, len(failed), len(cfg.Checks), strings.Join(failed, "; "))
+	}
+	logger.Printf("SUCCESS: All %d validation check(s) passed.", len(cfg.Checks))
+	return nil
+}
@@ -0,0 +1,161 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CloneOptions configures a server-to-server copy of one database.
+type CloneOptions struct {
+	FromDSN string // source connection string (URI or key=value)
+	ToDSN   string // target connection string; the database must exist
+	Jobs    int    // >1 dumps and restores in parallel via a temp directory
+	NoOwner bool   // skip ownership and privileges, for differing role layouts
+	Events  EventHandler
+	Runner  Runner
+}
+
+// Clone copies a database from one server to another. With Jobs <= 1,
+// pg_dump is piped straight into pg_restore and nothing touches the
+// disk; parallel mode needs pg_dump's directory format, which it writes
+// to a temporary directory that is removed afterwards.
+func Clone(ctx context.Context, opts CloneOptions, logger *log.Logger) error {
+	if opts.FromDSN == "" || opts.ToDSN == "" {
+		return fmt.Errorf("%w: source and target DSN are required", ErrUsage)
+	}
+	r := opts.Runner
+	if r == nil {
+		r = ExecRunner{}
+	}
+	events := opts.Events
+	if events == nil {
+		events = NopEventHandler{}
+	}
+	from, to := redactDSN(opts.FromDSN), redactDSN(opts.ToDSN)
+	ev := Event{Op: "clone", Database: to, Time: time.Now()}
+	events.OnStart(ev)
+	fail := func(msg string, err error) error {
+		err = contextErr(ctx, err)
+		logger.Printf("ERROR: %s: %v", msg, err)
+		ev.Err, ev.Time = err, time.Now()
+		events.OnError(ev)
+		return err
+	}
+
+	// Pre-flight: both servers must be reachable before anything is dropped
+	for _, dsn := range []string{opts.FromDSN, opts.ToDSN} {
+		if _, err := psqlQuery(ctx, r, []string{"-d", dsn}, "SELECT 1"); err != nil {
+			return fail(fmt.Sprintf("Pre-flight check failed for %s", redactDSN(dsn)), err)
+		}
+	}
+	if size, err := psqlQuery(ctx, r, []string{"-d", opts.FromDSN}, "SELECT pg_database_size(current_database())"); err == nil {
+		if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+			logger.Printf("INFO: Source database size is %s.", formatBytes(n))
+			fmt.Printf("Source database size: %s\n", formatBytes(n))
+		}
+	}
+
+	logger.Printf("INFO: Starting clone from %s to %s.", from, to)
+	fmt.Printf("Cloning %s -> %s...\n", from, to)
+
+	restoreArgs := []string{"-d", opts.ToDSN, "--clean", "--if-exists"}
+	if opts.NoOwner {
+		restoreArgs = append(restoreArgs, "--no-owner", "--no-privileges")
+	}
+
+	var err error
+	if opts.Jobs > 1 {
+		err = cloneParallel(ctx, r, opts, restoreArgs, logger.Writer())
+	} else {
+		ev.Phase = PhaseDump
+		err = clonePipe(ctx, r, opts.FromDSN, restoreArgs, newProgressWriter(io.Discard, events, ev), logger.Writer())
+	}
+	if err != nil {
+		return fail("Clone failed", err)
+	}
+
+	logger.Printf("SUCCESS: Clone completed from %s to %s.", from, to)
+	fmt.Println("Clone completed successfully.")
+	ev.Time = time.Now()
+	events.OnComplete(ev)
+	return nil
+}
+
+// clonePipe streams pg_dump's output straight into pg_restore, teeing it
+// through progress.
+func clonePipe(ctx context.Context, r Runner, fromDSN string, restoreArgs []string, progress, stderr io.Writer) error {
+	pr, pw := io.Pipe()
+	dumpDone := make(chan error, 1)
+	go func() {
+		c := Command{Name: "pg_dump", Args: []string{"-Fc", "-d", fromDSN}, Stdout: io.MultiWriter(pw, progress)}
+		err := runCommand(ctx, r, c, stderr)
+		pw.CloseWithError(err)
+		dumpDone <- err
+	}()
+
+	restoreErr := runCommand(ctx, r, Command{Name: "pg_restore", Args: restoreArgs, Stdin: pr}, stderr)
+	// Unblock pg_dump if pg_restore gave up early
+	pr.CloseWithError(io.ErrClosedPipe)
+	if dumpErr := <-dumpDone; dumpErr != nil {
+		return dumpErr
+	}
+	return restoreErr
+}
+
+// cloneParallel dumps with -j into a temporary directory and restores
+// from it with the same number of jobs.
+func cloneParallel(ctx context.Context, r Runner, opts CloneOptions, restoreArgs []string, stderr io.Writer) error {
+	dir, err := os.MkdirTemp("", "pgtool-clone-")
+	if err != nil {
+		return ioError(err)
+	}
+	defer os.RemoveAll(dir)
+	dumpDir := dir + "/dump"
+
+	jobs := strconv.Itoa(opts.Jobs)
+	dump := Command{Name: "pg_dump", Args: []string{"-Fd", "-j", jobs, "-f", dumpDir, "-d", opts.FromDSN}}
+	if err := runCommand(ctx, r, dump, stderr); err != nil {
+		return err
+	}
+	restore := Command{Name: "pg_restore", Args: append(restoreArgs, "-j", jobs, dumpDir)}
+	return runCommand(ctx, r, restore, stderr)
+}
+
+// psqlQuery runs a single-value query with psql using connArgs to
+// connect and returns the trimmed result.
+func psqlQuery(ctx context.Context, r Runner, connArgs []string, query string) (string, error) {
+	var out strings.Builder
+	c := psqlCommand(connArgs, query)
+	c.Stdout = &out
+	if err := runCommand(ctx, r, c, nil); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// psqlCommand returns the psql command that runs query, printing its
+// result unaligned and without headers.
+func psqlCommand(connArgs []string, query string) Command {
+	return Command{Name: "psql", Args: append(append([]string{}, connArgs...), "-X", "-A", "-t", "-c", query)}
+}
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// redactDSN hides the password in a connection URI or key=value string.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		}
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}xxxxx")
+}
@@ -0,0 +1,9 @@
+// Command pgtool backs up and restores PostgreSQL databases. The work is
+// done by package github.com/aleksi1/pgtool, which programs can import.
+package main
+
+import "github.com/aleksi1/pgtool"
+
+func main() {
+	pgtool.Main()
+}
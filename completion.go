@@ -0,0 +1,217 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// completionTimeout bounds the server and storage lookups made while
+// completing, so a slow or unreachable server never hangs the shell.
+const completionTimeout = 3 * time.Second
+
+// completionScripts hook pgtool __complete into each shell. The shell
+// falls back to file names when pgtool has nothing to offer.
+var completionScripts = map[string]string{
+	"bash": `_pgtool() {
+	local IFS=$'\n'
+	COMPREPLY=($(pgtool __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _pgtool pgtool
+`,
+	"zsh": `#compdef pgtool
+_pgtool() {
+	local -a candidates
+	candidates=("${(@f)$(pgtool __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	if [[ -n "${candidates[1]}" ]]; then
+		compadd -a candidates
+	else
+		_files
+	fi
+}
+compdef _pgtool pgtool
+`,
+}
+
+// groupCommands take a second word naming the operation, as in
+// catalog export.
+var groupCommands = []string{"catalog", "schedule", "config", "completion"}
+
+// dbFlags take database names.
+var dbFlags = []string{"db", "source-db", "other-db"}
+
+// backupFileCommands take a backup file as -file: restore and diff its
+// path, share its name.
+var backupFileCommands = map[string]bool{"restore": true, "diff": true, "share": false}
+
+var flagLine = regexp.MustCompile(`(?m)^  -(\S+)`)
+
+// flagValue returns the value of flag name in args, as in -name v, --name v
+// or -name=v, or def if it is not given.
+func flagValue(args []string, name, def string) string {
+	for i, a := range args {
+		a = strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+		if k, v, ok := strings.Cut(a, "="); ok && k == name {
+			def = v
+		} else if a == name && i+1 < len(args) {
+			def = args[i+1]
+		}
+	}
+	return def
+}
+
+// flagName returns the flag name of word, e.g. "db" for "--db", or "" if
+// word is not a flag.
+func flagName(word string) string {
+	if !strings.HasPrefix(word, "-") {
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(word, "-"), "-")
+}
+
+// complete returns the candidates for the last of words, the arguments
+// typed after pgtool so far.
+func complete(ctx context.Context, words []string) []string {
+	if len(words) == 0 {
+		return nil
+	}
+	cur, prev := words[len(words)-1], ""
+	if len(words) > 1 {
+		prev = words[len(words)-2]
+	}
+	var candidates []string
+	switch {
+	case len(words) == 1:
+		candidates = strings.Split(usage[strings.Index(usage, "<")+1:strings.Index(usage, ">")], "|")
+	case len(words) == 2 && slices.Contains(groupCommands, words[0]):
+		candidates = subcommands(ctx, words[0])
+	case slices.Contains(dbFlags, flagName(prev)):
+		candidates = completeDatabases(ctx, words)
+	case flagName(prev) == "file":
+		if paths, ok := backupFileCommands[words[0]]; ok {
+			candidates = completeBackups(words, paths)
+		}
+	case strings.HasPrefix(cur, "-"):
+		candidates = completeFlags(ctx, words)
+	case words[0] == "info" && !strings.HasPrefix(prev, "-"):
+		candidates = completeBackups(words, false)
+	}
+	var out []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, cur) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// helpOutput runs pgtool with args and -h and returns what it printed.
+func helpOutput(ctx context.Context, args ...string) string {
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	out, _ := exec.CommandContext(ctx, exe, append(args, "-h")...).CombinedOutput()
+	return string(out)
+}
+
+// subcommands returns the operations of a group command, from its usage
+// line, e.g. "Usage: pgtool catalog <export|import|gc> [options]".
+func subcommands(ctx context.Context, command string) []string {
+	if command == "completion" {
+		return slices.Sorted(maps.Keys(completionScripts))
+	}
+	out := helpOutput(ctx, command)
+	start, end := strings.Index(out, "<"), strings.Index(out, ">")
+	if start < 0 || end < start {
+		// A single operation, as in "pgtool config init"
+		f := strings.Fields(out)
+		if i := slices.Index(f, command); i >= 0 && i+1 < len(f) && !strings.HasPrefix(f[i+1], "[") {
+			return []string{f[i+1]}
+		}
+		return nil
+	}
+	return strings.Split(out[start+1:end], "|")
+}
+
+// completeFlags returns the flags of the command in words, as listed by
+// its -h output.
+func completeFlags(ctx context.Context, words []string) []string {
+	args := words[:1]
+	if slices.Contains(groupCommands, words[0]) && len(words) > 2 {
+		args = words[:2]
+	}
+	var flags []string
+	for _, m := range flagLine.FindAllStringSubmatch(helpOutput(ctx, args...), -1) {
+		flags = append(flags, "-"+m[1])
+	}
+	return flags
+}
+
+// completeDatabases lists the databases on the server given by the
+// connection flags in words, or if it cannot be reached, the databases
+// with backups in -backup-dir.
+func completeDatabases(ctx context.Context, words []string) []string {
+	cfg := DefaultConfig()
+	cfg.Host = flagValue(words, "host", cfg.Host)
+	cfg.User = flagValue(words, "user", cfg.User)
+	cfg.BinDir = flagValue(words, "pg-bindir", "")
+	if r, err := parseRunner(flagValue(words, "exec-via", "")); err == nil {
+		cfg.Runner = r
+	}
+	ctx, cancel := context.WithTimeout(ctx, completionTimeout)
+	defer cancel()
+	if names, err := discoverDatabases(ctx, cfg, DiscoveryRules{IncludeSystem: true}); err == nil {
+		return names
+	}
+	entries, err := listBackups(flagValue(words, "backup-dir", cfg.BackupDir), ListFilter{})
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if !slices.Contains(names, e.Database) {
+			names = append(names, e.Database)
+		}
+	}
+	return names
+}
+
+// completeBackups lists the backups in -backup-dir, of -db if given,
+// newest first: as file paths, or as names, and as IDs for info.
+func completeBackups(words []string, paths bool) []string {
+	dir := flagValue(words, "backup-dir", DefaultConfig().BackupDir)
+	entries, err := listBackups(dir, ListFilter{Database: flagValue(words, "db", "")})
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, e := range slices.Backward(entries) {
+		switch {
+		case paths:
+			out = append(out, filepath.Join(dir, e.File))
+		case words[0] == "info":
+			out = append(out, strings.TrimSuffix(e.File, ".dump.gz"))
+		default:
+			out = append(out, e.File)
+		}
+	}
+	return out
+}
+
+// printCompletion prints the completion script for shell.
+func printCompletion(shell string) error {
+	script, ok := completionScripts[shell]
+	if !ok {
+		return fmt.Errorf("%w: no completion for shell '%s' (want bash or zsh)", ErrUsage, shell)
+	}
+	fmt.Print(script)
+	return nil
+}
@@ -0,0 +1,421 @@
+package pgtool
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config holds the connection and dump settings for a backup or restore.
+type Config struct {
+	Database string
+	User     string
+	Host     string
+	// Role, if set, is the role pg_dump and pg_restore switch to with SET
+	// ROLE after connecting as User.
+	Role string
+	// DSN, if set, is a connection string (URI or key=value) used by
+	// restore instead of Database, User and Host.
+	DSN           string
+	BackupDir     string
+	LogFile       string
+	RetentionDays int
+	// RemoteRetentionDays, if positive, deletes this database's backups
+	// older than that from Storage. 0 keeps remote backups forever.
+	RemoteRetentionDays int
+
+	// CompressionLevel is the gzip level; see WithCompression.
+	CompressionLevel int
+
+	// Large object handling; see the -blobs, -no-blobs and -blobs-separate
+	// flags. On restore, NoBlobs skips large objects and BlobsSeparate
+	// restores them alongside everything else in a second pg_restore.
+	Blobs         bool
+	NoBlobs       bool
+	BlobsSeparate bool
+	// BlobReport, if set, is where a restore writes the OIDs of the
+	// archive's large objects and whether each already existed.
+	BlobReport string
+
+	// Tags and Labels are recorded in a backup's manifest, for finding it
+	// again with list, e.g. "pre-migration" or ticket=ENG-1234.
+	Tags   []string
+	Labels map[string]string
+	// SetID is recorded in the manifest as the backup set the backup
+	// belongs to. backup -all gives all its databases one, so that
+	// restore-set can restore them together.
+	SetID string
+
+	// Tables, if set, limits the dump to tables matching these pg_dump
+	// --table patterns, e.g. "events_2024_*".
+	Tables []string
+
+	// ExcludeTableData lists tables, as pg_dump patterns, whose definition
+	// is dumped but whose rows are not, e.g. large audit logs.
+	ExcludeTableData []string
+
+	// ChangedPartitions, if set, names a partitioned table whose leaf
+	// partitions are dumped only if they were written to since the last
+	// backup in BackupDir, judged by their pg_stat_user_tables counters.
+	ChangedPartitions string
+
+	// Tenant, if set, is the one schema a backup dumps, or a restore
+	// expects, in a database with a schema per tenant. Its backups form a
+	// set of their own; see backupSet.
+	Tenant string
+
+	// Priority orders the databases of a fleet or -all run, most urgent
+	// first; see priorities.
+	Priority string
+
+	// Window, if set, is when a backup may start. Outside it, the backup
+	// is skipped, or with WindowDefer waits for the window to open.
+	Window      *Window
+	WindowDefer bool
+	// Blackouts are periods in which backups are skipped, as is a backup
+	// directory paused with schedule pause, unless IgnoreBlackouts is set.
+	Blackouts       []Blackout
+	IgnoreBlackouts bool
+	// MaxDuration, if set, is how long a backup is expected to take at
+	// most. Slower backups are marked slow and notifiers are warned.
+	MaxDuration *DurationLimit
+
+	// InjectFailure, if set to PhaseDump, PhaseUpload or PhaseVerify,
+	// fails that phase of the backup on purpose, so that alerts can be
+	// tested without breaking a real run.
+	InjectFailure string
+
+	// Extensions, if set, limits the extensions dumped to those matching
+	// these pg_dump --extension patterns.
+	Extensions []string
+
+	// SerializableDeferrable makes pg_dump wait for a snapshot that cannot
+	// conflict with serializable transactions. LockTimeout and
+	// StatementTimeout, if positive, are set for the dump session so it
+	// fails instead of queueing behind, or holding up, other locks.
+	SerializableDeferrable bool
+	LockTimeout            time.Duration
+	StatementTimeout       time.Duration
+
+	// DumpArgs and RestoreArgs are extra options appended to the pg_dump
+	// and pg_restore command lines, e.g. --no-comments.
+	DumpArgs    []string
+	RestoreArgs []string
+
+	// Sections limits a restore to some of pre-data, data and
+	// post-data, so schema, rows and indexes can be restored by
+	// separate runs. Empty restores everything.
+	Sections []string
+
+	// DisableTriggers disables triggers, foreign key checks included,
+	// while a data-only restore loads rows, so that tables can be loaded
+	// in any order. Unless the restore connects as a superuser, pg_restore
+	// disables them as Superuser. ValidateForeignKeys checks every foreign
+	// key against the loaded rows afterwards.
+	DisableTriggers     bool
+	Superuser           string
+	ValidateForeignKeys bool
+
+	// AnalyzeAfter gathers planner statistics once a restore that loaded
+	// data has finished.
+	AnalyzeAfter bool
+
+	// IfExists is what a restore does when the target database exists:
+	// IfExistsClean (the default), IfExistsDrop, IfExistsRename or
+	// IfExistsFail. All but IfExistsClean create the database if needed.
+	IfExists string
+
+	// Checks are run against the restored database; the restore fails if
+	// any does not pass.
+	Checks []ValidationCheck
+
+	// Snapshot, if set, is an exported snapshot ID (see pg_export_snapshot)
+	// that every pass of the backup reads from. Backups with more than
+	// one pass export their own when it is empty.
+	Snapshot string
+
+	// Globals adds the cluster's roles and tablespaces, from pg_dumpall
+	// --globals-only, to the backup. WithGlobals restores them, before
+	// the database, from a backup that has them.
+	Globals     bool
+	WithGlobals bool
+
+	// Subset, if set, limits the rows of some tables in the backup. Their
+	// data goes to a separate SQL file instead of the main dump.
+	Subset *SubsetConfig
+
+	// Masking, if set, rewrites COPY data during restore so the target
+	// never receives the masked columns' real values.
+	Masking *MaskConfig
+
+	// RoleMap and TablespaceMap rename roles and tablespaces referenced by
+	// the dump (old name to new) during restore.
+	RoleMap       map[string]string
+	TablespaceMap map[string]string
+
+	// SkipExtensionCheck restores even if the target cannot install the
+	// extensions listed in the backup's manifest.
+	SkipExtensionCheck bool
+
+	// PreBackupSQL and PostBackupSQL are SQL statements run against the
+	// database before pg_dump starts and after the dump ends, whether it
+	// succeeded or not, e.g. CHECKPOINT or toggling a maintenance flag.
+	PreBackupSQL  []string
+	PostBackupSQL []string
+	// HookFailureAborts fails the backup when a hook statement fails
+	// instead of only logging it.
+	HookFailureAborts bool
+
+	// PostRestoreScripts are SQL files, or directories of *.sql files, run
+	// in order against the database after every restore.
+	PostRestoreScripts []string
+	// PostRestoreContinueOnError runs the remaining scripts after one fails
+	// instead of stopping; the restore still reports failure.
+	PostRestoreContinueOnError bool
+
+	// Storage receives a copy of every finished backup. Restore downloads
+	// from the first backend when the backup file is not present locally.
+	Storage []StorageBackend
+
+	// Notifiers are told about the outcome of every run.
+	Notifiers []Notifier
+
+	// SyncWrites fsyncs the backup directory once the files are in place
+	// and reads every file back to check its checksum, for network
+	// filesystems that may lose writes silently. DirectVerify reads them
+	// back bypassing the page cache (Linux dd iflag=direct).
+	SyncWrites   bool
+	DirectVerify bool
+
+	// SpaceFactor is how many times the database size must be free in
+	// BackupDir before a backup starts. 0 skips the check.
+	SpaceFactor float64
+
+	// MinFreeSpace aborts a backup if free space in BackupDir is, or
+	// drops while dumping, below this limit.
+	MinFreeSpace FreeSpaceLimit
+
+	// DumpTimeout, RestoreTimeout and UploadTimeout limit how long
+	// pg_dump, pg_restore and each upload may run, independently of the
+	// context's deadline for the whole run. 0 means no limit.
+	DumpTimeout    time.Duration
+	RestoreTimeout time.Duration
+	UploadTimeout  time.Duration
+
+	// ExitOnError stops pg_restore at the first error. MaxErrors, if
+	// positive, stops it once more than that many errors were reported.
+	ExitOnError bool
+	MaxErrors   int
+
+	// RestoreProgress runs pg_restore verbosely to report the object it
+	// is on, percent done and ETA.
+	RestoreProgress bool
+
+	// FailOnWarnings fails the run when pg_dump or pg_restore print
+	// warnings, even if they exit successfully.
+	FailOnWarnings bool
+
+	// Retry retries pg_dump connection failures, uploads and notifications
+	// that fail transiently. The zero value tries each once.
+	Retry RetryPolicy
+
+	// Events receives lifecycle and progress events. May be nil.
+	Events EventHandler
+
+	// BinDir, if set, is the directory pg_dump, pg_restore and psql are
+	// run from instead of the PATH.
+	BinDir string
+
+	// Runner runs pg_dump, pg_restore and psql. Nil means ExecRunner.
+	Runner Runner
+}
+
+func (c Config) runner() Runner {
+	r := c.Runner
+	if r == nil {
+		r = ExecRunner{}
+	}
+	if c.BinDir != "" {
+		return BinDirRunner{Dir: c.BinDir, Base: r}
+	}
+	return r
+}
+
+func (c Config) events() EventHandler {
+	if c.Events == nil {
+		return NopEventHandler{}
+	}
+	return c.Events
+}
+
+// runEvents returns the handler for a CLI run: the configured Events plus
+// the notifiers and, if OTEL_EXPORTER_OTLP_ENDPOINT is set, a tracer.
+// Notifier and export failures are logged as warnings.
+func (c Config) runEvents(logger *log.Logger) EventHandler {
+	handlers := MultiEventHandler{c.events()}
+	if len(c.Notifiers) > 0 {
+		handlers = append(handlers, &notifyHandler{
+			notifiers: c.Notifiers,
+			retry:     c.Retry,
+			onErr: func(n Notifier, err error) {
+				logger.Printf("WARNING: Notifier %s failed: %v", n.Name(), err)
+			},
+		})
+	}
+	if t := otlpTracerFromEnv(func(err error) { logger.Printf("WARNING: Trace export failed: %v", err) }); t != nil {
+		handlers = append(handlers, t)
+	}
+	if len(handlers) == 1 {
+		return handlers[0]
+	}
+	return handlers
+}
+
+// restoresData reports whether a restore includes the data section, and
+// so the large objects and subset rows kept in separate files.
+func (c Config) restoresData() bool {
+	return len(c.Sections) == 0 || slices.Contains(c.Sections, "data")
+}
+
+// dumpArgs returns the pg_dump arguments for the main dump.
+func (c Config) dumpArgs() []string {
+	args := append(c.dumpConnArgs(), "-Fc")
+	switch {
+	case c.Blobs:
+		args = append(args, "--blobs")
+	case c.NoBlobs || c.BlobsSeparate:
+		args = append(args, "--no-blobs")
+	}
+	for _, t := range c.Tables {
+		args = append(args, "--table="+t)
+	}
+	if c.Tenant != "" {
+		// Quoted, the name is taken literally rather than as a pattern
+		args = append(args, "--schema="+quoteIdent(c.Tenant))
+	}
+	for _, t := range c.ExcludeTableData {
+		args = append(args, "--exclude-table-data="+t)
+	}
+	for _, e := range c.Extensions {
+		args = append(args, "--extension="+e)
+	}
+	if c.Subset != nil {
+		args = append(args, c.Subset.excludeArgs()...)
+	}
+	if c.Snapshot != "" {
+		args = append(args, "--snapshot="+c.Snapshot)
+	}
+	if c.SerializableDeferrable {
+		args = append(args, "--serializable-deferrable")
+	}
+	args = append(args, c.DumpArgs...)
+	return append(args, c.Database)
+}
+
+// dumpCommand returns the pg_dump command with args, passing the session
+// timeouts through PGOPTIONS.
+func (c Config) dumpCommand(args []string) Command {
+	cmd := Command{Name: "pg_dump", Args: args}
+	var opts []string
+	if c.LockTimeout > 0 {
+		opts = append(opts, fmt.Sprintf("-c lock_timeout=%d", c.LockTimeout.Milliseconds()))
+	}
+	if c.StatementTimeout > 0 {
+		opts = append(opts, fmt.Sprintf("-c statement_timeout=%d", c.StatementTimeout.Milliseconds()))
+	}
+	if len(opts) > 0 {
+		if env := os.Getenv("PGOPTIONS"); env != "" {
+			opts = append([]string{env}, opts...)
+		}
+		cmd.Env = []string{"PGOPTIONS=" + strings.Join(opts, " ")}
+	}
+	return cmd
+}
+
+// blobsArgs returns the pg_dump arguments for a large-object-only dump.
+func (c Config) blobsArgs() []string {
+	args := append(c.dumpConnArgs(), "-Fc", "--data-only", "--blobs", "--exclude-schema=*")
+	if c.Snapshot != "" {
+		args = append(args, "--snapshot="+c.Snapshot)
+	}
+	return append(args, c.Database)
+}
+
+// dumpConnArgs returns the pg_dump and pg_dumpall arguments that connect
+// to c's server, as c's role if set.
+func (c Config) dumpConnArgs() []string {
+	args := []string{"-U", c.User, "-h", c.Host}
+	if c.Role != "" {
+		args = append(args, "--role="+c.Role)
+	}
+	return args
+}
+
+// connArgs returns the pg_restore and psql arguments that connect to the
+// configured database.
+func (c Config) connArgs() []string {
+	if c.DSN != "" {
+		return []string{"-d", c.DSN}
+	}
+	return []string{"-U", c.User, "-h", c.Host, "-d", c.Database}
+}
+
+// backupSet names the backups of c in file names and latest pointers:
+// the database, or for a tenant "<db>.<schema>", so that each tenant has
+// its own retention and latest backup.
+func (c Config) backupSet() string {
+	if c.Tenant != "" {
+		return c.Database + "." + c.Tenant
+	}
+	return c.Database
+}
+
+// target names the configured database for messages, hiding any password.
+func (c Config) target() string {
+	if c.DSN != "" {
+		return redactDSN(c.DSN)
+	}
+	return c.Database
+}
+
+// restoreArgs returns the pg_restore arguments to restore file into the
+// configured database. An empty file makes pg_restore read stdin.
+func (c Config) restoreArgs(file string) []string {
+	return append(c.connArgs(), c.restoreOptions(file)...)
+}
+
+// restoreOptions returns the pg_restore arguments of restoreArgs other than
+// the connection, for restoring file either into the database or, with -f,
+// to a SQL script.
+func (c Config) restoreOptions(file string) []string {
+	var args []string
+	if c.cleansTarget() {
+		args = append(args, "--clean") // drop objects before recreating
+	}
+	if c.Role != "" {
+		args = append(args, "--role="+c.Role)
+	}
+	if c.ExitOnError {
+		args = append(args, "--exit-on-error")
+	}
+	if c.RestoreProgress {
+		args = append(args, "--verbose")
+	}
+	for _, s := range c.Sections {
+		args = append(args, "--section="+s)
+	}
+	if c.DisableTriggers {
+		args = append(args, "--disable-triggers")
+		if c.Superuser != "" {
+			args = append(args, "--superuser="+c.Superuser)
+		}
+	}
+	args = append(args, c.RestoreArgs...)
+	if file != "" {
+		args = append(args, file)
+	}
+	return args
+}
@@ -0,0 +1,20 @@
+package pgtool
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDumpConnArgs(t *testing.T) {
+	cfg := Config{User: "pgtool", Host: "db1", Database: "app", Role: "backup"}
+	want := []string{"-U", "pgtool", "-h", "db1", "--role=backup"}
+	for name, args := range map[string][]string{
+		"pg_dump":    cfg.dumpArgs(),
+		"blobs":      cfg.blobsArgs(),
+		"pg_dumpall": globalsCommand(cfg).Args,
+	} {
+		if !slices.Equal(args[:len(want)], want) {
+			t.Errorf("%s args %q, want them to start with %q", name, args, want)
+		}
+	}
+}
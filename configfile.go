@@ -0,0 +1,381 @@
+package pgtool
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ConfigFile is the JSON file given with -config. It holds settings that
+// differ per database and are awkward to repeat on every command line.
+//
+//	{
+//	  "databases": {
+//	    "app": {"exclude_table_data": ["audit_log", "events_*"], "pg_bindir": "/usr/lib/postgresql/16/bin"},
+//	    "app_restore_test": {"checks": [{"query": "SELECT count(*) FROM users", "min": 1000}]}
+//	  },
+//	  "notifiers": [
+//	    {"type": "opsgenie", "api_key": "...", "priority": "P2"}
+//	  ]
+//	}
+type ConfigFile struct {
+	Databases map[string]DatabaseConfig `json:"databases"`
+	Notifiers []NotifierConfig          `json:"notifiers"`
+	// Servers is the fleet backed up by backup -fleet and daemon -fleet.
+	Servers []ServerConfig `json:"servers,omitempty"`
+	// MaxParallelPerHost is the default "max_parallel" of the servers;
+	// MaxParallelTotal, if set, limits the dumps running at once in the
+	// whole fleet.
+	MaxParallelPerHost int `json:"max_parallel_per_host,omitempty"`
+	MaxParallelTotal   int `json:"max_parallel_total,omitempty"`
+	// Blackouts are periods in which no database is backed up.
+	Blackouts []BlackoutConfig `json:"blackouts,omitempty"`
+	// Discovery selects the databases backed up by backup -all and by
+	// servers without a "databases" list.
+	Discovery DiscoveryRules `json:"discovery,omitzero"`
+
+	// flags are the options given on the command line, which win over
+	// the settings of every database.
+	flags map[string]bool
+}
+
+// NotifierConfig selects and configures one alerting provider.
+type NotifierConfig struct {
+	// "pagerduty", "opsgenie", "webhook", "sns", "sqs", "grafana", "statsd",
+	// "dogstatsd", "mqtt", "telegram", "matrix" or "catalog"
+	Type string `json:"type"`
+	// URL overrides the provider's API endpoint, e.g. Opsgenie's EU API.
+	URL string `json:"url,omitempty"`
+
+	// PagerDuty
+	RoutingKey string `json:"routing_key,omitempty"`
+	Severity   string `json:"severity,omitempty"`
+
+	// Opsgenie, and the token of Grafana, Telegram and Matrix
+	APIKey   string `json:"api_key,omitempty"`
+	Priority string `json:"priority,omitempty"`
+
+	// Webhook: Template is a text/template over RunResult producing the
+	// JSON body; Secret signs the body with HMAC-SHA256.
+	Template string            `json:"template,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Secret   string            `json:"secret,omitempty"`
+
+	// SNS and SQS, with credentials from the AWS environment variables
+	TopicARN string `json:"topic_arn,omitempty"`
+	QueueURL string `json:"queue_url,omitempty"`
+
+	// Grafana
+	DashboardUID string   `json:"dashboard_uid,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+
+	// StatsD and DogStatsD; Prefix defaults to "pgtool."
+	Address string  `json:"address,omitempty"`
+	Prefix  *string `json:"prefix,omitempty"`
+
+	// MQTT, with the broker in URL
+	Topic  string `json:"topic,omitempty"`
+	QoS    byte   `json:"qos,omitempty"`
+	Retain bool   `json:"retain,omitempty"`
+
+	// Telegram, and Matrix with its homeserver in URL
+	ChatID string `json:"chat_id,omitempty"`
+	Room   string `json:"room,omitempty"`
+
+	// Catalog, with the shared location in URL; Node names this host
+	// instead of its host name.
+	Node string `json:"node,omitempty"`
+}
+
+// DatabaseConfig holds the config file settings for one database. Each
+// applies unless the option of the same name, such as -retention for
+// Retention, is given on the command line.
+type DatabaseConfig struct {
+	// ExcludeTableData lists pg_dump --exclude-table-data patterns: the
+	// tables' definitions are dumped, their rows are not.
+	ExcludeTableData []string `json:"exclude_table_data,omitempty"`
+	// PgBinDir is the directory of the client tools matching this
+	// database's server.
+	PgBinDir string `json:"pg_bindir,omitempty"`
+	// PagerDutySeverity is the severity of this database's incidents.
+	PagerDutySeverity string `json:"pagerduty_severity,omitempty"`
+	// Checks are run after every restore into this database.
+	Checks []ValidationCheck `json:"checks,omitempty"`
+	// Priority is "critical", "high", "normal" (the default) or "low";
+	// see priorities.
+	Priority string `json:"priority,omitempty"`
+	// Window, e.g. "22:00-06:00", is when this database's backups may
+	// start; WindowDefer waits for it to open instead of skipping the
+	// backup.
+	Window      string `json:"window,omitempty"`
+	WindowDefer bool   `json:"window_defer,omitempty"`
+	// Blackouts are periods in which this database is not backed up.
+	Blackouts []BlackoutConfig `json:"blackouts,omitempty"`
+	// InjectFailure fails this phase of every backup of the database on
+	// purpose, as -inject-failure does, e.g. for a canary database whose
+	// scheduled failures prove that alerts fire.
+	InjectFailure string `json:"inject_failure,omitempty"`
+	// MaxDuration, e.g. "2h" or "1.5x", is how long this database's
+	// backups are expected to take at most.
+	MaxDuration string `json:"max_duration,omitempty"`
+
+	Storage         []string `json:"storage,omitempty"`
+	CompressLevel   *int     `json:"compress_level,omitempty"`
+	Retention       *int     `json:"retention,omitempty"`
+	RemoteRetention *int     `json:"remote_retention,omitempty"`
+	PreBackupSQL    []string `json:"pre_backup_sql,omitempty"`
+	PostBackupSQL   []string `json:"post_backup_sql,omitempty"`
+	HookAbort       *bool    `json:"hook_abort,omitempty"`
+	// Notifiers, if set, replace the top-level notifiers for this
+	// database; an empty list silences it.
+	Notifiers []NotifierConfig `json:"notifiers,omitempty"`
+}
+
+// LoadConfigFile reads a JSON config file.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cf ConfigFile
+	if err := json.Unmarshal(data, &cf); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, bc := range cf.Blackouts {
+		if _, err := bc.parse(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	for _, db := range cf.Databases {
+		for _, bc := range db.Blackouts {
+			if _, err := bc.parse(); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		if err := validatePriority(db.Priority); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if db.Window != "" {
+			if _, err := ParseWindow(db.Window); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		if db.MaxDuration != "" {
+			if _, err := ParseDurationLimit(db.MaxDuration); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		if db.InjectFailure != "" && !slices.Contains(injectPhases, db.InjectFailure) {
+			return nil, fmt.Errorf("%s: %w: inject_failure must be one of %s", path, ErrUsage, strings.Join(injectPhases, ", "))
+		}
+		for _, vc := range db.Checks {
+			if err := vc.validate(); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+	return &cf, nil
+}
+
+// notifiers returns the notifiers configured for runs on database db.
+func (cf *ConfigFile) notifiers(db string) ([]Notifier, error) {
+	ncs := cf.Notifiers
+	if d, ok := cf.Databases[db]; ok && d.Notifiers != nil {
+		ncs = d.Notifiers
+	}
+	var notifiers []Notifier
+	for _, nc := range ncs {
+		switch nc.Type {
+		case "pagerduty":
+			severity := nc.Severity
+			if s := cf.Databases[db].PagerDutySeverity; s != "" {
+				severity = s
+			}
+			severity, err := parsePagerDutySeverity(severity)
+			if err != nil {
+				return nil, err
+			}
+			if nc.RoutingKey == "" {
+				return nil, fmt.Errorf("%w: pagerduty notifier needs a routing_key", ErrUsage)
+			}
+			notifiers = append(notifiers, PagerDutyNotifier{RoutingKey: nc.RoutingKey, Severity: severity, URL: nc.URL})
+		case "opsgenie":
+			if nc.APIKey == "" {
+				return nil, fmt.Errorf("%w: opsgenie notifier needs an api_key", ErrUsage)
+			}
+			switch nc.Priority {
+			case "", "P1", "P2", "P3", "P4", "P5":
+			default:
+				return nil, fmt.Errorf("%w: invalid Opsgenie priority '%s'", ErrUsage, nc.Priority)
+			}
+			notifiers = append(notifiers, OpsgenieNotifier{APIKey: nc.APIKey, Priority: nc.Priority, URL: nc.URL})
+		case "webhook":
+			if nc.URL == "" {
+				return nil, fmt.Errorf("%w: webhook notifier needs a url", ErrUsage)
+			}
+			n := WebhookNotifier{URL: nc.URL, Headers: nc.Headers, Secret: nc.Secret}
+			if nc.Template != "" {
+				t, err := parseWebhookTemplate(nc.Template)
+				if err != nil {
+					return nil, err
+				}
+				n.Template = t
+			}
+			notifiers = append(notifiers, n)
+		case "sns":
+			if nc.TopicARN == "" {
+				return nil, fmt.Errorf("%w: sns notifier needs a topic_arn", ErrUsage)
+			}
+			notifiers = append(notifiers, SNSNotifier{TopicARN: nc.TopicARN, Endpoint: nc.URL})
+		case "sqs":
+			if nc.QueueURL == "" {
+				return nil, fmt.Errorf("%w: sqs notifier needs a queue_url", ErrUsage)
+			}
+			notifiers = append(notifiers, SQSNotifier{QueueURL: nc.QueueURL})
+		case "grafana":
+			if nc.URL == "" || nc.APIKey == "" {
+				return nil, fmt.Errorf("%w: grafana notifier needs a url and an api_key", ErrUsage)
+			}
+			notifiers = append(notifiers, GrafanaNotifier{URL: nc.URL, Token: nc.APIKey, DashboardUID: nc.DashboardUID, Tags: nc.Tags})
+		case "statsd", "dogstatsd":
+			n := StatsDNotifier{Address: nc.Address, Prefix: "pgtool.", DogStatsD: nc.Type == "dogstatsd"}
+			if n.Address == "" {
+				n.Address = "localhost:8125"
+			}
+			if nc.Prefix != nil {
+				n.Prefix = *nc.Prefix
+			}
+			notifiers = append(notifiers, n)
+		case "mqtt":
+			if nc.URL == "" || nc.Topic == "" {
+				return nil, fmt.Errorf("%w: mqtt notifier needs a url and a topic", ErrUsage)
+			}
+			if nc.QoS > 1 {
+				return nil, fmt.Errorf("%w: mqtt notifier supports qos 0 and 1", ErrUsage)
+			}
+			notifiers = append(notifiers, MQTTNotifier{URL: nc.URL, Topic: nc.Topic, QoS: nc.QoS, Retain: nc.Retain})
+		case "telegram":
+			if nc.APIKey == "" || nc.ChatID == "" {
+				return nil, fmt.Errorf("%w: telegram notifier needs an api_key and a chat_id", ErrUsage)
+			}
+			notifiers = append(notifiers, TelegramNotifier{Token: nc.APIKey, ChatID: nc.ChatID, URL: nc.URL})
+		case "matrix":
+			if nc.URL == "" || nc.APIKey == "" || nc.Room == "" {
+				return nil, fmt.Errorf("%w: matrix notifier needs a url, an api_key and a room", ErrUsage)
+			}
+			notifiers = append(notifiers, MatrixNotifier{Homeserver: nc.URL, AccessToken: nc.APIKey, Room: nc.Room})
+		case "catalog":
+			if nc.URL == "" {
+				return nil, fmt.Errorf("%w: catalog notifier needs a url", ErrUsage)
+			}
+			store, err := parseCatalog(nc.URL)
+			if err != nil {
+				return nil, err
+			}
+			notifiers = append(notifiers, CatalogNotifier{Store: store, Node: nc.Node})
+		default:
+			return nil, fmt.Errorf("%w: unknown notifier type '%s'", ErrUsage, nc.Type)
+		}
+	}
+	return notifiers, nil
+}
+
+// apply adds the settings for cfg.Database, and its notifiers, to cfg.
+// A setting replaces the option of the same name in cfg, unless that
+// option was given on the command line; see givenFlags.
+func (cf *ConfigFile) apply(cfg *Config) error {
+	notifiers, err := cf.notifiers(cfg.Database)
+	if err != nil {
+		return err
+	}
+	cfg.Notifiers = append(slices.Clip(cfg.Notifiers), notifiers...)
+	db, ok := cf.Databases[cfg.Database]
+	if !ok {
+		return nil
+	}
+	use := func(names ...string) bool {
+		for _, name := range names {
+			if cf.flags[name] {
+				return false
+			}
+		}
+		return true
+	}
+	if db.ExcludeTableData != nil && use("exclude-table-data") {
+		cfg.ExcludeTableData = db.ExcludeTableData
+	}
+	if db.PgBinDir != "" && use("pg-bindir") {
+		cfg.BinDir = db.PgBinDir
+	}
+	cfg.Checks = append(cfg.Checks, db.Checks...)
+	cfg.Priority = db.Priority
+	if db.Window != "" && use("window") {
+		cfg.Window, _ = ParseWindow(db.Window)
+	}
+	if db.WindowDefer && use("window-defer") {
+		cfg.WindowDefer = true
+	}
+	cfg.Blackouts = append(cfg.Blackouts, parseBlackouts(db.Blackouts)...)
+	if db.MaxDuration != "" && use("max-duration") {
+		cfg.MaxDuration, _ = ParseDurationLimit(db.MaxDuration)
+	}
+	if db.InjectFailure != "" && use("inject-failure") {
+		cfg.InjectFailure = db.InjectFailure
+	}
+	if db.Storage != nil && use("storage", "storage-plugin") {
+		if cfg.Storage, err = storageBackends(db.Storage, nil); err != nil {
+			return fmt.Errorf("database '%s': %w", cfg.Database, err)
+		}
+	}
+	if db.CompressLevel != nil && use("compress-level") {
+		cfg.CompressionLevel = *db.CompressLevel
+	}
+	if db.Retention != nil && use("retention") {
+		cfg.RetentionDays = *db.Retention
+	}
+	if db.RemoteRetention != nil && use("remote-retention") {
+		cfg.RemoteRetentionDays = *db.RemoteRetention
+	}
+	if db.PreBackupSQL != nil && use("pre-backup-sql") {
+		cfg.PreBackupSQL = db.PreBackupSQL
+	}
+	if db.PostBackupSQL != nil && use("post-backup-sql") {
+		cfg.PostBackupSQL = db.PostBackupSQL
+	}
+	if db.HookAbort != nil && use("hook-abort") {
+		cfg.HookFailureAborts = *db.HookAbort
+	}
+	return nil
+}
+
+// pagerDutySeverity returns the severity of db's PagerDuty incidents:
+// flag if given on the command line, or else the config file's.
+func (cf *ConfigFile) pagerDutySeverity(db, flag string) string {
+	if cf.flags["pagerduty-severity"] {
+		return flag
+	}
+	if s := cf.Databases[db].PagerDutySeverity; s != "" {
+		return s
+	}
+	return flag
+}
+
+// givenFlags records the options given on the command line of fs, so that
+// they win over the config file's settings.
+func (cf *ConfigFile) givenFlags(fs *flag.FlagSet) {
+	cf.flags = map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { cf.flags[f.Name] = true })
+}
+
+// parseBlackouts parses blackouts already checked by LoadConfigFile.
+func parseBlackouts(bcs []BlackoutConfig) []Blackout {
+	var out []Blackout
+	for _, bc := range bcs {
+		b, _ := bc.parse()
+		out = append(out, b)
+	}
+	return out
+}
@@ -0,0 +1,96 @@
+package pgtool
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestConfigFileFlagsWin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pgtool.json")
+	err := os.WriteFile(path, []byte(`{"databases": {"billing": {
+		"retention": 90, "remote_retention": 2555, "compress_level": 9,
+		"pg_bindir": "/usr/lib/postgresql/16/bin", "window": "22:00-06:00",
+		"max_duration": "2h", "exclude_table_data": ["invoice_log"],
+		"pre_backup_sql": ["CHECKPOINT"], "hook_abort": true,
+		"pagerduty_severity": "warning"
+	}}}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		args  []string
+		check func(t *testing.T, cfg Config)
+	}{
+		{"config file", nil, func(t *testing.T, cfg Config) {
+			if cfg.RetentionDays != 90 || cfg.RemoteRetentionDays != 2555 || cfg.CompressionLevel != 9 {
+				t.Errorf("retention %d, remote retention %d, compress level %d, want 90, 2555, 9",
+					cfg.RetentionDays, cfg.RemoteRetentionDays, cfg.CompressionLevel)
+			}
+			if cfg.BinDir != "/usr/lib/postgresql/16/bin" || cfg.Window == nil || cfg.MaxDuration == nil || cfg.MaxDuration.Max != 2*time.Hour {
+				t.Errorf("bindir %q, window %v, max duration %v, want the config file's", cfg.BinDir, cfg.Window, cfg.MaxDuration)
+			}
+			if !slices.Equal(cfg.ExcludeTableData, []string{"invoice_log"}) || !slices.Equal(cfg.PreBackupSQL, []string{"CHECKPOINT"}) || !cfg.HookFailureAborts {
+				t.Errorf("exclude %q, pre-backup %q, hook abort %v, want the config file's", cfg.ExcludeTableData, cfg.PreBackupSQL, cfg.HookFailureAborts)
+			}
+		}},
+		{"flags", []string{
+			"-retention", "14", "-remote-retention", "0", "-compress-level", "1",
+			"-pg-bindir", "/opt/pg/bin", "-window", "01:00-02:00", "-max-duration", "1.5x",
+			"-exclude-table-data", "audit_log", "-pre-backup-sql", "SELECT 1", "-hook-abort=false",
+		}, func(t *testing.T, cfg Config) {
+			if cfg.RetentionDays != 14 || cfg.RemoteRetentionDays != 0 || cfg.CompressionLevel != 1 {
+				t.Errorf("retention %d, remote retention %d, compress level %d, want 14, 0, 1",
+					cfg.RetentionDays, cfg.RemoteRetentionDays, cfg.CompressionLevel)
+			}
+			if cfg.BinDir != "/opt/pg/bin" || cfg.Window.String() != "01:00-02:00" || cfg.MaxDuration.Factor != 1.5 {
+				t.Errorf("bindir %q, window %v, max duration %v, want the flags'", cfg.BinDir, cfg.Window, cfg.MaxDuration)
+			}
+			if !slices.Equal(cfg.ExcludeTableData, []string{"audit_log"}) || !slices.Equal(cfg.PreBackupSQL, []string{"SELECT 1"}) || cfg.HookFailureAborts {
+				t.Errorf("exclude %q, pre-backup %q, hook abort %v, want the flags'", cfg.ExcludeTableData, cfg.PreBackupSQL, cfg.HookFailureAborts)
+			}
+		}},
+		{"flag equal to its default", []string{"-retention", "7"}, func(t *testing.T, cfg Config) {
+			if cfg.RetentionDays != 7 || cfg.CompressionLevel != 9 {
+				t.Errorf("retention %d, compress level %d, want 7 from the flag and 9 from the config file", cfg.RetentionDays, cfg.CompressionLevel)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+			build := backupFlags(fs)
+			if err := fs.Parse(append([]string{"-db", "billing", "-config", path, "-log-file", os.DevNull}, tt.args...)); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestConfigFilePagerDutySeverity(t *testing.T) {
+	cf := &ConfigFile{Databases: map[string]DatabaseConfig{"analytics": {PagerDutySeverity: "warning"}}}
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.String("pagerduty-severity", "", "")
+	cf.givenFlags(fs)
+	if got := cf.pagerDutySeverity("analytics", ""); got != "warning" {
+		t.Errorf("without the flag: %q, want warning", got)
+	}
+	if got := cf.pagerDutySeverity("other", ""); got != "" {
+		t.Errorf("other database: %q, want the default", got)
+	}
+	fs.Parse([]string{"-pagerduty-severity", "critical"})
+	cf.givenFlags(fs)
+	if got := cf.pagerDutySeverity("analytics", "critical"); got != "critical" {
+		t.Errorf("with the flag: %q, want critical", got)
+	}
+}
@@ -0,0 +1,265 @@
+package pgtool
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ConfigInit holds the answers config init builds a starter config file
+// from: one server, the databases to back up on it and where to send
+// their backups and alerts.
+type ConfigInit struct {
+	Host        string
+	User        string
+	PasswordEnv string
+	// Databases are backed up by name; empty discovers every database on
+	// the server at run time.
+	Databases       []string
+	Storage         []string
+	Retention       int
+	RemoteRetention int
+	PagerDutyKey    string
+	WebhookURL      string
+}
+
+// prompter asks for the settings config init was not given as flags.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask prints question and returns the answer, or def if it is empty.
+func (p prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", fmt.Errorf("%w: no answer to %q", ErrUsage, question)
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+// askList asks for a comma-separated list.
+func (p prompter) askList(question string, def []string) ([]string, error) {
+	answer, err := p.ask(question, strings.Join(def, ","))
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	for _, s := range strings.Split(answer, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list, nil
+}
+
+// askInt asks for a number of days or similar.
+func (p prompter) askInt(question string, def int) (int, error) {
+	for {
+		answer, err := p.ask(question, strconv.Itoa(def))
+		if err != nil {
+			return 0, err
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 0 {
+			return n, nil
+		}
+		fmt.Fprintln(p.out, "Please enter a whole number.")
+	}
+}
+
+// prompt asks for every setting on out, reading the answers from in and
+// offering the current value of ci as the default.
+func (ci *ConfigInit) prompt(in io.Reader, out io.Writer) error {
+	p := prompter{in: bufio.NewReader(in), out: out}
+	var err error
+	fmt.Fprintln(p.out, "Press Enter to accept the [default].")
+	if ci.Host, err = p.ask("PostgreSQL host", ci.Host); err != nil {
+		return err
+	}
+	if ci.User, err = p.ask("PostgreSQL user", ci.User); err != nil {
+		return err
+	}
+	if ci.PasswordEnv, err = p.ask("Environment variable holding the password (empty for ~/.pgpass)", ci.PasswordEnv); err != nil {
+		return err
+	}
+	if names := ci.serverDatabases(); len(names) > 0 {
+		fmt.Fprintf(p.out, "Databases on %s: %s\n", ci.Host, strings.Join(names, ", "))
+	}
+	if ci.Databases, err = p.askList("Databases to back up, comma-separated (empty for all)", ci.Databases); err != nil {
+		return err
+	}
+	if ci.Retention, err = p.askInt("Days to keep local backups", ci.Retention); err != nil {
+		return err
+	}
+	if ci.Storage, err = p.askList("Remote storage, e.g. rclone:offsite:pg or b2://bucket/pg (empty for none)", ci.Storage); err != nil {
+		return err
+	}
+	if len(ci.Storage) > 0 {
+		if ci.RemoteRetention, err = p.askInt("Days to keep remote backups (0 to keep them)", ci.RemoteRetention); err != nil {
+			return err
+		}
+	}
+	if ci.PagerDutyKey, err = p.ask("PagerDuty routing key to page on failure (empty for none)", ci.PagerDutyKey); err != nil {
+		return err
+	}
+	ci.WebhookURL, err = p.ask("Webhook URL to post every run to, e.g. a Slack workflow (empty for none)", ci.WebhookURL)
+	return err
+}
+
+// serverDatabases lists the databases on ci's server, so the prompt can
+// offer them, or returns nil if it cannot be reached in time.
+func (ci ConfigInit) serverDatabases() []string {
+	cfg := DefaultConfig()
+	cfg.Host, cfg.User = ci.Host, ci.User
+	if ci.PasswordEnv != "" {
+		cfg.Runner = EnvRunner{Env: []string{"PGPASSWORD=" + os.Getenv(ci.PasswordEnv)}, Base: ExecRunner{}}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+	names, err := discoverDatabases(ctx, cfg, DiscoveryRules{})
+	if err != nil {
+		return nil
+	}
+	return names
+}
+
+// configFile returns the config file for ci's answers.
+func (ci ConfigInit) configFile() *ConfigFile {
+	cf := &ConfigFile{Databases: map[string]DatabaseConfig{}, Notifiers: []NotifierConfig{}}
+	cf.Servers = []ServerConfig{{
+		Name:        ci.Host,
+		Host:        ci.Host,
+		User:        ci.User,
+		PasswordEnv: ci.PasswordEnv,
+		Databases:   ci.Databases,
+		Storage:     ci.Storage,
+	}}
+	for _, db := range ci.Databases {
+		dc := DatabaseConfig{Retention: &ci.Retention}
+		if len(ci.Storage) > 0 && ci.RemoteRetention > 0 {
+			dc.RemoteRetention = &ci.RemoteRetention
+		}
+		cf.Databases[db] = dc
+	}
+	if ci.PagerDutyKey != "" {
+		cf.Notifiers = append(cf.Notifiers, NotifierConfig{Type: "pagerduty", RoutingKey: ci.PagerDutyKey})
+	}
+	if ci.WebhookURL != "" {
+		cf.Notifiers = append(cf.Notifiers, NotifierConfig{Type: "webhook", URL: ci.WebhookURL})
+	}
+	return cf
+}
+
+// validateConfigFile checks the config file at path: the settings of its
+// servers, and the storage and notifiers of each of its databases. Unlike
+// backup -fleet it creates no directories and does not need the servers'
+// password variables set, as they may only be set where pgtool runs.
+func validateConfigFile(path string) error {
+	cf, err := LoadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	for _, sc := range cf.Servers {
+		if sc.Name == "" || sc.Host == "" {
+			return fmt.Errorf("%w: every server needs a name and a host", ErrUsage)
+		}
+		if _, err := parseRunner(sc.ExecVia); err != nil {
+			return fmt.Errorf("server '%s': %w", sc.Name, err)
+		}
+		if _, err := storageBackends(sc.Storage, nil); err != nil {
+			return fmt.Errorf("server '%s': %w", sc.Name, err)
+		}
+	}
+	for db := range cf.Databases {
+		cfg := DefaultConfig()
+		cfg.Database = db
+		if err := cf.apply(&cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeConfigInit writes cf to path once it validates. An existing file
+// is only replaced if force is set.
+func writeConfigInit(path string, cf *ConfigFile, force bool) error {
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%w: %s already exists (-force replaces it)", ErrUsage, path)
+	}
+	data, err := json.MarshalIndent(cf, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".pgtool-config-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := validateConfigFile(tmp.Name()); err != nil {
+		return fmt.Errorf("generated config does not validate: %w", err)
+	}
+	// The file may hold a routing key
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// printConfigGuide explains the config file written to path, since JSON
+// has no room for comments.
+func printConfigGuide(w io.Writer, path string, ci ConfigInit) {
+	fmt.Fprintf(w, "\nWrote %s:\n\n", path)
+	fmt.Fprintf(w, "  servers      how to reach %s; backups go to a %s/ subdirectory of -backup-dir\n", ci.Host, ci.Host)
+	if len(ci.Databases) == 0 {
+		fmt.Fprintln(w, "               with no \"databases\" list, every database is discovered at run time")
+	} else {
+		fmt.Fprintf(w, "  databases    per-database settings: local retention of %d days", ci.Retention)
+		if len(ci.Storage) > 0 && ci.RemoteRetention > 0 {
+			fmt.Fprintf(w, ", %d days remote", ci.RemoteRetention)
+		}
+		fmt.Fprintln(w)
+	}
+	if len(ci.Storage) > 0 {
+		fmt.Fprintf(w, "  storage      uploads to %s\n", strings.Join(ci.Storage, ", "))
+	}
+	if ci.PagerDutyKey != "" {
+		fmt.Fprintln(w, "  notifiers    pages PagerDuty on failure")
+	}
+	if ci.WebhookURL != "" {
+		fmt.Fprintln(w, "  notifiers    posts the outcome of every run to the webhook")
+	}
+
+	// Discovered databases have no entry to carry the retention
+	args := "-fleet -config " + quoteWord(path)
+	if len(ci.Databases) == 0 {
+		args += fmt.Sprintf(" -retention %d", ci.Retention)
+		if len(ci.Storage) > 0 && ci.RemoteRetention > 0 {
+			args += fmt.Sprintf(" -remote-retention %d", ci.RemoteRetention)
+		}
+	}
+	fmt.Fprintf(w, "\nThe README describes the other settings. Back up now, or nightly, with:\n\n")
+	fmt.Fprintf(w, "  pgtool backup %s\n", args)
+	fmt.Fprintf(w, "  pgtool daemon %s -schedule '0 2 * * *'\n", args)
+}
@@ -0,0 +1,142 @@
+package pgtool
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// CopyTableOptions configures copying individual tables between servers.
+type CopyTableOptions struct {
+	FromDSN  string
+	ToDSN    string
+	Tables   []string // schema-qualified names, e.g. "public.orders"
+	Truncate bool     // empty each target table before loading
+	// BatchSize commits every BatchSize rows instead of loading each table
+	// in a single transaction. 0 means one transaction per table.
+	BatchSize int
+	// DeferConstraints runs SET CONSTRAINTS ALL DEFERRED in every load
+	// transaction, so deferrable foreign keys are checked at commit.
+	DeferConstraints bool
+	Runner           Runner
+}
+
+// CopyTables streams each table with COPY TO STDOUT on the source and
+// COPY FROM STDIN on the target, without staging data on disk.
+func CopyTables(ctx context.Context, opts CopyTableOptions, logger *log.Logger) error {
+	if opts.FromDSN == "" || opts.ToDSN == "" || len(opts.Tables) == 0 {
+		return fmt.Errorf("%w: source DSN, target DSN and at least one table are required", ErrUsage)
+	}
+	r := opts.Runner
+	if r == nil {
+		r = ExecRunner{}
+	}
+	for _, table := range opts.Tables {
+		start := time.Now()
+		logger.Printf("INFO: Copying table %s from %s to %s.", table, redactDSN(opts.FromDSN), redactDSN(opts.ToDSN))
+		fmt.Printf("Copying %s...\n", table)
+		rows, err := copyTable(ctx, r, opts, quoteQualified(table), logger.Writer())
+		if err != nil {
+			err = contextErr(ctx, err)
+			logger.Printf("ERROR: Copy of table %s failed: %v", table, err)
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+		logger.Printf("SUCCESS: Copied %d rows of %s in %s.", rows, table, time.Since(start).Round(time.Second))
+		fmt.Printf("Copied %d rows of %s.\n", rows, table)
+	}
+	return nil
+}
+
+// copyTable pipes COPY output from the source into a psql script on the
+// target that loads it in one or more transactions. It returns the number
+// of rows copied.
+func copyTable(ctx context.Context, r Runner, opts CopyTableOptions, table string, stderr io.Writer) (int64, error) {
+	srcR, srcW := io.Pipe()
+	scriptR, scriptW := io.Pipe()
+
+	srcDone := make(chan error, 1)
+	go func() {
+		c := Command{Name: "psql", Args: []string{"-X", "-d", opts.FromDSN, "-c", "COPY " + table + " TO STDOUT"}, Stdout: srcW}
+		err := runCommand(ctx, r, c, stderr)
+		srcW.CloseWithError(err)
+		srcDone <- err
+	}()
+
+	var rows int64
+	go func() {
+		var err error
+		rows, err = writeCopyScript(scriptW, srcR, table, opts)
+		scriptW.CloseWithError(err)
+		srcR.CloseWithError(io.ErrClosedPipe)
+	}()
+
+	c := Command{Name: "psql", Args: []string{"-X", "-q", "-v", "ON_ERROR_STOP=1", "-d", opts.ToDSN, "-f", "-"}, Stdin: scriptR}
+	loadErr := runCommand(ctx, r, c, stderr)
+	scriptR.CloseWithError(io.ErrClosedPipe)
+	if err := <-srcDone; err != nil {
+		return rows, err
+	}
+	return rows, loadErr
+}
+
+// writeCopyScript wraps the COPY text rows read from src in the SQL needed
+// to load them into table, starting a new transaction every BatchSize rows.
+func writeCopyScript(w io.Writer, src io.Reader, table string, opts CopyTableOptions) (int64, error) {
+	bw := bufio.NewWriterSize(w, 256*1024)
+	begin := func(first bool) {
+		bw.WriteString("BEGIN;\n")
+		if opts.DeferConstraints {
+			bw.WriteString("SET CONSTRAINTS ALL DEFERRED;\n")
+		}
+		if first && opts.Truncate {
+			bw.WriteString("TRUNCATE " + table + ";\n")
+		}
+		bw.WriteString("COPY " + table + " FROM STDIN;\n")
+	}
+	end := func() {
+		bw.WriteString("\\.\nCOMMIT;\n")
+	}
+
+	begin(true)
+	br := bufio.NewReaderSize(src, 256*1024)
+	var rows, inBatch int64
+	for {
+		// Text-format COPY escapes embedded newlines, so one line is one row.
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			if opts.BatchSize > 0 && inBatch == int64(opts.BatchSize) {
+				end()
+				begin(false)
+				inBatch = 0
+			}
+			bw.WriteString(line)
+			rows++
+			inBatch++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return rows, err
+		}
+	}
+	end()
+	return rows, bw.Flush()
+}
+
+// quoteQualified quotes a possibly schema-qualified table name. Names that
+// already contain double quotes are used as given.
+func quoteQualified(name string) string {
+	if strings.Contains(name, `"`) {
+		return name
+	}
+	parts := strings.SplitN(name, ".", 2)
+	for i, p := range parts {
+		parts[i] = `"` + p + `"`
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,167 @@
+package pgtool
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed five-field cron expression:
+// minute hour day-of-month month day-of-week.
+type Schedule struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64 // bit sets of allowed values
+	domStar, dowStar              bool
+	hourStar                      bool // hour is * or */n
+}
+
+// ParseSchedule parses a standard cron expression such as "30 2 * * 1-5".
+// Fields accept *, lists, ranges and steps ("*/15", "1-10/2"). The
+// shorthands @hourly, @daily, @weekly and @monthly are also accepted.
+func ParseSchedule(expr string) (Schedule, error) {
+	switch expr {
+	case "@hourly":
+		expr = "0 * * * *"
+	case "@daily", "@midnight":
+		expr = "0 0 * * *"
+	case "@weekly":
+		expr = "0 0 * * 0"
+	case "@monthly":
+		expr = "0 0 1 * *"
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("cron expression %q: want 5 fields, got %d", expr, len(fields))
+	}
+	s := Schedule{expr: expr, domStar: fields[2] == "*", dowStar: fields[4] == "*", hourStar: strings.HasPrefix(fields[1], "*")}
+	var err error
+	bounds := []struct {
+		dst      *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		if *b.dst, err = parseCronField(fields[i], b.min, b.max); err != nil {
+			return Schedule{}, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+	}
+	// Sunday may be written as 0 or 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("bad value in %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("bad range in %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// String returns the expression the schedule was parsed from.
+func (s Schedule) String() string { return s.expr }
+
+// Next returns the first time after t that matches the schedule, or the
+// zero time if there is none within five years. Times are matched on the
+// wall clock of t's location. When clocks go forward, the skipped times do
+// not match; when they go back, the repeated times match only once, unless
+// the hour field is * or */n.
+func (s Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		y, m, d := t.Date()
+		if s.month&(1<<uint(m)) == 0 {
+			t = startOfHour(t, y, m+1, 1, 0)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = startOfHour(t, y, m, d+1, 0)
+			continue
+		}
+		h := t.Hour()
+		if s.hour&(1<<uint(h)) == 0 || !s.hourStar && repeated(t) {
+			t = startOfHour(t, y, m, d, h+1)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// startOfHour returns the start of hour h of the given day, normalized as
+// by time.Date, in t's location. If clocks go forward over h:00, which
+// time.Date resolves to a time before t, it returns the start of the next
+// hour instead.
+func startOfHour(t time.Time, y int, m time.Month, d, h int) time.Time {
+	next := time.Date(y, m, d, h, 0, 0, 0, t.Location())
+	if !next.After(t) {
+		next = time.Date(y, m, d, h+1, 0, 0, 0, t.Location())
+	}
+	return next
+}
+
+// repeated reports whether the wall-clock time of t occurred before, as
+// when clocks go back.
+func repeated(t time.Time) bool {
+	y, m, d := t.Date()
+	return !time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, t.Location()).Equal(t)
+}
+
+// dayMatches applies cron's rule that when both day-of-month and
+// day-of-week are restricted, either may match.
+func (s Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dow
+	case s.dowStar:
+		return dom
+	}
+	return dom || dow
+}
@@ -0,0 +1,85 @@
+package pgtool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	for _, expr := range []string{"* * * * *", "30 2 * * 1-5", "*/15 0-6/2 1,15 * 7", "@daily", "@hourly"} {
+		if _, err := ParseSchedule(expr); err != nil {
+			t.Errorf("ParseSchedule(%q): %v", expr, err)
+		}
+	}
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	load := func(name string) *time.Location {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Skipf("no time zone %s: %v", name, err)
+		}
+		return loc
+	}
+	utc := time.UTC
+	kolkata := load("Asia/Kolkata")
+	kathmandu := load("Asia/Kathmandu")
+	newYork := load("America/New_York")
+	saoPaulo := load("America/Sao_Paulo")
+
+	tests := []struct {
+		name, expr string
+		from, want time.Time
+	}{
+		{"next minute", "* * * * *",
+			time.Date(2026, 5, 1, 10, 17, 30, 0, utc), time.Date(2026, 5, 1, 10, 18, 0, 0, utc)},
+		{"later today", "30 2 * * *",
+			time.Date(2026, 5, 1, 1, 0, 0, 0, utc), time.Date(2026, 5, 1, 2, 30, 0, 0, utc)},
+		{"tomorrow", "30 2 * * *",
+			time.Date(2026, 5, 1, 2, 30, 0, 0, utc), time.Date(2026, 5, 2, 2, 30, 0, 0, utc)},
+		{"weekday", "0 3 * * 1-5",
+			time.Date(2026, 5, 1, 4, 0, 0, 0, utc), time.Date(2026, 5, 4, 3, 0, 0, 0, utc)}, // Friday to Monday
+		{"day of month or week", "0 0 13 * 5",
+			time.Date(2026, 5, 9, 0, 0, 0, 0, utc), time.Date(2026, 5, 13, 0, 0, 0, 0, utc)},
+		{"next month", "0 0 1 * *",
+			time.Date(2026, 12, 15, 0, 0, 0, 0, utc), time.Date(2027, 1, 1, 0, 0, 0, 0, utc)},
+		{"leap day", "0 0 29 2 *",
+			time.Date(2026, 3, 1, 0, 0, 0, 0, utc), time.Date(2028, 2, 29, 0, 0, 0, 0, utc)},
+		{"half-hour zone", "0 * * * *",
+			time.Date(2026, 5, 1, 10, 17, 0, 0, kolkata), time.Date(2026, 5, 1, 11, 0, 0, 0, kolkata)},
+		{"half-hour zone daily", "0 3 * * *",
+			time.Date(2026, 5, 1, 10, 17, 0, 0, kolkata), time.Date(2026, 5, 2, 3, 0, 0, 0, kolkata)},
+		{"quarter-hour zone", "0 3 * * *",
+			time.Date(2026, 5, 1, 10, 17, 0, 0, kathmandu), time.Date(2026, 5, 2, 3, 0, 0, 0, kathmandu)},
+		{"clocks go forward", "0 * * * *",
+			time.Date(2026, 3, 8, 1, 30, 0, 0, newYork), time.Date(2026, 3, 8, 3, 0, 0, 0, newYork)},
+		{"skipped time does not match", "30 2 * * *",
+			time.Date(2026, 3, 8, 1, 0, 0, 0, newYork), time.Date(2026, 3, 9, 2, 30, 0, 0, newYork)},
+		{"clocks go forward at midnight", "0 12 4 11 *",
+			time.Date(2018, 11, 3, 12, 0, 0, 0, saoPaulo), time.Date(2018, 11, 4, 12, 0, 0, 0, saoPaulo)},
+		{"clocks go back", "30 1 * * *",
+			time.Date(2026, 11, 1, 0, 0, 0, 0, newYork), time.Date(2026, 11, 1, 1, 30, 0, 0, newYork)}, // EDT
+		{"repeated time matches once", "30 1 * * *",
+			time.Date(2026, 11, 1, 1, 30, 0, 0, newYork), time.Date(2026, 11, 2, 1, 30, 0, 0, newYork)},
+		{"repeated hour with * hour", "30 * * * *",
+			time.Date(2026, 11, 1, 1, 30, 0, 0, newYork), time.Date(2026, 11, 1, 1, 30, 0, 0, newYork).Add(time.Hour)}, // EST
+		{"never", "0 0 30 2 *",
+			time.Date(2026, 1, 1, 0, 0, 0, 0, utc), time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) of %q = %s, want %s", tt.from, tt.expr, got, tt.want)
+			}
+		})
+	}
+}
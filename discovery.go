@@ -0,0 +1,102 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DiscoveryRules select the databases to back up when they are discovered
+// on the server at run time: those matching any Include regexp, or all if
+// there is none, unless they match an Exclude regexp. The regexps are not
+// anchored; "^staging_" matches names starting with "staging_". The system
+// databases are skipped unless IncludeSystem is set.
+type DiscoveryRules struct {
+	Include       []string `json:"include,omitempty"`
+	Exclude       []string `json:"exclude,omitempty"`
+	IncludeSystem bool     `json:"include_system,omitempty"`
+}
+
+// systemDatabases are created by initdb and hold nothing worth a backup:
+// template0 does not even accept connections.
+var systemDatabases = []string{"template0", "template1", "postgres"}
+
+// merge returns r with the patterns of o added, including the system
+// databases if either does.
+func (r DiscoveryRules) merge(o DiscoveryRules) DiscoveryRules {
+	return DiscoveryRules{
+		Include: append(r.Include[:len(r.Include):len(r.Include)], o.Include...),
+		Exclude: append(r.Exclude[:len(r.Exclude):len(r.Exclude)], o.Exclude...),
+
+		IncludeSystem: r.IncludeSystem || o.IncludeSystem,
+	}
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad database pattern '%s': %w", ErrUsage, p, err)
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
+func (r DiscoveryRules) validate() error {
+	if _, err := compilePatterns(r.Include); err != nil {
+		return err
+	}
+	_, err := compilePatterns(r.Exclude)
+	return err
+}
+
+// filter returns the names that r selects, in order.
+func (r DiscoveryRules) filter(names []string) ([]string, error) {
+	include, err := compilePatterns(r.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compilePatterns(r.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	matches := func(res []*regexp.Regexp, name string) bool {
+		for _, re := range res {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+		return false
+	}
+	var out []string
+	for _, name := range names {
+		if !r.IncludeSystem && slices.Contains(systemDatabases, name) {
+			continue
+		}
+		if (len(include) == 0 || matches(include, name)) && !matches(exclude, name) {
+			out = append(out, name)
+		}
+	}
+	return out, nil
+}
+
+// discoverDatabases lists the databases on cfg's server that accept
+// connections and that rules select, in name order.
+func discoverDatabases(ctx context.Context, cfg Config, rules DiscoveryRules) ([]string, error) {
+	out, err := queryScalar(ctx, cfg.maintenance(),
+		"SELECT datname FROM pg_catalog.pg_database WHERE datallowconn ORDER BY 1")
+	if err != nil {
+		return nil, fmt.Errorf("cannot list databases on %s: %w", cfg.Host, err)
+	}
+	var names []string
+	for _, name := range strings.Split(out, "\n") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return rules.filter(names)
+}
@@ -0,0 +1,72 @@
+package pgtool
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// syncDir fsyncs a directory so that files renamed into it survive a
+// crash of the machine or, on network filesystems, of the client.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return ioError(d.Sync())
+}
+
+// verifyWrites syncs dir and reads back each file in checksums (names in
+// dir mapped to their hex SHA-256), failing with ErrVerification if one
+// does not match what was written. With direct, files are read with
+// O_DIRECT through dd, bypassing the client's page cache, so a write the
+// server never stored cannot be answered from memory.
+func verifyWrites(ctx context.Context, dir string, checksums map[string]string, direct bool, logger *log.Logger) error {
+	if err := syncDir(dir); err != nil {
+		return fmt.Errorf("cannot sync '%s': %w", dir, err)
+	}
+	names := make([]string, 0, len(checksums))
+	for name := range checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		sum, err := rereadChecksum(ctx, path, direct)
+		if err != nil {
+			return fmt.Errorf("%w: cannot re-read '%s': %w", ErrVerification, path, err)
+		}
+		if sum != checksums[name] {
+			return fmt.Errorf("%w: '%s' reads back with checksum %s, wrote %s", ErrVerification, path, sum, checksums[name])
+		}
+	}
+	logger.Printf("INFO: Verified %d file(s) read back intact.", len(names))
+	return nil
+}
+
+// rereadChecksum reopens path and returns its hex SHA-256.
+func rereadChecksum(ctx context.Context, path string, direct bool) (string, error) {
+	h := sha256.New()
+	if direct {
+		c := Command{Name: "dd", Args: []string{"if=" + path, "iflag=direct", "bs=1M", "status=none"}, Stdout: h}
+		if err := runCommand(ctx, ExecRunner{}, c, nil); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := copyContext(ctx, h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,149 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EphemeralOptions configures a restore into a throwaway PostgreSQL
+// container, for looking inside a backup without a server to spare.
+type EphemeralOptions struct {
+	// Version is the postgres image tag, e.g. "16". Empty uses the
+	// server version recorded in the backup's manifest, or "latest".
+	Version string
+	// TTL removes the container after this long; 0 keeps it until ctx is
+	// cancelled, e.g. by Ctrl-C.
+	TTL time.Duration
+	// Timeout aborts the restore itself after this long (0 = no limit).
+	Timeout time.Duration
+}
+
+// ephemeralReadyTimeout bounds how long a new container may take to
+// accept connections.
+const ephemeralReadyTimeout = 2 * time.Minute
+
+// RestoreEphemeral starts a postgres container, restores backupFile into
+// it, prints a connection string and, once opts.TTL has passed or ctx is
+// cancelled, removes the container again. The client tools inside the
+// container do the restore, so they always match its server.
+func RestoreEphemeral(ctx context.Context, cfg Config, backupFile string, opts EphemeralOptions) error {
+	if cfg.Database == "" {
+		b, err := ParseBackupFilename(filepath.Base(backupFile))
+		if err != nil {
+			return fmt.Errorf("%w: -db is required when the backup file name does not give the database", ErrUsage)
+		}
+		cfg.Database = b.Database
+	}
+	version := opts.Version
+	if version == "" {
+		version = "latest"
+		if m, err := ReadManifest(strings.TrimSuffix(backupFile, ".dump.gz") + ".manifest.json"); err == nil {
+			if v := majorVersion(m.Versions["server"]); v >= 1000 {
+				version = strconv.Itoa(v / 100)
+			} else if v > 0 {
+				version = fmt.Sprintf("%d.%d", v/100, v%100)
+			}
+		}
+	}
+	image := "postgres:" + version
+	srv, err := startEphemeral(ctx, image)
+	if err != nil {
+		return err
+	}
+	defer srv.remove()
+
+	cfg.User, cfg.Host, cfg.DSN, cfg.BinDir, cfg.Runner = "postgres", "localhost", "", "", DockerRunner(srv.ID)
+	cfg.IfExists = IfExistsFail // creates the database
+	if cfg.Masking != nil {
+		mc := *cfg.Masking
+		mc.ephemeral = true
+		cfg.Masking = &mc
+	}
+	restoreCtx, cancel := withTimeout(ctx, opts.Timeout)
+	defer cancel()
+	if err := runRestore(restoreCtx, cfg, backupFile); err != nil {
+		return err
+	}
+
+	dsn := url.URL{Scheme: "postgres", User: url.UserPassword("postgres", srv.Password), Host: srv.Addr, Path: "/" + cfg.Database}
+	fmt.Printf("\nBackup restored into a temporary %s container:\n\n  psql '%s'\n\n", image, dsn.String())
+	if opts.TTL > 0 {
+		fmt.Printf("It will be removed in %s, or on Ctrl-C.\n", opts.TTL)
+	} else {
+		fmt.Println("Press Ctrl-C to remove it.")
+	}
+	var ttl <-chan time.Time
+	if opts.TTL > 0 {
+		ttl = time.After(opts.TTL)
+	}
+	select {
+	case <-ctx.Done():
+	case <-ttl:
+	}
+	return nil
+}
+
+// ephemeralServer is a throwaway postgres container.
+type ephemeralServer struct {
+	ID       string
+	Addr     string // host:port of the server, published on 127.0.0.1
+	Password string // of the postgres user
+}
+
+// startEphemeral starts a postgres container from image and waits for it
+// to accept connections. The caller must remove it.
+func startEphemeral(ctx context.Context, image string) (*ephemeralServer, error) {
+	srv := &ephemeralServer{Password: randomHex(12)}
+	fmt.Printf("Starting temporary %s container...\n", image)
+	var out strings.Builder
+	err := runCommand(ctx, ExecRunner{}, Command{Name: "docker", Args: []string{
+		"run", "-d", "--rm", "--name", "pgtool-" + randomHex(4), "--label", "pgtool.ephemeral=true",
+		"-e", "POSTGRES_PASSWORD=" + srv.Password, "-p", "127.0.0.1::5432", image,
+	}, Stdout: &out}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot start %s: %w", image, err)
+	}
+	srv.ID = strings.TrimSpace(out.String())
+
+	out.Reset()
+	if err := runCommand(ctx, ExecRunner{}, Command{Name: "docker", Args: []string{"port", srv.ID, "5432/tcp"}, Stdout: &out}, nil); err != nil {
+		srv.remove()
+		return nil, err
+	}
+	// One line per address family the port is published on
+	srv.Addr, _, _ = strings.Cut(strings.TrimSpace(out.String()), "\n")
+
+	// The image's entrypoint initialises the cluster with a server on a
+	// Unix socket only; the real server is up once TCP connections work.
+	ready := Command{Name: "pg_isready", Args: []string{"-U", "postgres", "-h", "localhost", "-q"}}
+	deadline := time.Now().Add(ephemeralReadyTimeout)
+	for runCommand(ctx, DockerRunner(srv.ID), ready, nil) != nil {
+		if time.Now().After(deadline) {
+			srv.remove()
+			return nil, fmt.Errorf("%s did not accept connections within %s", image, ephemeralReadyTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			srv.remove()
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	return srv, nil
+}
+
+// remove removes the container, even once ctx has been cancelled.
+func (s *ephemeralServer) remove() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	fmt.Println("Removing temporary container...")
+	if err := runCommand(ctx, ExecRunner{}, Command{Name: "docker", Args: []string{"rm", "-f", "-v", s.ID}}, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot remove container %s: %v\n", s.ID, err)
+	}
+}
@@ -0,0 +1,381 @@
+package pgtool
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Error kinds returned (wrapped) by backup and restore. Use errors.Is to
+// test for them.
+var (
+	ErrUsage            = errors.New("invalid usage")
+	ErrDatabaseNotFound = errors.New("database not found")
+	ErrAuthFailed       = errors.New("authentication failed")
+	ErrDiskFull         = errors.New("disk full")
+	ErrToolMissing      = errors.New("required tool not found")
+	ErrConnection       = errors.New("cannot connect to server")
+	ErrIgnoredErrors    = errors.New("errors ignored on restore")
+	ErrWarnings         = errors.New("tool reported warnings")
+	ErrAborted          = errors.New("aborted")
+	ErrVerification     = errors.New("verification failed")
+	ErrLocked           = errors.New("locked by another run")
+	ErrMissingExtension = errors.New("extensions missing on target")
+	ErrDatabaseExists   = errors.New("database already exists")
+	// ErrSkipped is returned by a backup that was not due to run, e.g.
+	// outside its window; it is reported as "skipped", not as a failure.
+	ErrSkipped = errors.New("skipped")
+	// ErrInjected is returned by a phase failed on purpose with
+	// -inject-failure, to test the alerting.
+	ErrInjected = errors.New("injected failure")
+)
+
+// ExitError is returned when pg_dump, pg_restore or psql fails. It carries
+// the tail of the tool's stderr and unwraps to both the underlying process
+// error and, when it can be recognised, one of the Err* kinds above.
+type ExitError struct {
+	Tool   string
+	Stderr string
+	Kind   error // one of the Err* values, or nil
+	Err    error
+}
+
+func (e *ExitError) Error() string {
+	msg := fmt.Sprintf("%s: %v", e.Tool, e.Err)
+	if e.Kind != nil {
+		msg = fmt.Sprintf("%s: %v: %v", e.Tool, e.Kind, e.Err)
+	}
+	if line := lastLine(e.Stderr); line != "" {
+		msg += ": " + line
+	}
+	return msg
+}
+
+func (e *ExitError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// statusError is an unsuccessful HTTP response from storage or a
+// notifier. Its code tells isTransient whether to retry.
+type statusError struct {
+	Op     string // e.g. "PUT https://dav.example.com/backups/app.dump.gz"
+	Code   int
+	Status string // e.g. "404 Not Found"
+}
+
+func newStatusError(op string, resp *http.Response) error {
+	return &statusError{Op: op, Code: resp.StatusCode, Status: resp.Status}
+}
+
+func (e *statusError) Error() string   { return e.Op + ": " + e.Status }
+func (e *statusError) httpStatus() int { return e.Code }
+
+// toolError wraps an error from running tool, classifying it from the
+// captured stderr. It returns nil if err is nil.
+func toolError(tool string, err error, stderr string) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("%w: %s: %w", ErrToolMissing, tool, err)
+	}
+	return &ExitError{Tool: tool, Stderr: stderr, Kind: classifyStderr(stderr), Err: err}
+}
+
+// classifyStderr maps well-known libpq and pg_dump messages to error kinds.
+func classifyStderr(stderr string) error {
+	s := strings.ToLower(stderr)
+	switch {
+	case strings.Contains(s, "errors ignored on restore"):
+		return ErrIgnoredErrors
+	case strings.Contains(s, "does not exist") && strings.Contains(s, "database"):
+		return ErrDatabaseNotFound
+	case strings.Contains(s, "authentication failed"),
+		strings.Contains(s, "no password supplied"),
+		strings.Contains(s, "permission denied for database"):
+		return ErrAuthFailed
+	case strings.Contains(s, "no space left on device"):
+		return ErrDiskFull
+	case strings.Contains(s, "could not connect to server"),
+		strings.Contains(s, "connection to server at"),
+		strings.Contains(s, "connection refused"),
+		strings.Contains(s, "timeout expired"),
+		strings.Contains(s, "server closed the connection unexpectedly"),
+		strings.Contains(s, "could not translate host name"),
+		strings.Contains(s, "the database system is starting up"),
+		strings.Contains(s, "remaining connection slots are reserved"),
+		strings.Contains(s, "too many clients already"):
+		return ErrConnection
+	}
+	return nil
+}
+
+// ioError marks err as ErrDiskFull when the filesystem ran out of space.
+func ioError(err error) error {
+	if err != nil && errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("%w: %w", ErrDiskFull, err)
+	}
+	return err
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// stderrTailSize bounds how much of a tool's stderr is kept for errors.
+const stderrTailSize = 8 * 1024
+
+// tailBuffer keeps the last stderrTailSize bytes written to it. It is safe
+// for concurrent use since exec copies stderr from its own goroutine.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf.Write(p)
+	if over := t.buf.Len() - stderrTailSize; over > 0 {
+		t.buf.Next(over)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.buf.String()
+}
+
+// StorageError is returned when a storage backend operation fails.
+type StorageError struct {
+	Backend string
+	Op      string
+	Err     error
+}
+
+func (e *StorageError) Error() string {
+	return fmt.Sprintf("storage %s: %s: %v", e.Backend, e.Op, e.Err)
+}
+
+func (e *StorageError) Unwrap() error { return e.Err }
+
+// diagCounter passes a tool's stderr through to w while counting the
+// warning and error lines in it. It is safe for concurrent use, so one
+// counter can collect the output of tools running in parallel.
+type diagCounter struct {
+	w        io.Writer
+	mu       sync.Mutex
+	partial  []byte
+	warnings int
+	errors   int
+	classes  map[string]*errorClass
+
+	// onError, if set, is called with the running error count after each
+	// error line.
+	onError func(n int)
+}
+
+// errorClass counts the errors of one kind and the objects they named.
+type errorClass struct {
+	count int
+	names []string
+}
+
+// errorClasses group common pg_restore errors for the summary. The first
+// non-empty submatch is the name of the role, extension or object.
+var errorClasses = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"missing roles", regexp.MustCompile(`role "([^"]+)" does not exist`)},
+	{"missing extensions", regexp.MustCompile(`extension "([^"]+)" is not available|could not open extension control file "[^"]*/([^/"]+)\.control"`)},
+	{"duplicate objects", regexp.MustCompile(`"([^"]+)" already exists`)},
+}
+
+// maxClassNames bounds how many names the summary lists per class.
+const maxClassNames = 5
+
+func newDiagCounter(w io.Writer) *diagCounter {
+	return &diagCounter{w: w}
+}
+
+func (d *diagCounter) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	d.partial = append(d.partial, p...)
+	for {
+		i := bytes.IndexByte(d.partial, '\n')
+		if i < 0 {
+			break
+		}
+		d.count(string(d.partial[:i]))
+		d.partial = d.partial[i+1:]
+	}
+	d.mu.Unlock()
+	return d.w.Write(p)
+}
+
+func (d *diagCounter) count(line string) {
+	s := strings.ToLower(line)
+	switch {
+	case strings.Contains(s, "warning:"):
+		d.warnings++
+	case strings.Contains(s, "error:"), strings.Contains(s, "fatal:"):
+		d.errors++
+		d.classify(line)
+		if d.onError != nil {
+			d.onError(d.errors)
+		}
+	}
+}
+
+func (d *diagCounter) classify(line string) {
+	if d.classes == nil {
+		d.classes = make(map[string]*errorClass)
+	}
+	class, name := "other", ""
+	for _, c := range errorClasses {
+		if m := c.re.FindStringSubmatch(line); m != nil {
+			class = c.name
+			for _, sub := range m[1:] {
+				if sub != "" {
+					name = sub
+					break
+				}
+			}
+			break
+		}
+	}
+	ec := d.classes[class]
+	if ec == nil {
+		ec = &errorClass{}
+		d.classes[class] = ec
+	}
+	ec.count++
+	if name != "" && len(ec.names) < maxClassNames && !slices.Contains(ec.names, name) {
+		ec.names = append(ec.names, name)
+	}
+}
+
+// summary describes the errors seen by class, one line per class, e.g.
+// "missing roles: 5 (prod_app, prod_ro)".
+func (d *diagCounter) summary() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var lines []string
+	var names []string
+	for _, c := range errorClasses {
+		names = append(names, c.name)
+	}
+	for _, name := range append(names, "other") {
+		ec := d.classes[name]
+		if ec == nil {
+			continue
+		}
+		line := fmt.Sprintf("%s: %d", name, ec.count)
+		if len(ec.names) > 0 {
+			line += " (" + strings.Join(ec.names, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// counts returns the number of warning and error lines seen so far.
+func (d *diagCounter) counts() (warnings, errors int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.warnings, d.errors
+}
+
+// Exit statuses of the pgtool command, one per failure class, so wrapper
+// scripts and monitoring can tell why a run failed. See -print-exit-codes.
+const (
+	exitFailure       = 1   // any failure not covered below
+	exitUsage         = 2   // invalid flags or configuration
+	exitConnection    = 3   // cannot connect, authenticate or find the database
+	exitTool          = 4   // pg_dump, pg_restore or psql failed or is missing
+	exitStorage       = 5   // a storage backend failed
+	exitVerification  = 6   // a backup failed verification
+	exitLocked        = 7   // another run holds the lock
+	exitIgnoredErrors = 8   // pg_restore finished but skipped errors
+	exitWarnings      = 9   // -fail-on-warnings was given and a tool warned
+	exitDiskFull      = 10  // the backup destination ran out of space
+	exitSkipped       = 11  // the backup was skipped: outside its window or in a blackout
+	exitAborted       = 130 // interrupted by SIGINT or SIGTERM
+)
+
+var exitCodeDescriptions = []struct {
+	code int
+	desc string
+}{
+	{0, "success"},
+	{exitFailure, "failure not covered below"},
+	{exitUsage, "invalid flags or configuration"},
+	{exitConnection, "cannot connect, authenticate or find the database"},
+	{exitTool, "pg_dump, pg_restore or psql failed or is missing"},
+	{exitStorage, "storage backend failed"},
+	{exitVerification, "backup failed verification"},
+	{exitLocked, "another run holds the lock"},
+	{exitIgnoredErrors, "pg_restore finished but ignored errors"},
+	{exitWarnings, "tool printed warnings and -fail-on-warnings was given"},
+	{exitDiskFull, "backup destination is out of space"},
+	{exitSkipped, "backup skipped: outside its window or in a blackout"},
+	{exitAborted, "aborted by SIGINT or SIGTERM"},
+}
+
+// printExitCodes writes the table of exit statuses to w.
+func printExitCodes(w io.Writer) {
+	for _, e := range exitCodeDescriptions {
+		fmt.Fprintf(w, "%3d  %s\n", e.code, e.desc)
+	}
+}
+
+// exitCode returns the process exit status for a failed command. The more
+// specific classes are checked first: an aborted upload is "aborted", not
+// "storage".
+func exitCode(err error) int {
+	var storageErr *StorageError
+	var exitErr *ExitError
+	switch {
+	case errors.Is(err, ErrAborted):
+		return exitAborted
+	case errors.Is(err, ErrSkipped):
+		return exitSkipped
+	case errors.Is(err, ErrUsage):
+		return exitUsage
+	case errors.Is(err, ErrIgnoredErrors):
+		return exitIgnoredErrors
+	case errors.Is(err, ErrWarnings):
+		return exitWarnings
+	case errors.Is(err, ErrVerification):
+		return exitVerification
+	case errors.Is(err, ErrLocked):
+		return exitLocked
+	case errors.Is(err, ErrDiskFull):
+		return exitDiskFull
+	case errors.Is(err, ErrConnection), errors.Is(err, ErrAuthFailed), errors.Is(err, ErrDatabaseNotFound):
+		return exitConnection
+	case errors.As(err, &storageErr):
+		return exitStorage
+	case errors.As(err, &exitErr), errors.Is(err, ErrToolMissing):
+		return exitTool
+	}
+	return exitFailure
+}
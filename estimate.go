@@ -0,0 +1,150 @@
+package pgtool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// estimateSamples is how many of the latest backups estimate learns from.
+const estimateSamples = 10
+
+// estimateTables is how many of the largest tables estimate lists.
+const estimateTables = 10
+
+// TableSize is the size of one table, with its indexes and without.
+type TableSize struct {
+	Name      string `json:"name"`
+	TotalSize int64  `json:"total_size"`
+	// DataSize is the table with its TOAST data but not its indexes,
+	// which pg_dump writes as definitions only.
+	DataSize int64 `json:"data_size"`
+}
+
+// Estimate predicts the size and duration of the next backup of a
+// database from its current size and the backups made of it before.
+type Estimate struct {
+	Database     string      `json:"database"`
+	DatabaseSize int64       `json:"database_size"`
+	DataSize     int64       `json:"data_size"` // of all tables, without indexes
+	Tables       []TableSize `json:"largest_tables"`
+
+	// Samples is the number of past backups with a recorded database
+	// size; Ratio and Throughput are only known if it is positive.
+	Samples    int     `json:"samples"`
+	Ratio      float64 `json:"ratio,omitempty"`      // backup size / database size
+	Throughput float64 `json:"throughput,omitempty"` // database bytes per second
+
+	// BackupSize and Duration are the predictions, or empty if unknown.
+	BackupSize int64  `json:"backup_size,omitempty"`
+	Duration   string `json:"duration,omitempty"`
+}
+
+// tableSizes returns the sizes of the tables and materialized views of
+// cfg's database, largest first.
+func tableSizes(ctx context.Context, cfg Config) ([]TableSize, error) {
+	out, err := queryScalar(ctx, cfg, `SELECT format('%I.%I', n.nspname, c.relname), pg_total_relation_size(c.oid), pg_table_size(c.oid)
+		FROM pg_catalog.pg_class c JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'm') AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+		ORDER BY 2 DESC, 1`)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list table sizes: %w", err)
+	}
+	var tables []TableSize
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Split(line, "|")
+		if len(f) != 3 {
+			continue
+		}
+		t := TableSize{Name: f[0]}
+		t.TotalSize, _ = strconv.ParseInt(f[1], 10, 64)
+		t.DataSize, _ = strconv.ParseInt(f[2], 10, 64)
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// EstimateBackup measures cfg's database and predicts its next backup
+// from up to estimateSamples of the latest successful backups in
+// cfg.BackupDir that recorded the size of the database they backed up.
+func EstimateBackup(ctx context.Context, cfg Config) (Estimate, error) {
+	e := Estimate{Database: cfg.backupSet()}
+	var err error
+	if e.DatabaseSize, err = databaseSize(ctx, cfg); err != nil {
+		return e, err
+	}
+	tables, err := tableSizes(ctx, cfg)
+	if err != nil {
+		return e, err
+	}
+	for _, t := range tables {
+		e.DataSize += t.DataSize
+	}
+	e.Tables = tables[:min(len(tables), estimateTables)]
+
+	records, err := backupHistory(cfg.BackupDir, "", cfg.backupSet())
+	if err != nil {
+		return e, err
+	}
+	var dbBytes, backupBytes, timedBytes int64
+	var elapsed time.Duration
+	for i := len(records) - 1; i >= 0 && e.Samples < estimateSamples; i-- {
+		r := records[i]
+		if r.Outcome != "success" || r.Removed || r.DatabaseSize <= 0 {
+			continue
+		}
+		e.Samples++
+		dbBytes += r.DatabaseSize
+		backupBytes += r.Size
+		if d, err := time.ParseDuration(r.Duration); err == nil && d > 0 {
+			timedBytes += r.DatabaseSize
+			elapsed += d
+		}
+	}
+	if e.Samples > 0 {
+		e.Ratio = float64(backupBytes) / float64(dbBytes)
+		e.BackupSize = int64(e.Ratio * float64(e.DatabaseSize))
+	}
+	if elapsed > 0 {
+		e.Throughput = float64(timedBytes) / elapsed.Seconds()
+		d := time.Duration(float64(e.DatabaseSize) / e.Throughput * float64(time.Second))
+		e.Duration = d.Round(time.Second).String()
+	}
+	return e, nil
+}
+
+// writeEstimate writes e to w as aligned text, or as JSON.
+func writeEstimate(w io.Writer, e Estimate, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(e)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Database:\t%s\t%s (tables %s, indexes and the rest %s)\n", e.Database, formatBytes(e.DatabaseSize),
+		formatBytes(e.DataSize), formatBytes(e.DatabaseSize-e.DataSize))
+	for i, t := range e.Tables {
+		label := ""
+		if i == 0 {
+			label = "Largest tables:"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s (data %s)\n", label, t.Name, formatBytes(t.TotalSize), formatBytes(t.DataSize))
+	}
+	fmt.Fprintf(tw, "Dump size:\t~%s\tbefore compression; pg_dump writes table data and index definitions\n", formatBytes(e.DataSize))
+	if e.Samples == 0 {
+		fmt.Fprintf(tw, "Backup size:\tunknown\tno earlier backups record their database size\n")
+	} else {
+		fmt.Fprintf(tw, "Backup size:\t~%s\t%.3g%% of the database size, from %d backup(s)\n", formatBytes(e.BackupSize), 100*e.Ratio, e.Samples)
+	}
+	if e.Duration == "" {
+		fmt.Fprintf(tw, "Duration:\tunknown\tno earlier backups record their database size and duration\n")
+	} else {
+		fmt.Fprintf(tw, "Duration:\t~%s\tat %s/s of database, from earlier backups\n", e.Duration, formatBytes(int64(e.Throughput)))
+	}
+	return tw.Flush()
+}
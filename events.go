@@ -0,0 +1,143 @@
+package pgtool
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Phases reported through EventHandler.OnPhaseChange.
+const (
+	PhaseConnect     = "connect"
+	PhaseDump        = "dump"
+	PhaseCompress    = "compress"
+	PhaseVerify      = "verify"
+	PhaseUpload      = "upload"
+	PhaseDownload    = "download"
+	PhaseCleanup     = "cleanup"
+	PhaseDecompress  = "decompress"
+	PhaseRestore     = "restore"
+	PhasePostRestore = "post-restore"
+	PhaseAnalyze     = "analyze"
+)
+
+// Event describes something that happened during a backup or restore.
+type Event struct {
+	Op       string // "backup" or "restore"
+	Database string
+	Phase    string
+	File     string
+	Bytes    int64 // bytes written so far in the current phase
+	// With restore progress, the object pg_restore is on and how many of
+	// the archive's Total TOC entries it has reached
+	Item  string
+	Done  int
+	Total int
+	Time  time.Time
+	Err   error
+	// Warning, with OnComplete, says why a backup that succeeded was
+	// slow; see Config.MaxDuration.
+	Warning string
+}
+
+// EventHandler receives structured lifecycle and progress events from
+// backup and restore runs.
+type EventHandler interface {
+	OnStart(Event)
+	OnProgress(Event)
+	OnPhaseChange(Event)
+	OnComplete(Event)
+	OnError(Event)
+}
+
+// NopEventHandler ignores all events. Embed it to handle only some of them.
+type NopEventHandler struct{}
+
+func (NopEventHandler) OnStart(Event)       {}
+func (NopEventHandler) OnProgress(Event)    {}
+func (NopEventHandler) OnPhaseChange(Event) {}
+func (NopEventHandler) OnComplete(Event)    {}
+func (NopEventHandler) OnError(Event)       {}
+
+// MultiEventHandler delivers every event to each of its handlers in order.
+type MultiEventHandler []EventHandler
+
+func (m MultiEventHandler) OnStart(e Event) {
+	for _, h := range m {
+		h.OnStart(e)
+	}
+}
+
+func (m MultiEventHandler) OnProgress(e Event) {
+	for _, h := range m {
+		h.OnProgress(e)
+	}
+}
+
+func (m MultiEventHandler) OnPhaseChange(e Event) {
+	for _, h := range m {
+		h.OnPhaseChange(e)
+	}
+}
+
+func (m MultiEventHandler) OnComplete(e Event) {
+	for _, h := range m {
+		h.OnComplete(e)
+	}
+}
+
+func (m MultiEventHandler) OnError(e Event) {
+	for _, h := range m {
+		h.OnError(e)
+	}
+}
+
+// progressInterval limits how often OnProgress fires while streaming data.
+const progressInterval = time.Second
+
+// progressWriter counts bytes written to w and reports them through
+// OnProgress at most once per progressInterval.
+type progressWriter struct {
+	w    io.Writer
+	ev   Event
+	h    EventHandler
+	last time.Time
+}
+
+func newProgressWriter(w io.Writer, h EventHandler, ev Event) *progressWriter {
+	return &progressWriter{w: w, ev: ev, h: h, last: time.Now()}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.ev.Bytes += int64(n)
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+		p.ev.Time = now
+		p.h.OnProgress(p.ev)
+	}
+	return n, err
+}
+
+// ConsoleProgress prints OnProgress events to stdout.
+type ConsoleProgress struct {
+	NopEventHandler
+}
+
+func (ConsoleProgress) OnProgress(e Event) {
+	fmt.Printf("  %s: %s\n", e.Phase, formatBytes(e.Bytes))
+}
+
+// formatBytes formats n using binary units, e.g. "1.5 GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
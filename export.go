@@ -0,0 +1,98 @@
+package pgtool
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ExportOptions describes a per-table data export. Connection, output
+// directory, compression level and storage come from Config.
+type ExportOptions struct {
+	Config
+	Tables []string // schema-qualified names, e.g. "public.events"
+	Format string   // "csv"; Parquet is not supported
+}
+
+// Export writes each table to <db>_<table>_<timestamp>.csv.gz in the
+// backup directory and uploads it to the configured storage backends.
+func Export(ctx context.Context, opts ExportOptions) error {
+	cfg := opts.Config
+	if cfg.Database == "" || len(opts.Tables) == 0 {
+		return fmt.Errorf("%w: database and at least one table are required", ErrUsage)
+	}
+	if opts.Format == "parquet" {
+		// Parquet needs a columnar writer, which we don't ship.
+		return fmt.Errorf("%w: Parquet export is not supported; use -format csv", ErrUsage)
+	}
+	if opts.Format != "csv" {
+		return fmt.Errorf("%w: unsupported export format '%s' (supported: csv)", ErrUsage, opts.Format)
+	}
+
+	logF, logger, err := openLog(cfg.LogFile)
+	if err != nil {
+		return err
+	}
+	defer logF.Close()
+
+	now := time.Now()
+	for _, table := range opts.Tables {
+		file := filepath.Join(cfg.BackupDir, fmt.Sprintf("%s_%s_%s.csv.gz", cfg.Database, table, now.Format(TimestampLayout)))
+		logger.Printf("INFO: Exporting table %s of database '%s'.", table, cfg.Database)
+		fmt.Printf("Exporting %s...\n", table)
+		if err := exportTable(ctx, cfg, quoteQualified(table), file, logF); err != nil {
+			err = contextErr(ctx, err)
+			logger.Printf("ERROR: Export of table %s failed: %v", table, err)
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+		err := runPhase(ctx, "Upload", cfg.UploadTimeout, func(ctx context.Context) error {
+			return uploadAll(ctx, cfg.Storage, file, cfg.Retry, logger)
+		})
+		if err != nil {
+			logger.Printf("ERROR: Upload failed: %v", err)
+			return err
+		}
+		logger.Printf("SUCCESS: Exported %s to %s.", table, file)
+		fmt.Println("Export successful:", file)
+	}
+	return nil
+}
+
+// exportTable streams table as CSV with a header row through gzip into dst,
+// which only appears once complete.
+func exportTable(ctx context.Context, cfg Config, table, dst string, stderr io.Writer) error {
+	tmp := dst + partialSuffix
+	out, err := os.Create(tmp)
+	if err != nil {
+		return ioError(err)
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+
+	gw, err := gzip.NewWriterLevel(out, cfg.CompressionLevel)
+	if err != nil {
+		return err
+	}
+	c := Command{
+		Name:   "psql",
+		Args:   append(cfg.connArgs(), "-X", "-c", "COPY "+table+" TO STDOUT WITH (FORMAT csv, HEADER)"),
+		Stdout: gw,
+	}
+	if err := runCommand(ctx, cfg.runner(), c, stderr); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return ioError(err)
+	}
+	if err := out.Sync(); err != nil {
+		return ioError(err)
+	}
+	if err := out.Close(); err != nil {
+		return ioError(err)
+	}
+	return os.Rename(tmp, dst)
+}
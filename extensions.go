@@ -0,0 +1,102 @@
+package pgtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Extension is an installed PostgreSQL extension as recorded in the
+// manifest.
+type Extension struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+func (e Extension) String() string { return e.Name + " " + e.Version }
+
+// listExtensions returns the extensions installed in the configured
+// database.
+func listExtensions(ctx context.Context, cfg Config) ([]Extension, error) {
+	out, err := queryScalar(ctx, cfg, "SELECT extname, extversion FROM pg_catalog.pg_extension ORDER BY 1")
+	if err != nil {
+		return nil, err
+	}
+	return parseExtensions(out), nil
+}
+
+// availableExtensions returns the versions of each extension the target
+// server could install, by name.
+func availableExtensions(ctx context.Context, cfg Config) (map[string][]string, error) {
+	out, err := queryScalar(ctx, cfg, "SELECT name, version FROM pg_catalog.pg_available_extension_versions")
+	if err != nil {
+		return nil, err
+	}
+	avail := make(map[string][]string)
+	for _, e := range parseExtensions(out) {
+		avail[e.Name] = append(avail[e.Name], e.Version)
+	}
+	return avail, nil
+}
+
+// parseExtensions parses psql's unaligned "name|version" rows.
+func parseExtensions(out string) []Extension {
+	var exts []Extension
+	for _, line := range strings.Split(out, "\n") {
+		name, version, ok := strings.Cut(strings.TrimSpace(line), "|")
+		if ok {
+			exts = append(exts, Extension{Name: name, Version: version})
+		}
+	}
+	return exts
+}
+
+// checkExtensions fails with ErrMissingExtension, listing them all, if the
+// target server cannot install an extension the backup was taken with. An
+// available extension with a different version is only logged, since
+// CREATE EXTENSION installs the default version anyway.
+func checkExtensions(ctx context.Context, cfg Config, logger *log.Logger, want []Extension) error {
+	if len(want) == 0 {
+		return nil
+	}
+	avail, err := availableExtensions(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("cannot list available extensions: %w", err)
+	}
+	var missing []string
+	for _, e := range want {
+		versions, ok := avail[e.Name]
+		switch {
+		case !ok:
+			missing = append(missing, e.String())
+		case !slices.Contains(versions, e.Version):
+			logger.Printf("WARNING: Extension %s is available only in version(s) %s.", e, strings.Join(versions, ", "))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingExtension, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// backupExtensions returns the extensions recorded in the manifest next to
+// backupFile, or nil if there is no manifest.
+func backupExtensions(backupFile string) ([]Extension, error) {
+	m, err := ReadManifest(strings.TrimSuffix(backupFile, ".dump.gz") + ".manifest.json")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return m.Extensions, err
+}
+
+func joinExtensions(exts []Extension) string {
+	s := make([]string, len(exts))
+	for i, e := range exts {
+		s[i] = e.String()
+	}
+	return strings.Join(s, ", ")
+}
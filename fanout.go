@@ -0,0 +1,50 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TargetResult is the outcome of restoring into one fan-out target.
+type TargetResult struct {
+	Target   string // redacted DSN
+	Err      error
+	Duration time.Duration
+}
+
+// RestoreTargets restores backupFile into every DSN concurrently, each
+// with cfg's settings, and returns one result per target in the order
+// given. It returns an error only if at least one target failed.
+func RestoreTargets(ctx context.Context, cfg Config, backupFile string, dsns []string) ([]TargetResult, error) {
+	if len(dsns) == 0 || backupFile == "" {
+		return nil, fmt.Errorf("%w: target DSNs and backup file are required", ErrUsage)
+	}
+	results := make([]TargetResult, len(dsns))
+	var wg sync.WaitGroup
+	for i, dsn := range dsns {
+		target := cfg
+		target.DSN = dsn
+		results[i].Target = target.target()
+		wg.Add(1)
+		go func(r *TargetResult) {
+			defer wg.Done()
+			start := time.Now()
+			r.Err = runRestore(ctx, target, backupFile)
+			r.Duration = time.Since(start)
+		}(&results[i])
+	}
+	wg.Wait()
+
+	var failed int
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d targets failed", failed, len(results))
+	}
+	return results, nil
+}
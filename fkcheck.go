@@ -0,0 +1,69 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// dataOnly reports whether a restore loads rows into an existing schema.
+func (c Config) dataOnly() bool {
+	if len(c.Sections) == 1 && c.Sections[0] == "data" {
+		return true
+	}
+	return slices.Contains(c.RestoreArgs, "-a") || slices.Contains(c.RestoreArgs, "--data-only")
+}
+
+// isSuperuser reports whether the restore connects as a superuser, who
+// can disable triggers without naming another role.
+func isSuperuser(ctx context.Context, cfg Config) (bool, error) {
+	out, err := queryScalar(ctx, cfg, "SELECT rolsuper FROM pg_catalog.pg_roles WHERE rolname = current_user")
+	if err != nil {
+		return false, err
+	}
+	return out == "t", nil
+}
+
+// foreignKeyQuery lists, one per line, a statement re-creating each
+// foreign key of the database. Dropping and adding the constraint in one
+// ALTER TABLE checks every row again, atomically.
+const foreignKeyQuery = `SELECT format('ALTER TABLE %s DROP CONSTRAINT %I, ADD CONSTRAINT %I %s',
+       c.conrelid::regclass, c.conname, c.conname, pg_catalog.pg_get_constraintdef(c.oid))
+  FROM pg_catalog.pg_constraint c
+  JOIN pg_catalog.pg_namespace n ON n.oid = c.connamespace
+ WHERE c.contype = 'f' AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+ ORDER BY 1`
+
+// validateForeignKeys checks every foreign key against the loaded rows,
+// which triggers disabled during a data-only restore did not. All keys
+// are checked; each violation is logged and the count returned as an
+// error.
+func validateForeignKeys(ctx context.Context, cfg Config, logger *log.Logger) error {
+	out, err := queryScalar(ctx, cfg, foreignKeyQuery)
+	if err != nil {
+		return fmt.Errorf("cannot list foreign keys: %w", err)
+	}
+	if out == "" {
+		return nil
+	}
+	stmts := strings.Split(out, "\n")
+	logger.Printf("INFO: Validating %d foreign key(s).", len(stmts))
+	fmt.Printf("Validating %d foreign key(s)...\n", len(stmts))
+	var failed int
+	for _, stmt := range stmts {
+		if _, err := queryScalar(ctx, cfg, stmt); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			logger.Printf("ERROR: Foreign key check failed (%s): %v", stmt, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d foreign keys are violated by the restored data; see the log", failed, len(stmts))
+	}
+	logger.Printf("SUCCESS: All %d foreign key(s) hold.", len(stmts))
+	return nil
+}
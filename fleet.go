@@ -0,0 +1,366 @@
+package pgtool
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerConfig is one server of a fleet in the config file, backed up by
+// backup -fleet or on its schedule by daemon -fleet.
+//
+//	"servers": [
+//	  {"name": "db1", "host": "db1.internal", "user": "backup", "password_env": "DB1_PASSWORD",
+//	   "databases": ["app", "billing"], "storage": ["b2://backups/db1"], "schedule": "0 2 * * *"}
+//	]
+//
+// A server without "databases" has its databases discovered on every run,
+// selected by its "include" and "exclude" regexps and the config file's
+// "discovery" rules.
+type ServerConfig struct {
+	// Name identifies the server in logs and the summary, and names its
+	// subdirectory of -backup-dir unless BackupDir is given.
+	Name string `json:"name"`
+	Host string `json:"host"`
+	User string `json:"user,omitempty"` // default: -user
+	// PasswordEnv names the environment variable holding the password,
+	// passed to the tools as PGPASSWORD; Passfile is a .pgpass file for
+	// the server, passed as PGPASSFILE.
+	PasswordEnv string   `json:"password_env,omitempty"`
+	Passfile    string   `json:"passfile,omitempty"`
+	Databases   []string `json:"databases,omitempty"`
+	Include     []string `json:"include,omitempty"`
+	Exclude     []string `json:"exclude,omitempty"`
+	// IncludeSystem also backs up discovered template1 and postgres.
+	IncludeSystem bool   `json:"include_system,omitempty"`
+	BackupDir     string `json:"backup_dir,omitempty"`
+	// Storage, if set, replaces the -storage destinations for this server.
+	Storage []string `json:"storage,omitempty"`
+	// Schedule is the server's cron schedule under daemon -fleet; default
+	// -schedule.
+	Schedule string `json:"schedule,omitempty"`
+	ExecVia  string `json:"exec_via,omitempty"`
+	PgBinDir string `json:"pg_bindir,omitempty"`
+	// Window and WindowDefer replace -window and -window-defer for the
+	// server's backups.
+	Window      string `json:"window,omitempty"`
+	WindowDefer bool   `json:"window_defer,omitempty"`
+	// MaxParallel is how many of the server's databases are dumped at
+	// once; default the config file's "max_parallel_per_host", or 1, so a
+	// fleet run never loads one server with two dumps while still working
+	// on several servers.
+	MaxParallel int `json:"max_parallel,omitempty"`
+}
+
+// FleetServer is one server's backups, ready to run.
+type FleetServer struct {
+	Name        string
+	Schedule    string
+	MaxParallel int
+	Server      Config   // the settings shared by the server's databases
+	Databases   []string // if empty, discovered with Discovery on every run
+	Discovery   DiscoveryRules
+	apply       func(*Config) error // adds a database's own settings
+	// OnlyPriority, if set, skips the databases less urgent than it.
+	OnlyPriority string
+	// Node, if set, names the server in a shared catalog instead of the
+	// host running pgtool.
+	Node string
+
+	// hostSlots and totalSlots limit the dumps running at once against
+	// the server's host and in the whole fleet; servers on one host share
+	// hostSlots, and all servers share totalSlots, which may be nil.
+	hostSlots, totalSlots chan struct{}
+}
+
+// configs returns the Config of each database to back up on s, most
+// urgent first.
+func (s FleetServer) configs(ctx context.Context) ([]Config, error) {
+	dbs := s.Databases
+	if len(dbs) == 0 {
+		var err error
+		if dbs, err = discoverDatabases(ctx, s.Server, s.Discovery); err != nil {
+			return nil, err
+		}
+		if len(dbs) == 0 {
+			return nil, fmt.Errorf("no database on %s matches the discovery rules", s.Server.Host)
+		}
+	}
+	configs := make([]Config, len(dbs))
+	for i, db := range dbs {
+		cfg := s.Server
+		cfg.Database = db
+		// Keep the databases' appended settings apart
+		cfg.ExcludeTableData = slices.Clip(cfg.ExcludeTableData)
+		cfg.Checks = slices.Clip(cfg.Checks)
+		if s.apply != nil {
+			if err := s.apply(&cfg); err != nil {
+				return nil, err
+			}
+		}
+		cfg.Notifiers = slices.Clone(cfg.Notifiers)
+		for i, n := range cfg.Notifiers {
+			if cn, ok := n.(CatalogNotifier); ok && cn.Node == "" {
+				cn.Node = s.Node
+				cfg.Notifiers[i] = cn
+			}
+		}
+		configs[i] = cfg
+	}
+	return byPriority(configs, s.OnlyPriority), nil
+}
+
+// fleet builds the servers of cf's fleet from base, the settings given on
+// the command line, which each server's settings override.
+func (cf *ConfigFile) fleet(base Config) ([]FleetServer, error) {
+	if len(cf.Servers) == 0 {
+		return nil, fmt.Errorf("%w: -fleet needs \"servers\" in the -config file", ErrUsage)
+	}
+	if cf.MaxParallelPerHost < 0 || cf.MaxParallelTotal < 0 {
+		return nil, fmt.Errorf("%w: max_parallel_per_host and max_parallel_total cannot be negative", ErrUsage)
+	}
+	var totalSlots chan struct{}
+	if cf.MaxParallelTotal > 0 {
+		totalSlots = make(chan struct{}, cf.MaxParallelTotal)
+	}
+	// Servers on one host, e.g. two clusters on different ports, share
+	// the smallest of their limits
+	hostLimits := map[string]int{}
+	for _, sc := range cf.Servers {
+		limit := cmp.Or(sc.MaxParallel, cf.MaxParallelPerHost, 1)
+		if l, ok := hostLimits[sc.Host]; !ok || limit < l {
+			hostLimits[sc.Host] = limit
+		}
+	}
+	hostSlots := map[string]chan struct{}{}
+	for host, limit := range hostLimits {
+		hostSlots[host] = make(chan struct{}, limit)
+	}
+
+	seen := map[string]bool{}
+	var fleet []FleetServer
+	for _, sc := range cf.Servers {
+		if sc.Name == "" || sc.Host == "" {
+			return nil, fmt.Errorf("%w: every server needs a name and a host", ErrUsage)
+		}
+		if seen[sc.Name] {
+			return nil, fmt.Errorf("%w: server '%s' is defined twice", ErrUsage, sc.Name)
+		}
+		seen[sc.Name] = true
+
+		srv := base
+		srv.Host = sc.Host
+		if sc.User != "" {
+			srv.User = sc.User
+		}
+		srv.BackupDir = sc.BackupDir
+		if srv.BackupDir == "" {
+			// Unlike a given directory, the default one is pgtool's own
+			srv.BackupDir = filepath.Join(base.BackupDir, sc.Name)
+			if err := os.MkdirAll(srv.BackupDir, 0750); err != nil {
+				return nil, ioError(err)
+			}
+		}
+		if sc.PgBinDir != "" {
+			srv.BinDir = sc.PgBinDir
+		}
+		if sc.ExecVia != "" {
+			r, err := parseRunner(sc.ExecVia)
+			if err != nil {
+				return nil, fmt.Errorf("server '%s': %w", sc.Name, err)
+			}
+			srv.Runner = r
+		}
+		var env []string
+		if sc.PasswordEnv != "" {
+			pw, ok := os.LookupEnv(sc.PasswordEnv)
+			if !ok {
+				return nil, fmt.Errorf("%w: server '%s': $%s is not set", ErrUsage, sc.Name, sc.PasswordEnv)
+			}
+			env = append(env, "PGPASSWORD="+pw)
+		}
+		if sc.Passfile != "" {
+			env = append(env, "PGPASSFILE="+sc.Passfile)
+		}
+		if len(env) > 0 {
+			base := srv.Runner
+			if base == nil {
+				base = ExecRunner{}
+			}
+			srv.Runner = EnvRunner{Env: env, Base: base}
+		}
+		if sc.Window != "" {
+			w, err := ParseWindow(sc.Window)
+			if err != nil {
+				return nil, fmt.Errorf("server '%s': %w", sc.Name, err)
+			}
+			srv.Window, srv.WindowDefer = w, sc.WindowDefer
+		}
+		if len(sc.Storage) > 0 {
+			storage, err := storageBackends(sc.Storage, nil)
+			if err != nil {
+				return nil, fmt.Errorf("server '%s': %w", sc.Name, err)
+			}
+			srv.Storage = storage
+		}
+
+		fs := FleetServer{
+			Name:        sc.Name,
+			Schedule:    sc.Schedule,
+			MaxParallel: cap(hostSlots[sc.Host]),
+			Server:      srv,
+			Databases:   sc.Databases,
+			Discovery:   cf.Discovery.merge(DiscoveryRules{Include: sc.Include, Exclude: sc.Exclude, IncludeSystem: sc.IncludeSystem}),
+			apply:       cf.apply,
+			Node:        sc.Name, // not the host running pgtool
+			hostSlots:   hostSlots[sc.Host],
+			totalSlots:  totalSlots,
+		}
+		if err := fs.validate(); err != nil {
+			return nil, fmt.Errorf("server '%s': %w", sc.Name, err)
+		}
+		fleet = append(fleet, fs)
+	}
+	return fleet, nil
+}
+
+// validate checks what can be checked before the run: the discovery
+// rules, or the settings of each listed database.
+func (s FleetServer) validate() error {
+	if len(s.Databases) == 0 {
+		return s.Discovery.validate()
+	}
+	configs, err := s.configs(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, cfg := range configs {
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acquire takes a slot of the semaphore slots, unless ctx ends first.
+func acquire(ctx context.Context, slots chan struct{}) error {
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FleetResult is the outcome of backing up one database of a fleet.
+type FleetResult struct {
+	Server   string
+	Database string
+	Err      error
+	Duration time.Duration
+}
+
+// failed reports whether the backup failed, rather than succeeding or
+// being skipped.
+func (r FleetResult) failed() bool {
+	return r.Err != nil && !errors.Is(r.Err, ErrSkipped)
+}
+
+// status describes the outcome: OK, SKIPPED with the reason, or FAILED
+// with the error.
+func (r FleetResult) status() string {
+	switch {
+	case r.Err == nil:
+		return "OK"
+	case !r.failed():
+		return "SKIPPED: " + strings.TrimPrefix(r.Err.Error(), ErrSkipped.Error()+": ")
+	}
+	return "FAILED: " + r.Err.Error()
+}
+
+// BackupFleet backs up every database of every server, working on all
+// servers at once but on at most MaxParallel databases of each host, and
+// within the fleet's total limit. Databases are discovered first, on all
+// servers at once; a server whose discovery fails gets one failed result
+// with Database "*". It returns one result per database, in server and
+// priority order, and an error only if at least one backup failed.
+func BackupFleet(ctx context.Context, fleet []FleetServer) ([]FleetResult, error) {
+	configs := make([][]Config, len(fleet))
+	errs := make([]error, len(fleet))
+	var wg sync.WaitGroup
+	for i, s := range fleet {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			configs[i], errs[i] = s.configs(ctx)
+		}()
+	}
+	wg.Wait()
+
+	var results []FleetResult
+	for i, s := range fleet {
+		if errs[i] != nil {
+			results = append(results, FleetResult{Server: s.Name, Database: "*", Err: errs[i]})
+		}
+		for _, cfg := range configs[i] {
+			results = append(results, FleetResult{Server: s.Name, Database: cfg.Database})
+		}
+	}
+	i := 0
+	for j, s := range fleet {
+		if errs[j] != nil {
+			i++
+		}
+		slots := s.hostSlots
+		if slots == nil {
+			slots = make(chan struct{}, max(s.MaxParallel, 1))
+		}
+		// Take the server's host slots in order, so that its databases
+		// start most urgent first, and only then wait for a total slot,
+		// so that waiting for one host does not hold up the others
+		wg.Add(1)
+		go func(results []FleetResult) {
+			defer wg.Done()
+			for k, cfg := range configs[j] {
+				r := &results[k]
+				if r.Err = acquire(ctx, slots); r.Err != nil {
+					continue
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer func() { <-slots }()
+					if s.totalSlots != nil {
+						if r.Err = acquire(ctx, s.totalSlots); r.Err != nil {
+							return
+						}
+						defer func() { <-s.totalSlots }()
+					}
+					start := time.Now()
+					r.Err = runBackup(ctx, cfg)
+					r.Duration = time.Since(start)
+				}()
+			}
+		}(results[i : i+len(configs[j])])
+		i += len(configs[j])
+	}
+	wg.Wait()
+
+	var failed int
+	for _, r := range results {
+		if r.failed() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d backups failed", failed, len(results))
+	}
+	return results, nil
+}
@@ -0,0 +1,175 @@
+package pgtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// GCOptions configures catalog gc.
+type GCOptions struct {
+	Database string // only this database's backups; empty for all
+	DryRun   bool
+	// Rehash records the current checksum of files that no longer match
+	// their manifest. Without it a mismatch, which may be corruption, is
+	// only reported and fails the run.
+	Rehash bool
+}
+
+// GCReport is what catalog gc found and, unless a dry run, fixed.
+type GCReport struct {
+	Removed    []string // manifests whose main dump is gone
+	Repaired   []string // manifests whose sizes or checksums were updated
+	Untracked  []string // backup files no manifest covers
+	Mismatched []string // files whose checksum differs, left as they are
+}
+
+// catalogGC reconciles the manifests in dir with the files actually there.
+// A manifest whose main dump is gone is removed, as is a latest pointer to
+// a missing file; manifests get the current sizes, checksums for files
+// that had none, and lose files that no longer exist. Dumps without a
+// manifest are reported, for catalog import to adopt. Checksum mismatches
+// fail with ErrVerification unless opts.Rehash.
+func catalogGC(ctx context.Context, dir string, opts GCOptions, logger *log.Logger) (GCReport, error) {
+	var report GCReport
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return report, err
+	}
+	present := map[string]bool{}
+	stems := map[string][]string{}
+	var manifests, links []string
+	for _, file := range files {
+		if file.Type()&os.ModeSymlink != 0 && strings.HasSuffix(file.Name(), ".latest") {
+			links = append(links, file.Name())
+			continue
+		}
+		b, err := ParseBackupFilename(file.Name())
+		if err != nil || file.IsDir() || (opts.Database != "" && b.Database != opts.Database) {
+			continue
+		}
+		present[file.Name()] = true
+		stems[b.Stem()] = append(stems[b.Stem()], file.Name())
+		if b.Kind == KindManifest {
+			manifests = append(manifests, file.Name())
+		}
+	}
+	act := func(format string, args ...any) {
+		if opts.DryRun {
+			format = "Would " + strings.ToLower(format[:1]) + format[1:]
+		}
+		logger.Printf("INFO: "+format, args...)
+	}
+
+	tracked := map[string]bool{}
+	for _, name := range manifests {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		path := filepath.Join(dir, name)
+		m, err := ReadManifest(path)
+		if err != nil {
+			logger.Printf("WARNING: Cannot read manifest '%s': %v", path, err)
+			continue
+		}
+		tracked[name] = true
+		if !present[m.File] {
+			act("Remove manifest %s: its backup %s is gone.", name, m.File)
+			report.Removed = append(report.Removed, name)
+			if !opts.DryRun {
+				if err := os.Remove(path); err != nil {
+					return report, ioError(err)
+				}
+			}
+			continue
+		}
+
+		changed := false
+		if m.Checksums == nil {
+			m.Checksums = map[string]string{}
+		}
+		b, _ := ParseBackupFilename(name)
+		for _, f := range stems[b.Stem()] {
+			if f != name {
+				tracked[f] = true
+				if _, ok := m.Checksums[f]; !ok {
+					m.Checksums[f] = "" // hashed below
+				}
+			}
+		}
+		for _, f := range slices.Sorted(maps.Keys(m.Checksums)) {
+			if !present[f] {
+				act("Drop %s from manifest %s: the file is gone.", f, name)
+				delete(m.Checksums, f)
+				changed = true
+				continue
+			}
+			sum, err := rereadChecksum(ctx, filepath.Join(dir, f), false)
+			if err != nil {
+				return report, fmt.Errorf("cannot read '%s': %w", filepath.Join(dir, f), err)
+			}
+			switch old := m.Checksums[f]; {
+			case old == sum:
+			case old == "":
+				act("Record the checksum of %s in manifest %s.", f, name)
+				m.Checksums[f], changed = sum, true
+			case opts.Rehash:
+				logger.Printf("WARNING: %s does not match manifest %s; recording its current checksum.", f, name)
+				m.Checksums[f], changed = sum, true
+			default:
+				logger.Printf("WARNING: %s does not match the checksum in manifest %s; it may be corrupt.", f, name)
+				report.Mismatched = append(report.Mismatched, f)
+			}
+		}
+		if fi, err := os.Stat(filepath.Join(dir, m.File)); err == nil && fi.Size() != m.Size {
+			act("Correct the size of %s in manifest %s from %d to %d.", m.File, name, m.Size, fi.Size())
+			m.Size, changed = fi.Size(), true
+		}
+		if changed {
+			report.Repaired = append(report.Repaired, name)
+			if !opts.DryRun {
+				if err := writeManifest(path, m); err != nil {
+					return report, err
+				}
+			}
+		}
+	}
+
+	for f := range present {
+		if !tracked[f] {
+			report.Untracked = append(report.Untracked, f)
+		}
+	}
+	sort.Strings(report.Untracked)
+	for _, f := range report.Untracked {
+		logger.Printf("WARNING: %s is not in the catalog; 'catalog import' can adopt it.", f)
+	}
+
+	for _, link := range links {
+		if opts.Database != "" && link != latestName(opts.Database) {
+			continue
+		}
+		path := filepath.Join(dir, link)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			act("Remove %s: it points to a missing backup.", link)
+			if !opts.DryRun {
+				if err := os.Remove(path); err != nil {
+					return report, ioError(err)
+				}
+			}
+		}
+	}
+
+	if len(report.Mismatched) > 0 {
+		return report, fmt.Errorf("%w: %d file(s) do not match their manifest checksum; check them, or use -rehash to accept them",
+			ErrVerification, len(report.Mismatched))
+	}
+	return report, nil
+}
@@ -0,0 +1,57 @@
+package pgtool
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// dumpGlobals writes the cluster's roles and tablespaces, which pg_dump
+// leaves out, to dst as a SQL script.
+func dumpGlobals(ctx context.Context, cfg Config, dst string, stderr io.Writer) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return ioError(err)
+	}
+	defer out.Close()
+
+	c := globalsCommand(cfg)
+	c.Stdout = out
+	if err := runCommand(ctx, cfg.runner(), c, stderr); err != nil {
+		return err
+	}
+	return ioError(out.Close())
+}
+
+// restoreGlobals runs a globals file written by dumpGlobals. Unlike other
+// scripts it keeps going after errors, since the target cluster usually
+// has some of the roles already; the errors are counted like pg_restore's.
+func restoreGlobals(ctx context.Context, cfg Config, globalsFile string, stderr io.Writer) error {
+	tempFile, err := decompressTemp(ctx, globalsFile)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile)
+	arg, stdin, err := fileInput(cfg.runner(), tempFile)
+	if err != nil {
+		return err
+	}
+	if arg == "" {
+		arg = "-"
+	}
+	c := Command{Name: "psql", Args: append(cfg.connArgs(), "-X", "-q", "-f", arg)}
+	if stdin != nil {
+		defer stdin.Close()
+		c.Stdin = stdin
+	}
+	return runCommand(ctx, cfg.runner(), c, stderr)
+}
+
+// globalsCommand returns the pg_dumpall command that dumps the roles and
+// tablespaces of cfg's server. It connects, and sets the session timeouts,
+// as the main pg_dump does.
+func globalsCommand(cfg Config) Command {
+	c := cfg.dumpCommand(append(cfg.dumpConnArgs(), "--globals-only"))
+	c.Name = "pg_dumpall"
+	return c
+}
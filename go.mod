@@ -0,0 +1,3 @@
+module github.com/aleksi1/pgtool
+
+go 1.24
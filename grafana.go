@@ -0,0 +1,72 @@
+package pgtool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// GrafanaNotifier adds an annotation to Grafana spanning each run, so
+// that load on database dashboards can be matched to backups and
+// restores. Without DashboardUID the annotation is organization-wide and
+// shows on every dashboard that queries annotations by its tags.
+type GrafanaNotifier struct {
+	URL          string // Grafana's base URL, e.g. https://grafana.example.com
+	Token        string // service account token
+	DashboardUID string
+	Tags         []string // added to "pgtool", the operation and the database
+	Client       *http.Client
+}
+
+func (n GrafanaNotifier) Name() string { return "grafana" }
+
+func (n GrafanaNotifier) Notify(ctx context.Context, r RunResult) error {
+	text := fmt.Sprintf("pgtool %s of %s: %s", r.Op, r.Database, r.Status)
+	if r.Error != "" {
+		text += " (" + r.Error + ")"
+	}
+	if r.Slow {
+		text += " (slow: " + r.Warning + ")"
+	}
+	tags := append([]string{"pgtool", r.Op}, n.Tags...)
+	if r.Database != "" {
+		tags = append(tags, r.Database)
+	}
+	annotation := map[string]any{
+		"time":    r.Started.UnixMilli(),
+		"timeEnd": r.Finished.UnixMilli(),
+		"tags":    tags,
+		"text":    text,
+	}
+	if n.DashboardUID != "" {
+		annotation["dashboardUID"] = n.DashboardUID
+	}
+	body, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+
+	u := strings.TrimSuffix(n.URL, "/") + "/api/annotations"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+n.Token)
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError("Grafana", resp)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+)
+
+// runHooks runs each SQL statement of a pre- or post-backup hook against
+// the configured database, in order and each in its own transaction.
+// Failures are logged; they only stop the hook, and fail the backup, with
+// HookFailureAborts.
+func runHooks(ctx context.Context, cfg Config, logger *log.Logger, name string, stmts []string, out io.Writer) error {
+	for _, stmt := range stmts {
+		logger.Printf("INFO: Running %s hook: %s", name, stmt)
+		c := hookCommand(cfg, stmt)
+		c.Stdout = out
+		if err := runCommand(ctx, cfg.runner(), c, out); err != nil {
+			err = contextErr(ctx, err)
+			if cfg.HookFailureAborts {
+				return fmt.Errorf("%s hook %q: %w", name, stmt, err)
+			}
+			logger.Printf("WARNING: Hook failed (%s): %v", name, err)
+		}
+	}
+	return nil
+}
+
+// hookCommand returns the psql command that runs a hook statement.
+func hookCommand(cfg Config, stmt string) Command {
+	return Command{Name: "psql", Args: append(cfg.connArgs(), "-X", "-q", "-v", "ON_ERROR_STOP=1", "-c", stmt)}
+}
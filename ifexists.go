@@ -0,0 +1,130 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// What a restore does when the target database already exists; see
+// Config.IfExists.
+const (
+	IfExistsClean  = "clean"  // drop and recreate the archive's objects in place
+	IfExistsDrop   = "drop"   // drop the whole database and create it afresh
+	IfExistsRename = "rename" // keep the database as <db>_old_<timestamp>
+	IfExistsFail   = "fail"   // refuse to touch it
+)
+
+func parseIfExists(s string) (string, error) {
+	switch s {
+	case "", IfExistsClean:
+		return IfExistsClean, nil
+	case IfExistsDrop, IfExistsRename, IfExistsFail:
+		return s, nil
+	}
+	return "", fmt.Errorf("%w: -if-exists must be drop, rename, fail or clean, not '%s'", ErrUsage, s)
+}
+
+// cleansTarget reports whether pg_restore restores over the existing
+// objects of the target database, rather than into one created for it.
+func (c Config) cleansTarget() bool {
+	return c.IfExists == "" || c.IfExists == IfExistsClean
+}
+
+// maintenance returns c connected to the postgres database, from which
+// the target database can be dropped, renamed and created.
+func (c Config) maintenance() Config {
+	c.Database = "postgres"
+	return c
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// quoteLiteral quotes s as an SQL string literal, for the queries pgtool
+// builds with values from flags, manifests and catalog records.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// maxIdentLen is the length in bytes of the longest identifier PostgreSQL
+// keeps; it silently truncates longer ones.
+const maxIdentLen = 63
+
+// oldName returns the n-th name -if-exists rename tries for database db
+// at t, counting from 1. db is cut short, at a character boundary, so that
+// the suffix still fits in maxIdentLen and tells the copies apart.
+func oldName(db string, t time.Time, n int) string {
+	suffix := "_old_" + t.Format("20060102_150405")
+	if n > 1 {
+		suffix += fmt.Sprintf("_%d", n)
+	}
+	for len(db)+len(suffix) > maxIdentLen {
+		_, size := utf8.DecodeLastRuneInString(db)
+		db = db[:len(db)-size]
+	}
+	return db + suffix
+}
+
+// databaseExists reports whether the server m connects to has a database
+// named name.
+func databaseExists(ctx context.Context, m Config, name string) (bool, error) {
+	out, err := queryScalar(ctx, m, "SELECT count(*) FROM pg_catalog.pg_database WHERE datname = "+quoteLiteral(name))
+	return out != "0", err
+}
+
+// prepareTarget applies cfg.IfExists to the target database and, unless
+// the restore cleans it in place, creates it empty from template0.
+func prepareTarget(ctx context.Context, cfg Config, logger *log.Logger) error {
+	if cfg.cleansTarget() {
+		return nil
+	}
+	if cfg.DSN != "" {
+		return fmt.Errorf("%w: -if-exists %s needs -db, not a connection string", ErrUsage, cfg.IfExists)
+	}
+	m := cfg.maintenance()
+	exists, err := databaseExists(ctx, m, cfg.Database)
+	if err != nil {
+		return fmt.Errorf("cannot check for database '%s': %w", cfg.Database, err)
+	}
+	db := quoteIdent(cfg.Database)
+	if exists {
+		switch cfg.IfExists {
+		case IfExistsFail:
+			return fmt.Errorf("%w: '%s' (-if-exists fail)", ErrDatabaseExists, cfg.Database)
+		case IfExistsDrop:
+			logger.Printf("INFO: Dropping existing database '%s'.", cfg.Database)
+			fmt.Printf("Dropping existing database '%s'...\n", cfg.Database)
+			if _, err := queryScalar(ctx, m, "DROP DATABASE "+db); err != nil {
+				return fmt.Errorf("cannot drop database '%s': %w", cfg.Database, err)
+			}
+		case IfExistsRename:
+			// Long names truncated to the same prefix, or restores in
+			// the same second, would otherwise rename onto each other
+			now := time.Now()
+			var old string
+			for n := 1; ; n++ {
+				old = oldName(cfg.Database, now, n)
+				if taken, err := databaseExists(ctx, m, old); err != nil {
+					return fmt.Errorf("cannot check for database '%s': %w", old, err)
+				} else if !taken {
+					break
+				}
+			}
+			logger.Printf("INFO: Renaming existing database '%s' to '%s'.", cfg.Database, old)
+			fmt.Printf("Renaming existing database '%s' to '%s'...\n", cfg.Database, old)
+			if _, err := queryScalar(ctx, m, "ALTER DATABASE "+db+" RENAME TO "+quoteIdent(old)); err != nil {
+				return fmt.Errorf("cannot rename database '%s': %w", cfg.Database, err)
+			}
+		}
+	}
+	logger.Printf("INFO: Creating database '%s'.", cfg.Database)
+	if _, err := queryScalar(ctx, m, "CREATE DATABASE "+db+" TEMPLATE template0"); err != nil {
+		return fmt.Errorf("cannot create database '%s': %w", cfg.Database, err)
+	}
+	return nil
+}
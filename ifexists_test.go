@@ -0,0 +1,77 @@
+package pgtool
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOldName(t *testing.T) {
+	now := time.Date(2026, 10, 15, 9, 30, 0, 0, time.Local)
+	long := strings.Repeat("a", 60)
+	tests := []struct {
+		db   string
+		n    int
+		want string
+	}{
+		{"app", 1, "app_old_20261015_093000"},
+		{"app", 2, "app_old_20261015_093000_2"},
+		{long, 1, strings.Repeat("a", 43) + "_old_20261015_093000"},
+		{long, 12, strings.Repeat("a", 40) + "_old_20261015_093000_12"},
+		{strings.Repeat("ä", 30), 1, strings.Repeat("ä", 21) + "_old_20261015_093000"},
+	}
+	for _, tt := range tests {
+		got := oldName(tt.db, now, tt.n)
+		if got != tt.want || len(got) > maxIdentLen {
+			t.Errorf("oldName(%q, %d) = %q (%d bytes), want %q", tt.db, tt.n, got, len(got), tt.want)
+		}
+	}
+}
+
+func TestPrepareTargetRename(t *testing.T) {
+	// The database and its first rename target already exist
+	var cmds []Command
+	cfg := Config{User: "pgtool", Host: "db1", Database: "app", IfExists: IfExistsRename}
+	cfg.Runner = runnerFunc(func(ctx context.Context, c Command) error {
+		cmds = append(cmds, c)
+		query := c.Args[len(c.Args)-1]
+		if strings.HasPrefix(query, "SELECT count(*)") {
+			out := "0"
+			if strings.HasSuffix(query, "'app'") || strings.Contains(query, "_old_") && !strings.HasSuffix(query, "_2'") {
+				out = "1"
+			}
+			io.WriteString(c.Stdout, out+"\n")
+		}
+		return nil
+	})
+	if err := prepareTarget(context.Background(), cfg, log.New(io.Discard, "", 0)); err != nil {
+		t.Fatal(err)
+	}
+	var queries []string
+	for _, c := range cmds {
+		queries = append(queries, c.Args[len(c.Args)-1])
+	}
+	if len(queries) != 5 || !strings.Contains(queries[2], "_old_") || !strings.HasSuffix(queries[2], `_2'`) ||
+		!strings.HasPrefix(queries[3], `ALTER DATABASE "app" RENAME TO "app_old_`) || !strings.HasSuffix(queries[3], `_2"`) ||
+		queries[4] != `CREATE DATABASE "app" TEMPLATE template0` {
+		t.Errorf("queries:\n%s", strings.Join(queries, "\n"))
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct{ s, ident, literal string }{
+		{"app", `"app"`, `'app'`},
+		{`o'brien "db"`, `"o'brien ""db"""`, `'o''brien "db"'`},
+	}
+	for _, tt := range tests {
+		if got := quoteIdent(tt.s); got != tt.ident {
+			t.Errorf("quoteIdent(%q) = %s, want %s", tt.s, got, tt.ident)
+		}
+		if got := quoteLiteral(tt.s); got != tt.literal {
+			t.Errorf("quoteLiteral(%q) = %s, want %s", tt.s, got, tt.literal)
+		}
+	}
+}
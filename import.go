@@ -0,0 +1,245 @@
+package pgtool
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveMagic starts every custom-format pg_dump archive.
+const archiveMagic = "PGDMP"
+
+// ImportOptions configures catalog import.
+type ImportOptions struct {
+	// Database names the database of every imported dump, instead of the
+	// name recorded in the archive.
+	Database string
+	// Move removes each source file once it is imported.
+	Move   bool
+	DryRun bool
+}
+
+// sniffArchive reports whether the file at path is a custom-format pg_dump
+// archive, plain or gzipped.
+func sniffArchive(path string) (archive, gzipped bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, false, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return false, true, nil
+		}
+		r, gzipped = gr, true
+	}
+	head := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(r, head); err != nil {
+		return false, gzipped, nil
+	}
+	return string(head) == archiveMagic, gzipped, nil
+}
+
+// readArchiveHeader returns the fields of the comment header pg_restore -l
+// prints for the archive at path, such as "dbname" and "Dumped from
+// database version", with the creation time under "created".
+func readArchiveHeader(ctx context.Context, path string, gzipped bool) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var stdin io.Reader = f
+	if gzipped {
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		stdin = gr
+	}
+	var out bytes.Buffer
+	if err := runCommand(ctx, ExecRunner{}, Command{Name: "pg_restore", Args: []string{"-l"}, Stdin: stdin, Stdout: &out}, nil); err != nil {
+		return nil, err
+	}
+	header := map[string]string{}
+	sc := bufio.NewScanner(&out)
+	for sc.Scan() {
+		line, ok := strings.CutPrefix(sc.Text(), ";")
+		if !ok {
+			break // the header ends where the entries start
+		}
+		line = strings.TrimSpace(line)
+		if t, ok := strings.CutPrefix(line, "Archive created at "); ok {
+			header["created"] = t
+		} else if k, v, ok := strings.Cut(line, ": "); ok {
+			header[k] = v
+		}
+	}
+	return header, sc.Err()
+}
+
+// copyFile copies src to dst, written under a partial name and renamed
+// once synced, and returns the SHA-256 of dst.
+func copyFile(ctx context.Context, src, dst string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	tmp := dst + partialSuffix
+	out, err := os.Create(tmp)
+	if err != nil {
+		return "", ioError(err)
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+	h := sha256.New()
+	if _, err := copyContext(ctx, io.MultiWriter(out, h), in); err != nil {
+		return "", ioError(err)
+	}
+	if err := out.Sync(); err != nil {
+		return "", ioError(err)
+	}
+	if err := out.Close(); err != nil {
+		return "", ioError(err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), os.Rename(tmp, dst)
+}
+
+// importBackup adopts the dump at src, made outside pgtool or before it
+// wrote manifests, into dir: it is copied there gzipped under a pgtool
+// name, with a manifest tagged "imported", so that list, prune and restore
+// treat it like any other backup. A file already named by pgtool keeps its
+// database and time; others take them from the archive header, or the
+// modification time. It returns the path of the imported dump, or "" if src is not a
+// custom-format archive.
+func importBackup(ctx context.Context, src, dir string, opts ImportOptions, logger *log.Logger) (string, error) {
+	archive, gzipped, err := sniffArchive(src)
+	if err != nil || !archive {
+		return "", err
+	}
+	header, err := readArchiveHeader(ctx, src, gzipped)
+	if err != nil {
+		return "", fmt.Errorf("cannot read archive header of '%s': %w", src, err)
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	legacy, nameErr := ParseBackupFilename(filepath.Base(src))
+
+	// A pgtool file name is kept; other names say nothing reliable
+	name := BackupName{Database: legacy.Database, Time: legacy.Time, Kind: KindDump, Format: "custom", Compression: "gzip"}
+	if nameErr != nil {
+		name.Database, name.Time = header["dbname"], fi.ModTime()
+		if t, err := time.Parse("2006-01-02 15:04:05 MST", header["created"]); err == nil {
+			name.Time = t.Local()
+		}
+	}
+	if opts.Database != "" {
+		name.Database = opts.Database
+	}
+	if name.Database == "" {
+		return "", fmt.Errorf("%w: cannot tell the database of '%s'; use -db", ErrUsage, src)
+	}
+
+	dst := filepath.Join(dir, name.String())
+	manifestName := name
+	manifestName.Kind = KindManifest
+	manifestFile := filepath.Join(dir, manifestName.String())
+	if _, err := os.Stat(manifestFile); err == nil {
+		logger.Printf("INFO: '%s' is already in the catalog as %s.", src, name.Stem())
+		return dst, nil
+	}
+	if opts.DryRun {
+		fmt.Printf("Would import %s as %s\n", src, filepath.Base(dst))
+		return dst, nil
+	}
+
+	var sum string
+	switch {
+	case filepath.Clean(src) == filepath.Clean(dst):
+		// A pgtool backup from before manifests; it only needs one
+		sum, err = rereadChecksum(ctx, dst, false)
+	case gzipped:
+		sum, err = copyFile(ctx, src, dst)
+	default:
+		sum, err = compressFile(ctx, src, dst, gzip.DefaultCompression)
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot import '%s': %w", src, err)
+	}
+	m := Manifest{
+		Version:     ManifestVersion,
+		Database:    name.Database,
+		Created:     name.Time,
+		Format:      name.Format,
+		Compression: "gzip",
+		File:        filepath.Base(dst),
+		Tags:        []string{"imported"},
+		Labels:      map[string]string{"source": src},
+		Checksums:   map[string]string{filepath.Base(dst): sum},
+	}
+	if v := header["Dumped from database version"]; v != "" {
+		m.Versions = map[string]string{"server": v, "pg_dump": header["Dumped by pg_dump version"]}
+	}
+	if fi, err := os.Stat(dst); err == nil {
+		m.Size = fi.Size()
+	}
+	if err := writeManifest(manifestFile, m); err != nil {
+		return "", err
+	}
+	if opts.Move && filepath.Clean(src) != filepath.Clean(dst) {
+		if err := os.Remove(src); err != nil {
+			logger.Printf("WARNING: Imported '%s' but cannot remove it: %v", src, err)
+		}
+	}
+	logger.Printf("INFO: Imported '%s' as %s.", src, filepath.Base(dst))
+	return dst, nil
+}
+
+// importBackups imports every custom-format archive in srcDir into dir,
+// skipping other files. One file that fails does not stop the others;
+// the errors are joined.
+func importBackups(ctx context.Context, srcDir, dir string, opts ImportOptions, logger *log.Logger) (int, error) {
+	files, err := os.ReadDir(srcDir)
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	n := 0
+	for _, file := range files {
+		if file.IsDir() || strings.HasSuffix(file.Name(), partialSuffix) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		src := filepath.Join(srcDir, file.Name())
+		dst, err := importBackup(ctx, src, dir, opts, logger)
+		switch {
+		case err != nil:
+			logger.Printf("ERROR: %v", err)
+			errs = append(errs, err)
+		case dst == "":
+			logger.Printf("INFO: Skipped '%s': not a custom-format pg_dump archive.", src)
+		default:
+			n++
+		}
+	}
+	return n, errors.Join(errs...)
+}
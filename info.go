@@ -0,0 +1,169 @@
+package pgtool
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// BackupInfo is what info reports about one backup: its manifest, the
+// size of each file and a count of the archive's objects by type.
+type BackupInfo struct {
+	Path     string           `json:"path"`
+	Manifest *Manifest        `json:"manifest,omitempty"`
+	Files    map[string]int64 `json:"files"`
+	TOC      map[string]int   `json:"toc,omitempty"`
+	// TOCError says why the TOC could not be read, e.g. no pg_restore.
+	TOCError string `json:"toc_error,omitempty"`
+}
+
+// tocTypes are the object types counted in the TOC summary. Longer types
+// come first so that "TABLE DATA" is not counted as a "TABLE".
+var tocTypes = []string{
+	"MATERIALIZED VIEW", "FK CONSTRAINT", "TABLE DATA", "BLOB METADATA", "SEQUENCE SET",
+	"CONSTRAINT", "EXTENSION", "FUNCTION", "SEQUENCE", "TRIGGER", "SCHEMA", "INDEX", "TABLE",
+	"BLOBS", "BLOB", "VIEW",
+}
+
+// summarizeTOC counts the entries of a pg_restore -l listing by type.
+func summarizeTOC(entries []string) map[string]int {
+	counts := map[string]int{}
+	for _, e := range entries {
+		// "<id>; <catalog oid> <oid> <type> <schema> <name> <owner>"
+		f := strings.SplitN(e, " ", 4)
+		if len(f) < 4 {
+			continue
+		}
+		for _, t := range tocTypes {
+			if strings.HasPrefix(f[3], t+" ") {
+				counts[t]++
+				break
+			}
+		}
+	}
+	return counts
+}
+
+// resolveBackup finds the main dump named by arg: a path, or a backup ID
+// such as "mydb_2025-08-09_114200" in dir.
+func resolveBackup(arg, dir string) (string, error) {
+	if strings.HasSuffix(arg, ".manifest.json") {
+		arg = strings.TrimSuffix(arg, ".manifest.json") + ".dump.gz"
+	}
+	if _, err := os.Stat(arg); err == nil {
+		return arg, nil
+	}
+	path := filepath.Join(dir, strings.TrimSuffix(arg, ".dump.gz")+".dump.gz")
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("%w: no backup '%s' (not a file, nor an ID in %s)", ErrUsage, arg, dir)
+	}
+	return path, nil
+}
+
+// backupInfo gathers the details of the backup whose main dump is at path.
+// The TOC is read by streaming the dump through pg_restore -l, and left
+// out with the reason if that fails.
+func backupInfo(ctx context.Context, path string) (BackupInfo, error) {
+	info := BackupInfo{Path: path, Files: map[string]int64{}}
+	stem := strings.TrimSuffix(path, ".dump.gz")
+	m, err := ReadManifest(stem + ".manifest.json")
+	switch {
+	case err == nil:
+		info.Manifest = &m
+	case !errors.Is(err, os.ErrNotExist):
+		return info, err
+	}
+	matches, _ := filepath.Glob(stem + ".*")
+	for _, f := range matches {
+		if b, err := ParseBackupFilename(filepath.Base(f)); err == nil && b.Stem() == filepath.Base(stem) {
+			if fi, err := os.Stat(f); err == nil {
+				info.Files[filepath.Base(f)] = fi.Size()
+			}
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return info, fmt.Errorf("%s: %w", path, err)
+	}
+	entries, err := readTOC(ctx, ExecRunner{}, "", gr)
+	if err != nil {
+		info.TOCError = err.Error()
+	} else {
+		info.TOC = summarizeTOC(entries)
+	}
+	return info, nil
+}
+
+// writeBackupInfo writes info to w as aligned text, or as JSON.
+func writeBackupInfo(w io.Writer, info BackupInfo, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	row := func(k, v string) {
+		if v != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", k, v)
+		}
+	}
+	row("Backup", info.Path)
+	if m := info.Manifest; m != nil {
+		row("Database", m.Database)
+		row("Host", m.Host)
+		row("Created", m.Created.Format("2006-01-02 15:04:05 MST"))
+		row("Duration", m.Duration)
+		row("Server", m.Versions["server"])
+		row("pg_dump", m.Versions["pg_dump"])
+		row("Format", m.Format)
+		row("Compression", m.Compression)
+		row("Tags", strings.Join(m.Tags, ", "))
+		row("Command", strings.Join(m.Args, " "))
+		row("Extensions", joinExtensions(m.Extensions))
+	} else {
+		row("Manifest", "missing; the run did not finish or predates manifests")
+	}
+	var names []string
+	var total int64
+	for name, size := range info.Files {
+		names = append(names, name)
+		total += size
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := formatBytes(info.Files[name])
+		if info.Manifest != nil {
+			if sum := info.Manifest.Checksums[name]; sum != "" {
+				v += "  sha256:" + sum
+			}
+		}
+		row("File "+name, v)
+	}
+	row("Total size", formatBytes(total))
+	if info.TOCError != "" {
+		row("Contents", "unavailable: "+info.TOCError)
+	} else {
+		var parts []string
+		for _, t := range tocTypes {
+			if n := info.TOC[t]; n > 0 {
+				parts = append(parts, fmt.Sprintf("%d %s", n, strings.ToLower(t)))
+			}
+		}
+		row("Contents", strings.Join(parts, ", "))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,87 @@
+package pgtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// latestName names the pointer to a database's newest full backup: in
+// the backup directory a symlink to the main dump, and on remote storage
+// a small object holding the dump's name.
+func latestName(db string) string { return db + ".latest" }
+
+// fullBackup reports whether a backup holds the whole database, and so
+// may become the latest one.
+func (c Config) fullBackup() bool {
+	return len(c.Tables) == 0 && c.ChangedPartitions == "" && c.Subset == nil
+}
+
+// updateLatest points the latest pointers of cfg's backup set at file,
+// locally and on every storage backend. Each pointer is replaced in one
+// step, so readers see either the old backup or the new one.
+func updateLatest(ctx context.Context, cfg Config, file string, logger *log.Logger) error {
+	dir, name := filepath.Dir(file), filepath.Base(file)
+	link := filepath.Join(dir, latestName(cfg.backupSet()))
+	tmp := link + partialSuffix
+	os.Remove(tmp)
+	if err := os.Symlink(name, tmp); err != nil {
+		return ioError(err)
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return ioError(err)
+	}
+	if len(cfg.Storage) > 0 {
+		markerDir, err := os.MkdirTemp("", "pgtool-latest-")
+		if err != nil {
+			return ioError(err)
+		}
+		defer os.RemoveAll(markerDir)
+		marker := filepath.Join(markerDir, latestName(cfg.backupSet()))
+		if err := os.WriteFile(marker, []byte(name+"\n"), 0644); err != nil {
+			return ioError(err)
+		}
+		if err := uploadAll(ctx, cfg.Storage, marker, cfg.Retry, logger); err != nil {
+			return err
+		}
+	}
+	logger.Printf("INFO: Latest backup of '%s' is now %s.", cfg.backupSet(), name)
+	return nil
+}
+
+// resolveLatest returns the path in dir of db's latest backup, following
+// the local pointer or, if there is none, the one on the first storage
+// backend. The file itself may then have to be downloaded too.
+func resolveLatest(ctx context.Context, dir, db string, backends []StorageBackend) (string, error) {
+	target, err := os.Readlink(filepath.Join(dir, latestName(db)))
+	if err == nil {
+		return filepath.Join(dir, target), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) || len(backends) == 0 {
+		return "", fmt.Errorf("no latest backup of '%s' in %s: %w", db, dir, err)
+	}
+	tmp, err := os.MkdirTemp("", "pgtool-latest-")
+	if err != nil {
+		return "", ioError(err)
+	}
+	defer os.RemoveAll(tmp)
+	marker := filepath.Join(tmp, latestName(db))
+	b := backends[0]
+	if err := b.Download(ctx, latestName(db), marker); err != nil {
+		return "", &StorageError{Backend: b.Name(), Op: "download", Err: fmt.Errorf("latest pointer of '%s': %w", db, err)}
+	}
+	data, err := os.ReadFile(marker)
+	if err != nil {
+		return "", ioError(err)
+	}
+	name := strings.TrimSpace(string(data))
+	if b, err := ParseBackupFilename(name); err != nil || b.Kind != KindDump || name != filepath.Base(name) {
+		return "", fmt.Errorf("latest pointer of '%s' on %s holds %q, not a backup", db, backends[0].Name(), name)
+	}
+	return filepath.Join(dir, name), nil
+}
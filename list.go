@@ -0,0 +1,186 @@
+package pgtool
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// BackupEntry describes one backup in a backup directory: its main dump
+// and the files written alongside it.
+type BackupEntry struct {
+	Database string    `json:"database"`
+	Time     time.Time `json:"time"`
+	File     string    `json:"file"`
+	Files    int       `json:"files"`
+	Size     int64     `json:"size"` // of all the backup's files
+	// Status is "ok" for a backup with a manifest, or "no-manifest" for
+	// one whose run never finished or that predates manifests.
+	Status string            `json:"status"`
+	Tags   []string          `json:"tags,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Duration is how long the backup took, and DatabaseSize the size of
+	// the database backed up, from its manifest.
+	Duration     string `json:"duration,omitempty"`
+	DatabaseSize int64  `json:"database_size,omitempty"`
+	Slow         bool   `json:"slow,omitempty"`
+}
+
+// listBackups returns the backups in dir that match f, oldest first. The
+// database and time come from the file names; only the manifests of the
+// backups that match on those are read.
+func listBackups(dir string, f ListFilter) ([]BackupEntry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	byStem := map[string]*BackupEntry{}
+	manifests := map[string]string{}
+	sizes := map[string]int64{}
+	counts := map[string]int{}
+	for _, file := range files {
+		b, err := ParseBackupFilename(file.Name())
+		if err != nil || file.IsDir() || !f.matchName(b) {
+			continue
+		}
+		stem := b.Stem()
+		if fi, err := file.Info(); err == nil {
+			sizes[stem] += fi.Size()
+		}
+		counts[stem]++
+		switch b.Kind {
+		case KindDump:
+			byStem[stem] = &BackupEntry{Database: b.Database, Time: b.Time, File: file.Name(), Status: "no-manifest"}
+		case KindManifest:
+			manifests[stem] = filepath.Join(dir, file.Name())
+		}
+	}
+	var entries []BackupEntry
+	for stem, e := range byStem {
+		e.Size, e.Files = sizes[stem], counts[stem]
+		if path, ok := manifests[stem]; ok {
+			if m, err := ReadManifest(path); err == nil {
+				e.Status, e.Tags, e.Labels, e.Duration = "ok", m.Tags, m.Labels, m.Duration
+				e.DatabaseSize, e.Slow = m.DatabaseSize, m.Slow
+			}
+		}
+		if f.match(*e) {
+			entries = append(entries, *e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].Time.Before(entries[j].Time)
+		}
+		return entries[i].Database < entries[j].Database
+	})
+	return entries, nil
+}
+
+// ListFilter selects backups for list; zero fields match everything.
+type ListFilter struct {
+	Database     string
+	Since, Until time.Time
+	Tags         []string          // a backup must carry all of them
+	Labels       map[string]string // and have all these label values
+	Status       string
+}
+
+// matchName reports whether a backup file name could match f.
+func (f ListFilter) matchName(b BackupName) bool {
+	return (f.Database == "" || b.Database == f.Database) &&
+		(f.Since.IsZero() || !b.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || !b.Time.After(f.Until))
+}
+
+func (f ListFilter) match(e BackupEntry) bool {
+	if f.Status != "" && e.Status != f.Status {
+		return false
+	}
+	for _, t := range f.Tags {
+		if !slices.Contains(e.Tags, t) {
+			return false
+		}
+	}
+	for k, v := range f.Labels {
+		if e.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// parseListTime parses a -since or -until value: a date, a date and time,
+// or a duration such as "72h" meaning that long before now.
+func parseListTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: '%s' is not a date (2006-01-02), date and time, or duration (72h)", ErrUsage, s)
+}
+
+// formatAge formats how long ago a backup was taken, e.g. "3d4h".
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh%dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%dd%dh", int(d.Hours())/24, int(d.Hours())%24)
+}
+
+// writeBackupList writes entries to w as a table, JSON or CSV.
+func writeBackupList(w io.Writer, entries []BackupEntry, format string, now time.Time) error {
+	switch format {
+	case "json":
+		type jsonEntry struct {
+			BackupEntry
+			AgeSeconds int64 `json:"age_seconds"`
+		}
+		out := make([]jsonEntry, len(entries))
+		for i, e := range entries {
+			out[i] = jsonEntry{e, int64(now.Sub(e.Time).Seconds())}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"database", "time", "size", "age_seconds", "status", "tags", "labels", "file"})
+		for _, e := range entries {
+			cw.Write([]string{e.Database, e.Time.Format(time.RFC3339), strconv.FormatInt(e.Size, 10),
+				strconv.FormatInt(int64(now.Sub(e.Time).Seconds()), 10), e.Status, strings.Join(e.Tags, ";"),
+				mapFlag(e.Labels).String(), e.File})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table", "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "DATABASE\tTIME\tSIZE\tAGE\tSTATUS\tTAGS\tLABELS\tFILE")
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Database, e.Time.Format("2006-01-02 15:04:05"),
+				formatBytes(e.Size), formatAge(now.Sub(e.Time)), e.Status, strings.Join(e.Tags, ","),
+				mapFlag(e.Labels).String(), e.File)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("%w: -format must be table, json or csv, not '%s'", ErrUsage, format)
+}
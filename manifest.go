@@ -0,0 +1,100 @@
+package pgtool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ManifestVersion is the current manifest schema version.
+const ManifestVersion = 1
+
+// Manifest describes one backup. It is written next to the dump as
+// <db>_<timestamp>.manifest.json.
+type Manifest struct {
+	Version     int       `json:"version"`
+	Database    string    `json:"database"`
+	Host        string    `json:"host"`
+	Created     time.Time `json:"created"`
+	Format      string    `json:"format"`
+	Compression string    `json:"compression"`
+	File        string    `json:"file"`
+	Size        int64     `json:"size"`
+	BlobsFile   string    `json:"blobs_file,omitempty"`
+	SubsetFile  string    `json:"subset_file,omitempty"`
+	GlobalsFile string    `json:"globals_file,omitempty"`
+	// Tags and Labels are the backup's -tag and -label values.
+	Tags   []string          `json:"tags,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// SetID names the backup -all run the backup was taken in.
+	SetID string `json:"set_id,omitempty"`
+	// Args are the command line arguments of the run, with secrets
+	// redacted, and Duration how long it took up to writing the manifest.
+	Args     []string `json:"args,omitempty"`
+	Duration string   `json:"duration,omitempty"`
+	// Slow marks a backup that took longer than its -max-duration.
+	Slow bool `json:"slow,omitempty"`
+	// DatabaseSize is pg_database_size when the backup started, from
+	// which estimate learns the compression ratio and dump speed.
+	DatabaseSize int64 `json:"database_size,omitempty"`
+	// Versions records the versions of the server and of pg_dump.
+	Versions map[string]string `json:"versions,omitempty"`
+	// Extensions lists the extensions installed in the database when
+	// it was backed up; restore checks the target can install them.
+	Extensions []Extension `json:"extensions,omitempty"`
+	// PartitionStats records the write counter of each partition at the
+	// time of a -changed-partitions backup, for the next one to compare.
+	PartitionStats map[string]int64 `json:"partition_stats,omitempty"`
+	// Checksums maps each file of the backup to its hex SHA-256.
+	Checksums map[string]string `json:"checksums,omitempty"`
+}
+
+// ParseManifest decodes a manifest from r.
+func ParseManifest(r io.Reader) (Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return m, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.Version < 1 || m.Version > ManifestVersion {
+		return m, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	return m, nil
+}
+
+// ReadManifest reads and parses the manifest file at path.
+func ReadManifest(path string) (Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer f.Close()
+	return ParseManifest(f)
+}
+
+// writeManifest writes m to path as indented JSON. Like the dump files it
+// is written under a partial name and renamed once synced.
+func writeManifest(path string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + partialSuffix
+	f, err := os.Create(tmp)
+	if err != nil {
+		return ioError(err)
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return ioError(err)
+	}
+	if err := f.Sync(); err != nil {
+		return ioError(err)
+	}
+	if err := f.Close(); err != nil {
+		return ioError(err)
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,246 @@
+package pgtool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Masking rule kinds.
+const (
+	MaskEmail    = "email"    // deterministic fake address, user_<hash>@example.invalid
+	MaskHash     = "hash"     // salted SHA-256, hex, truncated to 32 characters
+	MaskNull     = "null"     // NULL
+	MaskConstant = "constant" // Value
+	MaskRegex    = "regex"    // replace Pattern matches with Replace
+)
+
+// MaskRule masks one column. Table may be schema-qualified ("public.users")
+// or bare ("users", any schema).
+type MaskRule struct {
+	Table   string `json:"table"`
+	Column  string `json:"column"`
+	Rule    string `json:"rule"`
+	Value   string `json:"value,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	Replace string `json:"replace,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// MaskConfig is the masking rules file. Salt keeps hashed values from
+// being reversed by hashing guesses. Targets are the non-production
+// databases the rules are meant for, as host/database patterns such as
+// "staging.internal/*" or "*/app_dev"; restoring into any other is refused.
+type MaskConfig struct {
+	Salt    string     `json:"salt"`
+	Targets []string   `json:"targets"`
+	Rules   []MaskRule `json:"rules"`
+
+	ephemeral bool // allows any target: an -ephemeral container
+}
+
+// LoadMaskConfig reads and validates a JSON masking rules file.
+func LoadMaskConfig(path string) (*MaskConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mc MaskConfig
+	if err := json.Unmarshal(data, &mc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, t := range mc.Targets {
+		if _, err := filepath.Match(t, ""); err != nil {
+			return nil, fmt.Errorf("%s: target '%s': %w", path, t, err)
+		}
+	}
+	for i := range mc.Rules {
+		r := &mc.Rules[i]
+		if r.Table == "" || r.Column == "" {
+			return nil, fmt.Errorf("%s: rule %d: table and column are required", path, i+1)
+		}
+		switch r.Rule {
+		case MaskEmail, MaskHash, MaskNull, MaskConstant:
+		case MaskRegex:
+			if r.re, err = regexp.Compile(r.Pattern); err != nil {
+				return nil, fmt.Errorf("%s: rule %d: %w", path, i+1, err)
+			}
+		default:
+			return nil, fmt.Errorf("%s: rule %d: unknown rule '%s'", path, i+1, r.Rule)
+		}
+	}
+	return &mc, nil
+}
+
+// allows reports whether the rules may mask a restore into target, as
+// returned by maskTarget.
+func (mc *MaskConfig) allows(target string) bool {
+	if mc.ephemeral {
+		return true
+	}
+	for _, t := range mc.Targets {
+		if ok, _ := filepath.Match(t, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// maskTarget returns the host/database c restores into, from -host and
+// -db or from its connection string, for MaskConfig.allows.
+func maskTarget(c Config) string {
+	if c.DSN == "" {
+		return c.Host + "/" + c.Database
+	}
+	if u, err := url.Parse(c.DSN); err == nil && u.Scheme != "" {
+		return u.Hostname() + "/" + strings.TrimPrefix(u.Path, "/")
+	}
+	var host, db string
+	for _, f := range strings.Fields(c.DSN) {
+		switch k, v, _ := strings.Cut(f, "="); k {
+		case "host":
+			host = v
+		case "dbname":
+			db = v
+		}
+	}
+	return host + "/" + db
+}
+
+// rulesFor returns the rules for each column of a COPY block, indexed by
+// column position; nil entries are left untouched. It returns nil if no
+// column of table is masked.
+func (mc *MaskConfig) rulesFor(table string, columns []string) []*MaskRule {
+	var out []*MaskRule
+	for _, r := range mc.tableRules(table) {
+		for j, col := range columns {
+			if unquoteIdent(col) == r.Column {
+				if out == nil {
+					out = make([]*MaskRule, len(columns))
+				}
+				out[j] = r
+			}
+		}
+	}
+	return out
+}
+
+// tableRules returns the rules that mask a column of table.
+func (mc *MaskConfig) tableRules(table string) []*MaskRule {
+	schema, name := "", unquoteIdent(table)
+	if i := strings.Index(table, "."); i >= 0 {
+		schema, name = unquoteIdent(table[:i]), unquoteIdent(table[i+1:])
+	}
+	var out []*MaskRule
+	for i := range mc.Rules {
+		if r := &mc.Rules[i]; r.Table == name || r.Table == schema+"."+name {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// apply masks a single COPY text field (still escaped).
+func (mc *MaskConfig) apply(r *MaskRule, field string) string {
+	if field == `\N` {
+		return field
+	}
+	switch r.Rule {
+	case MaskNull:
+		return `\N`
+	case MaskConstant:
+		return copyEscape(r.Value)
+	case MaskHash:
+		return mc.hash(copyUnescape(field))
+	case MaskEmail:
+		return "user_" + mc.hash(copyUnescape(field))[:12] + "@example.invalid"
+	case MaskRegex:
+		return copyEscape(r.re.ReplaceAllString(copyUnescape(field), r.Replace))
+	}
+	return field
+}
+
+func (mc *MaskConfig) hash(s string) string {
+	sum := sha256.Sum256([]byte(mc.Salt + s))
+	return hex.EncodeToString(sum[:])[:32]
+}
+
+func maskRow(line string, rules []*MaskRule, mc *MaskConfig) string {
+	fields := strings.Split(strings.TrimSuffix(line, "\n"), "\t")
+	for i, r := range rules {
+		if r != nil && i < len(fields) {
+			fields[i] = mc.apply(r, fields[i])
+		}
+	}
+	return strings.Join(fields, "\t") + "\n"
+}
+
+var copyEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, "\b", `\b`, "\f", `\f`, "\v", `\v`)
+
+func copyEscape(s string) string { return copyEscaper.Replace(s) }
+
+// copyUnescape decodes a COPY text field as PostgreSQL does: \b, \f, \n,
+// \r, \t and \v, one to three octal digits, x and one or two hex digits,
+// and any other character after a backslash stands for itself.
+func copyUnescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; {
+		case c >= '0' && c <= '7':
+			n := 0
+			for j := 0; j < 3 && i < len(s) && s[i] >= '0' && s[i] <= '7'; i, j = i+1, j+1 {
+				n = n*8 + int(s[i]-'0')
+			}
+			i--
+			b.WriteByte(byte(n))
+		case c == 'x' && i+1 < len(s) && isHexDigit(s[i+1]):
+			n := 0
+			for j := 0; j < 2 && i+1 < len(s) && isHexDigit(s[i+1]); i, j = i+1, j+1 {
+				n = n*16 + hexValue(s[i+1])
+			}
+			b.WriteByte(byte(n))
+		default:
+			if e := strings.IndexByte("btnvfr", c); e >= 0 {
+				c = "\b\t\n\v\f\r"[e]
+			}
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func hexValue(c byte) int {
+	switch {
+	case c >= 'a':
+		return int(c-'a') + 10
+	case c >= 'A':
+		return int(c-'A') + 10
+	}
+	return int(c - '0')
+}
+
+func unquoteIdent(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return strings.ReplaceAll(s[1:len(s)-1], `""`, `"`)
+	}
+	return s
+}
@@ -0,0 +1,151 @@
+package pgtool
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MQTTNotifier publishes every run result as JSON to an MQTT broker, for
+// dashboards such as Home Assistant. It speaks just enough MQTT 3.1.1 to
+// connect, publish one message and disconnect.
+type MQTTNotifier struct {
+	URL    string // mqtt://[user:password@]host[:1883] or mqtts:// for TLS
+	Topic  string // "{op}" and "{database}" are replaced, e.g. "pgtool/{database}/{op}"
+	QoS    byte   // 0 or 1
+	Retain bool   // keep the last result on the broker for new subscribers
+}
+
+func (n MQTTNotifier) Name() string { return "mqtt" }
+
+// mqttString encodes s as a length-prefixed MQTT string.
+func mqttString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+// mqttPacket encodes a packet with the given first header byte.
+func mqttPacket(header byte, body []byte) []byte {
+	p := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		p = append(p, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(p, body...)
+}
+
+// readMQTTPacket reads a packet and returns its first header byte and body.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		mult *= 128
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+func (n MQTTNotifier) Notify(ctx context.Context, r RunResult) error {
+	u, err := url.Parse(n.URL)
+	if err != nil || (u.Scheme != "mqtt" && u.Scheme != "mqtts") {
+		return fmt.Errorf("%w: invalid MQTT broker URL '%s'", ErrUsage, n.URL)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), map[string]string{"mqtt": "1883", "mqtts": "8883"}[u.Scheme])
+	}
+	var conn net.Conn
+	if u.Scheme == "mqtts" {
+		d := tls.Dialer{Config: &tls.Config{ServerName: u.Hostname()}}
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	br := bufio.NewReader(conn)
+
+	// CONNECT with a clean session
+	flags := byte(0x02)
+	payload := mqttString(fmt.Sprintf("pgtool-%d", time.Now().UnixNano()))
+	if u.User != nil {
+		flags |= 0x80
+		payload = append(payload, mqttString(u.User.Username())...)
+		if pw, ok := u.User.Password(); ok {
+			flags |= 0x40
+			payload = append(payload, mqttString(pw)...)
+		}
+	}
+	connect := append(mqttString("MQTT"), 4, flags, 0, 60)
+	if _, err := conn.Write(mqttPacket(0x10, append(connect, payload...))); err != nil {
+		return err
+	}
+	header, body, err := readMQTTPacket(br)
+	if err != nil {
+		return fmt.Errorf("MQTT connect: %w", err)
+	}
+	if header != 0x20 || len(body) != 2 || body[1] != 0 {
+		return fmt.Errorf("MQTT connect refused by %s (code %v)", addr, body)
+	}
+
+	msg, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	topic := strings.NewReplacer("{op}", r.Op, "{database}", r.Database).Replace(n.Topic)
+	publish := mqttString(topic)
+	header = 0x30 | n.QoS<<1
+	if n.Retain {
+		header |= 0x01
+	}
+	if n.QoS > 0 {
+		publish = append(publish, 0, 1) // packet identifier
+	}
+	if _, err := conn.Write(mqttPacket(header, append(publish, msg...))); err != nil {
+		return err
+	}
+	if n.QoS > 0 {
+		header, _, err := readMQTTPacket(br)
+		if err != nil {
+			return fmt.Errorf("MQTT publish: %w", err)
+		}
+		if header != 0x40 {
+			return fmt.Errorf("MQTT publish: unexpected packet type %#x instead of PUBACK", header)
+		}
+	}
+	_, err = conn.Write([]byte{0xe0, 0}) // DISCONNECT
+	return err
+}
@@ -0,0 +1,130 @@
+package pgtool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TimestampLayout is the time format embedded in backup filenames.
+const TimestampLayout = "2006-01-02_150405"
+
+// Kinds of file written for a backup.
+const (
+	KindDump     = "dump"     // main pg_dump archive
+	KindBlobs    = "blobs"    // large objects dumped in a separate pass
+	KindManifest = "manifest" // JSON metadata describing the backup
+	KindSubset   = "subset"   // SQL data of subset tables, see -subset
+	KindGlobals  = "globals"  // roles and tablespaces, see -globals
+)
+
+// BackupName is the parsed form of a backup filename such as
+// "mydb_2025-08-09_114200.dump.gz".
+type BackupName struct {
+	Database    string
+	Time        time.Time
+	Kind        string // KindDump, KindBlobs, KindManifest, KindSubset or KindGlobals
+	Format      string // pg_dump format, "custom", or "sql" for subset data and globals; empty for manifests
+	Compression string // "gzip", or empty when uncompressed
+}
+
+// fileSuffixes maps filename suffixes to their kind, format and
+// compression. Longer suffixes come first so they match before their tails.
+var fileSuffixes = []struct {
+	suffix, kind, format, compression string
+}{
+	{".blobs.dump.gz", KindBlobs, "custom", "gzip"},
+	{".blobs.dump", KindBlobs, "custom", ""},
+	{".subset.sql.gz", KindSubset, "sql", "gzip"},
+	{".subset.sql", KindSubset, "sql", ""},
+	{".globals.sql.gz", KindGlobals, "sql", "gzip"},
+	{".globals.sql", KindGlobals, "sql", ""},
+	{".manifest.json", KindManifest, "", ""},
+	{".dump.gz", KindDump, "custom", "gzip"},
+	{".dump", KindDump, "custom", ""},
+}
+
+// ParseBackupFilename parses a filename (without directory) written by
+// pgtool. Database names may themselves contain underscores.
+func ParseBackupFilename(name string) (BackupName, error) {
+	var b BackupName
+	stem := ""
+	for _, s := range fileSuffixes {
+		if strings.HasSuffix(name, s.suffix) {
+			stem = strings.TrimSuffix(name, s.suffix)
+			b.Kind, b.Format, b.Compression = s.kind, s.format, s.compression
+			break
+		}
+	}
+	if stem == "" {
+		return b, fmt.Errorf("%q is not a pgtool backup filename", name)
+	}
+
+	// The stem is <db>_<date>_<time>; the timestamp is always the last
+	// len(TimestampLayout) characters.
+	n := len(TimestampLayout)
+	if len(stem) < n+2 || stem[len(stem)-n-1] != '_' {
+		return b, fmt.Errorf("%q is not a pgtool backup filename", name)
+	}
+	t, err := time.ParseInLocation(TimestampLayout, stem[len(stem)-n:], time.Local)
+	if err != nil {
+		return b, fmt.Errorf("%q: bad timestamp: %w", name, err)
+	}
+	b.Database = stem[:len(stem)-n-1]
+	b.Time = t
+	return b, nil
+}
+
+// String formats b as a filename, the inverse of ParseBackupFilename.
+func (b BackupName) String() string {
+	name := b.Database + "_" + b.Time.Format(TimestampLayout)
+	switch b.Kind {
+	case KindManifest:
+		return name + ".manifest.json"
+	case KindSubset, KindGlobals:
+		name += "." + b.Kind + ".sql"
+		if b.Compression == "gzip" {
+			name += ".gz"
+		}
+		return name
+	case KindBlobs:
+		name += ".blobs"
+	}
+	name += ".dump"
+	if b.Compression == "gzip" {
+		name += ".gz"
+	}
+	return name
+}
+
+// Stem returns the filename shared by all files of the same backup,
+// without kind or extension.
+func (b BackupName) Stem() string {
+	return b.Database + "_" + b.Time.Format(TimestampLayout)
+}
+
+// latestBackup returns the path of the newest compressed main dump of db
+// in dir.
+func latestBackup(dir, db string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var latest BackupName
+	var path string
+	for _, e := range entries {
+		b, err := ParseBackupFilename(e.Name())
+		if err != nil || b.Database != db || b.Kind != KindDump || b.Compression != "gzip" {
+			continue
+		}
+		if path == "" || b.Time.After(latest.Time) {
+			latest, path = b, filepath.Join(dir, e.Name())
+		}
+	}
+	if path == "" {
+		return "", fmt.Errorf("no backups of database '%s' found in %s", db, dir)
+	}
+	return path, nil
+}
@@ -0,0 +1,58 @@
+package pgtool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBackupFilename(t *testing.T) {
+	ts := time.Date(2025, 8, 9, 11, 42, 0, 0, time.Local)
+	tests := []struct {
+		name string
+		want BackupName
+	}{
+		{"mydb_2025-08-09_114200.dump.gz", BackupName{"mydb", ts, KindDump, "custom", "gzip"}},
+		{"mydb_2025-08-09_114200.dump", BackupName{"mydb", ts, KindDump, "custom", ""}},
+		{"my_app_db_2025-08-09_114200.dump.gz", BackupName{"my_app_db", ts, KindDump, "custom", "gzip"}},
+		{"mydb_2025-08-09_114200.blobs.dump.gz", BackupName{"mydb", ts, KindBlobs, "custom", "gzip"}},
+		{"mydb_2025-08-09_114200.subset.sql.gz", BackupName{"mydb", ts, KindSubset, "sql", "gzip"}},
+		{"mydb_2025-08-09_114200.globals.sql", BackupName{"mydb", ts, KindGlobals, "sql", ""}},
+		{"mydb_2025-08-09_114200.manifest.json", BackupName{"mydb", ts, KindManifest, "", ""}},
+		{"app.tenant_a_2025-08-09_114200.dump.gz", BackupName{"app.tenant_a", ts, KindDump, "custom", "gzip"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseBackupFilename(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Database != tt.want.Database || !got.Time.Equal(tt.want.Time) || got.Kind != tt.want.Kind ||
+				got.Format != tt.want.Format || got.Compression != tt.want.Compression {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if s := got.String(); s != tt.name {
+				t.Errorf("String() = %q, want %q", s, tt.name)
+			}
+			if stem := got.Stem(); stem != tt.want.Database+"_2025-08-09_114200" {
+				t.Errorf("Stem() = %q", stem)
+			}
+		})
+	}
+}
+
+func TestParseBackupFilenameErrors(t *testing.T) {
+	for _, name := range []string{
+		"",
+		"notes.txt",
+		".dump.gz",
+		"2025-08-09_114200.dump.gz",     // no database
+		"mydb2025-08-09_114200.dump.gz", // no separator
+		"mydb_2025-13-09_114200.dump.gz",
+		"mydb_2025-08-09_114200.dump.gz.partial",
+		"mydb_2025-08-09_1142.dump.gz",
+	} {
+		if b, err := ParseBackupFilename(name); err == nil {
+			t.Errorf("ParseBackupFilename(%q) = %+v, want error", name, b)
+		}
+	}
+}
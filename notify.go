@@ -0,0 +1,111 @@
+package pgtool
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// notifyTimeout bounds each notifier call. Notifications use their own
+// context so that a failure caused by a cancelled run is still reported.
+const notifyTimeout = 30 * time.Second
+
+// RunResult summarises a finished backup, restore, verify or prune for
+// notifiers.
+type RunResult struct {
+	Op       string    `json:"op"`
+	Database string    `json:"database"`
+	Status   string    `json:"status"` // "success", "failure", "aborted" or "skipped"
+	File     string    `json:"file,omitempty"`
+	Bytes    int64     `json:"bytes"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Duration string    `json:"duration"`
+	Error    string    `json:"error,omitempty"`
+	Deleted  int       `json:"deleted,omitempty"` // files removed by a prune
+	// Slow is set on a successful backup that took longer than its
+	// -max-duration; Warning says by how much.
+	Slow    bool   `json:"slow,omitempty"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// Notifier is told about the outcome of every run.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, r RunResult) error
+}
+
+// notifyHandler is an EventHandler that turns the final event of a run
+// into a RunResult and sends it to each notifier. Notifier failures are
+// passed to onErr rather than failing the run.
+type notifyHandler struct {
+	NopEventHandler
+	notifiers []Notifier
+	retry     RetryPolicy
+	onErr     func(n Notifier, err error)
+	started   time.Time
+}
+
+func (h *notifyHandler) OnStart(e Event) {
+	h.started = e.Time
+}
+
+func (h *notifyHandler) OnComplete(e Event) {
+	h.send(e, "success")
+}
+
+func (h *notifyHandler) OnError(e Event) {
+	if errors.Is(e.Err, ErrAborted) {
+		h.send(e, "aborted")
+		return
+	}
+	h.send(e, "failure")
+}
+
+func (h *notifyHandler) send(e Event, status string) {
+	r := RunResult{
+		Op:       e.Op,
+		Database: e.Database,
+		Status:   status,
+		File:     e.File,
+		Bytes:    e.Bytes,
+		Started:  h.started,
+		Finished: e.Time,
+		Duration: e.Time.Sub(h.started).Round(time.Second).String(),
+		Slow:     e.Warning != "",
+		Warning:  e.Warning,
+	}
+	if e.Err != nil {
+		r.Error = e.Err.Error()
+	}
+	if fi, err := os.Stat(e.File); err == nil && status == "success" {
+		r.Bytes = fi.Size()
+	}
+	notifyAll(h.notifiers, h.retry, r, h.onErr)
+}
+
+// notifySkipped tells cfg's notifiers that a backup was skipped because
+// of err, an ErrSkipped error.
+func notifySkipped(cfg Config, err error, logger *log.Logger) {
+	now := time.Now()
+	r := RunResult{Op: "backup", Database: cfg.backupSet(), Status: "skipped", Started: now, Finished: now, Duration: "0s", Error: err.Error()}
+	notifyAll(cfg.Notifiers, cfg.Retry, r, func(n Notifier, err error) {
+		logger.Printf("WARNING: Notifier %s failed: %v", n.Name(), err)
+	})
+}
+
+// notifyAll sends r to each notifier, passing failures to onErr.
+func notifyAll(notifiers []Notifier, retry RetryPolicy, r RunResult, onErr func(n Notifier, err error)) {
+	for _, n := range notifiers {
+		err := retry.do(context.Background(), nil, func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			return n.Notify(ctx, r)
+		})
+		if err != nil && onErr != nil {
+			onErr(n, err)
+		}
+	}
+}
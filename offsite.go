@@ -0,0 +1,124 @@
+package pgtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// latestManifest returns the manifest of the latest backup of db in dir
+// and the manifest's file name.
+func latestManifest(dir, db string) (Manifest, string, error) {
+	latest, err := latestBackup(dir, db)
+	if err != nil {
+		return Manifest{}, "", err
+	}
+	name := strings.TrimSuffix(filepath.Base(latest), ".dump.gz") + ".manifest.json"
+	m, err := ReadManifest(filepath.Join(dir, name))
+	if err == nil && len(m.Checksums) == 0 {
+		err = fmt.Errorf("%w: manifest %s has no checksums to compare", ErrVerification, name)
+	}
+	return m, name, err
+}
+
+// verifyOffsite checks that the latest local backup of db reached every
+// backend intact: its manifest and each file the manifest lists must be
+// there, encrypted if the backend is configured to encrypt, and the files
+// are downloaded to compare their checksums.
+//
+// Copies made by bucket replication arrive some time after the backup, so
+// a backend that lacks the latest backup only fails the check once the
+// backup is older than maxLag; until then it is logged as a warning. A
+// maxLag of 0 fails at once.
+func verifyOffsite(ctx context.Context, dir, db string, backends []StorageBackend, maxLag time.Duration, logger *log.Logger) error {
+	if len(backends) == 0 {
+		return fmt.Errorf("%w: -offsite needs at least one -storage", ErrUsage)
+	}
+	m, manifestName, err := latestManifest(dir, db)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(m.Checksums))
+	for name := range m.Checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tmp, err := os.MkdirTemp("", "pgtool-verify-")
+	if err != nil {
+		return ioError(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var errs []error
+	for _, b := range backends {
+		if err := verifyBackend(ctx, b, m, manifestName, names, tmp, maxLag, logger); err != nil {
+			logger.Printf("ERROR: Off-site copy on %s: %v", b.Name(), err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// verifyBackend checks one backend for verifyOffsite.
+func verifyBackend(ctx context.Context, b StorageBackend, m Manifest, manifestName string, names []string, tmp string, maxLag time.Duration, logger *log.Logger) error {
+	remote, err := b.List(ctx)
+	if err != nil {
+		return &StorageError{Backend: b.Name(), Op: "list", Err: err}
+	}
+	var missing []string
+	for _, name := range append([]string{manifestName}, names...) {
+		if !slices.Contains(remote, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		lag := time.Since(m.Created)
+		if lag <= maxLag {
+			logger.Printf("WARNING: %s has not received %s yet (backed up %s ago, limit %s).",
+				b.Name(), strings.Join(missing, ", "), lag.Round(time.Second), maxLag)
+			return nil
+		}
+		return fmt.Errorf("%w: missing %s, backed up %s ago", ErrVerification,
+			strings.Join(missing, ", "), lag.Round(time.Second))
+	}
+	if e, ok := b.(Encrypter); ok {
+		plain, err := e.Unencrypted(ctx)
+		if err != nil {
+			return &StorageError{Backend: b.Name(), Op: "list", Err: err}
+		}
+		var exposed []string
+		for _, name := range append([]string{manifestName}, names...) {
+			if slices.Contains(plain, name) {
+				exposed = append(exposed, name)
+			}
+		}
+		if len(exposed) > 0 {
+			return fmt.Errorf("%w: %s not encrypted as configured", ErrVerification, strings.Join(exposed, ", "))
+		}
+	}
+
+	for _, name := range names {
+		path := filepath.Join(tmp, name)
+		if err := b.Download(ctx, name, path); err != nil {
+			return &StorageError{Backend: b.Name(), Op: "download", Err: err}
+		}
+		sum, err := rereadChecksum(ctx, path, false)
+		os.Remove(path)
+		if err != nil {
+			return err
+		}
+		if sum != m.Checksums[name] {
+			return fmt.Errorf("%w: %s has checksum %s, expected %s", ErrVerification, name, sum, m.Checksums[name])
+		}
+	}
+	logger.Printf("INFO: Off-site copy of %s on %s verified (%d file(s)).", m.File, b.Name(), len(names))
+	return nil
+}
@@ -0,0 +1,105 @@
+package pgtool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// opsgenieAPIURL is Opsgenie's US API; EU accounts use
+// https://api.eu.opsgenie.com.
+const opsgenieAPIURL = "https://api.opsgenie.com"
+
+// opsgenieMaxMessage is the longest alert message Opsgenie accepts.
+const opsgenieMaxMessage = 130
+
+// OpsgenieNotifier opens an Opsgenie alert when a run fails and closes it
+// when the same operation on the same database next succeeds. Alerts are
+// keyed by an alias, so Opsgenie counts repeated failures on the open
+// alert rather than opening new ones. Runs aborted by the user are not
+// reported.
+type OpsgenieNotifier struct {
+	APIKey   string
+	Priority string // P1 to P5; "" leaves Opsgenie's default, P3
+	URL      string // "" means opsgenieAPIURL
+	Client   *http.Client
+}
+
+func (n OpsgenieNotifier) Name() string { return "opsgenie" }
+
+func (n OpsgenieNotifier) Notify(ctx context.Context, r RunResult) error {
+	if r.Status == "aborted" || r.Status == "skipped" {
+		return nil
+	}
+	host, _ := os.Hostname()
+	// The alias goes into URL paths, where some proxies mangle "%2F"
+	alias := fmt.Sprintf("pgtool:%s:%s:%s", host, r.Op, r.Database)
+	base := n.URL
+	if base == "" {
+		base = opsgenieAPIURL
+	}
+
+	if r.Status == "success" && !r.Slow {
+		u := base + "/v2/alerts/" + url.PathEscape(alias) + "/close?identifierType=alias"
+		return n.post(ctx, u, map[string]any{"source": "pgtool"})
+	}
+	message, description := fmt.Sprintf("pgtool %s of %s failed on %s", r.Op, r.Database, host), r.Error
+	if r.Status == "success" {
+		message, description = fmt.Sprintf("pgtool %s of %s was slow on %s", r.Op, r.Database, host), r.Warning
+	}
+	if len(message) > opsgenieMaxMessage {
+		message = message[:opsgenieMaxMessage]
+	}
+	alert := map[string]any{
+		"message":     message,
+		"alias":       alias,
+		"description": description,
+		"source":      host,
+		"entity":      r.Database,
+		"details": map[string]string{
+			"op":       r.Op,
+			"database": r.Database,
+			"started":  r.Started.String(),
+			"duration": r.Duration,
+		},
+	}
+	if n.Priority != "" {
+		alert["priority"] = n.Priority
+	}
+	if r.Slow {
+		alert["priority"] = "P4"
+	}
+	return n.post(ctx, base+"/v2/alerts", alert)
+}
+
+// post sends body to an Opsgenie endpoint. Opsgenie processes requests
+// asynchronously and answers 202 once it has accepted one.
+func (n OpsgenieNotifier) post(ctx context.Context, u string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "GenieKey "+n.APIKey)
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return newStatusError("Opsgenie", resp)
+	}
+	return nil
+}
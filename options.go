@@ -0,0 +1,162 @@
+package pgtool
+
+import (
+	"compress/gzip"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Option configures a Config built with NewConfig.
+type Option func(*Config)
+
+// DefaultConfig returns the settings used by the command line when no
+// flags are given.
+func DefaultConfig() Config {
+	return Config{
+		User:             "postgres",
+		Host:             "localhost",
+		BackupDir:        "/var/backups/postgresql",
+		LogFile:          "/var/log/postgres_backup.log",
+		RetentionDays:    7,
+		CompressionLevel: gzip.DefaultCompression,
+		SpaceFactor:      1,
+	}
+}
+
+// NewConfig applies opts on top of DefaultConfig and validates the result.
+func NewConfig(opts ...Option) (Config, error) {
+	cfg := DefaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate reports settings that cannot work together.
+func (c Config) Validate() error {
+	if c.Database == "" {
+		return fmt.Errorf("%w: database name is required", ErrUsage)
+	}
+	if c.Blobs && (c.NoBlobs || c.BlobsSeparate) {
+		return fmt.Errorf("%w: -blobs cannot be combined with -no-blobs or -blobs-separate", ErrUsage)
+	}
+	if c.RetentionDays < 0 {
+		return fmt.Errorf("%w: retention must not be negative", ErrUsage)
+	}
+	if c.SpaceFactor < 0 {
+		return fmt.Errorf("%w: space factor must not be negative", ErrUsage)
+	}
+	for _, arg := range append(c.DumpArgs, c.RestoreArgs...) {
+		if !strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("%w: extra argument '%s' is not an option", ErrUsage, arg)
+		}
+	}
+	for _, s := range c.Sections {
+		if s != "pre-data" && s != "data" && s != "post-data" {
+			return fmt.Errorf("%w: section must be pre-data, data or post-data, not '%s'", ErrUsage, s)
+		}
+	}
+	if c.InjectFailure != "" && !slices.Contains(injectPhases, c.InjectFailure) {
+		return fmt.Errorf("%w: can only inject a failure into %s, not '%s'", ErrUsage, strings.Join(injectPhases, ", "), c.InjectFailure)
+	}
+	if _, err := parseIfExists(c.IfExists); err != nil {
+		return err
+	}
+	if c.CompressionLevel < gzip.HuffmanOnly || c.CompressionLevel > gzip.BestCompression {
+		return fmt.Errorf("%w: compression level must be between %d and %d", ErrUsage, gzip.HuffmanOnly, gzip.BestCompression)
+	}
+	return nil
+}
+
+// injectPhases are the phases InjectFailure can fail.
+var injectPhases = []string{PhaseDump, PhaseVerify, PhaseUpload}
+
+// injected returns an ErrInjected error if c.InjectFailure names phase.
+func (c Config) injected(phase string) error {
+	if c.InjectFailure != phase {
+		return nil
+	}
+	return fmt.Errorf("%w in %s phase (-inject-failure)", ErrInjected, phase)
+}
+
+func WithDatabase(name string) Option { return func(c *Config) { c.Database = name } }
+
+func WithUser(user string) Option { return func(c *Config) { c.User = user } }
+
+func WithHost(host string) Option { return func(c *Config) { c.Host = host } }
+
+func WithBackupDir(dir string) Option { return func(c *Config) { c.BackupDir = dir } }
+
+func WithLogFile(path string) Option { return func(c *Config) { c.LogFile = path } }
+
+// WithRetention keeps local backups for the given number of days.
+func WithRetention(days int) Option { return func(c *Config) { c.RetentionDays = days } }
+
+// WithCompression sets the gzip level, from gzip.HuffmanOnly to
+// gzip.BestCompression.
+func WithCompression(level int) Option { return func(c *Config) { c.CompressionLevel = level } }
+
+// WithSpaceFactor requires factor times the database size to be free in
+// the backup directory before a backup starts. 0 disables the check.
+func WithSpaceFactor(factor float64) Option { return func(c *Config) { c.SpaceFactor = factor } }
+
+// WithDumpArgs appends args, such as "--no-comments", to the pg_dump
+// command line.
+func WithDumpArgs(args ...string) Option {
+	return func(c *Config) { c.DumpArgs = append(c.DumpArgs, args...) }
+}
+
+// WithTags records tags in the backup's manifest.
+func WithTags(tags ...string) Option {
+	return func(c *Config) { c.Tags = append(c.Tags, tags...) }
+}
+
+// WithLabel records a key=value label in the backup's manifest.
+func WithLabel(key, value string) Option {
+	return func(c *Config) {
+		if c.Labels == nil {
+			c.Labels = map[string]string{}
+		}
+		c.Labels[key] = value
+	}
+}
+
+// WithSections limits a restore to the given sections: pre-data, data
+// and post-data.
+func WithSections(sections ...string) Option {
+	return func(c *Config) { c.Sections = append(c.Sections, sections...) }
+}
+
+// WithIfExists sets what a restore does when the target database exists:
+// IfExistsClean, IfExistsDrop, IfExistsRename or IfExistsFail.
+func WithIfExists(mode string) Option {
+	return func(c *Config) { c.IfExists = mode }
+}
+
+// WithRestoreArgs appends args to the pg_restore command line.
+func WithRestoreArgs(args ...string) Option {
+	return func(c *Config) { c.RestoreArgs = append(c.RestoreArgs, args...) }
+}
+
+// WithStorage adds storage backends that receive a copy of every backup.
+func WithStorage(backends ...StorageBackend) Option {
+	return func(c *Config) { c.Storage = append(c.Storage, backends...) }
+}
+
+// WithNotifiers adds notifiers told about the outcome of every run.
+func WithNotifiers(notifiers ...Notifier) Option {
+	return func(c *Config) { c.Notifiers = append(c.Notifiers, notifiers...) }
+}
+
+// WithEvents sets the handler for lifecycle and progress events.
+func WithEvents(h EventHandler) Option { return func(c *Config) { c.Events = h } }
+
+// WithBlobsSeparate dumps large objects in a separate parallel pass.
+func WithBlobsSeparate() Option { return func(c *Config) { c.BlobsSeparate = true } }
+
+// WithoutBlobs leaves large objects out of the dump.
+func WithoutBlobs() Option { return func(c *Config) { c.NoBlobs = true } }
+
+// WithRunner runs pg_dump, pg_restore and psql through r.
+func WithRunner(r Runner) Option { return func(c *Config) { c.Runner = r } }
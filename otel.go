@@ -0,0 +1,192 @@
+package pgtool
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// otlpSpan is a span in the OTLP/HTTP JSON encoding.
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"` // 1 = internal
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       struct {
+		Code    int    `json:"code,omitempty"` // 1 = ok, 2 = error
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func otlpAttr(k, v string) otlpAttribute {
+	a := otlpAttribute{Key: k}
+	a.Value.StringValue = v
+	return a
+}
+
+func otlpTime(t time.Time) string { return strconv.FormatInt(t.UnixNano(), 10) }
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// OTLPTracer is an EventHandler that records a run as a trace: a span for
+// the whole backup or restore with a child span per phase, so a slow run
+// shows whether pg_dump, compression or the upload took the time. The
+// trace is exported over OTLP/HTTP with JSON encoding when the run ends.
+type OTLPTracer struct {
+	Endpoint    string // traces URL, e.g. http://localhost:4318/v1/traces
+	Headers     map[string]string
+	ServiceName string
+	Client      *http.Client
+	OnErr       func(error) // told about export failures
+
+	traceID string
+	root    otlpSpan
+	phase   *otlpSpan
+	spans   []otlpSpan
+}
+
+// otlpTracerFromEnv returns an OTLPTracer configured by the standard
+// OpenTelemetry environment variables, or nil if no endpoint is set.
+func otlpTracerFromEnv(onErr func(error)) *OTLPTracer {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	t := &OTLPTracer{Endpoint: endpoint, ServiceName: os.Getenv("OTEL_SERVICE_NAME"), OnErr: onErr}
+	if t.ServiceName == "" {
+		t.ServiceName = "pgtool"
+	}
+	headers := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS")
+	if headers == "" {
+		headers = os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")
+	}
+	t.Headers = make(map[string]string)
+	for _, h := range strings.Split(headers, ",") {
+		if k, v, ok := strings.Cut(h, "="); ok {
+			t.Headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return t
+}
+
+func (t *OTLPTracer) OnStart(e Event) {
+	t.traceID = randomHex(16)
+	t.root = otlpSpan{TraceID: t.traceID, SpanID: randomHex(8), Name: e.Op, Kind: 1, Start: otlpTime(e.Time)}
+	t.root.Attributes = []otlpAttribute{otlpAttr("db.system", "postgresql"), otlpAttr("db.name", e.Database)}
+	if e.File != "" {
+		t.root.Attributes = append(t.root.Attributes, otlpAttr("pgtool.file", e.File))
+	}
+}
+
+func (t *OTLPTracer) OnProgress(Event) {}
+
+func (t *OTLPTracer) OnPhaseChange(e Event) {
+	t.endPhase(e.Time, nil)
+	t.phase = &otlpSpan{TraceID: t.traceID, SpanID: randomHex(8), ParentSpanID: t.root.SpanID,
+		Name: e.Op + " " + e.Phase, Kind: 1, Start: otlpTime(e.Time)}
+}
+
+func (t *OTLPTracer) OnComplete(e Event) {
+	t.finish(e, nil)
+}
+
+func (t *OTLPTracer) OnError(e Event) {
+	t.finish(e, e.Err)
+}
+
+// endPhase ends the current phase span, if any, marking it failed if err
+// is not nil.
+func (t *OTLPTracer) endPhase(end time.Time, err error) {
+	if t.phase == nil {
+		return
+	}
+	t.phase.End = otlpTime(end)
+	setSpanStatus(t.phase, err)
+	t.spans = append(t.spans, *t.phase)
+	t.phase = nil
+}
+
+func setSpanStatus(s *otlpSpan, err error) {
+	s.Status.Code = 1
+	if err != nil {
+		s.Status.Code, s.Status.Message = 2, err.Error()
+	}
+}
+
+func (t *OTLPTracer) finish(e Event, err error) {
+	t.endPhase(e.Time, err)
+	t.root.End = otlpTime(e.Time)
+	setSpanStatus(&t.root, err)
+	t.spans = append(t.spans, t.root)
+	if err := t.export(); err != nil && t.OnErr != nil {
+		t.OnErr(err)
+	}
+}
+
+func (t *OTLPTracer) export() error {
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []otlpAttribute{otlpAttr("service.name", t.ServiceName)},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]string{"name": "pgtool"},
+				"spans": t.spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	// Like notifications, the export uses its own context so that the
+	// trace of a cancelled run is still sent
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.Headers {
+		req.Header.Set(k, v)
+	}
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return newStatusError("OTLP export to "+t.Endpoint, resp)
+	}
+	return nil
+}
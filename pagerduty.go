@@ -0,0 +1,110 @@
+package pgtool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// pagerDutyEventsURL is the PagerDuty Events API v2 endpoint.
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutyMaxSummary is the longest incident summary PagerDuty accepts.
+const pagerDutyMaxSummary = 1024
+
+// pagerDutySeverities are the severities PagerDuty accepts.
+var pagerDutySeverities = []string{"critical", "error", "warning", "info"}
+
+// PagerDutyNotifier triggers a PagerDuty incident when a run fails and
+// resolves it when the same operation on the same database next succeeds.
+// Runs aborted by the user are not reported.
+type PagerDutyNotifier struct {
+	RoutingKey string // integration key of an Events API v2 service
+	Severity   string // one of pagerDutySeverities; "" means "error"
+	URL        string // "" means pagerDutyEventsURL
+	Client     *http.Client
+}
+
+func (n PagerDutyNotifier) Name() string { return "pagerduty" }
+
+// parsePagerDutySeverity checks s, defaulting it to "error".
+func parsePagerDutySeverity(s string) (string, error) {
+	if s == "" {
+		return "error", nil
+	}
+	for _, v := range pagerDutySeverities {
+		if s == v {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("%w: invalid PagerDuty severity '%s'", ErrUsage, s)
+}
+
+func (n PagerDutyNotifier) Notify(ctx context.Context, r RunResult) error {
+	if r.Status == "aborted" || r.Status == "skipped" {
+		return nil
+	}
+	host, _ := os.Hostname()
+	event := map[string]any{
+		"routing_key":  n.RoutingKey,
+		"event_action": "resolve",
+		// One incident per host, operation and database, so repeated
+		// failures are grouped and a success resolves them
+		"dedup_key": fmt.Sprintf("pgtool/%s/%s/%s", host, r.Op, r.Database),
+	}
+	if r.Status != "success" || r.Slow {
+		severity, err := parsePagerDutySeverity(n.Severity)
+		if err != nil {
+			return err
+		}
+		summary := fmt.Sprintf("pgtool %s of %s failed on %s: %s", r.Op, r.Database, host, r.Error)
+		// A slow backup raises a warning, resolved by the next one that
+		// is on time
+		if r.Status == "success" {
+			severity = "warning"
+			summary = fmt.Sprintf("pgtool %s of %s was slow on %s: %s", r.Op, r.Database, host, r.Warning)
+		}
+		if len(summary) > pagerDutyMaxSummary {
+			summary = summary[:pagerDutyMaxSummary]
+		}
+		event["event_action"] = "trigger"
+		event["payload"] = map[string]any{
+			"summary":        summary,
+			"source":         host,
+			"severity":       severity,
+			"component":      r.Database,
+			"class":          r.Op,
+			"custom_details": r,
+		}
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	u := n.URL
+	if u == "" {
+		u = pagerDutyEventsURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return newStatusError("PagerDuty", resp)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// partitionStats returns each leaf partition of parent with its write
+// counter: the rows inserted, updated and deleted since statistics were
+// last reset.
+func partitionStats(ctx context.Context, cfg Config, parent string) (map[string]int64, error) {
+	query := fmt.Sprintf(`SELECT t.relid::regclass, coalesce(s.n_tup_ins + s.n_tup_upd + s.n_tup_del, 0)
+FROM pg_catalog.pg_partition_tree(%s::regclass) t
+LEFT JOIN pg_catalog.pg_stat_user_tables s ON s.relid = t.relid
+WHERE t.isleaf`, quoteLiteral(parent))
+	out, err := queryScalar(ctx, cfg, query)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]int64)
+	for _, line := range strings.Split(out, "\n") {
+		name, count, ok := strings.Cut(strings.TrimSpace(line), "|")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected write counter %q for %s", count, name)
+		}
+		stats[name] = n
+	}
+	if len(stats) == 0 {
+		return nil, fmt.Errorf("'%s' has no partitions", parent)
+	}
+	return stats, nil
+}
+
+// previousPartitionStats returns the partition write counters recorded by
+// the latest backup of db in dir, or nil if there are none.
+func previousPartitionStats(dir, db string) map[string]int64 {
+	latest, err := latestBackup(dir, db)
+	if err != nil {
+		return nil
+	}
+	m, err := ReadManifest(strings.TrimSuffix(latest, ".dump.gz") + ".manifest.json")
+	if err != nil {
+		return nil
+	}
+	return m.PartitionStats
+}
+
+// changedPartitions returns, sorted, the partitions in cur that are new
+// or whose counter differs from prev. A counter that went down means the
+// statistics were reset, so the partition is dumped to be safe.
+func changedPartitions(cur, prev map[string]int64) []string {
+	var changed []string
+	for name, n := range cur {
+		if old, ok := prev[name]; !ok || old != n {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,144 @@
+package pgtool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pgCatalogMigrations create and update the schema of a catalog kept in
+// PostgreSQL; migration i brings it to version i+1. Each is idempotent,
+// so that hosts racing to migrate a new catalog do no harm. Append only.
+var pgCatalogMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS pgtool.backup_runs (
+		node text NOT NULL,
+		database text NOT NULL,
+		time timestamptz NOT NULL,
+		outcome text NOT NULL,
+		file text,
+		size bigint NOT NULL DEFAULT 0,
+		duration_seconds double precision,
+		tags text[],
+		labels jsonb,
+		error text,
+		PRIMARY KEY (node, database, time)
+	);
+	CREATE INDEX IF NOT EXISTS backup_runs_time ON pgtool.backup_runs (time)`,
+}
+
+// pgCatalog keeps a shared catalog in the pgtool schema of a PostgreSQL
+// database, typically a dedicated ops database, reached through psql. The
+// schema is created and migrated on first use.
+type pgCatalog struct {
+	dsn string
+}
+
+func (c pgCatalog) Name() string { return redactDSN(c.dsn) }
+
+func (c pgCatalog) query(ctx context.Context, sql string) (string, error) {
+	return psqlQuery(ctx, ExecRunner{}, []string{"-d", c.dsn, "-v", "ON_ERROR_STOP=1"}, sql)
+}
+
+// migrate brings the catalog schema up to date. The transaction-scoped
+// advisory lock keeps concurrent hosts from migrating at the same time.
+func (c pgCatalog) migrate(ctx context.Context) error {
+	const lock = "SELECT pg_advisory_xact_lock(hashtext('pgtool catalog'));"
+	out, err := c.query(ctx, "BEGIN;"+lock+`
+		CREATE SCHEMA IF NOT EXISTS pgtool;
+		CREATE TABLE IF NOT EXISTS pgtool.schema_version (version integer NOT NULL);
+		COMMIT;
+		SELECT coalesce(max(version), 0) FROM pgtool.schema_version`)
+	if err != nil {
+		return fmt.Errorf("cannot read the catalog schema version: %w", err)
+	}
+	version, err := strconv.Atoi(out)
+	if err != nil {
+		return fmt.Errorf("catalog schema version is %q", out)
+	}
+	if version > len(pgCatalogMigrations) {
+		return fmt.Errorf("catalog schema version %d is newer than this pgtool supports (%d)", version, len(pgCatalogMigrations))
+	}
+	if version == len(pgCatalogMigrations) {
+		return nil
+	}
+	sql := "BEGIN;" + lock + strings.Join(pgCatalogMigrations[version:], ";") + fmt.Sprintf(
+		";DELETE FROM pgtool.schema_version;INSERT INTO pgtool.schema_version VALUES (%d);COMMIT", len(pgCatalogMigrations))
+	if _, err := c.query(ctx, sql); err != nil {
+		return fmt.Errorf("cannot migrate the catalog schema to version %d: %w", len(pgCatalogMigrations), err)
+	}
+	return nil
+}
+
+func (c pgCatalog) Add(ctx context.Context, r CatalogRecord) error {
+	if err := c.migrate(ctx); err != nil {
+		return err
+	}
+	seconds, labels, tags := "NULL", "NULL", "NULL"
+	if d, err := time.ParseDuration(r.Duration); err == nil {
+		seconds = strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+	}
+	if r.Labels != nil {
+		data, err := json.Marshal(r.Labels)
+		if err != nil {
+			return err
+		}
+		labels = quoteLiteral(string(data)) + "::jsonb"
+	}
+	if r.Tags != nil {
+		quoted := make([]string, len(r.Tags))
+		for i, t := range r.Tags {
+			quoted[i] = quoteLiteral(t)
+		}
+		tags = "ARRAY[" + strings.Join(quoted, ",") + "]::text[]"
+	}
+	_, err := c.query(ctx, fmt.Sprintf(`INSERT INTO pgtool.backup_runs
+		(node, database, time, outcome, file, size, duration_seconds, tags, labels, error)
+		VALUES (%s, %s, %s, %s, NULLIF(%s, ''), %d, %s, %s, %s, NULLIF(%s, ''))
+		ON CONFLICT (node, database, time) DO UPDATE SET outcome = EXCLUDED.outcome, file = EXCLUDED.file,
+		size = EXCLUDED.size, duration_seconds = EXCLUDED.duration_seconds, tags = EXCLUDED.tags,
+		labels = EXCLUDED.labels, error = EXCLUDED.error`,
+		quoteLiteral(r.Node), quoteLiteral(r.Database), quoteLiteral(r.Time.Format(time.RFC3339)), quoteLiteral(r.Outcome),
+		quoteLiteral(r.File), r.Size, seconds, tags, labels, quoteLiteral(r.Error)))
+	return err
+}
+
+func (c pgCatalog) Records(ctx context.Context, f ListFilter) ([]CatalogRecord, error) {
+	if err := c.migrate(ctx); err != nil {
+		return nil, err
+	}
+	where := []string{"true"}
+	if f.Database != "" {
+		where = append(where, "database = "+quoteLiteral(f.Database))
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "time >= "+quoteLiteral(f.Since.Format(time.RFC3339)))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "time <= "+quoteLiteral(f.Until.Format(time.RFC3339)))
+	}
+	out, err := c.query(ctx, `SELECT coalesce(json_agg(r ORDER BY time), '[]') FROM (
+		SELECT node, database, time, outcome, coalesce(file, '') AS file, size, duration_seconds,
+		coalesce(tags, '{}') AS tags, labels, coalesce(error, '') AS error
+		FROM pgtool.backup_runs WHERE `+strings.Join(where, " AND ")+`) r`)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		CatalogRecord
+		DurationSeconds *float64 `json:"duration_seconds"`
+	}
+	if err := json.Unmarshal([]byte(out), &rows); err != nil {
+		return nil, fmt.Errorf("cannot read the catalog: %w", err)
+	}
+	records := make([]CatalogRecord, len(rows))
+	for i, row := range rows {
+		records[i] = row.CatalogRecord
+		if s := row.DurationSeconds; s != nil {
+			records[i].Duration = (time.Duration(*s * float64(time.Second))).String()
+		}
+	}
+	return records, nil
+}
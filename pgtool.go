@@ -1,243 +1,2682 @@
-package main
-
-import (
-	"compress/gzip"
-	"flag"
-	"fmt"
-	"io"
-	"log"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"time"
-)
-
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: pgtool <backup|restore> [options]")
-		os.Exit(1)
-	}
-
-	switch os.Args[1] {
-	case "backup":
-		backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
-		dbName := backupCmd.String("db", "", "Database name (required)")
-		dbUser := backupCmd.String("user", "postgres", "PostgreSQL user")
-		dbHost := backupCmd.String("host", "localhost", "PostgreSQL host")
-		backupDir := backupCmd.String("backup-dir", "/var/backups/postgresql", "Backup directory")
-		logFile := backupCmd.String("log-file", "/var/log/postgres_backup.log", "Log file path")
-		retentionDays := backupCmd.Int("retention", 7, "Retention period in days")
-
-		backupCmd.Parse(os.Args[2:])
-		runBackup(*dbName, *dbUser, *dbHost, *backupDir, *logFile, *retentionDays)
-
-	case "restore":
-		restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
-		dbName := restoreCmd.String("db", "", "Database name (required)")
-		dbUser := restoreCmd.String("user", "postgres", "PostgreSQL user")
-		dbHost := restoreCmd.String("host", "localhost", "PostgreSQL host")
-		backupFile := restoreCmd.String("file", "", "Backup file (.dump.gz) to restore (required)")
-		logFile := restoreCmd.String("log-file", "/var/log/postgres_backup.log", "Log file path")
-
-		restoreCmd.Parse(os.Args[2:])
-		runRestore(*dbName, *dbUser, *dbHost, *backupFile, *logFile)
-
-	default:
-		fmt.Println("Unknown command:", os.Args[1])
-		fmt.Println("Usage: pgtool <backup|restore> [options]")
-		os.Exit(1)
-	}
-}
-
-func runBackup(dbName, dbUser, dbHost, backupDir, logFile string, retentionDays int) {
-	if dbName == "" {
-		fmt.Println("Error: Database name is required.")
-		os.Exit(1)
-	}
-
-	// Ensure backup directory exists
-	if _, err := os.Stat(backupDir); os.IsNotExist(err) {
-		fmt.Printf("Error: Backup directory '%s' not found.\n", backupDir)
-		os.Exit(1)
-	}
-
-	// Open log file
-	logF, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Printf("Error: Cannot open log file '%s': %v\n", logFile, err)
-		os.Exit(1)
-	}
-	defer logF.Close()
-	logger := log.New(logF, "", log.LstdFlags)
-
-	// Create backup filename
-	timestamp := time.Now().Format("2006-01-02_150405")
-	backupFile := filepath.Join(backupDir, fmt.Sprintf("%s_%s.dump", dbName, timestamp))
-
-	// Run pg_dump
-	logger.Printf("INFO: Starting backup for database '%s'.", dbName)
-	fmt.Printf("Starting backup for database '%s'...\n", dbName)
-
-	cmd := exec.Command(
-		"pg_dump",
-		"-U", dbUser,
-		"-h", dbHost,
-		"-Fc", dbName,
-	)
-	outFile, err := os.Create(backupFile)
-	if err != nil {
-		logger.Printf("ERROR: Cannot create backup file: %v", err)
-		fmt.Println("Backup failed.")
-		os.Exit(1)
-	}
-	defer outFile.Close()
-	cmd.Stdout = outFile
-	cmd.Stderr = logF
-
-	// Pass password from env if set
-	if pw := os.Getenv("PGPASSWORD"); pw != "" {
-		cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", pw))
-	}
-
-	if err := cmd.Run(); err != nil {
-		logger.Printf("ERROR: Backup failed: %v", err)
-		fmt.Println("Backup failed. Check log for details.")
-		os.Remove(backupFile)
-		os.Exit(1)
-	}
-
-	// Compress backup
-	compressedFile := backupFile + ".gz"
-	if err := compressFile(backupFile, compressedFile); err != nil {
-		logger.Printf("ERROR: Compression failed: %v", err)
-		fmt.Println("Compression failed.")
-		os.Exit(1)
-	}
-	os.Remove(backupFile)
-
-	logger.Printf("SUCCESS: Backup completed. File: %s", compressedFile)
-	fmt.Println("Backup successful:", compressedFile)
-
-	// Cleanup old backups
-	cleanupOldBackups(backupDir, retentionDays, logger)
-}
-
-func runRestore(dbName, dbUser, dbHost, backupFile, logFile string) {
-	if dbName == "" || backupFile == "" {
-		fmt.Println("Error: Database name and backup file are required.")
-		os.Exit(1)
-	}
-
-	// Open log file
-	logF, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Printf("Error: Cannot open log file '%s': %v\n", logFile, err)
-		os.Exit(1)
-	}
-	defer logF.Close()
-	logger := log.New(logF, "", log.LstdFlags)
-
-	logger.Printf("INFO: Starting restore for database '%s' from '%s'.", dbName, backupFile)
-	fmt.Printf("Restoring database '%s' from '%s'...\n", dbName, backupFile)
-
-	// Decompress to temp file
-	tempFile := backupFile[:len(backupFile)-3] // remove .gz
-	if err := decompressFile(backupFile, tempFile); err != nil {
-		logger.Printf("ERROR: Decompression failed: %v", err)
-		fmt.Println("Decompression failed.")
-		os.Exit(1)
-	}
-	defer os.Remove(tempFile)
-
-	// Run pg_restore
-	cmd := exec.Command(
-		"pg_restore",
-		"-U", dbUser,
-		"-h", dbHost,
-		"-d", dbName,
-		"--clean", // drop objects before recreating
-		tempFile,
-	)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = logF
-
-	if pw := os.Getenv("PGPASSWORD"); pw != "" {
-		cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", pw))
-	}
-
-	if err := cmd.Run(); err != nil {
-		logger.Printf("ERROR: Restore failed: %v", err)
-		fmt.Println("Restore failed. Check log for details.")
-		os.Exit(1)
-	}
-
-	logger.Printf("SUCCESS: Restore completed for database '%s'.", dbName)
-	fmt.Println("Restore completed successfully.")
-}
-
-func compressFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
-
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	gw := gzip.NewWriter(out)
-	defer gw.Close()
-
-	_, err = io.Copy(gw, in)
-	return err
-}
-
-func decompressFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
-
-	gr, err := gzip.NewReader(in)
-	if err != nil {
-		return err
-	}
-	defer gr.Close()
-
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	_, err = io.Copy(out, gr)
-	return err
-}
-
-func cleanupOldBackups(backupDir string, retentionDays int, logger *log.Logger) {
-	logger.Printf("INFO: Cleaning up backups older than %d days.", retentionDays)
-	fmt.Println("Cleaning up old backups...")
-
-	cutoff := time.Now().AddDate(0, 0, -retentionDays)
-	filepath.Walk(backupDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() && filepath.Ext(path) == ".gz" {
-			if info.ModTime().Before(cutoff) {
-				if rmErr := os.Remove(path); rmErr == nil {
-					logger.Printf("INFO: Deleted old backup: %s", path)
-				} else {
-					logger.Printf("WARNING: Failed to delete %s: %v", path, rmErr)
-				}
-			}
-		}
-		return nil
-	})
-	logger.Println("SUCCESS: Cleanup complete.")
-	fmt.Println("Cleanup complete.")
-}
\ No newline at end of file
+// Package pgtool backs up and restores PostgreSQL databases with pg_dump
+// and pg_restore. Programs use BackupTo, RestoreFrom and NewConfig, with an
+// EventHandler for progress, and Scheduler for recurring backups; Main is
+// the pgtool command line, as built by cmd/pgtool.
+package pgtool
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const usage = "Usage: pgtool <backup|restore|restore-set|sync|clone|copy-table|export|prune|list|info|estimate|report|plan|config|catalog|verify|audit|share|diff|daemon|schedule|coordinator|agent|selftest|completion> [options]"
+
+// Main runs the pgtool command line on os.Args and exits with its status.
+func Main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(exitUsage)
+	}
+	if os.Args[1] == "-print-exit-codes" || os.Args[1] == "--print-exit-codes" {
+		printExitCodes(os.Stdout)
+		return
+	}
+
+	// Ctrl-C and SIGTERM cancel the run, which stops the tools and
+	// removes partial files
+	ctx, stop := signalContext()
+	defer stop()
+
+	switch os.Args[1] {
+	case "backup":
+		backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
+		backupConfig := backupFlags(backupCmd)
+		timeout := backupCmd.Duration("timeout", 0, "Abort the backup after this long (0 = no limit)")
+		fleetMode := backupCmd.Bool("fleet", false, "Back up every database of every server in the -config file's \"servers\"")
+		tenantSchemas := backupCmd.String("tenant-schemas", "", "Back up each schema matching this pattern, e.g. 'tenant_*', on its own for restore -tenant")
+		all := backupCmd.Bool("all", false, "Back up every database on -host, listed when the backup starts")
+		var rules DiscoveryRules
+		backupCmd.Var((*stringList)(&rules.Include), "include", "With -all, back up only databases matching this regexp (repeatable)")
+		backupCmd.Var((*stringList)(&rules.Exclude), "exclude", "With -all, skip databases matching this regexp (repeatable)")
+		backupCmd.BoolVar(&rules.IncludeSystem, "include-system", false, "With -all, also back up template1 and postgres")
+		onlyPriority := backupCmd.String("only-priority", "", "With -all or -fleet, back up only databases of this priority or higher, e.g. critical")
+		printCommands := backupCmd.Bool("print-commands", false, "Print the commands and storage operations the backup would run, secrets redacted, and exit")
+
+		backupCmd.Parse(os.Args[2:])
+		cfg, err := backupConfig()
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		if err := validatePriority(*onlyPriority); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		if *onlyPriority != "" && !*fleetMode && !*all {
+			fmt.Println("Error: -only-priority needs -all or -fleet")
+			os.Exit(exitUsage)
+		}
+		if *printCommands {
+			if *fleetMode || *all || *tenantSchemas != "" {
+				fmt.Println("Error: -print-commands needs a single -db, not -fleet, -all or -tenant-schemas")
+				os.Exit(exitUsage)
+			}
+			if err := printBackupCommands(os.Stdout, cfg); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(exitCode(err))
+			}
+			break
+		}
+		ctx, cancel := withTimeout(ctx, *timeout)
+		defer cancel()
+		if *fleetMode {
+			fleet, err := loadFleet(backupCmd, cfg)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(exitCode(err))
+			}
+			for i := range fleet {
+				fleet[i].OnlyPriority = *onlyPriority
+			}
+			results, err := BackupFleet(ctx, fleet)
+			printFleetResults(results)
+			if err != nil {
+				fmt.Println("Backup failed:", err)
+				os.Exit(exitCode(err))
+			}
+			break
+		}
+		if !*all && (len(rules.Include)+len(rules.Exclude) > 0 || rules.IncludeSystem) {
+			fmt.Println("Error: -include, -exclude and -include-system need -all")
+			os.Exit(exitUsage)
+		}
+		if *all {
+			server, err := loadAll(backupCmd, cfg, rules)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(exitCode(err))
+			}
+			server.OnlyPriority = *onlyPriority
+			server.Server.SetID = time.Now().Format(TimestampLayout)
+			results, err := BackupFleet(ctx, []FleetServer{server})
+			printFleetResults(results)
+			fmt.Println("Backup set:", server.Server.SetID)
+			if err != nil {
+				fmt.Println("Backup failed:", err)
+				os.Exit(exitCode(err))
+			}
+			break
+		}
+		if *tenantSchemas != "" {
+			results, err := BackupTenants(ctx, cfg, *tenantSchemas)
+			printFleetResults(results)
+			if err != nil {
+				fmt.Println("Backup failed:", err)
+				os.Exit(exitCode(err))
+			}
+			break
+		}
+		if err := runBackup(ctx, cfg); errors.Is(err, ErrSkipped) {
+			fmt.Println("Backup", err)
+			os.Exit(exitCode(err))
+		} else if err != nil {
+			fmt.Println("Backup failed:", err)
+			os.Exit(exitCode(err))
+		}
+
+	case "restore":
+		restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+		restoreConfig := restoreFlags(restoreCmd)
+		backupFile := restoreCmd.String("file", "", "Backup file (.dump.gz) to restore (required unless -latest)")
+		latest := restoreCmd.Bool("latest", false, "Restore the latest full backup of -db instead of -file")
+		backupDir := restoreCmd.String("backup-dir", "/var/backups/postgresql", "Directory of the backups, for -latest")
+		timeout := restoreCmd.Duration("timeout", 0, "Abort the restore after this long (0 = no limit)")
+		var targets stringList
+		restoreCmd.Var(&targets, "target-dsn", "Restore into this connection string instead of -db; repeat to restore into several databases concurrently")
+		ephemeral := restoreCmd.Bool("ephemeral", false, "Restore into a temporary Docker container and print how to connect to it")
+		pgVersion := restoreCmd.String("pg-version", "", "PostgreSQL image version for -ephemeral (default: the backup's server version)")
+		ttl := restoreCmd.Duration("ttl", 0, "Remove the -ephemeral container after this long (0 = on Ctrl-C)")
+		printCommands := restoreCmd.Bool("print-commands", false, "Print the commands the restore would run, secrets redacted, and exit")
+		tenant := restoreCmd.String("tenant", "", "Restore this tenant's schema from its own backups (made with backup -tenant-schemas), leaving the other schemas alone")
+
+		restoreCmd.Parse(os.Args[2:])
+		cfg, err := restoreConfig()
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		cfg.Tenant = *tenant
+		if cfg.Tenant != "" && (cfg.IfExists != IfExistsClean || len(targets) > 0 || *ephemeral) {
+			fmt.Println("Error: -tenant restores into the existing -db; it cannot be combined with -if-exists other than clean, -target-dsn or -ephemeral")
+			os.Exit(exitUsage)
+		}
+		if *latest {
+			if *backupFile != "" || cfg.Database == "" {
+				fmt.Println("Error: -latest needs -db and no -file")
+				os.Exit(exitUsage)
+			}
+			if *backupFile, err = resolveLatest(ctx, *backupDir, cfg.backupSet(), cfg.Storage); err != nil {
+				fmt.Println("Restore failed:", err)
+				os.Exit(exitCode(err))
+			}
+		}
+		if cfg.Tenant != "" {
+			// A whole-database backup would clean every tenant's objects
+			if b, err := ParseBackupFilename(filepath.Base(*backupFile)); err != nil || b.Database != cfg.backupSet() {
+				fmt.Printf("Error: '%s' is not a backup of tenant '%s' of '%s'\n", *backupFile, cfg.Tenant, cfg.Database)
+				os.Exit(exitUsage)
+			}
+		}
+		if *printCommands {
+			if len(targets) > 0 || *ephemeral {
+				fmt.Println("Error: -print-commands cannot be combined with -target-dsn or -ephemeral")
+				os.Exit(exitUsage)
+			}
+			if err := printRestoreCommands(os.Stdout, cfg, *backupFile); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(exitCode(err))
+			}
+			break
+		}
+		if *ephemeral {
+			if len(targets) > 0 || restoreCmd.Lookup("exec-via").Value.String() != "" {
+				fmt.Println("Error: -ephemeral cannot be combined with -target-dsn or -exec-via")
+				os.Exit(exitUsage)
+			}
+			opts := EphemeralOptions{Version: *pgVersion, TTL: *ttl, Timeout: *timeout}
+			if err := RestoreEphemeral(ctx, cfg, *backupFile, opts); err != nil {
+				fmt.Println("Restore failed:", err)
+				os.Exit(exitCode(err))
+			}
+			break
+		}
+		ctx, cancel := withTimeout(ctx, *timeout)
+		defer cancel()
+		if len(targets) > 0 {
+			results, err := RestoreTargets(ctx, cfg, *backupFile, targets)
+			for _, r := range results {
+				status := "OK"
+				if r.Err != nil {
+					status = "FAILED: " + r.Err.Error()
+				}
+				fmt.Printf("%s: %s (%s)\n", r.Target, status, r.Duration.Round(time.Second))
+			}
+			if err != nil {
+				fmt.Println("Restore failed:", err)
+				os.Exit(exitCode(err))
+			}
+			break
+		}
+		if err := runRestore(ctx, cfg, *backupFile); err != nil {
+			fmt.Println("Restore failed:", err)
+			os.Exit(exitCode(err))
+		}
+
+	case "restore-set":
+		setCmd := flag.NewFlagSet("restore-set", flag.ExitOnError)
+		restoreConfig := restoreFlags(setCmd)
+		setID := setCmd.String("set", "", "Backup set to restore, as printed by backup -all (default: the latest in -backup-dir)")
+		backupDir := setCmd.String("backup-dir", "/var/backups/postgresql", "Directory of the backup set")
+		parallel := setCmd.Int("parallel", 1, "Restore this many databases at a time")
+		timeout := setCmd.Duration("timeout", 0, "Abort the restore after this long (0 = no limit)")
+
+		setCmd.Parse(os.Args[2:])
+		cfg, err := restoreConfig()
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		if cfg.Database != "" || *parallel < 1 {
+			fmt.Println("Error: restore-set restores each database of the set under its own name; it takes no -db, and -parallel must be at least 1")
+			os.Exit(exitUsage)
+		}
+		ctx, cancel := withTimeout(ctx, *timeout)
+		defer cancel()
+		results, err := RestoreSet(ctx, cfg, *backupDir, *setID, *parallel)
+		printFleetResults(results)
+		if err != nil {
+			fmt.Println("Restore failed:", err)
+			os.Exit(exitCode(err))
+		}
+
+	case "sync":
+		syncCmd := flag.NewFlagSet("sync", flag.ExitOnError)
+		restoreConfig := restoreFlags(syncCmd)
+		source := syncCmd.String("source-db", "", "Database whose latest backup is restored (required)")
+		backupDir := syncCmd.String("backup-dir", "/var/backups/postgresql", "Directory holding the source backups")
+		analyze := syncCmd.Bool("analyze", true, "Run ANALYZE after restoring (same as -analyze-after)")
+		schedule := syncCmd.String("schedule", "", "Cron schedule; run as a daemon instead of once")
+		timeout := syncCmd.Duration("timeout", 0, "Abort a sync run after this long (0 = no limit)")
+
+		syncCmd.Parse(os.Args[2:])
+		cfg, err := restoreConfig()
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		opts := SyncOptions{Source: *source, BackupDir: *backupDir, Analyze: *analyze && cfg.AnalyzeAfter, Target: cfg}
+		run := func(ctx context.Context) error {
+			ctx, cancel := withTimeout(ctx, *timeout)
+			defer cancel()
+			return Sync(ctx, opts)
+		}
+		if *schedule == "" {
+			if err := run(ctx); err != nil {
+				fmt.Println("Sync failed:", err)
+				os.Exit(exitCode(err))
+			}
+			break
+		}
+		sched, err := ParseSchedule(*schedule)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		runDaemon(ctx, Job{Name: *source + " -> " + cfg.Database, Schedule: sched, Run: run})
+
+	case "clone":
+		cloneCmd := flag.NewFlagSet("clone", flag.ExitOnError)
+		fromDSN := cloneCmd.String("from-dsn", "", "Source connection string (required)")
+		toDSN := cloneCmd.String("to-dsn", "", "Target connection string; the database must exist (required)")
+		jobs := cloneCmd.Int("jobs", 1, "Parallel jobs (>1 stages the dump in a temporary directory)")
+		noOwner := cloneCmd.Bool("no-owner", false, "Do not restore ownership or privileges")
+		logFile := cloneCmd.String("log-file", "/var/log/postgres_backup.log", "Log file path")
+		timeout := cloneCmd.Duration("timeout", 0, "Abort the clone after this long (0 = no limit)")
+		execVia := cloneCmd.String("exec-via", "", "Run the PostgreSQL tools through ssh:HOST, docker:CONTAINER or kubectl:POD")
+
+		cloneCmd.Parse(os.Args[2:])
+		runner, err := parseRunner(*execVia)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		logF, logger, err := openLog(*logFile)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		defer logF.Close()
+		ctx, cancel := withTimeout(ctx, *timeout)
+		defer cancel()
+		opts := CloneOptions{
+			FromDSN: *fromDSN,
+			ToDSN:   *toDSN,
+			Jobs:    *jobs,
+			NoOwner: *noOwner,
+			Events:  ConsoleProgress{},
+			Runner:  runner,
+		}
+		if err := Clone(ctx, opts, logger); err != nil {
+			fmt.Println("Clone failed:", err)
+			os.Exit(exitCode(err))
+		}
+
+	case "copy-table":
+		copyCmd := flag.NewFlagSet("copy-table", flag.ExitOnError)
+		fromDSN := copyCmd.String("from-dsn", "", "Source connection string (required)")
+		toDSN := copyCmd.String("to-dsn", "", "Target connection string (required)")
+		var tables stringList
+		copyCmd.Var(&tables, "table", "Schema-qualified table to copy (repeatable, required)")
+		truncate := copyCmd.Bool("truncate", false, "Truncate each target table before loading")
+		batchSize := copyCmd.Int("batch-size", 0, "Commit every N rows (0 = one transaction per table)")
+		deferConstraints := copyCmd.Bool("defer-constraints", false, "Defer deferrable constraints until each commit")
+		logFile := copyCmd.String("log-file", "/var/log/postgres_backup.log", "Log file path")
+		timeout := copyCmd.Duration("timeout", 0, "Abort the copy after this long (0 = no limit)")
+		execVia := copyCmd.String("exec-via", "", "Run psql through ssh:HOST, docker:CONTAINER or kubectl:POD")
+
+		copyCmd.Parse(os.Args[2:])
+		runner, err := parseRunner(*execVia)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		logF, logger, err := openLog(*logFile)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		defer logF.Close()
+		ctx, cancel := withTimeout(ctx, *timeout)
+		defer cancel()
+		opts := CopyTableOptions{
+			FromDSN:          *fromDSN,
+			ToDSN:            *toDSN,
+			Tables:           tables,
+			Truncate:         *truncate,
+			BatchSize:        *batchSize,
+			DeferConstraints: *deferConstraints,
+			Runner:           runner,
+		}
+		if err := CopyTables(ctx, opts, logger); err != nil {
+			fmt.Println("Copy failed:", err)
+			os.Exit(exitCode(err))
+		}
+
+	case "export":
+		exportCmd := flag.NewFlagSet("export", flag.ExitOnError)
+		dbName := exportCmd.String("db", "", "Database name (required)")
+		dbUser := exportCmd.String("user", "postgres", "PostgreSQL user")
+		dbHost := exportCmd.String("host", "localhost", "PostgreSQL host")
+		var tables stringList
+		exportCmd.Var(&tables, "table", "Schema-qualified table to export, e.g. public.events (repeatable, required)")
+		format := exportCmd.String("format", "csv", "Output format; only csv is supported")
+		backupDir := exportCmd.String("backup-dir", "/var/backups/postgresql", "Directory to write the exports to")
+		logFile := exportCmd.String("log-file", "/var/log/postgres_backup.log", "Log file path")
+		compressLevel := exportCmd.Int("compress-level", gzip.DefaultCompression, "gzip compression level (1-9, -1 = default)")
+		var storagePlugins, storageURLs stringList
+		exportCmd.Var(&storagePlugins, "storage-plugin", "Storage plugin executable to upload exports with (repeatable)")
+		exportCmd.Var(&storageURLs, "storage", "Storage to upload exports to: b2://BUCKET/PATH, webdav(s)://HOST/PATH or rclone:REMOTE:PATH (repeatable)")
+		uploadTimeout := exportCmd.Duration("upload-timeout", 0, "Fail an upload that takes longer than this (0 = no limit)")
+		retries := exportCmd.Int("retries", 0, "Retry uploads this many times")
+		retryBackoff := exportCmd.Duration("retry-backoff", 30*time.Second, "Delay before the first retry, doubled after each")
+		execVia := exportCmd.String("exec-via", "", "Run psql through ssh:HOST, docker:CONTAINER or kubectl:POD")
+		binDir := exportCmd.String("pg-bindir", "", "Directory of the psql to use, e.g. /usr/lib/postgresql/16/bin")
+		schedule := exportCmd.String("schedule", "", "Cron schedule; run as a daemon instead of once")
+		timeout := exportCmd.Duration("timeout", 0, "Abort an export run after this long (0 = no limit)")
+
+		exportCmd.Parse(os.Args[2:])
+		runner, err := parseRunner(*execVia)
+		var storage []StorageBackend
+		if err == nil {
+			storage, err = storageBackends(storageURLs, storagePlugins)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		cfg := Config{
+			Database:         *dbName,
+			User:             *dbUser,
+			Host:             *dbHost,
+			BackupDir:        *backupDir,
+			LogFile:          *logFile,
+			CompressionLevel: *compressLevel,
+			Storage:          storage,
+			UploadTimeout:    *uploadTimeout,
+			Retry:            RetryPolicy{Attempts: *retries + 1, Backoff: *retryBackoff},
+			BinDir:           *binDir,
+			Runner:           runner,
+		}
+		opts := ExportOptions{Config: cfg, Tables: tables, Format: *format}
+		run := func(ctx context.Context) error {
+			ctx, cancel := withTimeout(ctx, *timeout)
+			defer cancel()
+			return Export(ctx, opts)
+		}
+		if *schedule == "" {
+			if err := run(ctx); err != nil {
+				fmt.Println("Export failed:", err)
+				os.Exit(exitCode(err))
+			}
+			break
+		}
+		sched, err := ParseSchedule(*schedule)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		runDaemon(ctx, Job{Name: "export " + cfg.Database, Schedule: sched, Run: run})
+
+	case "prune":
+		pruneCmd := flag.NewFlagSet("prune", flag.ExitOnError)
+		backupDir := pruneCmd.String("backup-dir", "/var/backups/postgresql", "Backup directory")
+		logFile := pruneCmd.String("log-file", "/var/log/postgres_backup.log", "Log file path")
+		retentionDays := pruneCmd.Int("retention", 7, "Retention period in days")
+		stale := pruneCmd.Bool("stale", false, "Only remove files left behind by crashed runs")
+		staleAge := pruneCmd.Duration("stale-age", defaultStaleAge, "Age after which leftover .partial files are stale")
+		configFile := pruneCmd.String("config", "", "JSON config file whose notifiers are told about the prune")
+		auditLog := pruneCmd.String("audit-log", "", "Append who ran what, and the outcome, to this hash-chained JSONL file")
+
+		pruneCmd.Parse(os.Args[2:])
+		notifiers := auditNotifiers(nil, *auditLog)
+		if *configFile != "" {
+			cf, err := LoadConfigFile(*configFile)
+			if err == nil {
+				var more []Notifier
+				more, err = cf.notifiers("")
+				notifiers = append(notifiers, more...)
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(exitCode(err))
+			}
+		}
+		logF, logger, err := openLog(*logFile)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		defer logF.Close()
+		started := time.Now()
+		n := cleanupStale(ctx, *backupDir, *staleAge, logger)
+		fmt.Printf("Removed %d stale file(s).\n", n)
+		if !*stale {
+			n += cleanupOldBackups(ctx, *backupDir, *retentionDays, logger)
+		}
+		r := RunResult{Op: "prune", Status: "success", Started: started, Finished: time.Now(), Deleted: n}
+		r.Duration = r.Finished.Sub(started).Round(time.Second).String()
+		notifyAll(notifiers, RetryPolicy{Attempts: 1}, r, func(n Notifier, err error) {
+			logger.Printf("WARNING: Notifier %s failed: %v", n.Name(), err)
+		})
+
+	case "list":
+		listCmd := flag.NewFlagSet("list", flag.ExitOnError)
+		backupDir := listCmd.String("backup-dir", "/var/backups/postgresql", "Backup directory")
+		dbName := listCmd.String("db", "", "Only list backups of this database")
+		since := listCmd.String("since", "", "Only list backups taken at or after this date, date and time, or duration ago (e.g. 2025-08-01, 72h)")
+		until := listCmd.String("until", "", "Only list backups taken at or before this date, date and time, or duration ago")
+		var tags stringList
+		listCmd.Var(&tags, "tag", "Only list backups with this tag (repeatable; all must match)")
+		labels := mapFlag{}
+		listCmd.Var(labels, "label", "Only list backups with this KEY=VALUE label (repeatable; all must match)")
+		status := listCmd.String("status", "", "Only list backups with this status: ok or no-manifest; with -all-hosts, success, failure or aborted")
+		format := listCmd.String("format", "table", "Output format: table, json or csv")
+		latest := listCmd.Bool("latest", false, "Only the newest match; in table format, print just its path. With -all-hosts, the newest run of each host and database")
+		allHosts := listCmd.Bool("all-hosts", false, "List the runs of every host recorded in the shared -catalog instead of -backup-dir")
+		catalog := listCmd.String("catalog", "", "Shared catalog for -all-hosts, at a -storage URL")
+
+		listCmd.Parse(os.Args[2:])
+		now := time.Now()
+		filter := ListFilter{Database: *dbName, Tags: tags, Labels: labels, Status: *status}
+		var err error
+		if filter.Since, err = parseListTime(*since, now); err == nil {
+			filter.Until, err = parseListTime(*until, now)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		if *allHosts {
+			if *catalog == "" {
+				fmt.Println("Error: -all-hosts needs -catalog")
+				os.Exit(exitUsage)
+			}
+			store, err := parseCatalog(*catalog)
+			var records []CatalogRecord
+			if err == nil {
+				records, err = store.Records(ctx, filter)
+			}
+			if err != nil {
+				fmt.Println("List failed:", err)
+				os.Exit(exitCode(err))
+			}
+			records = catalogRecordsMatch(records, filter)
+			sortRecords(records)
+			if *latest {
+				records = nodeLatest(records)
+			}
+			if err := writeCatalog(os.Stdout, records, *format); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(exitCode(err))
+			}
+			break
+		}
+		entries, err := listBackups(*backupDir, filter)
+		if err != nil {
+			fmt.Println("List failed:", err)
+			os.Exit(exitCode(err))
+		}
+		if *latest {
+			if len(entries) == 0 {
+				fmt.Fprintln(os.Stderr, "No backup matches.")
+				os.Exit(exitFailure)
+			}
+			entries = entries[len(entries)-1:]
+			if *format == "table" {
+				fmt.Println(filepath.Join(*backupDir, entries[0].File))
+				break
+			}
+		}
+		if err := writeBackupList(os.Stdout, entries, *format, now); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+
+	case "info":
+		infoCmd := flag.NewFlagSet("info", flag.ExitOnError)
+		backupDir := infoCmd.String("backup-dir", "/var/backups/postgresql", "Directory to find backups given by ID in")
+		asJSON := infoCmd.Bool("json", false, "Print JSON instead of text")
+		infoCmd.Usage = func() {
+			fmt.Fprintln(infoCmd.Output(), "Usage: pgtool info [options] <file or ID, e.g. mydb_2025-08-09_114200>")
+			infoCmd.PrintDefaults()
+		}
+
+		infoCmd.Parse(os.Args[2:])
+		if infoCmd.NArg() != 1 {
+			infoCmd.Usage()
+			os.Exit(exitUsage)
+		}
+		path, err := resolveBackup(infoCmd.Arg(0), *backupDir)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		info, err := backupInfo(ctx, path)
+		if err == nil {
+			err = writeBackupInfo(os.Stdout, info, *asJSON)
+		}
+		if err != nil {
+			fmt.Println("Info failed:", err)
+			os.Exit(exitCode(err))
+		}
+
+	case "estimate":
+		estimateCmd := flag.NewFlagSet("estimate", flag.ExitOnError)
+		backupConfig := backupFlags(estimateCmd)
+		asJSON := estimateCmd.Bool("json", false, "Print JSON instead of text")
+
+		estimateCmd.Parse(os.Args[2:])
+		cfg, err := backupConfig()
+		if err == nil && cfg.Database == "" {
+			err = fmt.Errorf("%w: -db is required", ErrUsage)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		e, err := EstimateBackup(ctx, cfg)
+		if err == nil {
+			err = writeEstimate(os.Stdout, e, *asJSON)
+		}
+		if err != nil {
+			fmt.Println("Estimate failed:", err)
+			os.Exit(exitCode(err))
+		}
+
+	case "report":
+		reportCmd := flag.NewFlagSet("report", flag.ExitOnError)
+		backupDir := reportCmd.String("backup-dir", "/var/backups/postgresql", "Backup directory")
+		catalog := reportCmd.String("catalog", "", "Report on the runs of every host recorded in this shared catalog instead of -backup-dir")
+		dbName := reportCmd.String("db", "", "Only report on this database")
+		last := reportCmd.String("last", "90d", "How far back to report, e.g. 90d or 72h")
+		by := reportCmd.String("by", "week", "Period to group backups by: day, week or month")
+		format := reportCmd.String("format", "table", "Output format: table or csv")
+		spark := reportCmd.Bool("sparkline", false, "Add sparklines of size and duration to the table")
+
+		reportCmd.Parse(os.Args[2:])
+		period, err := parseLast(*last)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		filter := ListFilter{Database: *dbName, Since: time.Now().Add(-period)}
+		var records []CatalogRecord
+		if *catalog != "" {
+			var store CatalogStore
+			if store, err = parseCatalog(*catalog); err == nil {
+				records, err = store.Records(ctx, filter)
+			}
+			records = catalogRecordsMatch(records, filter)
+			sortRecords(records)
+		} else {
+			records, err = backupHistory(*backupDir, "", *dbName)
+		}
+		var trends []Trend
+		if err == nil {
+			trends, err = backupTrends(records, filter.Since, *by)
+		}
+		if err == nil {
+			err = writeReport(os.Stdout, trends, *by, *format, *spark)
+		}
+		if err != nil {
+			fmt.Println("Report failed:", err)
+			os.Exit(exitCode(err))
+		}
+
+	case "plan":
+		planCmd := flag.NewFlagSet("plan", flag.ExitOnError)
+		backupDir := planCmd.String("backup-dir", "/var/backups/postgresql", "Backup directory")
+		catalog := planCmd.String("catalog", "", "Plan from the runs of every host recorded in this shared catalog instead of -backup-dir")
+		dbName := planCmd.String("db", "", "Only plan for this database")
+		policy := planCmd.String("policy", "", "Retention policy to plan for, e.g. daily=7,weekly=4,monthly=12 (also yearly=N) (required)")
+		retentionDays := planCmd.Int("retention", 7, "Current retention period in days, to compare with")
+		last := planCmd.String("last", "90d", "How much history to learn the growth from, e.g. 90d")
+
+		planCmd.Parse(os.Args[2:])
+		if *policy == "" {
+			fmt.Println("Error: -policy is required")
+			os.Exit(exitUsage)
+		}
+		p, err := ParseRetentionPolicy(*policy)
+		var period time.Duration
+		if err == nil {
+			period, err = parseLast(*last)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		now := time.Now()
+		filter := ListFilter{Database: *dbName, Since: now.Add(-period)}
+		var records []CatalogRecord
+		if *catalog != "" {
+			var store CatalogStore
+			if store, err = parseCatalog(*catalog); err == nil {
+				records, err = store.Records(ctx, filter)
+			}
+			records = catalogRecordsMatch(records, filter)
+			sortRecords(records)
+		} else {
+			records, err = backupHistory(*backupDir, "", *dbName)
+		}
+		var plans []StoragePlan
+		if err == nil {
+			plans, err = planStorage(records, filter.Since, now, p, *retentionDays)
+		}
+		if err != nil {
+			fmt.Println("Plan failed:", err)
+			os.Exit(exitCode(err))
+		}
+		writePlan(os.Stdout, plans, p, *retentionDays, now)
+
+	case "config":
+		const configUsage = "Usage: pgtool config init [options]"
+		if len(os.Args) < 3 || os.Args[2] != "init" {
+			fmt.Println(configUsage)
+			os.Exit(exitUsage)
+		}
+		initCmd := flag.NewFlagSet("config init", flag.ExitOnError)
+		out := initCmd.String("out", "pgtool.json", "Config file to write")
+		force := initCmd.Bool("force", false, "Replace an existing -out file")
+		yes := initCmd.Bool("yes", false, "Don't prompt; use the flags and defaults as they are")
+		var ci ConfigInit
+		initCmd.StringVar(&ci.Host, "host", "localhost", "PostgreSQL host")
+		initCmd.StringVar(&ci.User, "user", "postgres", "PostgreSQL user")
+		initCmd.StringVar(&ci.PasswordEnv, "password-env", "", "Environment variable holding the password (default: use ~/.pgpass)")
+		initCmd.Var((*stringList)(&ci.Databases), "db", "Database to back up (repeatable; default: every database on the server)")
+		initCmd.Var((*stringList)(&ci.Storage), "storage", "Remote storage to upload backups to, as for backup -storage (repeatable)")
+		initCmd.IntVar(&ci.Retention, "retention", 7, "Days to keep local backups")
+		initCmd.IntVar(&ci.RemoteRetention, "remote-retention", 30, "Days to keep remote backups (0 = keep)")
+		initCmd.StringVar(&ci.PagerDutyKey, "pagerduty-key", "", "PagerDuty Events v2 routing key to page on failure")
+		initCmd.StringVar(&ci.WebhookURL, "webhook-url", "", "Webhook to post the outcome of every run to")
+
+		initCmd.Parse(os.Args[3:])
+		// Prompt only when someone is there to answer
+		if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 && !*yes {
+			if err := ci.prompt(os.Stdin, os.Stdout); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(exitCode(err))
+			}
+		}
+		if err := writeConfigInit(*out, ci.configFile(), *force); err != nil {
+			fmt.Println("Config init failed:", err)
+			os.Exit(exitCode(err))
+		}
+		printConfigGuide(os.Stdout, *out, ci)
+
+	case "catalog":
+		const catalogUsage = "Usage: pgtool catalog <export|import|gc> [options]"
+		if len(os.Args) < 3 {
+			fmt.Println(catalogUsage)
+			os.Exit(exitUsage)
+		}
+		switch os.Args[2] {
+		case "export":
+			exportCmd := flag.NewFlagSet("catalog export", flag.ExitOnError)
+			backupDir := exportCmd.String("backup-dir", "/var/backups/postgresql", "Backup directory")
+			auditLog := exportCmd.String("audit-log", "", "Audit log to add failed runs and removed backups from")
+			dbName := exportCmd.String("db", "", "Only export backups of this database")
+			format := exportCmd.String("format", "csv", "Output format: csv or json")
+
+			exportCmd.Parse(os.Args[3:])
+			records, err := backupHistory(*backupDir, *auditLog, *dbName)
+			if err == nil {
+				err = writeCatalog(os.Stdout, records, *format)
+			}
+			if err != nil {
+				fmt.Println("Catalog export failed:", err)
+				os.Exit(exitCode(err))
+			}
+
+		case "import":
+			importCmd := flag.NewFlagSet("catalog import", flag.ExitOnError)
+			srcDir := importCmd.String("dir", "", "Directory of existing pg_dump -Fc archives, plain or gzipped (required)")
+			backupDir := importCmd.String("backup-dir", "/var/backups/postgresql", "Backup directory to import them into")
+			logFile := importCmd.String("log-file", "/var/log/postgres_backup.log", "Log file path")
+			dbName := importCmd.String("db", "", "Database of every dump, instead of the name recorded in each archive")
+			move := importCmd.Bool("move", false, "Remove each source file once imported")
+			dryRun := importCmd.Bool("dry-run", false, "Print what would be imported without copying anything")
+
+			importCmd.Parse(os.Args[3:])
+			if *srcDir == "" {
+				fmt.Println("Error: -dir is required")
+				os.Exit(exitUsage)
+			}
+			logF, logger, err := openLog(*logFile)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(exitCode(err))
+			}
+			defer logF.Close()
+			opts := ImportOptions{Database: *dbName, Move: *move, DryRun: *dryRun}
+			n, err := importBackups(ctx, *srcDir, *backupDir, opts, logger)
+			if err != nil {
+				fmt.Println("Catalog import failed:", err)
+				os.Exit(exitCode(err))
+			}
+			fmt.Printf("%d backup(s) from %s are in the catalog.\n", n, *srcDir)
+
+		case "gc":
+			gcCmd := flag.NewFlagSet("catalog gc", flag.ExitOnError)
+			backupDir := gcCmd.String("backup-dir", "/var/backups/postgresql", "Backup directory")
+			logFile := gcCmd.String("log-file", "/var/log/postgres_backup.log", "Log file path")
+			dbName := gcCmd.String("db", "", "Only check backups of this database")
+			dryRun := gcCmd.Bool("dry-run", false, "Report what is wrong without changing anything")
+			rehash := gcCmd.Bool("rehash", false, "Record the current checksum of files that no longer match their manifest")
+
+			gcCmd.Parse(os.Args[3:])
+			logF, logger, err := openLog(*logFile)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(exitCode(err))
+			}
+			defer logF.Close()
+			opts := GCOptions{Database: *dbName, DryRun: *dryRun, Rehash: *rehash}
+			report, err := catalogGC(ctx, *backupDir, opts, logger)
+			for _, l := range []struct {
+				what  string
+				files []string
+			}{
+				{"stale manifest", report.Removed}, {"repaired", report.Repaired},
+				{"not in catalog", report.Untracked}, {"checksum differs", report.Mismatched},
+			} {
+				for _, f := range l.files {
+					fmt.Printf("%-17s %s\n", l.what+":", f)
+				}
+			}
+			fmt.Printf("%d stale manifest(s), %d repaired, %d file(s) not in the catalog, %d checksum mismatch(es).\n",
+				len(report.Removed), len(report.Repaired), len(report.Untracked), len(report.Mismatched))
+			if err != nil {
+				fmt.Println("Catalog gc failed:", err)
+				os.Exit(exitCode(err))
+			}
+
+		default:
+			fmt.Println(catalogUsage)
+			os.Exit(exitUsage)
+		}
+
+	case "verify":
+		verifyCmd := flag.NewFlagSet("verify", flag.ExitOnError)
+		dbName := verifyCmd.String("db", "", "Database whose latest backup to verify (required)")
+		backupDir := verifyCmd.String("backup-dir", "/var/backups/postgresql", "Backup directory")
+		logFile := verifyCmd.String("log-file", "/var/log/postgres_backup.log", "Log file path")
+		offsite := verifyCmd.Bool("offsite", false, "Verify the copies in -storage instead of the local files")
+		maxLag := verifyCmd.Duration("max-lag", 0, "With -offsite, only fail for a missing copy once the backup is older than this")
+		var storageURLs, storagePlugins stringList
+		verifyCmd.Var(&storageURLs, "storage", "Storage holding off-site copies, e.g. b2://bucket/prefix (repeatable)")
+		verifyCmd.Var(&storagePlugins, "storage-plugin", "Storage plugin executable (repeatable)")
+		pdKey := verifyCmd.String("pagerduty-key", os.Getenv("PAGERDUTY_ROUTING_KEY"), "PagerDuty Events v2 routing key to page on failure (default $PAGERDUTY_ROUTING_KEY)")
+		pdSeverity := verifyCmd.String("pagerduty-severity", "", "Severity of PagerDuty incidents: critical, error, warning or info (default error)")
+		configFile := verifyCmd.String("config", "", "JSON config file with per-database settings and notifiers")
+		auditLog := verifyCmd.String("audit-log", "", "Append who ran what, and the outcome, to this hash-chained JSONL file")
+
+		verifyCmd.Parse(os.Args[2:])
+		notifiers, err := pagerDutyNotifiers(auditNotifiers(nil, *auditLog), *pdKey, *pdSeverity)
+		if err == nil && *dbName == "" {
+			err = fmt.Errorf("%w: -db is required", ErrUsage)
+		}
+		if err == nil && *configFile != "" {
+			var cf *ConfigFile
+			if cf, err = LoadConfigFile(*configFile); err == nil {
+				var more []Notifier
+				more, err = cf.notifiers(*dbName)
+				notifiers = append(notifiers, more...)
+			}
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		logF, logger, err := openLog(*logFile)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		defer logF.Close()
+		started := time.Now()
+		if *offsite {
+			var backends []StorageBackend
+			if backends, err = storageBackends(storageURLs, storagePlugins); err == nil {
+				err = verifyOffsite(ctx, *backupDir, *dbName, backends, *maxLag, logger)
+			}
+		} else {
+			var m Manifest
+			if m, _, err = latestManifest(*backupDir, *dbName); err == nil {
+				err = verifyWrites(ctx, *backupDir, m.Checksums, false, logger)
+			}
+		}
+		r := RunResult{Op: "verify", Database: *dbName, Status: "success", Started: started, Finished: time.Now()}
+		r.Duration = r.Finished.Sub(started).Round(time.Second).String()
+		if err != nil {
+			r.Status, r.Error = "failure", err.Error()
+		}
+		notifyAll(notifiers, RetryPolicy{Attempts: 1}, r, func(n Notifier, err error) {
+			logger.Printf("WARNING: Notifier %s failed: %v", n.Name(), err)
+		})
+		if err != nil {
+			fmt.Println("Verify failed:", err)
+			os.Exit(exitCode(err))
+		}
+		fmt.Println("Verify completed.")
+
+	case "audit":
+		auditCmd := flag.NewFlagSet("audit", flag.ExitOnError)
+		file := auditCmd.String("file", "", "Audit log to check (required)")
+
+		auditCmd.Parse(os.Args[2:])
+		if *file == "" {
+			fmt.Println("Error: -file is required")
+			os.Exit(exitUsage)
+		}
+		n, err := checkAuditLog(*file)
+		if err != nil {
+			fmt.Println("Audit check failed:", err)
+			os.Exit(exitCode(err))
+		}
+		fmt.Printf("Audit log intact: %d entries.\n", n)
+
+	case "share":
+		shareCmd := flag.NewFlagSet("share", flag.ExitOnError)
+		storageURL := shareCmd.String("storage", "", "Storage holding the backup, e.g. b2://bucket/prefix (required)")
+		file := shareCmd.String("file", "", "Name of the backup file to share (required)")
+		expires := shareCmd.Duration("expires", 24*time.Hour, "How long the link stays valid")
+
+		shareCmd.Parse(os.Args[2:])
+		u, err := shareURL(ctx, *storageURL, filepath.Base(*file), *expires)
+		if err != nil {
+			fmt.Println("Share failed:", err)
+			os.Exit(exitCode(err))
+		}
+		fmt.Println(u)
+
+	case "diff":
+		diffCmd := flag.NewFlagSet("diff", flag.ExitOnError)
+		dbName := diffCmd.String("db", "", "Database to compare (required)")
+		dbUser := diffCmd.String("user", "postgres", "Database user")
+		dbHost := diffCmd.String("host", "localhost", "Database host")
+		backupFile := diffCmd.String("file", "", "Backup file (.dump.gz) to compare the database against")
+		otherDB := diffCmd.String("other-db", "", "Second database on the same server to compare against, instead of -file")
+		timeout := diffCmd.Duration("timeout", 0, "Abort after this long (0 = no limit)")
+		execVia := diffCmd.String("exec-via", "", "Run the PostgreSQL tools through ssh:HOST, docker:CONTAINER or kubectl:POD")
+
+		diffCmd.Parse(os.Args[2:])
+		runner, err := parseRunner(*execVia)
+		if err == nil && (*dbName == "" || (*backupFile == "") == (*otherDB == "")) {
+			err = fmt.Errorf("%w: -db and exactly one of -file or -other-db are required", ErrUsage)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		cfg := Config{Database: *dbName, User: *dbUser, Host: *dbHost, Runner: runner}
+		other := cfg
+		other.Database = *otherDB
+		old := func(ctx context.Context) (string, error) { return databaseSchema(ctx, other) }
+		if *backupFile != "" {
+			old = func(ctx context.Context) (string, error) { return backupSchema(ctx, cfg, *backupFile) }
+		}
+		ctx, cancel := withTimeout(ctx, *timeout)
+		defer cancel()
+		changes, err := SchemaDiff(ctx, old, func(ctx context.Context) (string, error) { return databaseSchema(ctx, cfg) })
+		if err != nil {
+			fmt.Println("Diff failed:", err)
+			os.Exit(exitCode(err))
+		}
+		printSchemaDiff(os.Stdout, changes)
+
+	case "daemon":
+		daemonCmd := flag.NewFlagSet("daemon", flag.ExitOnError)
+		backupConfig := backupFlags(daemonCmd)
+		schedule := daemonCmd.String("schedule", "0 2 * * *", "Cron schedule for backups (minute hour day month weekday)")
+		jitter := daemonCmd.Duration("jitter", 0, "Delay each run by a random duration up to this long")
+		timeout := daemonCmd.Duration("timeout", 0, "Abort a backup run after this long (0 = no limit)")
+
+		fleetMode := daemonCmd.Bool("fleet", false, "Back up the -config file's \"servers\", each on its own schedule (default -schedule)")
+
+		daemonCmd.Parse(os.Args[2:])
+		cfg, err := backupConfig()
+		if err == nil && !*fleetMode {
+			err = cfg.Validate()
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		sched, err := ParseSchedule(*schedule)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		if *fleetMode {
+			fleet, err := loadFleet(daemonCmd, cfg)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(exitCode(err))
+			}
+			var jobs []Job
+			for _, s := range fleet {
+				job := Job{Name: s.Name, Schedule: sched, Jitter: *jitter}
+				if s.Schedule != "" {
+					if job.Schedule, err = ParseSchedule(s.Schedule); err != nil {
+						fmt.Printf("Error: server '%s': %v\n", s.Name, err)
+						os.Exit(exitCode(err))
+					}
+				}
+				job.Run = func(ctx context.Context) error {
+					ctx, cancel := withTimeout(ctx, *timeout)
+					defer cancel()
+					_, err := BackupFleet(ctx, []FleetServer{s})
+					return err
+				}
+				jobs = append(jobs, job)
+			}
+			runDaemon(ctx, jobs...)
+			break
+		}
+		runDaemon(ctx, Job{
+			Name:     cfg.Database,
+			Schedule: sched,
+			Jitter:   *jitter,
+			Run: func(ctx context.Context) error {
+				ctx, cancel := withTimeout(ctx, *timeout)
+				defer cancel()
+				err := runBackup(ctx, cfg)
+				if errors.Is(err, ErrSkipped) {
+					log.Printf("Run of '%s' %v.", cfg.Database, err)
+					return nil
+				}
+				return err
+			},
+		})
+
+	case "schedule":
+		const scheduleUsage = "Usage: pgtool schedule <pause|resume|status> [options]"
+		if len(os.Args) < 3 {
+			fmt.Println(scheduleUsage)
+			os.Exit(exitUsage)
+		}
+		switch os.Args[2] {
+		case "pause":
+			pauseCmd := flag.NewFlagSet("schedule pause", flag.ExitOnError)
+			backupDir := pauseCmd.String("backup-dir", "/var/backups/postgresql", "Backup directory whose backups to pause")
+			from := pauseCmd.String("from", "", "Start of the pause, as a date or date and time (default now)")
+			until := pauseCmd.String("until", "", "End of the pause, as a date, date and time, or duration from now, e.g. 48h (required)")
+			reason := pauseCmd.String("reason", "", "Why backups are paused, shown in skipped runs")
+
+			pauseCmd.Parse(os.Args[3:])
+			now := time.Now()
+			b := Blackout{From: now, Reason: *reason}
+			var err error
+			if *from != "" {
+				b.From, err = parseListTime(*from, now)
+			}
+			if d, derr := time.ParseDuration(*until); derr == nil && err == nil {
+				b.Until = now.Add(d)
+			} else if err == nil {
+				b.Until, err = parseListTime(*until, now)
+			}
+			if err == nil && !b.Until.After(b.From) {
+				err = fmt.Errorf("%w: -until is required and must be after -from", ErrUsage)
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(exitCode(err))
+			}
+			if err := writePause(*backupDir, b); err != nil {
+				fmt.Println("Pause failed:", err)
+				os.Exit(exitCode(err))
+			}
+			fmt.Printf("Backups in %s paused from %s until %s.\n", *backupDir, b.From.Format("2006-01-02 15:04"), b.Until.Format("2006-01-02 15:04"))
+
+		case "resume":
+			resumeCmd := flag.NewFlagSet("schedule resume", flag.ExitOnError)
+			backupDir := resumeCmd.String("backup-dir", "/var/backups/postgresql", "Backup directory whose backups to resume")
+
+			resumeCmd.Parse(os.Args[3:])
+			paused, err := removePause(*backupDir)
+			if err != nil {
+				fmt.Println("Resume failed:", err)
+				os.Exit(exitCode(err))
+			}
+			if !paused {
+				fmt.Printf("Backups in %s were not paused.\n", *backupDir)
+				break
+			}
+			fmt.Printf("Backups in %s resumed.\n", *backupDir)
+
+		case "status":
+			statusCmd := flag.NewFlagSet("schedule status", flag.ExitOnError)
+			backupDir := statusCmd.String("backup-dir", "/var/backups/postgresql", "Backup directory")
+			configFile := statusCmd.String("config", "", "JSON config file whose blackouts to show too")
+
+			statusCmd.Parse(os.Args[3:])
+			var blackouts []Blackout
+			pause, err := readPause(*backupDir)
+			if err == nil && pause != nil {
+				pause.Reason = strings.TrimSuffix("paused: "+pause.Reason, ": ")
+				blackouts = append(blackouts, *pause)
+			}
+			if err == nil && *configFile != "" {
+				var cf *ConfigFile
+				if cf, err = LoadConfigFile(*configFile); err == nil {
+					blackouts = append(blackouts, parseBlackouts(cf.Blackouts)...)
+					var dbs []string
+					for db := range cf.Databases {
+						dbs = append(dbs, db)
+					}
+					sort.Strings(dbs)
+					for _, db := range dbs {
+						for _, b := range parseBlackouts(cf.Databases[db].Blackouts) {
+							b.Reason = strings.TrimSuffix(db+": "+b.Reason, ": ")
+							blackouts = append(blackouts, b)
+						}
+					}
+				}
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(exitCode(err))
+			}
+			now := time.Now()
+			for _, b := range blackouts {
+				state := ""
+				switch {
+				case b.Contains(now):
+					state = "ACTIVE"
+				case !b.Until.After(now):
+					state = "over"
+				}
+				fmt.Printf("%-6s %s - %s  %s\n", state, b.From.Format("2006-01-02 15:04"), b.Until.Format("2006-01-02 15:04"), b.Reason)
+			}
+			if len(blackouts) == 0 {
+				fmt.Println("No blackouts.")
+			}
+
+		default:
+			fmt.Println(scheduleUsage)
+			os.Exit(exitUsage)
+		}
+
+	case "coordinator":
+		coordCmd := flag.NewFlagSet("coordinator", flag.ExitOnError)
+		configFile := coordCmd.String("config", "", "JSON config file whose \"servers\" name the agents and their databases (required)")
+		listen := coordCmd.String("listen", ":8420", "Address to serve the agents on")
+		token := coordCmd.String("token", os.Getenv("PGTOOL_TOKEN"), "Shared secret of the coordinator and its agents (default $PGTOOL_TOKEN)")
+		tlsCert := coordCmd.String("tls-cert", "", "TLS certificate file; serve HTTPS")
+		tlsKey := coordCmd.String("tls-key", "", "TLS key file for -tls-cert")
+		schedule := coordCmd.String("schedule", "0 2 * * *", "Cron schedule of servers without their own")
+		jitter := coordCmd.Duration("jitter", 0, "Delay each run by a random duration up to this long")
+		timeout := coordCmd.Duration("timeout", 0, "Give up waiting for an agent's results after this long (0 = no limit)")
+
+		coordCmd.Parse(os.Args[2:])
+		if *configFile == "" || *token == "" || (*tlsCert == "") != (*tlsKey == "") {
+			fmt.Println("Error: -config and -token are required, and -tls-cert and -tls-key go together")
+			os.Exit(exitUsage)
+		}
+		cf, err := LoadConfigFile(*configFile)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		sched, err := ParseSchedule(*schedule)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		var names []string
+		for _, sc := range cf.Servers {
+			names = append(names, sc.Name)
+		}
+		coord := NewCoordinator(*token, names)
+		jobs, err := coordinatorJobs(coord, cf, sched, *jitter, *timeout)
+		if err == nil && len(jobs) == 0 {
+			err = fmt.Errorf("%w: the -config file has no \"servers\"", ErrUsage)
+		}
+		if err == nil {
+			err = serveCoordinator(ctx, *listen, *tlsCert, *tlsKey, coord)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		runDaemon(ctx, jobs...)
+
+	case "agent":
+		agentCmd := flag.NewFlagSet("agent", flag.ExitOnError)
+		backupConfig := backupFlags(agentCmd)
+		coordinator := agentCmd.String("coordinator", "", "URL of the coordinator, e.g. https://ops.internal:8420 (required)")
+		name := agentCmd.String("name", "", "This agent's server name in the coordinator's config (default: the host name)")
+		token := agentCmd.String("token", os.Getenv("PGTOOL_TOKEN"), "Shared secret of the coordinator and its agents (default $PGTOOL_TOKEN)")
+
+		agentCmd.Parse(os.Args[2:])
+		cfg, err := backupConfig()
+		if err == nil && (*coordinator == "" || *token == "" || cfg.Database != "") {
+			err = fmt.Errorf("%w: -coordinator and -token are required; the coordinator chooses the databases, not -db", ErrUsage)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		agent := Agent{Name: *name, URL: *coordinator, Token: *token, Server: cfg}
+		if agent.Name == "" {
+			agent.Name, _ = os.Hostname()
+		}
+		if path := agentCmd.Lookup("config").Value.String(); path != "" {
+			cf, err := LoadConfigFile(path)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(exitCode(err))
+			}
+			cf.givenFlags(agentCmd)
+			agent.apply = cf.apply
+		}
+		agent.Run(ctx)
+
+	case "selftest":
+		selftestCmd := flag.NewFlagSet("selftest", flag.ExitOnError)
+		backupConfig := backupFlags(selftestCmd)
+		pgVersion := selftestCmd.String("pg-version", "latest", "PostgreSQL image version to test against")
+		keep := selftestCmd.Bool("keep", false, "Keep the working directory with the backups and log")
+
+		selftestCmd.Parse(os.Args[2:])
+		cfg, err := backupConfig()
+		if err == nil && (cfg.Database != "" || selftestCmd.Lookup("exec-via").Value.String() != "") {
+			err = fmt.Errorf("%w: selftest backs up a sample database on its own server with the local tools; -db and -exec-via do not apply", ErrUsage)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+		if err := Selftest(ctx, cfg, SelftestOptions{Version: *pgVersion, Keep: *keep}); err != nil {
+			fmt.Println("Selftest failed:", err)
+			os.Exit(exitCode(err))
+		}
+		fmt.Println("Selftest passed.")
+
+	case "completion":
+		const completionUsage = "Usage: pgtool completion <bash|zsh>"
+		if len(os.Args) != 3 {
+			fmt.Println(completionUsage)
+			os.Exit(exitUsage)
+		}
+		if err := printCompletion(os.Args[2]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(exitCode(err))
+		}
+
+	case "__complete":
+		// Called by the completion scripts with the words typed so far
+		for _, c := range complete(ctx, os.Args[2:]) {
+			fmt.Println(c)
+		}
+
+	default:
+		fmt.Println("Unknown command:", os.Args[1])
+		fmt.Println(usage)
+		os.Exit(exitUsage)
+	}
+}
+
+// backupFlags defines the backup flags on fs and returns a function that
+// builds the Config once fs has been parsed.
+func backupFlags(fs *flag.FlagSet) func() (Config, error) {
+	dbName := fs.String("db", "", "Database name (required)")
+	dbUser := fs.String("user", "postgres", "PostgreSQL user")
+	dbHost := fs.String("host", "localhost", "PostgreSQL host")
+	role := fs.String("role", "", "Role to SET ROLE to after connecting, for pg_dump")
+	backupDir := fs.String("backup-dir", "/var/backups/postgresql", "Backup directory")
+	logFile := fs.String("log-file", "/var/log/postgres_backup.log", "Log file path")
+	retentionDays := fs.Int("retention", 7, "Retention period in days")
+	remoteRetention := fs.Int("remote-retention", 0, "Delete this database's backups older than this many days from -storage (0 = keep)")
+	blobs := fs.Bool("blobs", false, "Include large objects in the dump")
+	noBlobs := fs.Bool("no-blobs", false, "Exclude large objects from the dump")
+	blobsSeparate := fs.Bool("blobs-separate", false, "Dump large objects in a separate parallel pass")
+	subset := fs.String("subset", "", "JSON file of per-table row filters for a reduced dump")
+	var tags stringList
+	fs.Var(&tags, "tag", "Record this tag in the backup's manifest, e.g. pre-migration (repeatable)")
+	labels := mapFlag{}
+	fs.Var(labels, "label", "Record this KEY=VALUE label in the backup's manifest, e.g. ticket=ENG-1234 (repeatable)")
+	var extensions stringList
+	fs.Var(&extensions, "extension", "Dump only extensions matching this pattern (repeatable)")
+	var tables stringList
+	fs.Var(&tables, "table", "Table to back up, or pattern such as 'events_2024_*' (repeatable)")
+	changedParts := fs.String("changed-partitions", "", "Dump only the partitions of this table written to since the last backup")
+	var excludeData stringList
+	fs.Var(&excludeData, "exclude-table-data", "Dump the definition but not the rows of tables matching this pattern (repeatable)")
+	configFile := fs.String("config", "", "JSON config file with per-database settings and notifiers")
+	var preSQL, postSQL stringList
+	fs.Var(&preSQL, "pre-backup-sql", "SQL statement to run before pg_dump, e.g. CHECKPOINT (repeatable)")
+	fs.Var(&postSQL, "post-backup-sql", "SQL statement to run after the dump, even if it failed (repeatable)")
+	hookAbort := fs.Bool("hook-abort", false, "Fail the backup if a pre- or post-backup statement fails")
+	serializable := fs.Bool("serializable-deferrable", false, "Dump from a serializable deferrable snapshot (pg_dump --serializable-deferrable)")
+	lockTimeout := fs.Duration("lock-timeout", 0, "lock_timeout for the dump session, e.g. 30s (0 = server default)")
+	statementTimeout := fs.Duration("statement-timeout", 0, "statement_timeout for the dump session (0 = server default)")
+	globals := fs.Bool("globals", false, "Also back up roles and tablespaces (pg_dumpall --globals-only)")
+	var dumpArgs stringList
+	fs.Var(&dumpArgs, "dump-arg", "Extra pg_dump option, e.g. -dump-arg=--no-comments (repeatable)")
+	execVia := fs.String("exec-via", "", "Run pg_dump through ssh:HOST, docker:CONTAINER or kubectl:POD")
+	binDir := fs.String("pg-bindir", "", "Directory of the pg_dump, pg_restore and psql to use, e.g. /usr/lib/postgresql/16/bin")
+	compressLevel := fs.Int("compress-level", gzip.DefaultCompression, "gzip compression level (1-9, -1 = default)")
+	failOnWarnings := fs.Bool("fail-on-warnings", false, "Fail the backup if pg_dump prints warnings")
+	syncWrites := fs.Bool("sync-writes", false, "Fsync the backup directory and read every file back to check it, for NFS/SMB")
+	directVerify := fs.Bool("sync-writes-direct", false, "Like -sync-writes, but read back bypassing the page cache (Linux)")
+	minFree := fs.String("min-free-space", "", "Abort if free space in the backup directory is or falls below this, e.g. 20G or 10%")
+	spaceFactor := fs.Float64("space-factor", 1.0, "Require this many times the database size free in the backup directory (0 = don't check)")
+	dumpTimeout := fs.Duration("backup-timeout", 0, "Kill pg_dump if it runs longer than this (0 = no limit)")
+	uploadTimeout := fs.Duration("upload-timeout", 0, "Fail an upload that takes longer than this (0 = no limit)")
+	retries := fs.Int("retries", 0, "Retry connection failures, uploads and notifications this many times")
+	retryBackoff := fs.Duration("retry-backoff", 30*time.Second, "Delay before the first retry, doubled after each")
+	var storagePlugins, notifyPlugins stringList
+	fs.Var(&storagePlugins, "storage-plugin", "Storage plugin executable to upload backups with (repeatable)")
+	var storageURLs stringList
+	fs.Var(&storageURLs, "storage", "Storage to upload backups to: b2://BUCKET/PATH, webdav(s)://HOST/PATH or rclone:REMOTE:PATH (repeatable)")
+	fs.Var(&notifyPlugins, "notify-plugin", "Notifier plugin executable (repeatable)")
+	pdKey := fs.String("pagerduty-key", os.Getenv("PAGERDUTY_ROUTING_KEY"), "PagerDuty Events v2 routing key to page on failure (default $PAGERDUTY_ROUTING_KEY)")
+	pdSeverity := fs.String("pagerduty-severity", "", "Severity of PagerDuty incidents: critical, error, warning or info (default error)")
+	auditLog := fs.String("audit-log", "", "Append who ran what, and the outcome, to this hash-chained JSONL file")
+	catalog := fs.String("catalog", "", "Record every run under this host's name in a catalog shared between hosts, at a -storage URL")
+	window := fs.String("window", "", "Only start backups in this daily window of local time, e.g. 22:00-06:00; skip them outside it")
+	windowDefer := fs.Bool("window-defer", false, "Outside the -window, wait for it to open instead of skipping the backup")
+	ignoreBlackouts := fs.Bool("ignore-blackouts", false, "Back up even during a blackout or while the backup directory is paused")
+	maxDuration := fs.String("max-duration", "", "Warn when a backup takes longer than this, e.g. 2h, or than this factor of the median of recent backups, e.g. 1.5x")
+	injectFailure := fs.String("inject-failure", "", "Testing: fail the dump, verify or upload phase on purpose, to check that alerts fire")
+
+	return func() (Config, error) {
+		runner, err := parseRunner(*execVia)
+		if err != nil {
+			return Config{}, err
+		}
+		minFreeSpace, err := ParseFreeSpaceLimit(*minFree)
+		if err != nil {
+			return Config{}, err
+		}
+		storage, err := storageBackends(storageURLs, storagePlugins)
+		if err != nil {
+			return Config{}, err
+		}
+		var w *Window
+		if *window != "" {
+			if w, err = ParseWindow(*window); err != nil {
+				return Config{}, err
+			}
+		}
+		var maxDur *DurationLimit
+		if *maxDuration != "" {
+			if maxDur, err = ParseDurationLimit(*maxDuration); err != nil {
+				return Config{}, err
+			}
+		}
+		var sc *SubsetConfig
+		if *subset != "" {
+			if sc, err = LoadSubsetConfig(*subset); err != nil {
+				return Config{}, err
+			}
+		}
+		cfg := Config{
+			Database:            *dbName,
+			User:                *dbUser,
+			Host:                *dbHost,
+			Role:                *role,
+			BackupDir:           *backupDir,
+			LogFile:             *logFile,
+			RetentionDays:       *retentionDays,
+			RemoteRetentionDays: *remoteRetention,
+			Blobs:               *blobs,
+			NoBlobs:             *noBlobs,
+			BlobsSeparate:       *blobsSeparate,
+			Tables:              tables,
+			ExcludeTableData:    excludeData,
+			ChangedPartitions:   *changedParts,
+			Extensions:          extensions,
+			Tags:                tags,
+			Labels:              labels,
+			DumpArgs:            dumpArgs,
+			Globals:             *globals,
+			PreBackupSQL:        preSQL,
+			PostBackupSQL:       postSQL,
+			HookFailureAborts:   *hookAbort,
+			Subset:              sc,
+			Storage:             storage,
+			Notifiers:           execNotifiers(notifyPlugins),
+
+			CompressionLevel: *compressLevel,
+			Retry:            RetryPolicy{Attempts: *retries + 1, Backoff: *retryBackoff},
+			FailOnWarnings:   *failOnWarnings,
+			SpaceFactor:      *spaceFactor,
+			MinFreeSpace:     minFreeSpace,
+			SyncWrites:       *syncWrites,
+			DirectVerify:     *directVerify,
+			DumpTimeout:      *dumpTimeout,
+			UploadTimeout:    *uploadTimeout,
+			BinDir:           *binDir,
+			Runner:           runner,
+
+			SerializableDeferrable: *serializable,
+			LockTimeout:            *lockTimeout,
+			StatementTimeout:       *statementTimeout,
+
+			Window:          w,
+			WindowDefer:     *windowDefer,
+			IgnoreBlackouts: *ignoreBlackouts,
+			MaxDuration:     maxDur,
+			InjectFailure:   *injectFailure,
+		}
+		severity := *pdSeverity
+		if *configFile != "" {
+			cf, err := LoadConfigFile(*configFile)
+			if err != nil {
+				return Config{}, err
+			}
+			cf.givenFlags(fs)
+			cfg.Blackouts = parseBlackouts(cf.Blackouts)
+			// Without -db, as in fleet and -all runs, the config file
+			// is applied to each database instead
+			if cfg.Database != "" {
+				if err := cf.apply(&cfg); err != nil {
+					return Config{}, err
+				}
+			}
+			severity = cf.pagerDutySeverity(cfg.Database, severity)
+		}
+		if *catalog != "" {
+			store, err := parseCatalog(*catalog)
+			if err != nil {
+				return Config{}, err
+			}
+			cfg.Notifiers = append(cfg.Notifiers, CatalogNotifier{Store: store})
+		}
+		cfg.Notifiers, err = pagerDutyNotifiers(cfg.Notifiers, *pdKey, severity)
+		cfg.Notifiers = auditNotifiers(cfg.Notifiers, *auditLog)
+		return cfg, err
+	}
+}
+
+// restoreFlags defines the restore flags on fs and returns a function that
+// builds the Config once fs has been parsed.
+func restoreFlags(fs *flag.FlagSet) func() (Config, error) {
+	dbName := fs.String("db", "", "Database name (required)")
+	dbUser := fs.String("user", "postgres", "PostgreSQL user")
+	dbHost := fs.String("host", "localhost", "PostgreSQL host")
+	role := fs.String("role", "", "Role to SET ROLE to after connecting, for pg_restore")
+	logFile := fs.String("log-file", "/var/log/postgres_backup.log", "Log file path")
+	execVia := fs.String("exec-via", "", "Run pg_restore through ssh:HOST, docker:CONTAINER or kubectl:POD")
+	binDir := fs.String("pg-bindir", "", "Directory of the pg_restore and psql to use, e.g. /usr/lib/postgresql/16/bin")
+	var storagePlugins, notifyPlugins stringList
+	fs.Var(&storagePlugins, "storage-plugin", "Storage plugin executable to download the backup from if it is not local")
+	var storageURLs stringList
+	fs.Var(&storageURLs, "storage", "Storage to download the backup from if it is not local, e.g. b2://bucket/prefix")
+	fs.Var(&notifyPlugins, "notify-plugin", "Notifier plugin executable (repeatable)")
+	pdKey := fs.String("pagerduty-key", os.Getenv("PAGERDUTY_ROUTING_KEY"), "PagerDuty Events v2 routing key to page on failure (default $PAGERDUTY_ROUTING_KEY)")
+	pdSeverity := fs.String("pagerduty-severity", "", "Severity of PagerDuty incidents: critical, error, warning or info (default error)")
+	auditLog := fs.String("audit-log", "", "Append who ran what, and the outcome, to this hash-chained JSONL file")
+	configFile := fs.String("config", "", "JSON config file with per-database settings, validation checks and notifiers")
+	var postScripts stringList
+	fs.Var(&postScripts, "post-restore-sql", "SQL file or directory of *.sql files to run after the restore (repeatable, in order)")
+	postContinue := fs.Bool("post-restore-continue-on-error", false, "Keep running post-restore scripts after one fails")
+	maskRules := fs.String("mask-rules", "", "JSON file of column masking rules applied while restoring into the non-production targets it lists")
+	roleMap, tablespaceMap := mapFlag{}, mapFlag{}
+	fs.Var(roleMap, "remap-role", "Restore objects of role OLD as role NEW, given as OLD=NEW (repeatable)")
+	fs.Var(tablespaceMap, "remap-tablespace", "Restore objects in tablespace OLD into NEW, given as OLD=NEW (repeatable)")
+	withGlobals := fs.Bool("with-globals", false, "Restore the backup's roles and tablespaces before the database")
+	var restoreArgs stringList
+	fs.Var(&restoreArgs, "restore-arg", "Extra pg_restore option, e.g. -restore-arg=--no-comments (repeatable)")
+	var sections stringList
+	fs.Var(&sections, "section", "Restore only this section: pre-data, data or post-data (repeatable)")
+	disableTriggers := fs.Bool("disable-triggers", false, "Disable triggers and foreign key checks while a data-only restore loads rows")
+	superuser := fs.String("superuser", "postgres", "Superuser to disable triggers as, if -user is not one")
+	validateFKs := fs.Bool("validate-fks", false, "Check every foreign key against the restored rows afterwards")
+	noBlobs := fs.Bool("no-blobs", false, "Skip large objects")
+	blobsSeparate := fs.Bool("blobs-separate", false, "Restore large objects in a second pg_restore, alongside everything else")
+	blobReport := fs.String("blob-report", "", "Write the OIDs of the backup's large objects, and which already existed, to this file")
+	ifExists := fs.String("if-exists", "clean", "If the database exists: drop it, rename it to <db>_old_<timestamp>, fail, or clean its objects in place")
+	analyzeAfter := fs.Bool("analyze-after", true, "Gather planner statistics after restoring data, with vacuumdb --analyze-in-stages")
+	skipExtCheck := fs.Bool("skip-extension-check", false, "Restore even if the target lacks extensions listed in the manifest")
+	failOnWarnings := fs.Bool("fail-on-warnings", false, "Fail the restore if pg_restore prints warnings")
+	exitOnError := fs.Bool("exit-on-error", false, "Stop pg_restore at the first error")
+	maxErrors := fs.Int("max-errors", 0, "Stop pg_restore after more than this many errors (0 = no limit)")
+	progress := fs.Bool("progress", false, "Report the object being restored, percent done and ETA")
+	restoreTimeout := fs.Duration("restore-timeout", 0, "Kill pg_restore if it runs longer than this (0 = no limit)")
+
+	return func() (Config, error) {
+		runner, err := parseRunner(*execVia)
+		if err != nil {
+			return Config{}, err
+		}
+		storage, err := storageBackends(storageURLs, storagePlugins)
+		if err != nil {
+			return Config{}, err
+		}
+		var masking *MaskConfig
+		if *maskRules != "" {
+			if masking, err = LoadMaskConfig(*maskRules); err != nil {
+				return Config{}, err
+			}
+		}
+		for _, s := range sections {
+			if s != "pre-data" && s != "data" && s != "post-data" {
+				return Config{}, fmt.Errorf("%w: -section must be pre-data, data or post-data, not '%s'", ErrUsage, s)
+			}
+		}
+		dataOnly := Config{Sections: sections, RestoreArgs: restoreArgs}.dataOnly()
+		if *disableTriggers && !dataOnly {
+			return Config{}, fmt.Errorf("%w: -disable-triggers only applies to data-only restores (-section data)", ErrUsage)
+		}
+		ifExistsMode, err := parseIfExists(*ifExists)
+		if err != nil {
+			return Config{}, err
+		}
+		if (*noBlobs || *blobsSeparate) && !runsLocally(runner) {
+			return Config{}, fmt.Errorf("%w: -no-blobs and -blobs-separate need local client tools, not -exec-via", ErrUsage)
+		}
+		if *noBlobs && *blobsSeparate {
+			return Config{}, fmt.Errorf("%w: -no-blobs cannot be combined with -blobs-separate", ErrUsage)
+		}
+		if dataOnly && ifExistsMode != IfExistsClean {
+			return Config{}, fmt.Errorf("%w: a data-only restore needs the existing schema; -if-exists %s would remove it", ErrUsage, ifExistsMode)
+		}
+		cfg := Config{
+			Database:  *dbName,
+			User:      *dbUser,
+			Host:      *dbHost,
+			Role:      *role,
+			LogFile:   *logFile,
+			Storage:   storage,
+			Notifiers: execNotifiers(notifyPlugins),
+			BinDir:    *binDir,
+			Runner:    runner,
+
+			FailOnWarnings:             *failOnWarnings,
+			RestoreTimeout:             *restoreTimeout,
+			ExitOnError:                *exitOnError,
+			MaxErrors:                  *maxErrors,
+			RestoreProgress:            *progress,
+			SkipExtensionCheck:         *skipExtCheck,
+			RestoreArgs:                restoreArgs,
+			Sections:                   sections,
+			DisableTriggers:            *disableTriggers,
+			Superuser:                  *superuser,
+			ValidateForeignKeys:        *validateFKs,
+			AnalyzeAfter:               *analyzeAfter,
+			IfExists:                   ifExistsMode,
+			NoBlobs:                    *noBlobs,
+			BlobsSeparate:              *blobsSeparate,
+			BlobReport:                 *blobReport,
+			WithGlobals:                *withGlobals,
+			Masking:                    masking,
+			RoleMap:                    roleMap,
+			TablespaceMap:              tablespaceMap,
+			PostRestoreScripts:         postScripts,
+			PostRestoreContinueOnError: *postContinue,
+		}
+		severity := *pdSeverity
+		if *configFile != "" {
+			cf, err := LoadConfigFile(*configFile)
+			if err != nil {
+				return Config{}, err
+			}
+			cf.givenFlags(fs)
+			if err := cf.apply(&cfg); err != nil {
+				return Config{}, err
+			}
+			severity = cf.pagerDutySeverity(cfg.Database, severity)
+		}
+		cfg.Notifiers, err = pagerDutyNotifiers(cfg.Notifiers, *pdKey, severity)
+		cfg.Notifiers = auditNotifiers(cfg.Notifiers, *auditLog)
+		return cfg, err
+	}
+}
+
+// loadFleet builds the fleet of the -config file given to fs, with base
+// holding the other flags.
+func loadFleet(fs *flag.FlagSet, base Config) ([]FleetServer, error) {
+	path := fs.Lookup("config").Value.String()
+	if path == "" {
+		return nil, fmt.Errorf("%w: -fleet needs -config", ErrUsage)
+	}
+	cf, err := LoadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cf.givenFlags(fs)
+	return cf.fleet(base)
+}
+
+// loadAll builds the server backed up by backup -all from base, its
+// databases to be discovered with rules and the "discovery" rules of the
+// -config file given to fs.
+func loadAll(fs *flag.FlagSet, base Config, rules DiscoveryRules) (FleetServer, error) {
+	if base.Database != "" {
+		return FleetServer{}, fmt.Errorf("%w: -all cannot be combined with -db", ErrUsage)
+	}
+	s := FleetServer{Name: base.Host, MaxParallel: 1, Server: base}
+	if path := fs.Lookup("config").Value.String(); path != "" {
+		cf, err := LoadConfigFile(path)
+		if err != nil {
+			return FleetServer{}, err
+		}
+		cf.givenFlags(fs)
+		rules = cf.Discovery.merge(rules)
+		s.apply = cf.apply
+	}
+	s.Discovery = rules
+	return s, rules.validate()
+}
+
+// printFleetResults prints one line per database of a fleet run.
+func printFleetResults(results []FleetResult) {
+	for _, r := range results {
+		fmt.Printf("%s/%s: %s (%s)\n", r.Server, r.Database, r.status(), r.Duration.Round(time.Second))
+	}
+}
+
+// runDaemon runs jobs on their schedules until ctx is cancelled, reporting
+// scheduler activity on stdout. Each run still logs to its log file.
+func runDaemon(ctx context.Context, jobs ...Job) {
+	s := &Scheduler{
+		Jobs: jobs,
+		OnRun: func(job string) {
+			log.Printf("Starting scheduled run of '%s'.", job)
+		},
+		OnSkip: func(job, reason string) {
+			log.Printf("Skipped run of '%s': %s.", job, reason)
+		},
+		OnDone: func(job string, err error) {
+			if err != nil {
+				log.Printf("Scheduled run of '%s' failed: %v", job, err)
+			}
+		},
+	}
+	for _, j := range jobs {
+		log.Printf("Scheduled '%s' at '%s', next run %s.", j.Name, j.Schedule, j.Schedule.Next(time.Now()).Format(time.RFC3339))
+	}
+	s.Run(ctx)
+	log.Println("Daemon stopped.")
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// mapFlag is a repeatable OLD=NEW flag.
+type mapFlag map[string]string
+
+func (m mapFlag) String() string {
+	var pairs []string
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m mapFlag) Set(v string) error {
+	from, to, ok := strings.Cut(v, "=")
+	if !ok || from == "" || to == "" {
+		return fmt.Errorf("expected OLD=NEW, got %q", v)
+	}
+	m[from] = to
+	return nil
+}
+
+func execStorages(paths []string) []StorageBackend {
+	var backends []StorageBackend
+	for _, p := range paths {
+		backends = append(backends, ExecStorage{Path: p})
+	}
+	return backends
+}
+
+// pagerDutyNotifiers adds a PagerDutyNotifier to notifiers if key is set.
+func pagerDutyNotifiers(notifiers []Notifier, key, severity string) ([]Notifier, error) {
+	if key == "" {
+		return notifiers, nil
+	}
+	severity, err := parsePagerDutySeverity(severity)
+	if err != nil {
+		return nil, err
+	}
+	return append(notifiers, PagerDutyNotifier{RoutingKey: key, Severity: severity}), nil
+}
+
+// auditNotifiers adds an AuditNotifier to notifiers if path is set.
+func auditNotifiers(notifiers []Notifier, path string) []Notifier {
+	if path == "" {
+		return notifiers
+	}
+	return append(notifiers, AuditNotifier{Path: path, Args: os.Args[1:]})
+}
+
+func execNotifiers(paths []string) []Notifier {
+	var notifiers []Notifier
+	for _, p := range paths {
+		notifiers = append(notifiers, ExecNotifier{Path: p})
+	}
+	return notifiers
+}
+
+// runPhase runs fn with ctx limited to timeout (0 = no limit). If the
+// phase's own timeout expires, the error says which phase it was.
+func runPhase(ctx context.Context, name string, timeout time.Duration, fn func(context.Context) error) error {
+	pctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+	err := fn(pctx)
+	if err != nil && ctx.Err() == nil && errors.Is(pctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out after %s: %w (%v)", name, timeout, context.DeadlineExceeded, err)
+	}
+	return err
+}
+
+// signalContext returns a context cancelled by the first SIGINT or
+// SIGTERM. A second signal kills the process as usual.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// withTimeout returns a context that is cancelled after timeout, or never
+// if timeout is zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// openLog opens the append-only operational log.
+func openLog(logFile string) (*os.File, *log.Logger, error) {
+	logF, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot open log file '%s': %w", logFile, err)
+	}
+	return logF, log.New(logF, "", log.LstdFlags), nil
+}
+
+// partialSuffix marks files that are still being written. Anything with a
+// final backup name is complete; partial files left behind by a crash are
+// removed by cleanupStale.
+const partialSuffix = ".partial"
+
+func runBackup(ctx context.Context, cfg Config) error {
+	dbName, backupDir := cfg.Database, cfg.BackupDir
+	set := cfg.backupSet()
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	// Ensure backup directory exists
+	if _, err := os.Stat(backupDir); os.IsNotExist(err) {
+		return fmt.Errorf("%w: backup directory '%s' not found", ErrUsage, backupDir)
+	}
+
+	// Open log file
+	logF, logger, err := openLog(cfg.LogFile)
+	if err != nil {
+		return err
+	}
+	defer logF.Close()
+
+	if !cfg.IgnoreBlackouts {
+		err = cfg.checkBlackout()
+	}
+	if err == nil {
+		err = cfg.checkWindow(ctx, logger)
+	}
+	if err != nil {
+		if errors.Is(err, ErrSkipped) {
+			logger.Printf("INFO: Backup of %s %v", set, err)
+			notifySkipped(cfg, err, logger)
+		}
+		return err
+	}
+
+	// Reclaim space left behind by crashed runs
+	cleanupStale(ctx, backupDir, defaultStaleAge, logger)
+
+	// Create backup filename
+	name := BackupName{Database: set, Time: time.Now(), Kind: KindDump, Format: "custom"}
+	backupFile := filepath.Join(backupDir, name.String())
+
+	stats := newRunStats(os.Stdout, logger)
+	events := MultiEventHandler{cfg.runEvents(logger), stats}
+	ev := Event{Op: "backup", Database: set, File: backupFile, Time: time.Now()}
+	events.OnStart(ev)
+	fail := func(msg string, err error) error {
+		err = contextErr(ctx, err)
+		logger.Printf("ERROR: %s: %v", msg, err)
+		ev.Err, ev.Time = err, time.Now()
+		events.OnError(ev)
+		return err
+	}
+	phase := func(name string) {
+		ev.Phase, ev.Time = name, time.Now()
+		events.OnPhaseChange(ev)
+	}
+
+	// Fail fast if the server is unreachable or the disk too small
+	phase(PhaseConnect)
+	dbSize, err := preflight(ctx, cfg, logger)
+	if err != nil {
+		return fail("Pre-flight check failed", err)
+	}
+	if cfg.fullBackup() {
+		stats.Rows = liveRows(ctx, cfg)
+	}
+
+	// Run the pre-backup hooks. The post-backup hooks run once the dump is
+	// over, even if it failed or was aborted.
+	if err := runHooks(ctx, cfg, logger, "pre-backup", cfg.PreBackupSQL, logF); err != nil {
+		return fail("Pre-backup hook failed", err)
+	}
+	postHooks := sync.OnceValue(func() error {
+		return runHooks(context.WithoutCancel(ctx), cfg, logger, "post-backup", cfg.PostBackupSQL, logF)
+	})
+	defer postHooks()
+
+	// Dump only the partitions written to since the last backup
+	var partStats map[string]int64
+	if cfg.ChangedPartitions != "" {
+		partStats, err = partitionStats(ctx, cfg, cfg.ChangedPartitions)
+		if err != nil {
+			return fail("Cannot read partition statistics", err)
+		}
+		changed := changedPartitions(partStats, previousPartitionStats(backupDir, set))
+		if len(changed) == 0 {
+			logger.Printf("INFO: No partitions of '%s' changed since the last backup.", cfg.ChangedPartitions)
+			fmt.Println("No partitions changed since the last backup.")
+			ev.File, ev.Time = "", time.Now()
+			events.OnComplete(ev)
+			return nil
+		}
+		logger.Printf("INFO: %d of %d partitions of '%s' changed since the last backup.", len(changed), len(partStats), cfg.ChangedPartitions)
+		cfg.Tables = append(cfg.Tables, changed...)
+	}
+
+	// Refuse to dump with client tools older than the server
+	versions, err := checkDumpVersion(ctx, cfg, logger)
+	if err != nil {
+		return fail("Version check failed", err)
+	}
+
+	// Record the installed extensions so restore can check for them
+	extensions, err := listExtensions(ctx, cfg)
+	if err != nil {
+		logger.Printf("WARNING: Cannot list extensions: %v", err)
+	} else if len(extensions) > 0 {
+		logger.Printf("INFO: Installed extensions: %s.", joinExtensions(extensions))
+	}
+
+	// Abort if the disk fills up while dumping
+	ctx, stopWatch := watchFreeSpace(ctx, backupDir, cfg.MinFreeSpace, logger)
+	defer stopWatch()
+
+	// Warn if large objects would be left out of the backup
+	if cfg.NoBlobs {
+		if n, err := countLargeObjects(ctx, cfg); err != nil {
+			logger.Printf("WARNING: Cannot count large objects: %v", err)
+		} else if n > 0 {
+			logger.Printf("WARNING: Database '%s' contains %d large objects that will not be backed up (-no-blobs).", dbName, n)
+			fmt.Printf("Warning: %d large objects will not be backed up.\n", n)
+		}
+	}
+
+	// Run pg_dump
+	logger.Printf("INFO: Starting backup for database '%s'.", dbName)
+	fmt.Printf("Starting backup for database '%s'...\n", dbName)
+	phase(PhaseDump)
+	if err := cfg.injected(PhaseDump); err != nil {
+		return fail("Backup failed", err)
+	}
+
+	// Count pg_dump's warnings and errors on their way to the log
+	toolLog := newDiagCounter(logF)
+
+	// Let every pass of a multi-pass backup see the same point in time
+	releaseSnapshot := func() error { return nil }
+	if cfg.Snapshot == "" && ((cfg.BlobsSeparate && !cfg.NoBlobs) || cfg.Subset != nil) {
+		id, release, err := exportSnapshot(ctx, cfg, toolLog)
+		if err != nil {
+			logger.Printf("WARNING: Cannot export snapshot, passes will not be consistent: %v", err)
+		} else {
+			logger.Printf("INFO: Dumping from snapshot %s.", id)
+			cfg.Snapshot, releaseSnapshot = id, release
+		}
+	}
+	defer releaseSnapshot()
+
+	// Every file is written under a partial name until it is compressed;
+	// on failure, removePartials removes those started so far.
+	partials := []string{backupFile + partialSuffix}
+	removePartials := func() {
+		for _, p := range partials {
+			os.Remove(p)
+		}
+	}
+
+	// Dump large objects alongside the main dump so they don't serialize it
+	var blobsFile string
+	var blobsErr error
+	var wg sync.WaitGroup
+	if cfg.BlobsSeparate && !cfg.NoBlobs {
+		blobsName := name
+		blobsName.Kind = KindBlobs
+		blobsFile = filepath.Join(backupDir, blobsName.String())
+		partials = append(partials, blobsFile+partialSuffix)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			blobsErr = runPhase(ctx, "Large object dump", cfg.DumpTimeout, func(ctx context.Context) error {
+				return cfg.Retry.do(ctx, logRetries(logger, "Large object dump"), func() error {
+					return runDump(ctx, cfg.runner(), cfg.dumpCommand(cfg.blobsArgs()), blobsFile+partialSuffix, toolLog, nil)
+				})
+			})
+		}()
+	}
+	err = runPhase(ctx, "pg_dump", cfg.DumpTimeout, func(ctx context.Context) error {
+		return cfg.Retry.do(ctx, logRetries(logger, "pg_dump"), func() error {
+			return runDump(ctx, cfg.runner(), cfg.dumpCommand(cfg.dumpArgs()), backupFile+partialSuffix, toolLog, func(w io.Writer) io.Writer {
+				return newProgressWriter(w, events, ev)
+			})
+		})
+	})
+	if err != nil {
+		wg.Wait()
+		removePartials()
+		return fail("Backup failed", err)
+	}
+	wg.Wait()
+	if blobsErr != nil {
+		removePartials()
+		return fail("Large object backup failed", blobsErr)
+	}
+	if err := checkWarnings(cfg, logger, "pg_dump", toolLog); err != nil {
+		removePartials()
+		return fail("Backup failed", err)
+	}
+
+	// Dump the kept rows of subset tables
+	var subsetFile string
+	if cfg.Subset != nil {
+		subsetName := name
+		subsetName.Kind, subsetName.Format = KindSubset, "sql"
+		subsetFile = filepath.Join(backupDir, subsetName.String())
+		partials = append(partials, subsetFile+partialSuffix)
+		logger.Printf("INFO: Dumping subset of %d table(s).", len(cfg.Subset.Tables))
+		if err := dumpSubset(ctx, cfg, subsetFile+partialSuffix, logF); err != nil {
+			removePartials()
+			return fail("Subset backup failed", err)
+		}
+	}
+	releaseSnapshot()
+
+	// Dump roles and tablespaces
+	var globalsFile string
+	if cfg.Globals {
+		globalsName := name
+		globalsName.Kind, globalsName.Format = KindGlobals, "sql"
+		globalsFile = filepath.Join(backupDir, globalsName.String())
+		partials = append(partials, globalsFile+partialSuffix)
+		logger.Printf("INFO: Dumping roles and tablespaces.")
+		if err := dumpGlobals(ctx, cfg, globalsFile+partialSuffix, toolLog); err != nil {
+			removePartials()
+			return fail("Globals backup failed", err)
+		}
+	}
+	if err := postHooks(); err != nil {
+		removePartials()
+		return fail("Post-backup hook failed", err)
+	}
+
+	// Compress backup. Files only get their final name once complete.
+	phase(PhaseCompress)
+	stats.DataBytes = fileSizes(partials...)
+	stats.PeakTempBytes = stats.DataBytes
+	checksums := make(map[string]string)
+	compressedFile := backupFile + ".gz"
+	sum, err := compressFile(ctx, backupFile+partialSuffix, compressedFile, cfg.CompressionLevel)
+	if err != nil {
+		removePartials()
+		return fail("Compression failed", err)
+	}
+	checksums[filepath.Base(compressedFile)] = sum
+	os.Remove(backupFile + partialSuffix)
+
+	logger.Printf("SUCCESS: Backup completed. File: %s", compressedFile)
+	fmt.Println("Backup successful:", compressedFile)
+
+	if blobsFile != "" {
+		sum, err := compressFile(ctx, blobsFile+partialSuffix, blobsFile+".gz", cfg.CompressionLevel)
+		os.Remove(blobsFile + partialSuffix)
+		if err != nil {
+			removePartials()
+			return fail("Compression failed", err)
+		}
+		checksums[filepath.Base(blobsFile)+".gz"] = sum
+		logger.Printf("SUCCESS: Large object backup completed. File: %s", blobsFile+".gz")
+		fmt.Println("Large objects:", blobsFile+".gz")
+	}
+
+	if subsetFile != "" {
+		sum, err := compressFile(ctx, subsetFile+partialSuffix, subsetFile+".gz", cfg.CompressionLevel)
+		os.Remove(subsetFile + partialSuffix)
+		if err != nil {
+			removePartials()
+			return fail("Compression failed", err)
+		}
+		checksums[filepath.Base(subsetFile)+".gz"] = sum
+		fmt.Println("Subset data:", subsetFile+".gz")
+	}
+
+	if globalsFile != "" {
+		sum, err := compressFile(ctx, globalsFile+partialSuffix, globalsFile+".gz", cfg.CompressionLevel)
+		os.Remove(globalsFile + partialSuffix)
+		if err != nil {
+			removePartials()
+			return fail("Compression failed", err)
+		}
+		checksums[filepath.Base(globalsFile)+".gz"] = sum
+		fmt.Println("Globals:", globalsFile+".gz")
+	}
+
+	for f := range checksums {
+		stats.StoredBytes += fileSizes(filepath.Join(backupDir, f))
+	}
+
+	// Make sure the files can be read back as written
+	if cfg.SyncWrites || cfg.DirectVerify || cfg.InjectFailure == PhaseVerify {
+		phase(PhaseVerify)
+		if err := cfg.injected(PhaseVerify); err != nil {
+			return fail("Write verification failed", err)
+		}
+		if err := verifyWrites(ctx, backupDir, checksums, cfg.DirectVerify, logger); err != nil {
+			return fail("Write verification failed", err)
+		}
+	}
+
+	// Compare the run so far with the expected duration
+	took := time.Since(name.Time)
+	slow, err := cfg.slowWarning(took)
+	if err != nil {
+		logger.Printf("WARNING: Cannot check the backup duration: %v", err)
+	} else if slow != "" {
+		logger.Printf("WARNING: Backup of %s was slow: %s.", set, slow)
+		fmt.Println("Warning: backup was slow:", slow)
+	}
+
+	// Write manifest
+	manifestName := name
+	manifestName.Kind = KindManifest
+	manifestFile := filepath.Join(backupDir, manifestName.String())
+	m := Manifest{
+		Version:        ManifestVersion,
+		Database:       dbName,
+		Host:           cfg.Host,
+		Created:        name.Time,
+		Format:         name.Format,
+		Compression:    "gzip",
+		File:           filepath.Base(compressedFile),
+		Versions:       versions,
+		Extensions:     extensions,
+		PartitionStats: partStats,
+		Checksums:      checksums,
+		Tags:           cfg.Tags,
+		Labels:         cfg.Labels,
+		SetID:          cfg.SetID,
+		Args:           redactArgs(os.Args[1:]),
+		Duration:       took.Round(time.Second).String(),
+		Slow:           slow != "",
+		DatabaseSize:   dbSize,
+	}
+	if fi, err := os.Stat(compressedFile); err == nil {
+		m.Size = fi.Size()
+	}
+	if blobsFile != "" {
+		m.BlobsFile = filepath.Base(blobsFile) + ".gz"
+	}
+	if subsetFile != "" {
+		m.SubsetFile = filepath.Base(subsetFile) + ".gz"
+	}
+	if globalsFile != "" {
+		m.GlobalsFile = filepath.Base(globalsFile) + ".gz"
+	}
+	if err := writeManifest(manifestFile, m); err != nil {
+		return fail("Cannot write manifest", err)
+	}
+	if cfg.SyncWrites || cfg.DirectVerify {
+		if err := syncDir(backupDir); err != nil {
+			return fail("Cannot write manifest", err)
+		}
+	}
+
+	// Upload to remote storage
+	if len(cfg.Storage) > 0 || cfg.InjectFailure == PhaseUpload {
+		phase(PhaseUpload)
+		if err := cfg.injected(PhaseUpload); err != nil {
+			return fail("Upload failed", err)
+		}
+		fmt.Println("Uploading backup...")
+		uploads := []string{compressedFile, manifestFile}
+		if blobsFile != "" {
+			uploads = append(uploads, blobsFile+".gz")
+		}
+		if subsetFile != "" {
+			uploads = append(uploads, subsetFile+".gz")
+		}
+		if globalsFile != "" {
+			uploads = append(uploads, globalsFile+".gz")
+		}
+		for _, f := range uploads {
+			err := runPhase(ctx, "Upload", cfg.UploadTimeout, func(ctx context.Context) error {
+				return uploadAll(ctx, cfg.Storage, f, cfg.Retry, logger)
+			})
+			if err != nil {
+				return fail("Upload failed", err)
+			}
+			stats.TransferBytes += fileSizes(f) * int64(len(cfg.Storage))
+		}
+		logger.Printf("SUCCESS: Uploaded %s to %d storage backend(s).", filepath.Base(compressedFile), len(cfg.Storage))
+	}
+
+	// Point the stable latest pointers at this backup
+	if cfg.fullBackup() {
+		if err := updateLatest(ctx, cfg, compressedFile, logger); err != nil {
+			return fail("Cannot update latest pointer", err)
+		}
+	}
+
+	// Cleanup old backups
+	phase(PhaseCleanup)
+	cleanupOldBackups(ctx, backupDir, cfg.RetentionDays, logger)
+	if cfg.RemoteRetentionDays > 0 {
+		cleanupRemoteBackups(ctx, cfg.Storage, set, cfg.RemoteRetentionDays, logger)
+	}
+
+	ev.File, ev.Time, ev.Warning = compressedFile, time.Now(), slow
+	events.OnComplete(ev)
+	return nil
+}
+
+func runRestore(ctx context.Context, cfg Config, backupFile string) error {
+	dbName := cfg.target()
+	if dbName == "" || backupFile == "" {
+		return fmt.Errorf("%w: database name and backup file are required", ErrUsage)
+	}
+	if strings.HasSuffix(backupFile, partialSuffix) {
+		return fmt.Errorf("%w: '%s' is an incomplete backup", ErrUsage, backupFile)
+	}
+	if cfg.Masking != nil && !cfg.Masking.allows(maskTarget(cfg)) {
+		return fmt.Errorf("%w: '%s' is not one of the -mask-rules targets", ErrUsage, maskTarget(cfg))
+	}
+
+	// Open log file
+	logF, logger, err := openLog(cfg.LogFile)
+	if err != nil {
+		return err
+	}
+	defer logF.Close()
+
+	logger.Printf("INFO: Starting restore for database '%s' from '%s'.", dbName, backupFile)
+	fmt.Printf("Restoring database '%s' from '%s'...\n", dbName, backupFile)
+
+	stats := newRunStats(os.Stdout, logger)
+	events := MultiEventHandler{cfg.runEvents(logger), stats}
+	ev := Event{Op: "restore", Database: dbName, File: backupFile, Time: time.Now()}
+	events.OnStart(ev)
+	fail := func(msg string, err error) error {
+		err = contextErr(ctx, err)
+		logger.Printf("ERROR: %s: %v", msg, err)
+		ev.Err, ev.Time = err, time.Now()
+		events.OnError(ev)
+		return err
+	}
+	phase := func(name string) {
+		ev.Phase, ev.Time = name, time.Now()
+		events.OnPhaseChange(ev)
+	}
+
+	// Fail fast if the target lacks extensions the backup needs. The
+	// available extensions are the same in every database of a server, so
+	// a target that may not exist yet is checked through postgres.
+	if !cfg.SkipExtensionCheck {
+		exts, err := backupExtensions(backupFile)
+		if err != nil {
+			logger.Printf("WARNING: Cannot read manifest: %v", err)
+		}
+		checkCfg := cfg
+		if !cfg.cleansTarget() {
+			checkCfg = cfg.maintenance()
+		}
+		if err := checkExtensions(ctx, checkCfg, logger, exts); err != nil {
+			return fail("Extension check failed", err)
+		}
+	}
+
+	// Fetch the backup from remote storage if it isn't available locally
+	if _, err := os.Stat(backupFile); os.IsNotExist(err) && len(cfg.Storage) > 0 {
+		phase(PhaseDownload)
+		dir, err := os.MkdirTemp("", "pgtool-restore-")
+		if err != nil {
+			return fail("Download failed", ioError(err))
+		}
+		defer os.RemoveAll(dir)
+		local := filepath.Join(dir, filepath.Base(backupFile))
+		b := cfg.Storage[0]
+		if err := b.Download(ctx, filepath.Base(backupFile), local); err != nil {
+			return fail("Download failed", &StorageError{Backend: b.Name(), Op: "download", Err: err})
+		}
+		logger.Printf("INFO: Downloaded '%s' from %s.", filepath.Base(backupFile), b.Name())
+		backupFile = local
+		stats.TransferBytes = fileSizes(local)
+		stats.PeakTempBytes = stats.TransferBytes
+	}
+
+	// Decompress to temp file
+	phase(PhaseDecompress)
+	tempFile, err := decompressTemp(ctx, backupFile)
+	if err != nil {
+		return fail("Decompression failed", err)
+	}
+	defer os.Remove(tempFile)
+	stats.StoredBytes, stats.DataBytes = fileSizes(backupFile), fileSizes(tempFile)
+	stats.PeakTempBytes += stats.DataBytes
+
+	// Run pg_restore, counting its warnings and errors on their way to the log
+	phase(PhaseRestore)
+	if err := prepareTarget(ctx, cfg, logger); err != nil {
+		return fail("Cannot prepare target database", err)
+	}
+	toolLog := newDiagCounter(logF)
+
+	// Create roles and tablespaces first so ownership and grants apply
+	if cfg.WithGlobals {
+		globalsFile := strings.TrimSuffix(backupFile, ".dump.gz") + ".globals.sql.gz"
+		if _, err := os.Stat(globalsFile); err != nil || globalsFile == backupFile {
+			return fail("Globals restore failed", fmt.Errorf("%w: no globals file '%s' next to the backup", ErrUsage, globalsFile))
+		}
+		logger.Printf("INFO: Restoring roles and tablespaces from '%s'.", globalsFile)
+		fmt.Println("Restoring roles and tablespaces...")
+		if err := restoreGlobals(ctx, cfg, globalsFile, toolLog); err != nil {
+			return fail("Globals restore failed", err)
+		}
+	}
+
+	// Disabling triggers needs a superuser; name one only if the
+	// restore does not already connect as one
+	if cfg.DisableTriggers {
+		super, err := isSuperuser(ctx, cfg)
+		if err != nil {
+			return fail("Cannot check for superuser", err)
+		}
+		if super {
+			cfg.Superuser = ""
+		} else {
+			logger.Printf("INFO: Disabling triggers as superuser '%s'.", cfg.Superuser)
+		}
+	}
+
+	// Split the large objects off the rest of the archive, to skip them or
+	// to restore them alongside it
+	blobCfg := cfg
+	var blobList string
+	if cfg.NoBlobs || cfg.BlobsSeparate || cfg.BlobReport != "" {
+		entries, err := listTOC(ctx, cfg, tempFile)
+		if err != nil {
+			return fail("Cannot list archive", err)
+		}
+		if cfg.BlobReport != "" {
+			if err := writeBlobReport(ctx, cfg, entries, cfg.BlobReport, logger); err != nil {
+				return fail("Large object report failed", err)
+			}
+		}
+		if cfg.NoBlobs || cfg.BlobsSeparate {
+			dir, err := os.MkdirTemp("", "pgtool-restore-")
+			if err != nil {
+				return fail("Restore failed", ioError(err))
+			}
+			defer os.RemoveAll(dir)
+			rest, blobs, err := splitBlobTOC(entries, dir)
+			if err != nil {
+				return fail("Restore failed", err)
+			}
+			cfg.RestoreArgs = append(cfg.RestoreArgs[:len(cfg.RestoreArgs):len(cfg.RestoreArgs)], "--use-list="+rest)
+			if cfg.NoBlobs {
+				logger.Printf("INFO: Skipping large objects.")
+			} else {
+				blobList = blobs
+			}
+		}
+	}
+	blobsFile := strings.TrimSuffix(backupFile, ".dump.gz") + ".blobs.dump.gz"
+	if _, err := os.Stat(blobsFile); err != nil || blobsFile == backupFile || !cfg.restoresData() || cfg.NoBlobs {
+		blobsFile = ""
+	}
+
+	var restoreLog io.Writer = toolLog
+	if cfg.RestoreProgress {
+		total, err := countTOC(ctx, cfg, tempFile)
+		if err != nil {
+			return fail("Cannot list archive", err)
+		}
+		logger.Printf("INFO: Archive has %d objects to restore.", total)
+		restoreLog = newRestoreProgress(toolLog, total, logger, events, ev)
+	}
+
+	arg, stdin, err := fileInput(cfg.runner(), tempFile)
+	if err != nil {
+		return fail("Restore failed", err)
+	}
+	if stdin != nil {
+		defer stdin.Close()
+	}
+
+	// With BlobsSeparate, large objects are restored by a second
+	// pg_restore while the first restores everything else
+	blobCtx, cancelBlobs := context.WithCancel(ctx)
+	defer cancelBlobs()
+	blobLog := newDiagCounter(logF)
+	blobDone := make(chan error, 1)
+	if cfg.BlobsSeparate && (blobList != "" || blobsFile != "") {
+		logger.Printf("INFO: Restoring large objects in a separate pass.")
+		go func() {
+			blobDone <- runPhase(blobCtx, "Large object restore", cfg.RestoreTimeout, func(ctx context.Context) error {
+				if blobList != "" {
+					if err := restoreBlobList(ctx, blobCfg, arg, nil, blobList, blobLog); err != nil {
+						return err
+					}
+				}
+				if blobsFile != "" {
+					return restoreBlobs(ctx, cfg, blobsFile, blobLog)
+				}
+				return nil
+			})
+		}()
+		blobsFile = ""
+	} else {
+		blobDone <- nil
+	}
+
+	err = runPhase(ctx, "pg_restore", cfg.RestoreTimeout, func(ctx context.Context) error {
+		ctx, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
+		if cfg.MaxErrors > 0 {
+			toolLog.onError = func(n int) {
+				if n > cfg.MaxErrors {
+					cancel(fmt.Errorf("stopped after more than %d errors (-max-errors)", cfg.MaxErrors))
+				}
+			}
+		}
+		err := restoreMain(ctx, cfg, logger, arg, stdin, restoreLog)
+		if cause := context.Cause(ctx); err != nil && cause != nil && cause != ctx.Err() {
+			return fmt.Errorf("%v: %w", cause, err)
+		}
+		return err
+	})
+	reportRestoreErrors(logger, toolLog)
+	if err != nil {
+		cancelBlobs()
+		<-blobDone
+		return fail("Restore failed", err)
+	}
+	err = <-blobDone
+	reportRestoreErrors(logger, blobLog)
+	if err != nil {
+		return fail("Large object restore failed", err)
+	}
+
+	// Restore large objects dumped in a separate pass, if any
+	if blobsFile != "" {
+		logger.Printf("INFO: Restoring large objects from '%s'.", blobsFile)
+		fmt.Println("Restoring large objects...")
+		err := runPhase(ctx, "Large object restore", cfg.RestoreTimeout, func(ctx context.Context) error {
+			return restoreBlobs(ctx, cfg, blobsFile, toolLog)
+		})
+		if err != nil {
+			return fail("Large object restore failed", err)
+		}
+	}
+	// Load the rows of subset tables, if this is a subset backup
+	subsetFile := strings.TrimSuffix(backupFile, ".dump.gz") + ".subset.sql.gz"
+	if _, err := os.Stat(subsetFile); err == nil && subsetFile != backupFile && cfg.restoresData() {
+		logger.Printf("INFO: Loading subset data from '%s'.", subsetFile)
+		fmt.Println("Loading subset data...")
+		if err := restoreSubset(ctx, cfg, subsetFile, logF); err != nil {
+			return fail("Subset restore failed", err)
+		}
+	}
+
+	if err := checkWarnings(cfg, logger, "pg_restore", toolLog); err != nil {
+		return fail("Restore failed", err)
+	}
+
+	// Check the rows loaded with triggers off against the foreign keys
+	if cfg.ValidateForeignKeys {
+		if err := validateForeignKeys(ctx, cfg, logger); err != nil {
+			return fail("Foreign key validation failed", err)
+		}
+	}
+
+	// Run fixup scripts
+	if len(cfg.PostRestoreScripts) > 0 {
+		phase(PhasePostRestore)
+		if err := runPostRestoreScripts(ctx, cfg, logger, logF); err != nil {
+			return fail("Post-restore scripts failed", err)
+		}
+	}
+
+	if len(cfg.Checks) > 0 {
+		phase(PhaseVerify)
+		if err := runValidationChecks(ctx, cfg, logger); err != nil {
+			return fail("Validation checks failed", err)
+		}
+	}
+
+	// Fresh tables have no statistics; plan queries on them well from
+	// the start
+	if cfg.AnalyzeAfter && cfg.restoresData() {
+		phase(PhaseAnalyze)
+		if err := analyzeDatabase(ctx, cfg, logger); err != nil {
+			return fail("Analyze failed", err)
+		}
+	}
+
+	logger.Printf("SUCCESS: Restore completed for database '%s'.", dbName)
+	fmt.Println("Restore completed successfully.")
+	if cfg.restoresData() {
+		stats.Rows = liveRows(ctx, cfg)
+	}
+	ev.Time = time.Now()
+	events.OnComplete(ev)
+	return nil
+}
+
+// checkWarnings logs the warnings tool printed to stderr and, with
+// FailOnWarnings, turns them into an error.
+func checkWarnings(cfg Config, logger *log.Logger, tool string, stderr *diagCounter) error {
+	n, _ := stderr.counts()
+	if n == 0 {
+		return nil
+	}
+	logger.Printf("WARNING: %s reported %d warning(s).", tool, n)
+	if cfg.FailOnWarnings {
+		return fmt.Errorf("%w: %s reported %d warning(s)", ErrWarnings, tool, n)
+	}
+	return nil
+}
+
+// reportRestoreErrors logs and prints a summary of the errors pg_restore
+// reported, grouped by kind.
+func reportRestoreErrors(logger *log.Logger, stderr *diagCounter) {
+	_, n := stderr.counts()
+	if n == 0 {
+		return
+	}
+	logger.Printf("ERROR: pg_restore reported %d error(s).", n)
+	fmt.Printf("pg_restore reported %d error(s):\n", n)
+	for _, line := range stderr.summary() {
+		logger.Printf("ERROR:   %s", line)
+		fmt.Println("  " + line)
+	}
+}
+
+// restoreMain runs pg_restore for the main dump, rewriting its SQL on the
+// way to psql when masking or remapping is configured.
+func restoreMain(ctx context.Context, cfg Config, logger *log.Logger, arg string, stdin io.Reader, stderr io.Writer) error {
+	if !cfg.needsRewrite() {
+		c := Command{Name: "pg_restore", Args: cfg.restoreArgs(arg), Stdin: stdin, Stdout: os.Stdout}
+		return runCommand(ctx, cfg.runner(), c, stderr)
+	}
+	if cfg.Masking != nil {
+		logger.Printf("INFO: Masking %d column(s) during restore.", len(cfg.Masking.Rules))
+	}
+	for from, to := range cfg.RoleMap {
+		logger.Printf("INFO: Remapping role '%s' to '%s'.", from, to)
+	}
+	for from, to := range cfg.TablespaceMap {
+		logger.Printf("INFO: Remapping tablespace '%s' to '%s'.", from, to)
+	}
+	return restoreRewritten(ctx, cfg, arg, stdin, stderr)
+}
+
+// runDump runs pg_dump with args, writing the dump to dst. If wrap is not
+// nil it is applied to the output file, e.g. to report progress.
+func runDump(ctx context.Context, r Runner, c Command, dst string, stderr io.Writer, wrap func(io.Writer) io.Writer) error {
+	outFile, err := os.Create(dst)
+	if err != nil {
+		return ioError(err)
+	}
+	defer outFile.Close()
+
+	c.Stdout = outFile
+	if wrap != nil {
+		c.Stdout = wrap(outFile)
+	}
+	if err := runCommand(ctx, r, c, stderr); err != nil {
+		return err
+	}
+	return ioError(outFile.Close())
+}
+
+// restoreBlobs restores a large-object-only dump into dbName.
+func restoreBlobs(ctx context.Context, cfg Config, blobsFile string, stderr io.Writer) error {
+	tempFile, err := decompressTemp(ctx, blobsFile)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile)
+
+	arg, stdin, err := fileInput(cfg.runner(), tempFile)
+	if err != nil {
+		return err
+	}
+	c := Command{Name: "pg_restore", Args: append(cfg.connArgs(), "--data-only"), Stdout: os.Stdout}
+	if cfg.Role != "" {
+		c.Args = append(c.Args, "--role="+cfg.Role)
+	}
+	if arg != "" {
+		c.Args = append(c.Args, arg)
+	}
+	if stdin != nil {
+		defer stdin.Close()
+		c.Stdin = stdin
+	}
+	return runCommand(ctx, cfg.runner(), c, stderr)
+}
+
+// queryScalar runs a single-value SQL query with psql and returns the result.
+func queryScalar(ctx context.Context, cfg Config, query string) (string, error) {
+	return psqlQuery(ctx, cfg.runner(), cfg.connArgs(), query)
+}
+
+// countLargeObjects returns the number of large objects in dbName.
+func countLargeObjects(ctx context.Context, cfg Config) (int, error) {
+	out, err := queryScalar(ctx, cfg, "SELECT count(*) FROM pg_catalog.pg_largeobject_metadata")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(out)
+}
+
+// contextErr reports the context's error in place of err when the context
+// was cancelled or timed out, since the killed process's error is less useful.
+// A cancellation cause, such as ErrDiskFull from watchFreeSpace, is reported
+// as is; plain cancellation, e.g. by Ctrl-C, is reported as ErrAborted.
+func contextErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if cause := context.Cause(ctx); cause != ctxErr {
+			return fmt.Errorf("%w (%v)", cause, err)
+		}
+		if errors.Is(ctxErr, context.Canceled) {
+			return fmt.Errorf("%w: %w (%v)", ErrAborted, ctxErr, err)
+		}
+		return fmt.Errorf("%w (%v)", ctxErr, err)
+	}
+	return err
+}
+
+// compressFile gzips src into dst and returns the SHA-256 of dst. The
+// output is written under a partial name, synced and then renamed, so dst
+// only ever exists complete.
+func compressFile(ctx context.Context, src, dst string, level int) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	tmp := dst + partialSuffix
+	out, err := os.Create(tmp)
+	if err != nil {
+		return "", ioError(err)
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+
+	h := sha256.New()
+	gw, err := gzip.NewWriterLevel(io.MultiWriter(out, h), level)
+	if err != nil {
+		return "", err
+	}
+	if _, err := copyContext(ctx, gw, in); err != nil {
+		return "", ioError(err)
+	}
+	if err := gw.Close(); err != nil {
+		return "", ioError(err)
+	}
+	if err := out.Sync(); err != nil {
+		return "", ioError(err)
+	}
+	if err := out.Close(); err != nil {
+		return "", ioError(err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func decompressFile(ctx context.Context, src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	gr, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return ioError(err)
+	}
+	defer out.Close()
+
+	if _, err := copyContext(ctx, out, gr); err != nil {
+		return ioError(err)
+	}
+	return ioError(out.Close())
+}
+
+// decompressTemp decompresses src to a new temporary file next to it and
+// returns its path. The name is unique so that concurrent restores of the
+// same backup don't collide.
+func decompressTemp(ctx context.Context, src string) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(src), strings.TrimSuffix(filepath.Base(src), ".gz")+".*"+partialSuffix)
+	if err != nil {
+		return "", ioError(err)
+	}
+	f.Close()
+	if err := decompressFile(ctx, src, f.Name()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// copyContext is io.Copy that stops early when ctx is done.
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	buf := make([]byte, 256*1024)
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			w, werr := dst.Write(buf[:n])
+			written += int64(w)
+			if werr != nil {
+				return written, werr
+			}
+			if w != n {
+				return written, io.ErrShortWrite
+			}
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+}
+
+// cleanupOldBackups deletes backup files older than retentionDays from
+// backupDir and returns how many it deleted.
+func cleanupOldBackups(ctx context.Context, backupDir string, retentionDays int, logger *log.Logger) int {
+	logger.Printf("INFO: Cleaning up backups older than %d days.", retentionDays)
+	fmt.Println("Cleaning up old backups...")
+
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	var deleted int
+	filepath.Walk(backupDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !info.IsDir() && (filepath.Ext(path) == ".gz" || strings.HasSuffix(path, ".manifest.json")) {
+			if info.ModTime().Before(cutoff) {
+				if rmErr := os.Remove(path); rmErr == nil {
+					logger.Printf("INFO: Deleted old backup: %s", path)
+					deleted++
+				} else {
+					logger.Printf("WARNING: Failed to delete %s: %v", path, rmErr)
+				}
+			}
+		}
+		return nil
+	})
+	logger.Println("SUCCESS: Cleanup complete.")
+	fmt.Println("Cleanup complete.")
+	return deleted
+}
@@ -0,0 +1,191 @@
+package pgtool
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakePG is a Runner standing in for the PostgreSQL client tools. psql
+// answers the queries pgtool makes with a healthy, empty server, pg_dump
+// writes a placeholder archive and pg_restore reads its input. A tool
+// named in fail exits with that text on stderr instead.
+type fakePG struct {
+	fail map[string]string
+
+	mu   sync.Mutex
+	cmds []Command
+}
+
+func (f *fakePG) Run(ctx context.Context, c Command) error {
+	f.mu.Lock()
+	f.cmds = append(f.cmds, c)
+	f.mu.Unlock()
+	if stderr, ok := f.fail[c.Name]; ok {
+		if stderr == "" {
+			return exec.ErrNotFound
+		}
+		io.WriteString(c.Stderr, stderr)
+		return errors.New("exit status 1")
+	}
+	if slices.Contains(c.Args, "--version") {
+		_, err := io.WriteString(c.Stdout, c.Name+" (PostgreSQL) 16.2\n")
+		return err
+	}
+	switch c.Name {
+	case "psql":
+		query := c.Args[len(c.Args)-1]
+		out := ""
+		switch {
+		case query == "SELECT 1":
+			out = "1"
+		case strings.Contains(query, "pg_database_size"):
+			out = "1048576"
+		case query == "SHOW server_version":
+			out = "16.2"
+		case strings.Contains(query, "count(*)"), strings.Contains(query, "n_live_tup"):
+			out = "0"
+		}
+		if c.Stdout != nil {
+			_, err := io.WriteString(c.Stdout, out+"\n")
+			return err
+		}
+	case "pg_dump":
+		_, err := io.WriteString(c.Stdout, "PGDMP fake archive\n")
+		return err
+	case "pg_restore":
+		if c.Stdin != nil {
+			_, err := io.Copy(io.Discard, c.Stdin)
+			return err
+		}
+	}
+	return nil
+}
+
+// commands returns the arguments of each command f ran named name.
+func (f *fakePG) commands(name string) [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out [][]string
+	for _, c := range f.cmds {
+		if c.Name == name {
+			out = append(out, c.Args)
+		}
+	}
+	return out
+}
+
+func fakeConfig(t *testing.T, pg *fakePG) Config {
+	dir := t.TempDir()
+	return Config{
+		Database: "app", User: "pgtool", Host: "db1",
+		BackupDir: dir, LogFile: filepath.Join(t.TempDir(), "pgtool.log"),
+		CompressionLevel: gzip.DefaultCompression, RetentionDays: 7,
+		Runner: pg,
+	}
+}
+
+func TestRunBackup(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    map[string]string
+		wantErr error
+	}{
+		{"ok", nil, nil},
+		{"auth failed", map[string]string{"psql": `psql: error: FATAL:  password authentication failed for user "pgtool"`}, ErrAuthFailed},
+		{"unreachable", map[string]string{"psql": "psql: error: connection to server at \"db1\" (10.0.0.1), port 5432 failed: Connection refused"}, ErrConnection},
+		{"no database", map[string]string{"pg_dump": `pg_dump: error: connection to server failed: FATAL:  database "app" does not exist`}, ErrDatabaseNotFound},
+		{"disk full", map[string]string{"pg_dump": "pg_dump: error: could not write to output file: No space left on device"}, ErrDiskFull},
+		{"no pg_dump", map[string]string{"pg_dump": ""}, ErrToolMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pg := &fakePG{fail: tt.fail}
+			cfg := fakeConfig(t, pg)
+			err := runBackup(context.Background(), cfg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("runBackup: %v, want %v", err, tt.wantErr)
+			}
+
+			files, _ := filepath.Glob(filepath.Join(cfg.BackupDir, "app_*"))
+			if tt.wantErr != nil {
+				if len(files) != 0 {
+					t.Errorf("files left behind: %q", files)
+				}
+				return
+			}
+			if dumps := pg.commands("pg_dump"); len(dumps) != 2 || !slices.Equal(dumps[1], cfg.dumpArgs()) {
+				t.Errorf("pg_dump runs %q, want --version and %q", dumps, cfg.dumpArgs())
+			}
+			if len(files) != 2 || !strings.HasSuffix(files[0], ".dump.gz") || !strings.HasSuffix(files[1], ".manifest.json") {
+				t.Fatalf("files %q, want a dump and its manifest", files)
+			}
+			m, err := ReadManifest(files[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Database != "app" || m.File != filepath.Base(files[0]) || m.DatabaseSize != 1048576 || m.Versions["server"] != "16.2" {
+				t.Errorf("manifest %+v", m)
+			}
+		})
+	}
+}
+
+func TestRunRestore(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    map[string]string
+		wantErr error
+	}{
+		{"ok", nil, nil},
+		{"no database", map[string]string{"pg_restore": `pg_restore: error: connection to server failed: FATAL:  database "app" does not exist`}, ErrDatabaseNotFound},
+		{"errors ignored", map[string]string{"pg_restore": "pg_restore: warning: errors ignored on restore: 3"}, ErrIgnoredErrors},
+		{"no pg_restore", map[string]string{"pg_restore": ""}, ErrToolMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pg := &fakePG{fail: tt.fail}
+			cfg := fakeConfig(t, pg)
+			cfg.SkipExtensionCheck = true
+			backupFile := filepath.Join(cfg.BackupDir, "app_2026-10-15_020000.dump.gz")
+			writeGzip(t, backupFile, "PGDMP fake archive\n")
+
+			err := runRestore(context.Background(), cfg, backupFile)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("runRestore: %v, want %v", err, tt.wantErr)
+			}
+			// The archive reaches pg_restore on stdin, since the fake
+			// runner does not see the local filesystem
+			if restores := pg.commands("pg_restore"); len(restores) != 1 || !slices.Equal(restores[0], cfg.restoreArgs("")) {
+				t.Errorf("pg_restore runs %q, want %q", restores, cfg.restoreArgs(""))
+			}
+			if files, _ := filepath.Glob(filepath.Join(cfg.BackupDir, "*"+partialSuffix)); len(files) != 0 {
+				t.Errorf("temporary files left behind: %q", files)
+			}
+		})
+	}
+}
+
+func writeGzip(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := gzip.NewWriter(f)
+	if _, err := io.WriteString(zw, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
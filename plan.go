@@ -0,0 +1,143 @@
+package pgtool
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// RetentionPolicy is a grandfather-father-son retention policy: how many
+// daily, weekly, monthly and yearly backups to keep.
+type RetentionPolicy struct {
+	Daily, Weekly, Monthly, Yearly int
+}
+
+// policyTier is a tier of a RetentionPolicy, and how many days apart the
+// backups it keeps are.
+type policyTier struct {
+	name string
+	days int
+}
+
+var policyTiers = []policyTier{{"daily", 1}, {"weekly", 7}, {"monthly", 30}, {"yearly", 365}}
+
+// counts returns the counts of p in the order of policyTiers.
+func (p *RetentionPolicy) counts() []*int {
+	return []*int{&p.Daily, &p.Weekly, &p.Monthly, &p.Yearly}
+}
+
+// ParseRetentionPolicy parses a policy such as "daily=7,weekly=4,monthly=12".
+func ParseRetentionPolicy(s string) (RetentionPolicy, error) {
+	var p RetentionPolicy
+	counts := p.counts()
+	for _, kv := range strings.Split(s, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		i := slices.IndexFunc(policyTiers, func(t policyTier) bool { return t.name == k })
+		n, err := strconv.Atoi(v)
+		if i < 0 || err != nil || n < 0 {
+			return p, fmt.Errorf("%w: invalid retention policy '%s' (want e.g. daily=7,weekly=4,monthly=12)", ErrUsage, s)
+		}
+		*counts[i] = n
+	}
+	if p == (RetentionPolicy{}) {
+		return p, fmt.Errorf("%w: retention policy '%s' keeps nothing", ErrUsage, s)
+	}
+	return p, nil
+}
+
+func (p RetentionPolicy) String() string {
+	var parts []string
+	for i, n := range p.counts() {
+		if *n > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", policyTiers[i].name, *n))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// ages returns the ages in days of the backups p keeps once it is full,
+// youngest first, assuming a backup every day.
+func (p RetentionPolicy) ages() []int {
+	var ages []int
+	for i, n := range p.counts() {
+		for k := range *n {
+			ages = append(ages, k*policyTiers[i].days)
+		}
+	}
+	slices.Sort(ages)
+	return slices.Compact(ages)
+}
+
+// StoragePlan forecasts the storage a retention policy needs for one
+// database.
+type StoragePlan struct {
+	Node, Database string
+	Latest         int64   // size of the latest backup
+	Growth         float64 // bytes per day
+	// Current is what the backups of the last retention days take up
+	// now, for comparison.
+	Current int64
+	// Needed is the storage the backups the policy keeps take up once it
+	// is full.
+	Needed int64
+}
+
+// planStorage forecasts, from the successful backups among records taken
+// at or after since, the storage p needs for each database once it is
+// full, and what keeping retentionDays of backups takes up now.
+func planStorage(records []CatalogRecord, since, now time.Time, p RetentionPolicy, retentionDays int) ([]StoragePlan, error) {
+	trends, err := backupTrends(records, since, "day")
+	if err != nil {
+		return nil, err
+	}
+	ages := p.ages()
+	full := ages[len(ages)-1]
+	var plans []StoragePlan
+	for _, t := range trends {
+		sp := StoragePlan{Node: t.Node, Database: t.Database, Growth: t.SizeGrowth}
+		sp.Latest = t.Points[len(t.Points)-1].Size
+		for _, age := range ages {
+			// The backup taken age days before the policy is full
+			sp.Needed += max(0, sp.Latest+int64(float64(full-age)*sp.Growth))
+		}
+		cutoff := now.AddDate(0, 0, -retentionDays)
+		for _, r := range records {
+			if r.Node == t.Node && r.Database == t.Database && r.Outcome == "success" && !r.Removed && r.Time.After(cutoff) {
+				sp.Current += r.Size
+			}
+		}
+		plans = append(plans, sp)
+	}
+	return plans, nil
+}
+
+// writePlan writes plans for policy p to w as a table with a total.
+func writePlan(w io.Writer, plans []StoragePlan, p RetentionPolicy, retentionDays int, now time.Time) {
+	if len(plans) == 0 {
+		fmt.Fprintln(w, "No successful backups to plan from.")
+		return
+	}
+	ages := p.ages()
+	full := ages[len(ages)-1]
+	fmt.Fprintf(w, "Policy %s keeps %d backups of each database, the oldest %d days old. It is full on %s.\n\n",
+		p, len(ages), full, now.AddDate(0, 0, full).Format("2006-01-02"))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "DATABASE\tLATEST\tGROWTH/30D\tNOW (%d DAYS)\tWITH POLICY\n", retentionDays)
+	var latest, current, needed int64
+	var growth float64
+	for _, sp := range plans {
+		name := Trend{Node: sp.Node, Database: sp.Database}.name()
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", name, formatBytes(sp.Latest), signedBytes(int64(30*sp.Growth)),
+			formatBytes(sp.Current), formatBytes(sp.Needed))
+		latest, growth, current, needed = latest+sp.Latest, growth+sp.Growth, current+sp.Current, needed+sp.Needed
+	}
+	if len(plans) > 1 {
+		fmt.Fprintf(tw, "total\t%s\t%s\t%s\t%s\n", formatBytes(latest), signedBytes(int64(30*growth)),
+			formatBytes(current), formatBytes(needed))
+	}
+	tw.Flush()
+}
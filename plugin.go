@@ -0,0 +1,93 @@
+package pgtool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+// Exec plugins are external programs implementing a storage backend or a
+// notifier. pgtool runs the program once per call with a single JSON
+// request on stdin and reads a single JSON response from stdout:
+//
+//	request:  {"method": "upload", "file": "/var/backups/x.dump.gz", "name": "x.dump.gz"}
+//	response: {"error": ""}
+//
+// Storage plugins handle the methods upload, download, list (answering
+// with "names") and delete. Notifier plugins handle notify, receiving the
+// run result in "result". A non-empty "error" or a non-zero exit status
+// fails the call.
+
+type pluginRequest struct {
+	Method string     `json:"method"`
+	File   string     `json:"file,omitempty"`
+	Name   string     `json:"name,omitempty"`
+	Result *RunResult `json:"result,omitempty"`
+}
+
+type pluginResponse struct {
+	Error string   `json:"error,omitempty"`
+	Names []string `json:"names,omitempty"`
+}
+
+// callPlugin sends req to the plugin at path and decodes its response.
+func callPlugin(ctx context.Context, path string, req pluginRequest) (pluginResponse, error) {
+	var resp pluginResponse
+	in, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	var out bytes.Buffer
+	c := Command{Name: path, Stdin: bytes.NewReader(in), Stdout: &out}
+	if err := runCommand(ctx, ExecRunner{}, c, nil); err != nil {
+		return resp, err
+	}
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		return resp, fmt.Errorf("plugin %s: invalid response: %w", filepath.Base(path), err)
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("plugin %s: %s", filepath.Base(path), resp.Error)
+	}
+	return resp, nil
+}
+
+// ExecStorage is a StorageBackend implemented by an exec plugin.
+type ExecStorage struct {
+	Path string
+}
+
+func (s ExecStorage) Name() string { return filepath.Base(s.Path) }
+
+func (s ExecStorage) Upload(ctx context.Context, localPath, name string) error {
+	_, err := callPlugin(ctx, s.Path, pluginRequest{Method: "upload", File: localPath, Name: name})
+	return err
+}
+
+func (s ExecStorage) Download(ctx context.Context, name, localPath string) error {
+	_, err := callPlugin(ctx, s.Path, pluginRequest{Method: "download", File: localPath, Name: name})
+	return err
+}
+
+func (s ExecStorage) List(ctx context.Context) ([]string, error) {
+	resp, err := callPlugin(ctx, s.Path, pluginRequest{Method: "list"})
+	return resp.Names, err
+}
+
+func (s ExecStorage) Delete(ctx context.Context, name string) error {
+	_, err := callPlugin(ctx, s.Path, pluginRequest{Method: "delete", Name: name})
+	return err
+}
+
+// ExecNotifier is a Notifier implemented by an exec plugin.
+type ExecNotifier struct {
+	Path string
+}
+
+func (n ExecNotifier) Name() string { return filepath.Base(n.Path) }
+
+func (n ExecNotifier) Notify(ctx context.Context, r RunResult) error {
+	_, err := callPlugin(ctx, n.Path, pluginRequest{Method: "notify", Result: &r})
+	return err
+}
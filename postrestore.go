@@ -0,0 +1,86 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// expandScripts turns the configured post-restore paths into an ordered
+// list of SQL files. Files are kept in the order given; a directory
+// expands to its *.sql files sorted by name, so "010_grants.sql" runs
+// before "020_config.sql".
+func expandScripts(paths []string) ([]string, error) {
+	var scripts []string
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			scripts = append(scripts, p)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(p, "*.sql"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		scripts = append(scripts, matches...)
+	}
+	return scripts, nil
+}
+
+// runPostRestoreScripts runs each script against the restored database,
+// each in its own transaction. Unless continueOnError is set, the first
+// failing script stops the run.
+func runPostRestoreScripts(ctx context.Context, cfg Config, logger *log.Logger, stderr io.Writer) error {
+	scripts, err := expandScripts(cfg.PostRestoreScripts)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, script := range scripts {
+		logger.Printf("INFO: Running post-restore script '%s'.", script)
+		fmt.Printf("Running %s...\n", filepath.Base(script))
+		if err := runSQLFile(ctx, cfg, script, stderr); err != nil {
+			err = contextErr(ctx, err)
+			logger.Printf("ERROR: Post-restore script '%s' failed: %v", script, err)
+			if !cfg.PostRestoreContinueOnError {
+				return fmt.Errorf("post-restore script %s: %w", filepath.Base(script), err)
+			}
+			failed++
+			continue
+		}
+		logger.Printf("SUCCESS: Post-restore script '%s' completed.", script)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d post-restore scripts failed", failed, len(scripts))
+	}
+	return nil
+}
+
+// runSQLFile runs a SQL file with psql in a single transaction, stopping
+// at the first error.
+func runSQLFile(ctx context.Context, cfg Config, path string, stderr io.Writer) error {
+	arg, stdin, err := fileInput(cfg.runner(), path)
+	if err != nil {
+		return err
+	}
+	if arg == "" {
+		arg = "-"
+	}
+	c := Command{
+		Name: "psql",
+		Args: append(cfg.connArgs(), "-X", "-q", "-v", "ON_ERROR_STOP=1", "--single-transaction", "-f", arg),
+	}
+	if stdin != nil {
+		defer stdin.Close()
+		c.Stdin = stdin
+	}
+	return runCommand(ctx, cfg.runner(), c, stderr)
+}
@@ -0,0 +1,162 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// preflight checks that a backup can succeed before pg_dump is started:
+// the server must accept a connection and the backup directory must have
+// at least SpaceFactor times the database's size free. A SpaceFactor of 0
+// skips the space check. It returns the database's size.
+func preflight(ctx context.Context, cfg Config, logger *log.Logger) (int64, error) {
+	if _, err := queryScalar(ctx, cfg, "SELECT 1"); err != nil {
+		return 0, fmt.Errorf("cannot connect to database '%s': %w", cfg.Database, err)
+	}
+
+	size, err := databaseSize(ctx, cfg)
+	if err != nil {
+		return 0, err
+	}
+	logger.Printf("INFO: Database '%s' size is %s.", cfg.Database, formatBytes(size))
+	if cfg.SpaceFactor <= 0 && cfg.MinFreeSpace.IsZero() {
+		return size, nil
+	}
+
+	free, total, err := freeSpace(cfg.BackupDir)
+	if err != nil {
+		return size, fmt.Errorf("cannot check free space in '%s': %w", cfg.BackupDir, err)
+	}
+	if err := cfg.MinFreeSpace.check(cfg.BackupDir, free, total); err != nil {
+		return size, err
+	}
+	need := int64(float64(size) * cfg.SpaceFactor)
+	if free < need {
+		return size, fmt.Errorf("%w: %s free in '%s', need %s (%.1f x database size)",
+			ErrDiskFull, formatBytes(free), cfg.BackupDir, formatBytes(need), cfg.SpaceFactor)
+	}
+	return size, nil
+}
+
+// databaseSize returns pg_database_size of the configured database.
+func databaseSize(ctx context.Context, cfg Config) (int64, error) {
+	out, err := queryScalar(ctx, cfg, "SELECT pg_database_size(current_database())")
+	if err != nil {
+		return 0, fmt.Errorf("cannot get size of database '%s': %w", cfg.Database, err)
+	}
+	size, err := strconv.ParseInt(out, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected database size %q: %w", out, err)
+	}
+	return size, nil
+}
+
+// freeSpace returns the bytes available to unprivileged users and the
+// total size of the filesystem holding dir.
+func freeSpace(dir string) (free, total int64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), int64(st.Blocks) * int64(st.Bsize), nil
+}
+
+// FreeSpaceLimit is a minimum amount of free space, either in bytes or as
+// a percentage of the filesystem. The zero value is no limit.
+type FreeSpaceLimit struct {
+	Bytes   int64
+	Percent float64
+}
+
+// ParseFreeSpaceLimit parses "20G", "500M", "1T", a plain byte count or a
+// percentage such as "10%". Units are binary.
+func ParseFreeSpaceLimit(s string) (FreeSpaceLimit, error) {
+	if s == "" {
+		return FreeSpaceLimit{}, nil
+	}
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 || v >= 100 {
+			return FreeSpaceLimit{}, fmt.Errorf("%w: invalid free space percentage '%s'", ErrUsage, s)
+		}
+		return FreeSpaceLimit{Percent: v}, nil
+	}
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	mult := int64(1)
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("KMGT", num[n-1]); i >= 0 {
+			mult, num = 1<<(10*(i+1)), num[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 {
+		return FreeSpaceLimit{}, fmt.Errorf("%w: invalid free space '%s'", ErrUsage, s)
+	}
+	return FreeSpaceLimit{Bytes: int64(v * float64(mult))}, nil
+}
+
+// IsZero reports whether l sets no limit.
+func (l FreeSpaceLimit) IsZero() bool { return l.Bytes == 0 && l.Percent == 0 }
+
+func (l FreeSpaceLimit) String() string {
+	if l.Percent > 0 {
+		return fmt.Sprintf("%g%%", l.Percent)
+	}
+	return formatBytes(l.Bytes)
+}
+
+// check returns ErrDiskFull if free is below the limit.
+func (l FreeSpaceLimit) check(dir string, free, total int64) error {
+	min := l.Bytes
+	if l.Percent > 0 {
+		min = int64(float64(total) * l.Percent / 100)
+	}
+	if free < min {
+		return fmt.Errorf("%w: %s free in '%s', below the minimum of %s", ErrDiskFull, formatBytes(free), dir, l)
+	}
+	return nil
+}
+
+// freeSpaceInterval is how often free space is checked during a backup.
+const freeSpaceInterval = 5 * time.Second
+
+// watchFreeSpace returns a context that is cancelled, with an ErrDiskFull
+// cause, if free space in dir drops below limit. Call stop when done.
+func watchFreeSpace(ctx context.Context, dir string, limit FreeSpaceLimit, logger *log.Logger) (context.Context, func()) {
+	if limit.IsZero() {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(freeSpaceInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			free, total, err := freeSpace(dir)
+			if err != nil {
+				continue
+			}
+			if err := limit.check(dir, free, total); err != nil {
+				logger.Printf("ERROR: Aborting: %v", err)
+				cancel(err)
+				return
+			}
+		}
+	}()
+	return ctx, func() {
+		close(done)
+		cancel(nil)
+	}
+}
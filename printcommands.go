@@ -0,0 +1,237 @@
+package pgtool
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// recordRunner collects the commands it is given instead of running them.
+type recordRunner struct {
+	cmds *[]Command
+}
+
+func (r recordRunner) Run(_ context.Context, c Command) error {
+	*r.cmds = append(*r.cmds, c)
+	return nil
+}
+
+// withBase returns r with the runner at the bottom of its chain, the one
+// that starts processes, replaced by base, so that base sees commands as
+// they would be run: wrapped by -exec-via, with the -pg-bindir path and
+// with any added environment.
+func withBase(r, base Runner) Runner {
+	switch r := r.(type) {
+	case BinDirRunner:
+		r.Base = withBase(r.Base, base)
+		return r
+	case EnvRunner:
+		r.Base = withBase(r.Base, base)
+		return r
+	case PrefixRunner:
+		r.Base = withBase(r.Base, base)
+		return r
+	}
+	return base
+}
+
+// commandPrinter prints the external commands of a run for
+// -print-commands, with secrets redacted.
+type commandPrinter struct {
+	w io.Writer
+	r Runner
+}
+
+// command prints c as r would run it, followed by redirect, e.g.
+// " > file", describing where its output goes.
+func (p commandPrinter) command(c Command, redirect string) {
+	var cmds []Command
+	runCommand(context.Background(), withBase(p.r, recordRunner{&cmds}), c, nil)
+	for _, c := range cmds {
+		fmt.Fprintln(p.w, formatCommand(c)+redirect)
+	}
+}
+
+// note prints an operation pgtool does itself, as a shell comment.
+func (p commandPrinter) note(format string, args ...any) {
+	fmt.Fprintf(p.w, "# "+format+"\n", args...)
+}
+
+// formatCommand returns c as a shell command line, with secrets in its
+// environment and connection strings hidden.
+func formatCommand(c Command) string {
+	var words []string
+	for _, kv := range c.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		if secretName(k) {
+			v = "xxxxx"
+		}
+		words = append(words, k+"="+quoteWord(v))
+	}
+	words = append(words, quoteWord(c.Name))
+	for _, a := range c.Args {
+		words = append(words, quoteWord(redactDSN(a)))
+	}
+	return strings.Join(words, " ")
+}
+
+// secretName reports whether an environment variable looks like it holds
+// a secret.
+func secretName(name string) bool {
+	name = strings.ToUpper(name)
+	for _, s := range []string{"PASSWORD", "SECRET", "TOKEN", "KEY"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Placeholders stand for the temporary files a restore decompresses to,
+// which are only named at run time.
+const (
+	dumpPlaceholder    = "$DUMP"
+	globalsPlaceholder = "$GLOBALS"
+)
+
+// quoteWord shell-quotes s if it needs it.
+func quoteWord(s string) string {
+	if s == dumpPlaceholder || s == globalsPlaceholder {
+		return s
+	}
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=.,/:@%+") == "" {
+		return s
+	}
+	// Arguments already quoted for a remote shell, as by ssh -exec-via,
+	// read better in double quotes
+	if strings.Contains(s, "'") && !strings.ContainsAny(s, "\"$`\\!") {
+		return `"` + s + `"`
+	}
+	return shellQuote(s)
+}
+
+// printBackupCommands prints what runBackup would run for cfg, without
+// connecting to the server or touching the backup directory.
+func printBackupCommands(w io.Writer, cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	p := commandPrinter{w: w, r: cfg.runner()}
+	name := BackupName{Database: cfg.backupSet(), Time: time.Now(), Kind: KindDump, Format: "custom"}
+	backupFile := filepath.Join(cfg.BackupDir, name.String())
+	var files []string
+
+	for _, stmt := range cfg.PreBackupSQL {
+		p.command(hookCommand(cfg, stmt), "")
+	}
+	if cfg.ChangedPartitions != "" {
+		p.note("--table options for the partitions of %s changed since the last backup are added at run time", cfg.ChangedPartitions)
+	}
+	p.command(cfg.dumpCommand(cfg.dumpArgs()), " > "+quoteWord(backupFile+partialSuffix))
+	files = append(files, backupFile+".gz")
+	if cfg.BlobsSeparate && !cfg.NoBlobs {
+		blobsName := name
+		blobsName.Kind = KindBlobs
+		blobsFile := filepath.Join(cfg.BackupDir, blobsName.String())
+		p.command(cfg.dumpCommand(cfg.blobsArgs()), " > "+quoteWord(blobsFile+partialSuffix))
+		files = append(files, blobsFile+".gz")
+	}
+	if cfg.Subset != nil {
+		subsetName := name
+		subsetName.Kind, subsetName.Format = KindSubset, "sql"
+		subsetFile := filepath.Join(cfg.BackupDir, subsetName.String())
+		p.note("the kept rows of %d subset table(s) are copied out with psql to %s", len(cfg.Subset.Tables), subsetFile)
+		files = append(files, subsetFile+".gz")
+	}
+	if cfg.Globals {
+		globalsName := name
+		globalsName.Kind, globalsName.Format = KindGlobals, "sql"
+		globalsFile := filepath.Join(cfg.BackupDir, globalsName.String())
+		p.command(globalsCommand(cfg), " > "+quoteWord(globalsFile+partialSuffix))
+		files = append(files, globalsFile+".gz")
+	}
+	for _, stmt := range cfg.PostBackupSQL {
+		p.command(hookCommand(cfg, stmt), "")
+	}
+	if cfg.CompressionLevel == gzip.DefaultCompression {
+		p.note("gzip each %s file and drop the suffix", partialSuffix)
+	} else {
+		p.note("gzip each %s file at level %d and drop the suffix", partialSuffix, cfg.CompressionLevel)
+	}
+
+	manifestName := name
+	manifestName.Kind = KindManifest
+	files = append(files, filepath.Join(cfg.BackupDir, manifestName.String()))
+	p.note("write %s", files[len(files)-1])
+	for _, b := range cfg.Storage {
+		for _, f := range files {
+			p.note("upload %s to %s", filepath.Base(f), b.Name())
+		}
+	}
+	p.note("delete backups of %s older than %d days from %s", cfg.backupSet(), cfg.RetentionDays, cfg.BackupDir)
+	if cfg.RemoteRetentionDays > 0 {
+		for _, b := range cfg.Storage {
+			p.note("delete backups of %s older than %d days from %s", cfg.backupSet(), cfg.RemoteRetentionDays, b.Name())
+		}
+	}
+	return nil
+}
+
+// printRestoreCommands prints what runRestore would run to restore
+// backupFile with cfg, without connecting to the server.
+func printRestoreCommands(w io.Writer, cfg Config, backupFile string) error {
+	if cfg.target() == "" || backupFile == "" {
+		return fmt.Errorf("%w: database name and backup file are required", ErrUsage)
+	}
+	p := commandPrinter{w: w, r: cfg.runner()}
+	if _, err := os.Stat(backupFile); os.IsNotExist(err) && len(cfg.Storage) > 0 {
+		p.note("download %s from %s", filepath.Base(backupFile), cfg.Storage[0].Name())
+	}
+	p.note("decompress %s to a temporary file, %s below", backupFile, dumpPlaceholder)
+
+	if !cfg.cleansTarget() {
+		m, db := cfg.maintenance(), quoteIdent(cfg.Database)
+		switch cfg.IfExists {
+		case IfExistsFail:
+			p.note("stop if database '%s' exists", cfg.Database)
+		case IfExistsDrop:
+			p.note("if database '%s' exists:", cfg.Database)
+			p.command(psqlCommand(m.connArgs(), "DROP DATABASE "+db), "")
+		case IfExistsRename:
+			old := fmt.Sprintf("%s_old_%s", cfg.Database, time.Now().Format("20060102_150405"))
+			p.note("if database '%s' exists:", cfg.Database)
+			p.command(psqlCommand(m.connArgs(), "ALTER DATABASE "+db+" RENAME TO "+quoteIdent(old)), "")
+		}
+		p.command(psqlCommand(m.connArgs(), "CREATE DATABASE "+db+" TEMPLATE template0"), "")
+	}
+
+	// Runners that cannot see the local files read them on stdin
+	local := runsLocally(cfg.runner())
+	if cfg.WithGlobals {
+		globalsFile := strings.TrimSuffix(backupFile, ".dump.gz") + ".globals.sql.gz"
+		p.note("decompress %s to a temporary file, %s below", globalsFile, globalsPlaceholder)
+		if local {
+			p.command(Command{Name: "psql", Args: append(cfg.connArgs(), "-X", "-q", "-f", globalsPlaceholder)}, "")
+		} else {
+			p.command(Command{Name: "psql", Args: append(cfg.connArgs(), "-X", "-q", "-f", "-")}, " < "+globalsPlaceholder)
+		}
+	}
+	if cfg.needsRewrite() {
+		p.note("pg_restore's SQL output is rewritten for masking or role and tablespace maps and piped into psql")
+		return nil
+	}
+	arg, redirect := dumpPlaceholder, ""
+	if !local {
+		arg, redirect = "", " < "+dumpPlaceholder
+	}
+	if cfg.NoBlobs || cfg.BlobsSeparate {
+		p.note("a --use-list=FILE option leaving out large objects is added at run time")
+	}
+	p.command(Command{Name: "pg_restore", Args: cfg.restoreArgs(arg)}, redirect)
+	return nil
+}
@@ -0,0 +1,42 @@
+package pgtool
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// priorities are the database priorities of the config file, most urgent
+// first. Within a fleet or -all run, each server's databases are backed up
+// in this order, so that the critical ones are done first if the run
+// overruns its window. A database without a priority is "normal".
+var priorities = []string{"critical", "high", "normal", "low"}
+
+// priorityRank returns the position of p in priorities.
+func priorityRank(p string) int {
+	if p == "" {
+		return slices.Index(priorities, "normal")
+	}
+	return slices.Index(priorities, p)
+}
+
+func validatePriority(p string) error {
+	if p != "" && !slices.Contains(priorities, p) {
+		return fmt.Errorf("%w: unknown priority '%s' (want %s)", ErrUsage, p, strings.Join(priorities, ", "))
+	}
+	return nil
+}
+
+// byPriority sorts configs by priority, keeping their order otherwise, and
+// drops those less urgent than only, if set.
+func byPriority(configs []Config, only string) []Config {
+	slices.SortStableFunc(configs, func(a, b Config) int {
+		return priorityRank(a.Priority) - priorityRank(b.Priority)
+	})
+	if only == "" {
+		return configs
+	}
+	return slices.DeleteFunc(configs, func(c Config) bool {
+		return priorityRank(c.Priority) > priorityRank(only)
+	})
+}
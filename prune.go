@@ -0,0 +1,56 @@
+package pgtool
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultStaleAge is how old leftovers of a crashed run must be before
+// they are removed. It is far longer than any run, so files of a backup
+// still in progress are never touched.
+const defaultStaleAge = 24 * time.Hour
+
+// isStale reports whether name is a leftover of an interrupted run rather
+// than a finished backup: a .partial file, which is what dumps, compressed
+// output and decompression temp files are named while being written.
+// Finished files, even uncompressed dumps, are never stale.
+func isStale(name string) bool {
+	return strings.HasSuffix(name, partialSuffix)
+}
+
+// cleanupStale removes stale files older than maxAge from dir and returns
+// how many were removed. Errors are logged, not returned, so that cleanup
+// never fails the run it precedes.
+func cleanupStale(ctx context.Context, dir string, maxAge time.Duration, logger *log.Logger) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Printf("WARNING: Cannot scan %s for stale files: %v", dir, err)
+		return 0
+	}
+	cutoff := time.Now().Add(-maxAge)
+	var removed int
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		if e.IsDir() || !isStale(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if err := os.Remove(path); err != nil {
+			logger.Printf("WARNING: Failed to delete %s: %v", path, err)
+			continue
+		}
+		logger.Printf("INFO: Deleted stale file %s (%s).", path, formatBytes(info.Size()))
+		removed++
+	}
+	return removed
+}
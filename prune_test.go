@@ -0,0 +1,70 @@
+package pgtool
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// oldFiles creates names in dir, last modified two days ago.
+func oldFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestCleanupStale(t *testing.T) {
+	dir := t.TempDir()
+	oldFiles(t, dir, "app_2026-10-13_020000.dump.partial", "app_2026-10-13_020000.dump.123.partial",
+		"app_2026-10-12_020000.dump", "app_2026-10-12_020000.dump.gz", "notes.txt")
+	if n := cleanupStale(context.Background(), dir, defaultStaleAge, log.New(io.Discard, "", 0)); n != 2 {
+		t.Errorf("cleanupStale removed %d files, want 2", n)
+	}
+	want := []string{"app_2026-10-12_020000.dump", "app_2026-10-12_020000.dump.gz", "notes.txt"}
+	if got := dirNames(t, dir); !slices.Equal(got, want) {
+		t.Errorf("left %q, want %q", got, want)
+	}
+}
+
+func TestRunRestoreLeavesFileDirAlone(t *testing.T) {
+	pg := &fakePG{}
+	cfg := fakeConfig(t, pg)
+	cfg.SkipExtensionCheck = true
+	dir := t.TempDir()
+	oldFiles(t, dir, "app_2025-01-01_000000.dump", "download.partial")
+	backupFile := filepath.Join(dir, "app_2026-10-15_020000.dump.gz")
+	writeGzip(t, backupFile, "PGDMP fake archive\n")
+	if err := runRestore(context.Background(), cfg, backupFile); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"app_2025-01-01_000000.dump", "app_2026-10-15_020000.dump.gz", "download.partial"}
+	if got := dirNames(t, dir); !slices.Equal(got, want) {
+		t.Errorf("left %q, want %q", got, want)
+	}
+}
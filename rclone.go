@@ -0,0 +1,49 @@
+package pgtool
+
+import (
+	"context"
+	"strings"
+)
+
+// RcloneStorage is a StorageBackend that runs rclone, so any remote rclone
+// is configured for (see rclone config) can hold backups. Remote is an
+// rclone path such as "gdrive:backups/postgres".
+type RcloneStorage struct {
+	Remote string
+	// Args are extra rclone options, e.g. --config or --bwlimit.
+	Args []string
+}
+
+func (s RcloneStorage) Name() string { return "rclone:" + s.Remote }
+
+func (s RcloneStorage) path(name string) string {
+	return strings.TrimSuffix(s.Remote, "/") + "/" + name
+}
+
+func (s RcloneStorage) run(ctx context.Context, out *strings.Builder, args ...string) error {
+	c := Command{Name: "rclone", Args: append(append([]string{}, s.Args...), args...)}
+	if out != nil {
+		c.Stdout = out
+	}
+	return runCommand(ctx, ExecRunner{}, c, nil)
+}
+
+func (s RcloneStorage) Upload(ctx context.Context, localPath, name string) error {
+	return s.run(ctx, nil, "copyto", localPath, s.path(name))
+}
+
+func (s RcloneStorage) Download(ctx context.Context, name, localPath string) error {
+	return s.run(ctx, nil, "copyto", s.path(name), localPath)
+}
+
+func (s RcloneStorage) List(ctx context.Context) ([]string, error) {
+	var out strings.Builder
+	if err := s.run(ctx, &out, "lsf", "--files-only", s.Remote); err != nil {
+		return nil, err
+	}
+	return strings.Fields(out.String()), nil
+}
+
+func (s RcloneStorage) Delete(ctx context.Context, name string) error {
+	return s.run(ctx, nil, "deletefile", s.path(name))
+}
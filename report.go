@@ -0,0 +1,242 @@
+package pgtool
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// TrendPoint summarizes the successful backups of one period.
+type TrendPoint struct {
+	Start       time.Time
+	Backups     int
+	Size        int64 // average
+	Duration    time.Duration
+	MaxDuration time.Duration
+}
+
+// Trend is how the backups of one database grew over a report's period.
+// Growth is from a least-squares fit over the individual backups, so one
+// unusual backup does not swing it.
+type Trend struct {
+	Node, Database string
+	Points         []TrendPoint
+	// SizeGrowth is in bytes, DurationGrowth in seconds, per day.
+	SizeGrowth, DurationGrowth float64
+}
+
+// name is the database, qualified with its host in a shared catalog.
+func (t Trend) name() string {
+	if t.Node != "" {
+		return t.Node + "/" + t.Database
+	}
+	return t.Database
+}
+
+// parseLast parses how far back a report goes: a number of days, e.g.
+// "90d", or a duration, e.g. "72h".
+func parseLast(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("%w: invalid period '%s' (want e.g. 90d or 72h)", ErrUsage, s)
+}
+
+// periodStart returns the start of the day, week (from Monday) or month
+// that t is in.
+func periodStart(t time.Time, by string) time.Time {
+	y, m, d := t.Date()
+	switch by {
+	case "month":
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	case "week":
+		d -= (int(t.Weekday()) + 6) % 7
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// backupTrends groups the successful backups among records taken at or
+// after since by host and database, and those by period: "day", "week"
+// or "month".
+func backupTrends(records []CatalogRecord, since time.Time, by string) ([]Trend, error) {
+	if by != "day" && by != "week" && by != "month" {
+		return nil, fmt.Errorf("%w: -by must be day, week or month, not '%s'", ErrUsage, by)
+	}
+	var trends []Trend
+	index := map[[2]string]int{}
+	type sample struct {
+		t        time.Time
+		size     int64
+		duration time.Duration
+	}
+	samples := map[int][]sample{}
+	for _, r := range records {
+		if r.Outcome != "success" || r.Size <= 0 || r.Time.Before(since) {
+			continue
+		}
+		k := [2]string{r.Node, r.Database}
+		i, ok := index[k]
+		if !ok {
+			i = len(trends)
+			index[k] = i
+			trends = append(trends, Trend{Node: r.Node, Database: r.Database})
+		}
+		d, _ := time.ParseDuration(r.Duration)
+		samples[i] = append(samples[i], sample{r.Time.Local(), r.Size, d})
+	}
+
+	for i := range trends {
+		t := &trends[i]
+		var xs, sizes, durations []float64
+		for _, s := range samples[i] {
+			start := periodStart(s.t, by)
+			if n := len(t.Points); n == 0 || !t.Points[n-1].Start.Equal(start) {
+				t.Points = append(t.Points, TrendPoint{Start: start})
+			}
+			p := &t.Points[len(t.Points)-1]
+			// Running averages
+			p.Backups++
+			p.Size += (s.size - p.Size) / int64(p.Backups)
+			p.Duration += (s.duration - p.Duration) / time.Duration(p.Backups)
+			p.MaxDuration = max(p.MaxDuration, s.duration)
+
+			xs = append(xs, s.t.Sub(since).Hours()/24)
+			sizes = append(sizes, float64(s.size))
+			durations = append(durations, s.duration.Seconds())
+		}
+		t.SizeGrowth = slope(xs, sizes)
+		t.DurationGrowth = slope(xs, durations)
+	}
+	return trends, nil
+}
+
+// slope returns the slope of the least-squares line through the points
+// (xs[i], ys[i]), or zero if there are fewer than two distinct xs.
+func slope(xs, ys []float64) float64 {
+	n := float64(len(xs))
+	var sx, sy, sxx, sxy float64
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+		sxx += xs[i] * xs[i]
+		sxy += xs[i] * ys[i]
+	}
+	d := n*sxx - sx*sx
+	if n < 2 || d <= 1e-9 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / d
+}
+
+// sparkline draws values as a line of block characters, lowest to
+// highest.
+func sparkline(values []float64) string {
+	const blocks = "▁▂▃▄▅▆▇█"
+	levels := []rune(blocks)
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if hi > lo {
+			i = int((v - lo) / (hi - lo) * float64(len(levels)-1))
+		}
+		b.WriteRune(levels[i])
+	}
+	return b.String()
+}
+
+// signedBytes formats n as formatBytes does, with a sign.
+func signedBytes(n int64) string {
+	if n < 0 {
+		return "-" + formatBytes(-n)
+	}
+	return "+" + formatBytes(n)
+}
+
+// signedDuration formats d rounded to seconds, with a sign.
+func signedDuration(d time.Duration) string {
+	if d < 0 {
+		return "-" + (-d).Round(time.Second).String()
+	}
+	return "+" + d.Round(time.Second).String()
+}
+
+// change describes going from first to last, e.g. "+25%".
+func change(first, last float64) string {
+	if first == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.0f%%", 100*(last-first)/first)
+}
+
+// writeReport writes trends to w as tables, by period, with sparklines if
+// spark is set, or as CSV.
+func writeReport(w io.Writer, trends []Trend, by, format string, spark bool) error {
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"node", "database", by, "backups", "size", "duration_seconds", "max_duration_seconds"})
+		for _, t := range trends {
+			for _, p := range t.Points {
+				cw.Write([]string{t.Node, t.Database, p.Start.Format("2006-01-02"), strconv.Itoa(p.Backups), strconv.FormatInt(p.Size, 10),
+					strconv.FormatFloat(p.Duration.Seconds(), 'f', 0, 64), strconv.FormatFloat(p.MaxDuration.Seconds(), 'f', 0, 64)})
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	if format != "table" {
+		return fmt.Errorf("%w: -format must be table or csv, not '%s'", ErrUsage, format)
+	}
+
+	if len(trends) == 0 {
+		fmt.Fprintln(w, "No successful backups in the period.")
+		return nil
+	}
+	for i, t := range trends {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		backups := 0
+		var sizes, durations []float64
+		for _, p := range t.Points {
+			backups += p.Backups
+			sizes = append(sizes, float64(p.Size))
+			durations = append(durations, p.Duration.Seconds())
+		}
+		fmt.Fprintf(w, "%s: %d backup(s) since %s\n", t.name(), backups, t.Points[0].Start.Format("2006-01-02"))
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "%s\tBACKUPS\tSIZE\tDURATION\tMAX DURATION\n", strings.ToUpper(by))
+		for _, p := range t.Points {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", p.Start.Format("2006-01-02"), p.Backups, formatBytes(p.Size),
+				p.Duration.Round(time.Second), p.MaxDuration.Round(time.Second))
+		}
+		tw.Flush()
+
+		first, last := t.Points[0], t.Points[len(t.Points)-1]
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Size:\t%s -> %s (%s)\t%s per 30 days", formatBytes(first.Size), formatBytes(last.Size),
+			change(float64(first.Size), float64(last.Size)), signedBytes(int64(30*t.SizeGrowth)))
+		if spark {
+			fmt.Fprintf(tw, "\t%s", sparkline(sizes))
+		}
+		fmt.Fprintf(tw, "\nDuration:\t%s -> %s (%s)\t%s per 30 days", first.Duration.Round(time.Second), last.Duration.Round(time.Second),
+			change(first.Duration.Seconds(), last.Duration.Seconds()), signedDuration(time.Duration(30*t.DurationGrowth*float64(time.Second))))
+		if spark {
+			fmt.Fprintf(tw, "\t%s", sparkline(durations))
+		}
+		fmt.Fprintln(tw)
+		tw.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package pgtool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// restoreProgressInterval limits how often restore progress is printed
+// and logged.
+const restoreProgressInterval = 10 * time.Second
+
+// listTOC returns the entries in the table of contents of the archive at
+// path, as listed by pg_restore -l, without its comments.
+func listTOC(ctx context.Context, cfg Config, path string) ([]string, error) {
+	arg, stdin, err := fileInput(cfg.runner(), path)
+	if err != nil {
+		return nil, err
+	}
+	if stdin != nil {
+		defer stdin.Close()
+	}
+	return readTOC(ctx, cfg.runner(), arg, stdin)
+}
+
+// readTOC lists the table of contents of the archive file, or of the
+// archive on stdin if file is empty.
+func readTOC(ctx context.Context, r Runner, file string, stdin io.Reader) ([]string, error) {
+	args := []string{"-l"}
+	if file != "" {
+		args = append(args, file)
+	}
+	var out bytes.Buffer
+	c := Command{Name: "pg_restore", Args: args, Stdin: stdin, Stdout: &out}
+	if err := runCommand(ctx, r, c, nil); err != nil {
+		return nil, err
+	}
+	var entries []string
+	sc := bufio.NewScanner(&out)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, ";") {
+			entries = append(entries, line)
+		}
+	}
+	return entries, sc.Err()
+}
+
+// countTOC returns the number of entries in the table of contents of the
+// archive at path.
+func countTOC(ctx context.Context, cfg Config, path string) (int, error) {
+	entries, err := listTOC(ctx, cfg, path)
+	return len(entries), err
+}
+
+// restoreItemPrefixes start the lines pg_restore --verbose prints as it
+// takes on each TOC entry.
+var restoreItemPrefixes = []string{
+	"pg_restore: creating ",
+	"pg_restore: processing data for table ",
+	"pg_restore: executing ",
+	"pg_restore: finished item ",
+}
+
+// restoreProgress passes pg_restore's verbose output through to w while
+// counting the TOC entries it has reached. Every restoreProgressInterval
+// it prints and logs the current object, percent done and ETA, and
+// reports them through OnProgress.
+type restoreProgress struct {
+	w      io.Writer
+	total  int
+	logger *log.Logger
+	events EventHandler
+	ev     Event
+
+	mu      sync.Mutex
+	partial []byte
+	done    int
+	item    string
+	started time.Time
+	last    time.Time
+}
+
+func newRestoreProgress(w io.Writer, total int, logger *log.Logger, events EventHandler, ev Event) *restoreProgress {
+	now := time.Now()
+	ev.Total = total
+	return &restoreProgress{w: w, total: total, logger: logger, events: events, ev: ev, started: now, last: now}
+}
+
+func (p *restoreProgress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		p.line(string(p.partial[:i]))
+		p.partial = p.partial[i+1:]
+	}
+	p.mu.Unlock()
+	return p.w.Write(b)
+}
+
+func (p *restoreProgress) line(line string) {
+	for _, prefix := range restoreItemPrefixes {
+		if strings.HasPrefix(line, prefix) {
+			p.done = min(p.done+1, p.total)
+			p.item = strings.TrimPrefix(line, "pg_restore: ")
+			break
+		}
+	}
+	if now := time.Now(); now.Sub(p.last) >= restoreProgressInterval && p.done > 0 {
+		p.last = now
+		p.report(now)
+	}
+}
+
+func (p *restoreProgress) report(now time.Time) {
+	elapsed := now.Sub(p.started)
+	eta := time.Duration(float64(elapsed) / float64(p.done) * float64(p.total-p.done)).Round(time.Second)
+	pct := 100 * p.done / max(p.total, 1)
+	fmt.Printf("  restore: %d/%d (%d%%), ETA %s: %s\n", p.done, p.total, pct, eta, p.item)
+	p.logger.Printf("INFO: Restore progress: %d/%d objects (%d%%), ETA %s, at %s", p.done, p.total, pct, eta, p.item)
+	p.ev.Item, p.ev.Done, p.ev.Time = p.item, p.done, now
+	p.events.OnProgress(p.ev)
+}
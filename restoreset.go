@@ -0,0 +1,109 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// setManifests returns the manifests in dir of the backup set id, or of
+// the latest set if id is empty, ordered by database.
+func setManifests(dir, id string) ([]Manifest, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var all []Manifest
+	for _, file := range files {
+		b, err := ParseBackupFilename(file.Name())
+		if err != nil || file.IsDir() || b.Kind != KindManifest {
+			continue
+		}
+		m, err := ReadManifest(filepath.Join(dir, file.Name()))
+		if err != nil || m.SetID == "" {
+			continue
+		}
+		all = append(all, m)
+	}
+	if id == "" {
+		// Set IDs are the times the runs started, so the latest sorts last
+		for _, m := range all {
+			id = max(id, m.SetID)
+		}
+		if id == "" {
+			return nil, fmt.Errorf("%w: no backup -all sets in %s", ErrUsage, dir)
+		}
+	}
+	var set []Manifest
+	for _, m := range all {
+		if m.SetID == id {
+			set = append(set, m)
+		}
+	}
+	if len(set) == 0 {
+		return nil, fmt.Errorf("%w: no backups of set '%s' in %s", ErrUsage, id, dir)
+	}
+	slices.SortFunc(set, func(a, b Manifest) int { return strings.Compare(a.Database, b.Database) })
+	return set, nil
+}
+
+// RestoreSet restores the backup set id from dir, the latest if id is
+// empty: the set's roles and tablespaces first, if it has them, then each
+// of its databases under its own name, parallel at a time, with cfg's
+// other settings. It returns one result per database, and an error if
+// the set cannot be found or at least one database failed.
+func RestoreSet(ctx context.Context, cfg Config, dir, id string, parallel int) ([]FleetResult, error) {
+	set, err := setManifests(dir, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every database of a -globals set has the same globals; restore
+	// them once, before any database needs its owners and grants
+	if i := slices.IndexFunc(set, func(m Manifest) bool { return m.GlobalsFile != "" }); i >= 0 {
+		fmt.Println("Restoring roles and tablespaces...")
+		if err := restoreGlobals(ctx, cfg.maintenance(), filepath.Join(dir, set[i].GlobalsFile), os.Stderr); err != nil {
+			return nil, fmt.Errorf("globals restore failed: %w", err)
+		}
+	} else if cfg.WithGlobals {
+		return nil, fmt.Errorf("%w: set '%s' has no globals; back it up with -globals", ErrUsage, set[0].SetID)
+	}
+	cfg.WithGlobals = false
+
+	results := make([]FleetResult, len(set))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, m := range set {
+		target := cfg
+		target.Database = m.Database
+		results[i] = FleetResult{Server: cfg.Host, Database: m.Database}
+		wg.Add(1)
+		go func(r *FleetResult) {
+			defer wg.Done()
+			if r.Err = acquire(ctx, slots); r.Err != nil {
+				return
+			}
+			defer func() { <-slots }()
+			start := time.Now()
+			r.Err = runRestore(ctx, target, filepath.Join(dir, m.File))
+			r.Duration = time.Since(start)
+		}(&results[i])
+	}
+	wg.Wait()
+
+	var failed int
+	for _, r := range results {
+		if r.failed() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d restores failed", failed, len(results))
+	}
+	return results, nil
+}
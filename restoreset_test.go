@@ -0,0 +1,49 @@
+package pgtool
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetManifests(t *testing.T) {
+	dir := t.TempDir()
+	for _, m := range []Manifest{
+		{Database: "billing", SetID: "2026-10-14_020000"},
+		{Database: "app", SetID: "2026-10-14_020000"},
+		{Database: "billing", SetID: "2026-10-15_020000"},
+		{Database: "app", SetID: "2026-10-15_020000"},
+		{Database: "app"}, // a single-database backup, in no set
+	} {
+		stamp := m.SetID
+		if stamp == "" {
+			stamp = "2026-10-16_120000"
+		}
+		m.Version = ManifestVersion
+		if err := writeManifest(filepath.Join(dir, m.Database+"_"+stamp+".manifest.json"), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		id, want string
+	}{
+		{"2026-10-14_020000", "2026-10-14_020000"},
+		{"", "2026-10-15_020000"},
+	}
+	for _, tt := range tests {
+		set, err := setManifests(dir, tt.id)
+		if err != nil {
+			t.Fatalf("setManifests(%q): %v", tt.id, err)
+		}
+		if len(set) != 2 || set[0].Database != "app" || set[1].Database != "billing" || set[0].SetID != tt.want || set[1].SetID != tt.want {
+			t.Errorf("setManifests(%q) = %+v, want app and billing of %s", tt.id, set, tt.want)
+		}
+	}
+	if _, err := setManifests(dir, "2026-10-13_020000"); !errors.Is(err, ErrUsage) {
+		t.Errorf("unknown set: %v, want ErrUsage", err)
+	}
+	if _, err := setManifests(t.TempDir(), ""); !errors.Is(err, ErrUsage) {
+		t.Errorf("no sets: %v, want ErrUsage", err)
+	}
+}
@@ -0,0 +1,63 @@
+package pgtool
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// do runs fn, retrying transient failures with exponential backoff until
+// p.Attempts attempts have been made. onRetry, if not nil, is called
+// before each retry.
+func (p RetryPolicy) do(ctx context.Context, onRetry func(attempt int, delay time.Duration, err error), fn func() error) error {
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.Attempts || ctx.Err() != nil || !isTransient(err) {
+			return err
+		}
+		if onRetry != nil {
+			onRetry(attempt, backoff, err)
+		}
+		if !sleepUntil(ctx, time.Now().Add(backoff)) {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// logRetries returns an onRetry hook for RetryPolicy.do that logs a
+// warning naming what is being retried.
+func logRetries(logger *log.Logger, what string) func(int, time.Duration, error) {
+	return func(attempt int, delay time.Duration, err error) {
+		logger.Printf("WARNING: %s failed (attempt %d), retrying in %s: %v", what, attempt, delay, err)
+	}
+}
+
+// isTransient reports whether err is worth retrying. Failures of the
+// PostgreSQL tools are retried only when they could not reach the server,
+// and HTTP responses unless they are a client error other than 408 or
+// 429: an authentication, permission or missing-bucket response will not
+// change. Usage, authentication, missing database, full disk, missing
+// tools, locks, failed verification and missing extensions are permanent.
+// Other errors, such as those from plugins, are usually network trouble
+// and are treated as transient.
+func isTransient(err error) bool {
+	switch {
+	case errors.Is(err, ErrConnection):
+		return true
+	case errors.Is(err, ErrUsage), errors.Is(err, ErrDatabaseNotFound), errors.Is(err, ErrAuthFailed),
+		errors.Is(err, ErrDiskFull), errors.Is(err, ErrToolMissing), errors.Is(err, ErrLocked),
+		errors.Is(err, ErrVerification), errors.Is(err, ErrMissingExtension), errors.Is(err, ErrDatabaseExists):
+		return false
+	}
+	var status interface{ httpStatus() int }
+	if errors.As(err, &status) {
+		code := status.httpStatus()
+		return code/100 != 4 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	}
+	var exitErr *ExitError
+	return !errors.As(err, &exitErr)
+}
@@ -0,0 +1,98 @@
+package pgtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&ExitError{Tool: "pg_dump", Kind: ErrConnection, Err: errors.New("exit status 1")}, true},
+		{&ExitError{Tool: "pg_dump", Kind: ErrAuthFailed, Err: errors.New("exit status 1")}, false},
+		{&ExitError{Tool: "pg_dump", Kind: ErrDatabaseNotFound, Err: errors.New("exit status 1")}, false},
+		{&ExitError{Tool: "pg_dump", Err: errors.New("exit status 1")}, false},
+		{fmt.Errorf("%w: bad flag", ErrUsage), false},
+		{fmt.Errorf("writing: %w", ErrDiskFull), false},
+		{ErrToolMissing, false},
+		{errors.New("upload: connection reset by peer"), true},
+		{fmt.Errorf("backup lock: %w", ErrLocked), false},
+		{fmt.Errorf("%w: checksum mismatch", ErrVerification), false},
+		{fmt.Errorf("%w: postgis", ErrMissingExtension), false},
+		{&statusError{Op: "PUT https://dav.example.com/app.dump.gz", Code: 401, Status: "401 Unauthorized"}, false},
+		{&statusError{Op: "PagerDuty", Code: 403, Status: "403 Forbidden"}, false},
+		{fmt.Errorf("upload: %w", &b2Error{Status: 404, Code: "not_found"}), false},
+		{&statusError{Op: "webhook", Code: 408, Status: "408 Request Timeout"}, true},
+		{&b2Error{Status: 429, Code: "too_many_requests"}, true},
+		{&statusError{Op: "PUT https://dav.example.com/app.dump.gz", Code: 503, Status: "503 Service Unavailable"}, true},
+	}
+	for _, tt := range tests {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	transient := &ExitError{Tool: "pg_dump", Kind: ErrConnection, Err: errors.New("exit status 1")}
+	permanent := &ExitError{Tool: "pg_dump", Kind: ErrAuthFailed, Err: errors.New("exit status 1")}
+	tests := []struct {
+		name         string
+		attempts     int
+		errs         []error // returned by successive calls; nil after the last
+		wantCalls    int
+		wantErr      error
+		wantRetries  int
+		wantBackoffs []time.Duration
+	}{
+		{"success", 3, nil, 1, nil, 0, nil},
+		{"no retries", 1, []error{transient}, 1, transient, 0, nil},
+		{"retried until success", 3, []error{transient, transient}, 3, nil, 2, []time.Duration{time.Millisecond, 2 * time.Millisecond}},
+		{"attempts used up", 3, []error{transient, transient, transient, transient}, 3, transient, 2, nil},
+		{"permanent", 3, []error{permanent}, 1, permanent, 0, nil},
+		{"permanent after transient", 3, []error{transient, permanent}, 2, permanent, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := RetryPolicy{Attempts: tt.attempts, Backoff: time.Millisecond}
+			calls := 0
+			var backoffs []time.Duration
+			err := p.do(context.Background(), func(attempt int, delay time.Duration, err error) {
+				backoffs = append(backoffs, delay)
+			}, func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if err != tt.wantErr {
+				t.Errorf("err %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls || len(backoffs) != tt.wantRetries {
+				t.Errorf("%d calls and %d retries, want %d and %d", calls, len(backoffs), tt.wantCalls, tt.wantRetries)
+			}
+			if tt.wantBackoffs != nil && fmt.Sprint(backoffs) != fmt.Sprint(tt.wantBackoffs) {
+				t.Errorf("backoffs %v, want %v", backoffs, tt.wantBackoffs)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := RetryPolicy{Attempts: 5, Backoff: time.Hour}
+	calls := 0
+	err := p.do(ctx, func(int, time.Duration, error) { cancel() }, func() error {
+		calls++
+		return errors.New("network down")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("err %v after %d calls, want the first error without waiting for the backoff", err, calls)
+	}
+}
@@ -0,0 +1,189 @@
+package pgtool
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// needsRewrite reports whether restore must go through a SQL script so it
+// can be rewritten on the way to the database, for masking or remapping.
+func (c Config) needsRewrite() bool {
+	return c.Masking != nil || len(c.RoleMap) > 0 || len(c.TablespaceMap) > 0
+}
+
+// identPattern matches a name, plain, double-quoted as an identifier or
+// single-quoted as a string.
+const identPattern = `("(?:[^"]|"")+"|'[^']*'|[A-Za-z_][A-Za-z0-9_$]*)`
+
+var (
+	copyHeader = regexp.MustCompile(`^COPY (\S+)(?: \((.*)\))? FROM stdin;$`)
+
+	// Role names appear in these statements of pg_dump's output, each on
+	// a line of its own: GRANT ... TO, REVOKE ... FROM, ALTER DEFAULT
+	// PRIVILEGES FOR ROLE, CREATE SCHEMA ... AUTHORIZATION and SET SESSION
+	// AUTHORIZATION, and at the end of ALTER ... OWNER TO. Other lines,
+	// such as view definitions, may say FROM or TO followed by anything.
+	roleStatement = regexp.MustCompile(`^(GRANT|REVOKE|ALTER DEFAULT PRIVILEGES|CREATE SCHEMA|SET SESSION AUTHORIZATION) `)
+	roleRef       = regexp.MustCompile(`\b(TO|FROM|FOR ROLE|AUTHORIZATION|GRANTED BY) ` + identPattern)
+	ownerRef      = regexp.MustCompile(`^ALTER .* (OWNER TO) ` + identPattern + `;\n?$`)
+	tablespaceRef = regexp.MustCompile(`\b(TABLESPACE|default_tablespace =) ` + identPattern)
+
+	// dollarQuote matches the quotes of dollar-quoted strings, such as
+	// function bodies: $$ or $tag$, but not a$b$ in a name.
+	dollarQuote = regexp.MustCompile(`(^|[^A-Za-z0-9_$])(\$(?:[A-Za-z_][A-Za-z0-9_]*)?\$)`)
+)
+
+// rewriteScript copies a plain SQL script written by pg_restore from src
+// to dst, masking COPY data and renaming roles and tablespaces as cfg
+// asks. Data rows and dollar-quoted function bodies are never matched
+// against the role and tablespace patterns.
+func rewriteScript(dst io.Writer, src io.Reader, cfg Config) error {
+	br := bufio.NewReaderSize(src, 256*1024)
+	bw := bufio.NewWriterSize(dst, 256*1024)
+	var rules []*MaskRule
+	inCopy := false
+	body := "" // the quote of the dollar-quoted string the line starts in
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			switch {
+			case inCopy && line == "\\.\n":
+				inCopy, rules = false, nil
+			case inCopy && rules != nil:
+				line = maskRow(line, rules, cfg.Masking)
+			case inCopy:
+			default:
+				if m := copyHeader.FindStringSubmatch(strings.TrimSuffix(line, "\n")); m != nil {
+					inCopy = true
+					if cfg.Masking != nil && m[2] == "" && len(cfg.Masking.tableRules(m[1])) > 0 {
+						// Subset files of older versions do not name the columns
+						return fmt.Errorf("%w: cannot mask %s, whose COPY data does not name its columns", ErrUsage, m[1])
+					}
+					if cfg.Masking != nil {
+						rules = cfg.Masking.rulesFor(m[1], strings.Split(m[2], ", "))
+					}
+					break
+				}
+				inBody := body != ""
+				body = dollarQuoted(line, body)
+				if inBody {
+					break
+				}
+				if roleStatement.MatchString(line) {
+					line = remapNames(line, roleRef, cfg.RoleMap)
+				} else {
+					line = remapNames(line, ownerRef, cfg.RoleMap)
+				}
+				line = remapNames(line, tablespaceRef, cfg.TablespaceMap)
+			}
+			if _, werr := bw.WriteString(line); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return bw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// dollarQuoted returns the quote, such as $$ or $body$, of the
+// dollar-quoted string open at the end of line, given the one open at its
+// start, or "" if there is none.
+func dollarQuoted(line, open string) string {
+	for _, m := range dollarQuote.FindAllStringSubmatch(line, -1) {
+		switch {
+		case open == "":
+			open = m[2]
+		case m[2] == open:
+			open = ""
+		}
+	}
+	return open
+}
+
+// remapNames replaces the names captured by the second group of re that
+// have an entry in m, keeping their quoting style.
+func remapNames(line string, re *regexp.Regexp, m map[string]string) string {
+	if len(m) == 0 {
+		return line
+	}
+	var b strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(line, -1) {
+		start, end := loc[4], loc[5]
+		name, quote := line[start:end], ""
+		switch name[0] {
+		case '"':
+			name, quote = unquoteIdent(name), `"`
+		case '\'':
+			name, quote = name[1:len(name)-1], `'`
+		}
+		to, ok := m[name]
+		if !ok {
+			continue
+		}
+		if quote == `"` {
+			to = `"` + strings.ReplaceAll(to, `"`, `""`) + `"`
+		} else if quote == `'` {
+			to = `'` + to + `'`
+		}
+		b.WriteString(line[last:start])
+		b.WriteString(to)
+		last = end
+	}
+	b.WriteString(line[last:])
+	return b.String()
+}
+
+// restoreRewritten restores by having pg_restore emit a SQL script,
+// rewriting it in flight and feeding the result to psql, so masked values
+// never reach the target database.
+func restoreRewritten(ctx context.Context, cfg Config, arg string, stdin io.Reader, stderr io.Writer) error {
+	args := append([]string{"-f", "-"}, cfg.restoreOptions(arg)...)
+	sqlR, sqlW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	dumpDone := make(chan error, 1)
+	go func() {
+		err := runCommand(ctx, cfg.runner(), Command{Name: "pg_restore", Args: args, Stdin: stdin, Stdout: sqlW}, stderr)
+		sqlW.CloseWithError(err)
+		dumpDone <- err
+	}()
+	go func() {
+		err := rewriteScript(outW, sqlR, cfg)
+		outW.CloseWithError(err)
+		sqlR.CloseWithError(io.ErrClosedPipe)
+	}()
+
+	// Like pg_restore, psql keeps going after errors unless -exit-on-error
+	// is given, and the restore fails if there were any
+	onErrorStop := "ON_ERROR_STOP=0"
+	if cfg.ExitOnError {
+		onErrorStop = "ON_ERROR_STOP=1"
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	psqlLog := newDiagCounter(stderr)
+	c := Command{
+		Name:  "psql",
+		Args:  append(cfg.connArgs(), "-X", "-q", "-v", onErrorStop, "-f", "-"),
+		Stdin: outR,
+	}
+	loadErr := runCommand(ctx, cfg.runner(), c, psqlLog)
+	outR.CloseWithError(io.ErrClosedPipe)
+	if _, n := psqlLog.counts(); loadErr == nil && n > 0 {
+		loadErr = fmt.Errorf("psql: errors ignored on restore: %d", n)
+	}
+	if err := <-dumpDone; err != nil {
+		return err
+	}
+	return loadErr
+}
@@ -0,0 +1,111 @@
+package pgtool
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRewriteScriptRoles(t *testing.T) {
+	cfg := Config{
+		RoleMap:       map[string]string{"app": "app_staging", "Old Role": "new"},
+		TablespaceMap: map[string]string{"fast": "pg_default"},
+	}
+	tests := []struct {
+		name, in, want string
+	}{
+		{"owner", "ALTER TABLE public.t OWNER TO app;\n", "ALTER TABLE public.t OWNER TO app_staging;\n"},
+		{"quoted owner", "ALTER SCHEMA s OWNER TO \"Old Role\";\n", "ALTER SCHEMA s OWNER TO \"new\";\n"},
+		{"owner of a schema named like the role", "ALTER SCHEMA app OWNER TO app;\n", "ALTER SCHEMA app OWNER TO app_staging;\n"},
+		{"other owner", "ALTER TABLE public.t OWNER TO postgres;\n", "ALTER TABLE public.t OWNER TO postgres;\n"},
+		{"grant", "GRANT SELECT ON TABLE app.t TO app;\n", "GRANT SELECT ON TABLE app.t TO app_staging;\n"},
+		{"revoke", "REVOKE ALL ON SCHEMA app FROM app;\n", "REVOKE ALL ON SCHEMA app FROM app_staging;\n"},
+		{"granted by", "GRANT admin TO app GRANTED BY app;\n", "GRANT admin TO app_staging GRANTED BY app_staging;\n"},
+		{"default privileges",
+			"ALTER DEFAULT PRIVILEGES FOR ROLE app IN SCHEMA app GRANT SELECT ON TABLES TO app;\n",
+			"ALTER DEFAULT PRIVILEGES FOR ROLE app_staging IN SCHEMA app GRANT SELECT ON TABLES TO app_staging;\n"},
+		{"schema authorization", "CREATE SCHEMA app AUTHORIZATION app;\n", "CREATE SCHEMA app AUTHORIZATION app_staging;\n"},
+		{"session authorization", "SET SESSION AUTHORIZATION 'app';\n", "SET SESSION AUTHORIZATION 'app_staging';\n"},
+		{"view selecting from a schema named like the role",
+			"CREATE VIEW public.v AS\n SELECT t.id\n   FROM app.t;\n",
+			"CREATE VIEW public.v AS\n SELECT t.id\n   FROM app.t;\n"},
+		{"view on one line", "CREATE VIEW public.v AS SELECT 1 FROM app;\n", "CREATE VIEW public.v AS SELECT 1 FROM app;\n"},
+		{"rename column", "ALTER TABLE public.t RENAME COLUMN a TO app;\n", "ALTER TABLE public.t RENAME COLUMN a TO app;\n"},
+		{"check constraint", "ALTER TABLE ONLY public.t ADD CONSTRAINT c CHECK ((EXTRACT(year FROM app) > 2000));\n",
+			"ALTER TABLE ONLY public.t ADD CONSTRAINT c CHECK ((EXTRACT(year FROM app) > 2000));\n"},
+		{"function body",
+			"CREATE FUNCTION public.f() RETURNS void\n    LANGUAGE plpgsql\n    AS $$\nBEGIN\nGRANT SELECT ON app.t TO app;\nALTER TABLE app.t OWNER TO app;\nEND\n$$;\nALTER FUNCTION public.f() OWNER TO app;\n",
+			"CREATE FUNCTION public.f() RETURNS void\n    LANGUAGE plpgsql\n    AS $$\nBEGIN\nGRANT SELECT ON app.t TO app;\nALTER TABLE app.t OWNER TO app;\nEND\n$$;\nALTER FUNCTION public.f() OWNER TO app_staging;\n"},
+		{"tagged function body with nested quotes",
+			"CREATE FUNCTION public.g() RETURNS text\n    AS $body$\nSELECT $$x$$;\nREVOKE ALL ON app.t FROM app;\n$body$;\nREVOKE ALL ON FUNCTION public.g() FROM app;\n",
+			"CREATE FUNCTION public.g() RETURNS text\n    AS $body$\nSELECT $$x$$;\nREVOKE ALL ON app.t FROM app;\n$body$;\nREVOKE ALL ON FUNCTION public.g() FROM app_staging;\n"},
+		{"one-line function body", "CREATE FUNCTION public.h() RETURNS int AS $$ SELECT 1 FROM app $$;\nGRANT ALL ON TABLE t TO app;\n",
+			"CREATE FUNCTION public.h() RETURNS int AS $$ SELECT 1 FROM app $$;\nGRANT ALL ON TABLE t TO app_staging;\n"},
+		{"tablespace", "SET default_tablespace = fast;\n", "SET default_tablespace = pg_default;\n"},
+		{"copy data", "COPY public.t (id, role) FROM stdin;\n1\tGRANT x TO app\n\\.\nALTER TABLE public.t OWNER TO app;\n",
+			"COPY public.t (id, role) FROM stdin;\n1\tGRANT x TO app\n\\.\nALTER TABLE public.t OWNER TO app_staging;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := rewriteScript(&out, strings.NewReader(tt.in), cfg); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("rewriteScript:\n%s\nwant:\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreRewritten(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         Config
+		psqlStderr  string
+		wantRestore []string
+		wantStop    string
+		wantErr     bool
+	}{
+		{"role and triggers", Config{IfExists: IfExistsDrop, Role: "owner", Sections: []string{"data"}, DisableTriggers: true, Superuser: "postgres", ExitOnError: true},
+			"", []string{"-f", "-", "--role=owner", "--exit-on-error", "--section=data", "--disable-triggers", "--superuser=postgres", "app.dump"},
+			"ON_ERROR_STOP=1", false},
+		{"errors ignored", Config{RestoreProgress: true},
+			"psql:<stdin>:12: ERROR:  role \"app\" does not exist\n", []string{"-f", "-", "--clean", "--verbose", "app.dump"},
+			"ON_ERROR_STOP=0", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			cmds := map[string][]string{}
+			cfg := tt.cfg
+			cfg.User, cfg.Host, cfg.Database = "pgtool", "db2", "app"
+			cfg.RoleMap = map[string]string{"app": "app_staging"}
+			cfg.Runner = runnerFunc(func(ctx context.Context, c Command) error {
+				mu.Lock()
+				cmds[c.Name] = c.Args
+				mu.Unlock()
+				if c.Name == "pg_restore" {
+					io.WriteString(c.Stdout, "ALTER TABLE public.t OWNER TO app;\n")
+					return nil
+				}
+				io.Copy(io.Discard, c.Stdin)
+				io.WriteString(c.Stderr, tt.psqlStderr)
+				return nil
+			})
+			err := restoreRewritten(context.Background(), cfg, "app.dump", nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("restoreRewritten: %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(cmds["pg_restore"], tt.wantRestore) {
+				t.Errorf("pg_restore args %q, want %q", cmds["pg_restore"], tt.wantRestore)
+			}
+			if !slices.Contains(cmds["psql"], tt.wantStop) {
+				t.Errorf("psql args %q, want %s", cmds["psql"], tt.wantStop)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Command is an external program invocation made by backup or restore.
+type Command struct {
+	Name   string
+	Args   []string
+	Env    []string // added to the runner's environment
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Runner runs external commands such as pg_dump, pg_restore and psql.
+// Backup and restore only talk to PostgreSQL through a Runner, so tests
+// can substitute a fake and the tools can run somewhere else entirely.
+type Runner interface {
+	Run(ctx context.Context, c Command) error
+}
+
+// killGrace is how long a cancelled command gets to exit after SIGTERM
+// before it is killed.
+const killGrace = 10 * time.Second
+
+// ExecRunner runs commands on the local machine. Each command gets its
+// own process group, and cancelling ctx sends SIGTERM to the whole group
+// so that helpers such as pg_dump's parallel workers stop too.
+type ExecRunner struct{}
+
+func (ExecRunner) Run(ctx context.Context, c Command) error {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = killGrace
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = c.Stdin, c.Stdout, c.Stderr
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	return cmd.Run()
+}
+
+// PrefixRunner runs commands through a wrapper command, such as ssh,
+// docker exec or kubectl exec. Stdin and stdout are passed through, so
+// dumps still land on the local machine. The wrapper does not pass on the
+// command's environment, so PGOPTIONS is set on the other side with env;
+// other variables, notably PGPASSWORD, are not forwarded that way.
+type PrefixRunner struct {
+	Prefix []string
+	// Quote shell-quotes the wrapped command, for wrappers like ssh that
+	// hand it to a remote shell.
+	Quote bool
+	Base  Runner
+}
+
+func (r PrefixRunner) Run(ctx context.Context, c Command) error {
+	var env, forward []string
+	for _, kv := range c.Env {
+		if strings.HasPrefix(kv, "PGOPTIONS=") {
+			forward = append(forward, kv)
+		} else {
+			env = append(env, kv)
+		}
+	}
+	args := append([]string{c.Name}, c.Args...)
+	if len(forward) > 0 {
+		args = append(append([]string{"env"}, forward...), args...)
+	}
+	c.Env = env
+	if r.Quote {
+		for i, a := range args {
+			args[i] = shellQuote(a)
+		}
+	}
+	wrapped := c
+	wrapped.Name = r.Prefix[0]
+	wrapped.Args = append(append([]string{}, r.Prefix[1:]...), args...)
+	base := r.Base
+	if base == nil {
+		base = ExecRunner{}
+	}
+	return base.Run(ctx, wrapped)
+}
+
+// BinDirRunner runs the PostgreSQL client tools from Dir, e.g.
+// /usr/lib/postgresql/16/bin, so that a host with several versions
+// installed uses the one matching the server.
+type BinDirRunner struct {
+	Dir  string
+	Base Runner
+}
+
+func (r BinDirRunner) Run(ctx context.Context, c Command) error {
+	c.Name = filepath.Join(r.Dir, c.Name)
+	return r.Base.Run(ctx, c)
+}
+
+// EnvRunner adds Env to the environment of every command, e.g. the
+// PGPASSWORD or PGPASSFILE of one server in a fleet. It takes precedence
+// over the PGPASSWORD passed through from pgtool's own environment.
+type EnvRunner struct {
+	Env  []string
+	Base Runner
+}
+
+func (r EnvRunner) Run(ctx context.Context, c Command) error {
+	c.Env = append(c.Env[:len(c.Env):len(c.Env)], r.Env...)
+	return r.Base.Run(ctx, c)
+}
+
+// SSHRunner runs commands on host over ssh. The remote side must find its
+// own credentials, e.g. in ~/.pgpass, since ssh does not forward PGPASSWORD.
+func SSHRunner(host string) Runner {
+	return PrefixRunner{Prefix: []string{"ssh", "-T", host, "--"}, Quote: true}
+}
+
+// DockerRunner runs commands inside a running container, forwarding
+// PGPASSWORD from the local environment.
+func DockerRunner(container string) Runner {
+	return PrefixRunner{Prefix: []string{"docker", "exec", "-i", "-e", "PGPASSWORD", container}}
+}
+
+// KubectlRunner runs commands inside a pod. Like SSHRunner, the pod must
+// provide its own credentials.
+func KubectlRunner(pod string) Runner {
+	return PrefixRunner{Prefix: []string{"kubectl", "exec", "-i", pod, "--"}}
+}
+
+// parseRunner parses the -exec-via flag: "", "ssh:HOST", "docker:CONTAINER"
+// or "kubectl:POD".
+func parseRunner(spec string) (Runner, error) {
+	if spec == "" {
+		return ExecRunner{}, nil
+	}
+	kind, target, ok := strings.Cut(spec, ":")
+	if !ok || target == "" {
+		return nil, fmt.Errorf("%w: -exec-via must look like ssh:HOST, docker:CONTAINER or kubectl:POD", ErrUsage)
+	}
+	switch kind {
+	case "ssh":
+		return SSHRunner(target), nil
+	case "docker":
+		return DockerRunner(target), nil
+	case "kubectl":
+		return KubectlRunner(target), nil
+	}
+	return nil, fmt.Errorf("%w: unknown -exec-via kind '%s'", ErrUsage, kind)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runCommand runs c with r, passing PGPASSWORD through and copying the
+// command's stderr to stderr while keeping the tail for the returned
+// *ExitError.
+func runCommand(ctx context.Context, r Runner, c Command, stderr io.Writer) error {
+	var tail tailBuffer
+	if stderr != nil {
+		c.Stderr = io.MultiWriter(stderr, &tail)
+	} else {
+		c.Stderr = &tail
+	}
+
+	// Pass password from env if set
+	if pw := os.Getenv("PGPASSWORD"); pw != "" {
+		c.Env = append(c.Env, fmt.Sprintf("PGPASSWORD=%s", pw))
+	}
+	return toolError(filepath.Base(c.Name), r.Run(ctx, c), tail.String())
+}
+
+// runsLocally reports whether commands run by r see the local filesystem.
+func runsLocally(r Runner) bool {
+	if b, ok := r.(BinDirRunner); ok {
+		r = b.Base
+	}
+	if e, ok := r.(EnvRunner); ok {
+		r = e.Base
+	}
+	_, ok := r.(ExecRunner)
+	return ok
+}
+
+// fileInput returns how a command should read the local file path: as a
+// file argument when running locally, or on stdin for runners that cannot
+// see the local filesystem. The returned reader, if any, must be closed.
+func fileInput(r Runner, path string) (string, io.ReadCloser, error) {
+	if runsLocally(r) {
+		return path, nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return "", f, nil
+}
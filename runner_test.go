@@ -0,0 +1,54 @@
+package pgtool
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestPrefixRunnerForwardsPGOPTIONS(t *testing.T) {
+	c := Command{Name: "pg_dump", Args: []string{"-Fc", "app"}, Env: []string{"PGOPTIONS=-c lock_timeout=30000", "PGPASSWORD=secret"}}
+	tests := []struct {
+		name     string
+		r        PrefixRunner
+		wantArgs []string
+	}{
+		{"ssh", SSHRunner("db1").(PrefixRunner),
+			[]string{"-T", "db1", "--", "'env'", "'PGOPTIONS=-c lock_timeout=30000'", "'pg_dump'", "'-Fc'", "'app'"}},
+		{"docker", DockerRunner("pg").(PrefixRunner),
+			[]string{"exec", "-i", "-e", "PGPASSWORD", "pg", "env", "PGOPTIONS=-c lock_timeout=30000", "pg_dump", "-Fc", "app"}},
+		{"kubectl", KubectlRunner("pg-0").(PrefixRunner),
+			[]string{"exec", "-i", "pg-0", "--", "env", "PGOPTIONS=-c lock_timeout=30000", "pg_dump", "-Fc", "app"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cmds []Command
+			tt.r.Base = recordRunner{&cmds}
+			if err := tt.r.Run(context.Background(), c); err != nil {
+				t.Fatal(err)
+			}
+			got := cmds[0]
+			if !slices.Equal(got.Args, tt.wantArgs) {
+				t.Errorf("args %q, want %q", got.Args, tt.wantArgs)
+			}
+			// PGPASSWORD stays in the local environment, for docker -e
+			if !slices.Equal(got.Env, []string{"PGPASSWORD=secret"}) {
+				t.Errorf("env %q, want only PGPASSWORD", got.Env)
+			}
+		})
+	}
+}
+
+func TestPrefixRunnerWithoutPGOPTIONS(t *testing.T) {
+	var cmds []Command
+	r := PrefixRunner{Prefix: []string{"kubectl", "exec", "-i", "pg-0", "--"}, Base: recordRunner{&cmds}}
+	r.Run(context.Background(), Command{Name: "psql", Args: []string{"-X"}})
+	if want := []string{"exec", "-i", "pg-0", "--", "psql", "-X"}; !slices.Equal(cmds[0].Args, want) {
+		t.Errorf("args %q, want %q", cmds[0].Args, want)
+	}
+}
+
+// runnerFunc adapts a function to a Runner.
+type runnerFunc func(ctx context.Context, c Command) error
+
+func (f runnerFunc) Run(ctx context.Context, c Command) error { return f(ctx, c) }
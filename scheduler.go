@@ -0,0 +1,123 @@
+package pgtool
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy retries a failed job run with exponential backoff.
+type RetryPolicy struct {
+	Attempts int           // total attempts; 0 or 1 means no retries
+	Backoff  time.Duration // delay before the first retry, doubled each time
+}
+
+// Job is a unit of scheduled work.
+type Job struct {
+	Name     string
+	Schedule Schedule
+	// Jitter delays each run by a random duration up to this long, so
+	// many hosts on the same schedule don't start at the same second.
+	Jitter time.Duration
+	Retry  RetryPolicy
+	Run    func(ctx context.Context) error
+}
+
+// Scheduler runs jobs on their cron schedules. A run that is still in
+// progress when its job fires again causes that firing to be skipped
+// rather than overlapping.
+type Scheduler struct {
+	Jobs []Job
+
+	// Hooks for logging; any may be nil.
+	OnRun   func(job string)
+	OnSkip  func(job, reason string)
+	OnError func(job string, attempt int, err error)
+	OnDone  func(job string, err error)
+}
+
+// Run schedules all jobs and blocks until ctx is done, then waits for
+// in-flight runs to finish (they see the cancelled context) and returns
+// ctx.Err().
+func (s *Scheduler) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, job := range s.Jobs {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	var running sync.Mutex
+	var runs sync.WaitGroup
+	defer runs.Wait()
+	for {
+		next := job.Schedule.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+		if job.Jitter > 0 {
+			next = next.Add(time.Duration(rand.Int63n(int64(job.Jitter))))
+		}
+		if !sleepUntil(ctx, next) {
+			return
+		}
+		if !running.TryLock() {
+			if s.OnSkip != nil {
+				s.OnSkip(job.Name, "previous run still in progress")
+			}
+			continue
+		}
+		runs.Add(1)
+		go func() {
+			defer runs.Done()
+			defer running.Unlock()
+			err := s.runOnce(ctx, job)
+			if s.OnDone != nil {
+				s.OnDone(job.Name, err)
+			}
+		}()
+	}
+}
+
+// runOnce runs job, retrying according to its RetryPolicy.
+func (s *Scheduler) runOnce(ctx context.Context, job Job) error {
+	if s.OnRun != nil {
+		s.OnRun(job.Name)
+	}
+	backoff := job.Retry.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = job.Run(ctx); err == nil {
+			return nil
+		}
+		if s.OnError != nil {
+			s.OnError(job.Name, attempt, err)
+		}
+		if attempt >= job.Retry.Attempts || ctx.Err() != nil {
+			return err
+		}
+		if !sleepUntil(ctx, time.Now().Add(backoff)) {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// sleepUntil waits until t, returning false if ctx is done first.
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
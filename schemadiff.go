@@ -0,0 +1,189 @@
+package pgtool
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SchemaObject is one entry of a plain-format schema dump, e.g. a table
+// or an index, with the SQL that defines it.
+type SchemaObject struct {
+	Type   string // "TABLE", "INDEX", "FUNCTION", ...
+	Schema string
+	Name   string
+	Lines  []string // significant SQL lines, comments and blanks dropped
+}
+
+func (o SchemaObject) key() string {
+	if o.Schema == "" || o.Schema == "-" {
+		return o.Type + " " + o.Name
+	}
+	return o.Type + " " + o.Schema + "." + o.Name
+}
+
+// Every object in pg_dump's plain output is introduced by a header like
+// "-- Name: users; Type: TABLE; Schema: public; Owner: app".
+var tocHeader = regexp.MustCompile(`^-- (?:Data for )?Name: (.*); Type: (.*); Schema: (.*); Owner: .*$`)
+
+// parseSchema splits a plain-format schema dump into its objects, keyed by
+// type and qualified name.
+func parseSchema(r io.Reader) (map[string]SchemaObject, error) {
+	objects := make(map[string]SchemaObject)
+	var cur *SchemaObject
+	flush := func() {
+		if cur != nil {
+			objects[cur.key()] = *cur
+		}
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if m := tocHeader.FindStringSubmatch(line); m != nil {
+			flush()
+			cur = &SchemaObject{Name: m[1], Type: m[2], Schema: m[3]}
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if cur == nil || trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		cur.Lines = append(cur.Lines, strings.TrimSuffix(trimmed, ","))
+	}
+	flush()
+	return objects, sc.Err()
+}
+
+// SchemaChange is one difference between two schemas.
+type SchemaChange struct {
+	Op      byte   // '+' added, '-' removed, '~' changed
+	Object  string // e.g. "TABLE public.users"
+	Added   []string
+	Removed []string
+}
+
+// diffSchemas compares two parsed schemas and returns the changes needed
+// to go from old to new, sorted by object.
+func diffSchemas(old, new map[string]SchemaObject) []SchemaChange {
+	var changes []SchemaChange
+	for k, o := range old {
+		n, ok := new[k]
+		if !ok {
+			changes = append(changes, SchemaChange{Op: '-', Object: k})
+			continue
+		}
+		added, removed := diffLines(o.Lines, n.Lines)
+		if len(added) > 0 || len(removed) > 0 {
+			changes = append(changes, SchemaChange{Op: '~', Object: k, Added: added, Removed: removed})
+		}
+	}
+	for k := range new {
+		if _, ok := old[k]; !ok {
+			changes = append(changes, SchemaChange{Op: '+', Object: k})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Object < changes[j].Object })
+	return changes
+}
+
+// diffLines returns the lines only in b and the lines only in a. Order
+// is ignored, which suits column lists and similar definitions.
+func diffLines(a, b []string) (added, removed []string) {
+	count := make(map[string]int)
+	for _, l := range a {
+		count[l]++
+	}
+	for _, l := range b {
+		if count[l] > 0 {
+			count[l]--
+			continue
+		}
+		added = append(added, l)
+	}
+	for _, l := range a {
+		if count[l] > 0 {
+			count[l]--
+			removed = append(removed, l)
+		}
+	}
+	return added, removed
+}
+
+// printSchemaDiff writes changes in a readable form.
+func printSchemaDiff(w io.Writer, changes []SchemaChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No differences.")
+		return
+	}
+	for _, c := range changes {
+		fmt.Fprintf(w, "%c %s\n", c.Op, c.Object)
+		for _, l := range c.Removed {
+			fmt.Fprintf(w, "    - %s\n", l)
+		}
+		for _, l := range c.Added {
+			fmt.Fprintf(w, "    + %s\n", l)
+		}
+	}
+}
+
+// databaseSchema returns the schema of the configured database as a
+// plain-format dump.
+func databaseSchema(ctx context.Context, cfg Config) (string, error) {
+	var out strings.Builder
+	c := Command{Name: "pg_dump", Args: append(cfg.connArgs(), "-s"), Stdout: &out}
+	if err := runCommand(ctx, cfg.runner(), c, nil); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// backupSchema returns the schema stored in a compressed backup as a
+// plain-format dump.
+func backupSchema(ctx context.Context, cfg Config, backupFile string) (string, error) {
+	tempFile, err := decompressTemp(ctx, backupFile)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tempFile)
+
+	arg, stdin, err := fileInput(cfg.runner(), tempFile)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	c := Command{Name: "pg_restore", Args: []string{"-s", "-f", "-"}, Stdout: &out}
+	if arg != "" {
+		c.Args = append(c.Args, arg)
+	}
+	if stdin != nil {
+		defer stdin.Close()
+		c.Stdin = stdin
+	}
+	if err := runCommand(ctx, cfg.runner(), c, nil); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// SchemaDiff compares the plain-format schema dumps returned by old and
+// new, such as databaseSchema or backupSchema, and returns the changes
+// from old to new.
+func SchemaDiff(ctx context.Context, old, new func(context.Context) (string, error)) ([]SchemaChange, error) {
+	var schemas [2]map[string]SchemaObject
+	for i, get := range []func(context.Context) (string, error){old, new} {
+		sql, err := get(ctx)
+		if err != nil {
+			return nil, contextErr(ctx, err)
+		}
+		if schemas[i], err = parseSchema(strings.NewReader(sql)); err != nil {
+			return nil, err
+		}
+	}
+	return diffSchemas(schemas[0], schemas[1]), nil
+}
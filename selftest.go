@@ -0,0 +1,169 @@
+package pgtool
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// selftestDatabase is the database selftest creates, backs up and
+// restores as selftestDatabase + "_restored".
+const selftestDatabase = "pgtool_selftest"
+
+// selftestSchema is the sample data of selftest: tables with a foreign
+// key, an index, a view and enough rows to span several pages.
+const selftestSchema = `
+CREATE TABLE customers (id serial PRIMARY KEY, name text NOT NULL, email text UNIQUE);
+CREATE TABLE orders (
+	id bigserial PRIMARY KEY,
+	customer_id int NOT NULL REFERENCES customers,
+	total numeric(10,2) NOT NULL,
+	placed_at timestamptz NOT NULL,
+	note jsonb
+);
+CREATE INDEX orders_placed_at ON orders (placed_at);
+CREATE VIEW customer_totals AS
+	SELECT customer_id, count(*) AS orders, sum(total) AS total FROM orders GROUP BY customer_id;
+COMMENT ON TABLE orders IS 'pgtool selftest';
+INSERT INTO customers (name, email)
+	SELECT 'Customer ' || i, 'customer' || i || '@example.com' FROM generate_series(1, 1000) i;
+INSERT INTO orders (customer_id, total, placed_at, note)
+	SELECT 1 + i % 1000, (i % 9973) / 100.0, timestamptz '2024-01-01' + i * interval '1 minute',
+		CASE WHEN i % 10 = 0 THEN jsonb_build_object('gift', true, 'n', i) END
+	FROM generate_series(1, 20000) i;
+`
+
+// selftestTables are compared row for row after the restore.
+var selftestTables = []string{"customers", "orders"}
+
+// SelftestOptions configures a selftest.
+type SelftestOptions struct {
+	// Version is the postgres image tag, e.g. "16"; empty means "latest".
+	Version string
+	// Keep keeps the working directory, with its backups and log, even
+	// when every step passed.
+	Keep bool
+}
+
+// Selftest checks that backups made with cfg can be restored: it starts
+// a postgres container, creates sample data in it and runs it through a
+// backup, a verify of the backup's checksums, a restore into a second
+// database and a comparison of their schemas and rows, printing PASS or
+// FAIL for each step. Unlike restore -ephemeral, the local client tools
+// do the work, as in a real backup, so it also checks -pg-bindir and
+// the tools' versions against the server's.
+//
+// cfg's connection, runner, backup directory, log file and notifiers are
+// replaced; the rest of it, including any -storage, is used as given.
+// The working directory is kept if a step fails, for its log.
+func Selftest(ctx context.Context, cfg Config, opts SelftestOptions) error {
+	image := "postgres:" + cmp.Or(opts.Version, "latest")
+	dir, err := os.MkdirTemp("", "pgtool-selftest-")
+	if err != nil {
+		return err
+	}
+	srv, err := startEphemeral(ctx, image)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	defer srv.remove()
+
+	_, port, _ := strings.Cut(srv.Addr, ":")
+	cfg.User, cfg.Host, cfg.Database = "postgres", "127.0.0.1", selftestDatabase
+	cfg.Runner = EnvRunner{Env: []string{"PGPORT=" + port, "PGPASSWORD=" + srv.Password}, Base: ExecRunner{}}
+	cfg.BackupDir, cfg.LogFile = filepath.Join(dir, "backups"), filepath.Join(dir, "pgtool.log")
+	cfg.Notifiers, cfg.Window, cfg.IgnoreBlackouts = nil, nil, true
+	if err := os.Mkdir(cfg.BackupDir, 0755); err != nil {
+		return err
+	}
+	restored := cfg
+	restored.Database = selftestDatabase + "_restored"
+	restored.IfExists = IfExistsFail // creates the database
+
+	var backupFile string
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"create sample data", func() error {
+			if _, err := queryScalar(ctx, cfg.maintenance(), "CREATE DATABASE "+selftestDatabase); err != nil {
+				return err
+			}
+			_, err := queryScalar(ctx, cfg, selftestSchema)
+			return err
+		}},
+		{"backup", func() error {
+			if err := runBackup(ctx, cfg); err != nil {
+				return err
+			}
+			var err error
+			backupFile, err = latestBackup(cfg.BackupDir, cfg.Database)
+			return err
+		}},
+		{"verify", func() error {
+			logF, logger, err := openLog(cfg.LogFile)
+			if err != nil {
+				return err
+			}
+			defer logF.Close()
+			m, _, err := latestManifest(cfg.BackupDir, cfg.Database)
+			if err != nil {
+				return err
+			}
+			return verifyWrites(ctx, cfg.BackupDir, m.Checksums, false, logger)
+		}},
+		{"restore", func() error {
+			return runRestore(ctx, restored, backupFile)
+		}},
+		{"compare schema", func() error {
+			changes, err := SchemaDiff(ctx,
+				func(ctx context.Context) (string, error) { return databaseSchema(ctx, cfg) },
+				func(ctx context.Context) (string, error) { return databaseSchema(ctx, restored) })
+			if err != nil {
+				return err
+			}
+			if len(changes) > 0 {
+				printSchemaDiff(os.Stdout, changes)
+				return fmt.Errorf("%w: %d schema objects differ", ErrVerification, len(changes))
+			}
+			return nil
+		}},
+		{"compare rows", func() error {
+			for _, table := range selftestTables {
+				query := fmt.Sprintf("SELECT count(*) || ' ' || md5(string_agg(t::text, ',' ORDER BY id)) FROM %s t", table)
+				want, err := queryScalar(ctx, cfg, query)
+				if err != nil {
+					return err
+				}
+				got, err := queryScalar(ctx, restored, query)
+				if err != nil {
+					return err
+				}
+				if got != want {
+					return fmt.Errorf("%w: rows of %s differ", ErrVerification, table)
+				}
+			}
+			return nil
+		}},
+	}
+	for _, step := range steps {
+		started := time.Now()
+		if err := step.run(); err != nil {
+			fmt.Printf("FAIL  %-20s %v\n", step.name, err)
+			fmt.Println("Kept", dir, "for its log and backups.")
+			return fmt.Errorf("%s: %w", step.name, err)
+		}
+		fmt.Printf("PASS  %-20s %s\n", step.name, time.Since(started).Round(time.Millisecond))
+	}
+	if opts.Keep {
+		fmt.Println("Kept", dir+".")
+	} else {
+		os.RemoveAll(dir)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package pgtool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CatalogStore is a catalog shared by the pgtool instances of several
+// hosts, each recording its backup runs with its host name, so that one
+// place shows whether every server was backed up.
+type CatalogStore interface {
+	Name() string
+	Add(ctx context.Context, r CatalogRecord) error
+	// Records returns the runs of every host that may match f; the caller
+	// still filters them.
+	Records(ctx context.Context, f ListFilter) ([]CatalogRecord, error)
+}
+
+// parseCatalog parses a -catalog location: a postgres:// connection URI
+// for a catalog kept in PostgreSQL, or else any -storage URL, whose records
+// are kept next to the backups uploaded there, or on their own.
+func parseCatalog(spec string) (CatalogStore, error) {
+	if strings.HasPrefix(spec, "postgres://") || strings.HasPrefix(spec, "postgresql://") {
+		return pgCatalog{dsn: spec}, nil
+	}
+	b, err := parseStorage(spec)
+	if err != nil {
+		return nil, err
+	}
+	return storageCatalog{b}, nil
+}
+
+// storageCatalog keeps a catalog as one small JSON object per run,
+// named <node>@<db>_<timestamp>.run.json. Host names cannot contain '@',
+// and the names are not backups, so retention leaves them alone.
+type storageCatalog struct {
+	b StorageBackend
+}
+
+const runRecordSuffix = ".run.json"
+
+func (c storageCatalog) Name() string { return c.b.Name() }
+
+func (c storageCatalog) Add(ctx context.Context, r CatalogRecord) error {
+	tmp, err := os.MkdirTemp("", "pgtool-catalog-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	name := r.Node + "@" + BackupName{Database: r.Database, Time: r.Time}.Stem() + runRecordSuffix
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(tmp, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	return c.b.Upload(ctx, path, name)
+}
+
+func (c storageCatalog) Records(ctx context.Context, f ListFilter) ([]CatalogRecord, error) {
+	names, err := c.b.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp("", "pgtool-catalog-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	var records []CatalogRecord
+	for _, name := range names {
+		_, stem, ok := strings.Cut(strings.TrimSuffix(name, runRecordSuffix), "@")
+		if !ok || !strings.HasSuffix(name, runRecordSuffix) {
+			continue
+		}
+		// Parse the stem as a dump name to filter before downloading
+		if b, err := ParseBackupFilename(stem + ".dump"); err != nil || !f.matchName(b) {
+			continue
+		}
+		path := filepath.Join(tmp, name)
+		if err := c.b.Download(ctx, name, path); err != nil {
+			return nil, &StorageError{Backend: c.b.Name(), Op: "download", Err: err}
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var r CatalogRecord
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("%s on %s: %w", name, c.b.Name(), err)
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// CatalogNotifier records every backup run, successful or not, in a
+// shared catalog under this host's name.
+type CatalogNotifier struct {
+	Store CatalogStore
+	Node  string // defaults to the host name
+}
+
+func (n CatalogNotifier) Name() string { return "catalog " + n.Store.Name() }
+
+func (n CatalogNotifier) Notify(ctx context.Context, r RunResult) error {
+	if r.Op != "backup" {
+		return nil
+	}
+	rec := CatalogRecord{
+		Node:     n.Node,
+		Database: r.Database,
+		Time:     r.Started,
+		Outcome:  r.Status,
+		Size:     r.Bytes,
+		Duration: r.Duration,
+		Error:    r.Error,
+		Slow:     r.Slow,
+	}
+	if rec.Node == "" {
+		rec.Node, _ = os.Hostname()
+	}
+	if r.File != "" {
+		rec.File = filepath.Base(r.File)
+		if b, err := ParseBackupFilename(rec.File); err == nil {
+			rec.Time = b.Time
+		}
+	}
+	if m, err := ReadManifest(strings.TrimSuffix(r.File, ".dump.gz") + ".manifest.json"); err == nil {
+		rec.Tags, rec.Labels = m.Tags, m.Labels
+	}
+	return n.Store.Add(ctx, rec)
+}
+
+// catalogRecordsMatch returns the records that match f.
+func catalogRecordsMatch(records []CatalogRecord, f ListFilter) []CatalogRecord {
+	var out []CatalogRecord
+	for _, r := range records {
+		e := BackupEntry{Tags: r.Tags, Labels: r.Labels, Status: r.Outcome}
+		if f.matchName(BackupName{Database: r.Database, Time: r.Time}) && f.match(e) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// nodeLatest keeps only the newest record of each host and database, in
+// the order they first appear.
+func nodeLatest(records []CatalogRecord) []CatalogRecord {
+	latest := map[[2]string]int{}
+	var out []CatalogRecord
+	for _, r := range records {
+		k := [2]string{r.Node, r.Database}
+		if i, ok := latest[k]; ok {
+			if r.Time.After(out[i].Time) {
+				out[i] = r
+			}
+			continue
+		}
+		latest[k] = len(out)
+		out = append(out, r)
+	}
+	return out
+}
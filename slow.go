@@ -0,0 +1,92 @@
+package pgtool
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slowSamples is how many of the latest backups a relative duration
+// limit compares against.
+const slowSamples = 10
+
+// DurationLimit is how long a backup is expected to take at most: a fixed
+// Max, or Factor times the median duration of recent backups. Backups that
+// take longer succeed but are reported as slow.
+type DurationLimit struct {
+	Max    time.Duration
+	Factor float64
+}
+
+// ParseDurationLimit parses a limit given as a duration, e.g. "2h", or as
+// a factor of recent backups, e.g. "1.5x".
+func ParseDurationLimit(s string) (*DurationLimit, error) {
+	if f, ok := strings.CutSuffix(s, "x"); ok {
+		factor, err := strconv.ParseFloat(f, 64)
+		if err != nil || factor < 1 {
+			return nil, fmt.Errorf("%w: invalid duration limit '%s' (want a factor of at least 1, e.g. 1.5x)", ErrUsage, s)
+		}
+		return &DurationLimit{Factor: factor}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("%w: invalid duration limit '%s' (want e.g. 2h or 1.5x)", ErrUsage, s)
+	}
+	return &DurationLimit{Max: d}, nil
+}
+
+func (l DurationLimit) String() string {
+	if l.Factor > 0 {
+		return strconv.FormatFloat(l.Factor, 'g', -1, 64) + "x"
+	}
+	return l.Max.String()
+}
+
+// limit returns the longest a backup of set in dir may take, or zero if
+// there are no earlier backups to compare a relative limit with. Earlier
+// slow backups are left out, so that a gradual slowdown does not raise
+// the bar.
+func (l DurationLimit) limit(dir, set string) (time.Duration, error) {
+	if l.Factor == 0 {
+		return l.Max, nil
+	}
+	records, err := backupHistory(dir, "", set)
+	if err != nil {
+		return 0, err
+	}
+	var durations []time.Duration
+	for i := len(records) - 1; i >= 0 && len(durations) < slowSamples; i-- {
+		r := records[i]
+		if r.Outcome != "success" || r.Slow {
+			continue
+		}
+		if d, err := time.ParseDuration(r.Duration); err == nil && d > 0 {
+			durations = append(durations, d)
+		}
+	}
+	if len(durations) == 0 {
+		return 0, nil
+	}
+	slices.Sort(durations)
+	median := durations[len(durations)/2]
+	return time.Duration(l.Factor * float64(median)), nil
+}
+
+// slowWarning returns why a backup of cfg that took took is slow, or ""
+// if it is not, or cfg sets no MaxDuration.
+func (c Config) slowWarning(took time.Duration) (string, error) {
+	if c.MaxDuration == nil {
+		return "", nil
+	}
+	limit, err := c.MaxDuration.limit(c.BackupDir, c.backupSet())
+	if err != nil || limit == 0 || took <= limit {
+		return "", err
+	}
+	warning := fmt.Sprintf("took %s, expected at most %s", took.Round(time.Second), limit.Round(time.Second))
+	if c.MaxDuration.Factor > 0 {
+		warning += fmt.Sprintf(" (%s the median of recent backups)", c.MaxDuration)
+	}
+	return warning, nil
+}
@@ -0,0 +1,58 @@
+package pgtool
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// exportSnapshot starts a transaction on the configured database, exports
+// its snapshot and keeps the transaction open until release is called, so
+// that several passes over the database, such as the main and large
+// object dumps, see the same point in time. Snapshots can only be shared
+// within one database. release may be called more than once.
+func exportSnapshot(ctx context.Context, cfg Config, stderr io.Writer) (id string, release func() error, err error) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		c := Command{
+			Name:   "psql",
+			Args:   append(cfg.connArgs(), "-X", "-A", "-t", "-q", "-v", "ON_ERROR_STOP=1", "-f", "-"),
+			Stdin:  inR,
+			Stdout: outW,
+		}
+		err := runCommand(ctx, cfg.runner(), c, stderr)
+		inR.CloseWithError(io.ErrClosedPipe)
+		outW.CloseWithError(io.EOF)
+		done <- err
+	}()
+
+	go io.WriteString(inW, "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY;\nSELECT pg_export_snapshot();\n")
+	line, readErr := bufio.NewReader(outR).ReadString('\n')
+	id = strings.TrimSpace(line)
+	if id == "" {
+		inW.Close()
+		if err := <-done; err != nil {
+			return "", nil, err
+		}
+		return "", nil, fmt.Errorf("cannot export snapshot: %v", readErr)
+	}
+
+	var once sync.Once
+	var releaseErr error
+	release = func() error {
+		once.Do(func() {
+			// Drain psql's output so it never blocks writing it.
+			go io.Copy(io.Discard, outR)
+			io.WriteString(inW, "COMMIT;\n")
+			inW.Close()
+			releaseErr = <-done
+		})
+		return releaseErr
+	}
+	return id, release, nil
+}
@@ -0,0 +1,177 @@
+package pgtool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// PhaseTime is how long one phase of a run took.
+type PhaseTime struct {
+	Phase    string        `json:"phase"`
+	Duration time.Duration `json:"-"`
+	Seconds  float64       `json:"seconds"`
+}
+
+// RunStats summarizes a backup or restore: the wall time of each phase
+// and how much data it moved, for diagnosing slow runs.
+type RunStats struct {
+	Op       string      `json:"op"`
+	Database string      `json:"database"`
+	Outcome  string      `json:"outcome"` // "success" or "failure"
+	Seconds  float64     `json:"seconds"`
+	Phases   []PhaseTime `json:"phases"`
+
+	// Rows is estimated from the statistics of the database, for full
+	// backups and for restores; zero if unknown.
+	Rows int64 `json:"rows,omitempty"`
+	// DataBytes is the size of the uncompressed dump, StoredBytes of the
+	// compressed files in the backup directory.
+	DataBytes   int64 `json:"data_bytes,omitempty"`
+	StoredBytes int64 `json:"stored_bytes,omitempty"`
+	// TransferBytes were uploaded to (for a backup) or downloaded from
+	// (for a restore) remote storage, during the upload or download phase.
+	TransferBytes int64 `json:"transfer_bytes,omitempty"`
+	// PeakTempBytes is the most space the run's temporary files, the
+	// uncompressed dump files of a backup or the decompressed archive
+	// and downloaded files of a restore, took up at once.
+	PeakTempBytes int64 `json:"peak_temp_bytes,omitempty"`
+}
+
+// runStats collects RunStats from the events of a run and reports them
+// when it completes or fails. The run fills in the byte counts.
+type runStats struct {
+	NopEventHandler
+	RunStats
+	w      io.Writer
+	logger *log.Logger
+
+	start, phaseStart time.Time
+}
+
+func newRunStats(w io.Writer, logger *log.Logger) *runStats {
+	return &runStats{w: w, logger: logger}
+}
+
+func (s *runStats) OnStart(e Event) {
+	s.Op, s.Database, s.start = e.Op, e.Database, e.Time
+}
+
+func (s *runStats) OnPhaseChange(e Event) {
+	s.endPhase(e.Time)
+	s.Phases = append(s.Phases, PhaseTime{Phase: e.Phase})
+	s.phaseStart = e.Time
+}
+
+func (s *runStats) OnComplete(e Event) {
+	s.finish(e, "success")
+}
+
+func (s *runStats) OnError(e Event) {
+	s.finish(e, "failure")
+}
+
+// endPhase ends the current phase, if any, at end.
+func (s *runStats) endPhase(end time.Time) {
+	if len(s.Phases) == 0 {
+		return
+	}
+	p := &s.Phases[len(s.Phases)-1]
+	p.Duration = end.Sub(s.phaseStart)
+	p.Seconds = p.Duration.Round(time.Millisecond).Seconds()
+}
+
+func (s *runStats) finish(e Event, outcome string) {
+	s.endPhase(e.Time)
+	s.Outcome = outcome
+	s.Seconds = e.Time.Sub(s.start).Round(time.Millisecond).Seconds()
+	if data, err := json.Marshal(s.RunStats); err == nil {
+		s.logger.Printf("STATS: %s", data)
+	}
+	writeRunStats(s.w, s.RunStats)
+}
+
+// phase returns how long phase took, or zero if the run did not reach it.
+func (r RunStats) phase(phase string) time.Duration {
+	for _, p := range r.Phases {
+		if p.Phase == phase {
+			return p.Duration
+		}
+	}
+	return 0
+}
+
+// writeRunStats writes the summary of r to w as aligned text.
+func writeRunStats(w io.Writer, r RunStats) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Run summary (%s of %s, %s):\n", r.Op, r.Database, r.Outcome)
+	for _, p := range r.Phases {
+		fmt.Fprintf(tw, "  %s\t%s\n", p.Phase, p.Duration.Round(time.Millisecond))
+	}
+	fmt.Fprintf(tw, "  total\t%s\n", time.Duration(r.Seconds*float64(time.Second)).Round(time.Millisecond))
+	if r.Rows > 0 {
+		fmt.Fprintf(tw, "  rows\t~%s\testimated from table statistics\n", formatCount(r.Rows))
+	}
+	if r.DataBytes > 0 {
+		fmt.Fprintf(tw, "  data\t%s\tuncompressed\n", formatBytes(r.DataBytes))
+	}
+	if r.StoredBytes > 0 {
+		fmt.Fprintf(tw, "  compressed\t%s", formatBytes(r.StoredBytes))
+		if r.DataBytes > 0 {
+			fmt.Fprintf(tw, "\t%.3g%% of the data", 100*float64(r.StoredBytes)/float64(r.DataBytes))
+		}
+		fmt.Fprintln(tw)
+	}
+	if r.TransferBytes > 0 {
+		label, phase := "uploaded", PhaseUpload
+		if r.Op == "restore" {
+			label, phase = "downloaded", PhaseDownload
+		}
+		fmt.Fprintf(tw, "  %s\t%s", label, formatBytes(r.TransferBytes))
+		if d := r.phase(phase); d > 0 {
+			fmt.Fprintf(tw, "\tat %s/s", formatBytes(int64(float64(r.TransferBytes)/d.Seconds())))
+		}
+		fmt.Fprintln(tw)
+	}
+	if r.PeakTempBytes > 0 {
+		fmt.Fprintf(tw, "  peak temp\t%s\n", formatBytes(r.PeakTempBytes))
+	}
+	tw.Flush()
+}
+
+// formatCount formats n with thousands separators, e.g. "1,234,567".
+func formatCount(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// liveRows estimates the number of rows in the tables of cfg's database
+// from its statistics, or returns zero if they cannot be read.
+func liveRows(ctx context.Context, cfg Config) int64 {
+	out, err := queryScalar(ctx, cfg, "SELECT coalesce(sum(n_live_tup), 0) FROM pg_catalog.pg_stat_user_tables")
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(out, 10, 64)
+	return n
+}
+
+// fileSizes returns the total size of the files that exist among paths.
+func fileSizes(paths ...string) int64 {
+	var n int64
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			n += fi.Size()
+		}
+	}
+	return n
+}
@@ -0,0 +1,66 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// StatsDNotifier sends metrics for each run over UDP to a StatsD agent:
+//
+//	<prefix><op>.duration  timing, in milliseconds
+//	<prefix><op>.bytes     gauge, size of the backup or restored file
+//	<prefix><op>.<status>  counter, e.g. backup.success, restore.failure
+//	<prefix>prune.deleted  counter, files removed by a prune
+//
+// With DogStatsD set, metrics are tagged with the database and host in
+// Datadog's format; plain StatsD has no tags.
+type StatsDNotifier struct {
+	Address   string // host:port of the agent, e.g. localhost:8125
+	Prefix    string // prepended to every metric name, e.g. "pgtool."
+	DogStatsD bool
+}
+
+func (n StatsDNotifier) Name() string { return "statsd" }
+
+func (n StatsDNotifier) Notify(ctx context.Context, r RunResult) error {
+	var tags string
+	if n.DogStatsD {
+		host, _ := os.Hostname()
+		t := []string{"host:" + host}
+		if r.Database != "" {
+			t = append(t, "database:"+r.Database)
+		}
+		tags = "|#" + strings.Join(t, ",")
+	}
+	metric := n.Prefix + r.Op + "."
+	lines := []string{
+		fmt.Sprintf("%sduration:%d|ms%s", metric, r.Finished.Sub(r.Started).Milliseconds(), tags),
+		fmt.Sprintf("%s%s:1|c%s", metric, r.Status, tags),
+	}
+	if r.Slow {
+		lines = append(lines, fmt.Sprintf("%sslow:1|c%s", metric, tags))
+	}
+	if r.Bytes > 0 {
+		lines = append(lines, fmt.Sprintf("%sbytes:%d|g%s", metric, r.Bytes, tags))
+	}
+	if r.Op == "prune" {
+		lines = append(lines, fmt.Sprintf("%sdeleted:%d|c%s", metric, r.Deleted, tags))
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", n.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// One packet per metric stays under any agent's size limit
+	for _, l := range lines {
+		if _, err := conn.Write([]byte(l)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
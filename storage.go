@@ -0,0 +1,202 @@
+package pgtool
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StorageBackend copies finished backups to a destination other than the
+// local backup directory. Names are plain file names such as
+// "mydb_2025-08-09_114200.dump.gz".
+type StorageBackend interface {
+	// Name identifies the backend in logs.
+	Name() string
+	Upload(ctx context.Context, localPath, name string) error
+	Download(ctx context.Context, name, localPath string) error
+	List(ctx context.Context) ([]string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Sharer is implemented by storage backends that can hand out a
+// time-limited download URL for a file, usable without credentials.
+type Sharer interface {
+	ShareURL(ctx context.Context, name string, expires time.Duration) (string, error)
+}
+
+// Locker is implemented by storage backends whose files can be locked
+// against deletion. LockedUntil returns, for each file still locked, when
+// its lock expires.
+type Locker interface {
+	LockedUntil(ctx context.Context) (map[string]time.Time, error)
+}
+
+// Encrypter is implemented by storage backends that can encrypt files at
+// rest. Unencrypted returns the files that are not encrypted as the
+// backend is configured to.
+type Encrypter interface {
+	Unencrypted(ctx context.Context) ([]string, error)
+}
+
+// uploadAll copies localPath to every backend, stopping at the first error.
+// Transient failures are retried per backend according to retry.
+func uploadAll(ctx context.Context, backends []StorageBackend, localPath string, retry RetryPolicy, logger *log.Logger) error {
+	name := filepath.Base(localPath)
+	for _, b := range backends {
+		err := retry.do(ctx, logRetries(logger, "Upload to "+b.Name()), func() error {
+			return b.Upload(ctx, localPath, name)
+		})
+		if err != nil {
+			return &StorageError{Backend: b.Name(), Op: "upload", Err: err}
+		}
+	}
+	return nil
+}
+
+// parseStorage parses a -storage destination URL:
+//
+//	b2://BUCKET[/PREFIX]  Backblaze B2, with the application key in
+//	                      B2_APPLICATION_KEY_ID and B2_APPLICATION_KEY;
+//	                      ?lock=governance|compliance&lock-days=N locks
+//	                      uploads with Object Lock; ?sse=b2 encrypts
+//	                      them with SSE-B2, ?sse=c with SSE-C and the
+//	                      base64 AES-256 key in B2_SSE_C_KEY
+//	webdav://HOST/PATH    WebDAV over http, or webdavs:// over https, with
+//	                      the user and password in the URL or in
+//	                      WEBDAV_USER and WEBDAV_PASSWORD
+//	rclone:REMOTE:PATH    any remote configured in rclone
+func parseStorage(spec string) (StorageBackend, error) {
+	if remote, ok := strings.CutPrefix(spec, "rclone:"); ok {
+		if !strings.Contains(remote, ":") {
+			return nil, fmt.Errorf("%w: %s must name an rclone remote, e.g. rclone:gdrive:backups", ErrUsage, spec)
+		}
+		return RcloneStorage{Remote: remote}, nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%w: invalid storage URL '%s'", ErrUsage, spec)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	switch u.Scheme {
+	case "b2":
+		keyID, key := os.Getenv("B2_APPLICATION_KEY_ID"), os.Getenv("B2_APPLICATION_KEY")
+		if keyID == "" || key == "" {
+			return nil, fmt.Errorf("%w: %s needs B2_APPLICATION_KEY_ID and B2_APPLICATION_KEY", ErrUsage, spec)
+		}
+		s := B2Storage{KeyID: keyID, Key: key, Bucket: u.Host, Prefix: prefix}
+		if s.LockMode = u.Query().Get("lock"); s.LockMode != "" {
+			days, err := strconv.Atoi(u.Query().Get("lock-days"))
+			if (s.LockMode != "governance" && s.LockMode != "compliance") || err != nil || days <= 0 {
+				return nil, fmt.Errorf("%w: %s: lock must be governance or compliance, with lock-days > 0", ErrUsage, spec)
+			}
+			s.LockDays = days
+		}
+		switch u.Query().Get("sse") {
+		case "":
+		case "b2":
+			s.Encryption = b2SSEB2
+		case "c":
+			key, err := base64.StdEncoding.DecodeString(os.Getenv("B2_SSE_C_KEY"))
+			if err != nil || len(key) != 32 {
+				return nil, fmt.Errorf("%w: %s needs a base64 256-bit key in B2_SSE_C_KEY", ErrUsage, spec)
+			}
+			s.Encryption, s.CustomerKey = b2SSEC, key
+		default:
+			return nil, fmt.Errorf("%w: %s: sse must be b2 or c", ErrUsage, spec)
+		}
+		return s, nil
+	case "webdav", "webdavs":
+		s := &WebDAVStorage{User: os.Getenv("WEBDAV_USER"), Password: os.Getenv("WEBDAV_PASSWORD")}
+		if u.User != nil {
+			s.User = u.User.Username()
+			if pw, ok := u.User.Password(); ok {
+				s.Password = pw
+			}
+		}
+		scheme := "http"
+		if u.Scheme == "webdavs" {
+			scheme = "https"
+		}
+		s.URL = (&url.URL{Scheme: scheme, Host: u.Host, Path: "/" + prefix}).String()
+		return s, nil
+	}
+	return nil, fmt.Errorf("%w: unknown storage scheme '%s'", ErrUsage, u.Scheme)
+}
+
+// storageBackends returns the backends given by -storage URLs followed by
+// -storage-plugin executables.
+func storageBackends(urls, plugins []string) ([]StorageBackend, error) {
+	var backends []StorageBackend
+	for _, spec := range urls {
+		b, err := parseStorage(spec)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, b)
+	}
+	return append(backends, execStorages(plugins)...), nil
+}
+
+// shareURL returns a time-limited download URL for the backup file name
+// in the storage at spec.
+func shareURL(ctx context.Context, spec, name string, expires time.Duration) (string, error) {
+	if spec == "" || name == "" || name == "." {
+		return "", fmt.Errorf("%w: -storage and -file are required", ErrUsage)
+	}
+	b, err := parseStorage(spec)
+	if err != nil {
+		return "", err
+	}
+	s, ok := b.(Sharer)
+	if !ok {
+		return "", fmt.Errorf("%w: %s cannot create share links", ErrUsage, b.Name())
+	}
+	return s.ShareURL(ctx, name, expires)
+}
+
+// cleanupRemoteBackups deletes the backups of db older than retentionDays
+// from each backend, judging their age by the timestamp in their names.
+// Failures are logged; the local backups are what a run depends on.
+// Files a Locker reports as locked are kept until their lock expires.
+func cleanupRemoteBackups(ctx context.Context, backends []StorageBackend, db string, retentionDays int, logger *log.Logger) {
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	for _, b := range backends {
+		names, err := b.List(ctx)
+		if err != nil {
+			logger.Printf("WARNING: Cannot list %s for cleanup: %v", b.Name(), err)
+			continue
+		}
+		var locked map[string]time.Time
+		if l, ok := b.(Locker); ok {
+			if locked, err = l.LockedUntil(ctx); err != nil {
+				logger.Printf("WARNING: Cannot read locks of %s for cleanup: %v", b.Name(), err)
+				continue
+			}
+		}
+		for _, name := range names {
+			bn, err := ParseBackupFilename(name)
+			if err != nil || bn.Database != db || !bn.Time.Before(cutoff) {
+				continue
+			}
+			if until, ok := locked[name]; ok {
+				logger.Printf("INFO: Keeping %s on %s: locked until %s.", name, b.Name(), until.Format(time.RFC3339))
+				continue
+			}
+			if err := b.Delete(ctx, name); err != nil {
+				logger.Printf("WARNING: Failed to delete %s from %s: %v", name, b.Name(), err)
+				continue
+			}
+			logger.Printf("INFO: Deleted old backup %s from %s.", name, b.Name())
+		}
+	}
+}
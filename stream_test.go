@@ -0,0 +1,96 @@
+package pgtool
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestBackupTo(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    map[string]string
+		wantErr error
+	}{
+		{"ok", nil, nil},
+		{"auth failed", map[string]string{"pg_dump": `pg_dump: error: connection to server failed: FATAL:  password authentication failed for user "pgtool"`}, ErrAuthFailed},
+		{"no pg_dump", map[string]string{"pg_dump": ""}, ErrToolMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pg := &fakePG{fail: tt.fail}
+			cfg := fakeConfig(t, pg)
+			var buf bytes.Buffer
+			err := BackupTo(context.Background(), cfg, &buf)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("BackupTo: %v, want %v", err, tt.wantErr)
+			}
+			if dumps := pg.commands("pg_dump"); len(dumps) != 1 || !slices.Equal(dumps[0], cfg.dumpArgs()) {
+				t.Errorf("pg_dump runs %q, want %q", dumps, cfg.dumpArgs())
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			zr, err := gzip.NewReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, err := io.ReadAll(zr); err != nil || string(data) != "PGDMP fake archive\n" {
+				t.Errorf("archive %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestRestoreFrom(t *testing.T) {
+	var archive bytes.Buffer
+	zw := gzip.NewWriter(&archive)
+	io.WriteString(zw, "PGDMP fake archive\n")
+	zw.Close()
+
+	tests := []struct {
+		name     string
+		setup    func(*Config)
+		wantErr  error
+		wantPsql bool
+	}{
+		{"plain", func(*Config) {}, nil, false},
+		{"role map", func(c *Config) { c.RoleMap = map[string]string{"app_owner": "app_dev"} }, nil, true},
+		{"masking", func(c *Config) { c.Masking = &MaskConfig{ephemeral: true} }, nil, true},
+		{"drop", func(c *Config) { c.IfExists = IfExistsDrop }, ErrUsage, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pg := &fakePG{}
+			cfg := fakeConfig(t, pg)
+			tt.setup(&cfg)
+			err := RestoreFrom(context.Background(), cfg, bytes.NewReader(archive.Bytes()))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RestoreFrom: %v, want %v", err, tt.wantErr)
+			}
+			restores := pg.commands("pg_restore")
+			switch {
+			case tt.wantErr != nil:
+				if len(restores) != 0 {
+					t.Errorf("pg_restore runs %q, want none", restores)
+				}
+			case tt.wantPsql:
+				want := append([]string{"-f", "-"}, cfg.restoreOptions("")...)
+				if len(restores) != 1 || !slices.Equal(restores[0], want) {
+					t.Errorf("pg_restore runs %q, want %q", restores, want)
+				}
+				if loads := pg.commands("psql"); len(loads) != 1 || !slices.Contains(loads[0], "ON_ERROR_STOP=0") {
+					t.Errorf("psql runs %q, want one loading the script", loads)
+				}
+			default:
+				if len(restores) != 1 || !slices.Equal(restores[0], cfg.restoreArgs("")) {
+					t.Errorf("pg_restore runs %q, want %q", restores, cfg.restoreArgs(""))
+				}
+			}
+		})
+	}
+}
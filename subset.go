@@ -0,0 +1,237 @@
+package pgtool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// SubsetRule limits the rows of one table that go into a backup. Exactly
+// one of Where, Percent or Parent is set.
+type SubsetRule struct {
+	Table string `json:"table"`
+
+	// Where keeps the rows matching a SQL condition,
+	// e.g. "created_at > now() - interval '30 days'".
+	Where string `json:"where,omitempty"`
+
+	// Percent keeps a random sample of about this share of the rows.
+	Percent float64 `json:"percent,omitempty"`
+
+	// Parent keeps only the rows whose Column references a row kept from
+	// the Parent table (itself a subset rule), so foreign keys still hold.
+	Parent     string `json:"parent,omitempty"`
+	Column     string `json:"column,omitempty"`
+	References string `json:"references,omitempty"` // parent column, default "id"
+}
+
+// SubsetConfig is the JSON file given to -subset.
+type SubsetConfig struct {
+	Tables []SubsetRule `json:"tables"`
+}
+
+// subsetSeed makes sampling repeatable, so a child table following a
+// sampled parent sees the same sample.
+const subsetSeed = 42
+
+// LoadSubsetConfig reads and validates a subset file. The rules are
+// returned with parents ahead of the tables that follow them.
+func LoadSubsetConfig(path string) (*SubsetConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sc SubsetConfig
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := sc.sort(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &sc, nil
+}
+
+// sort validates the rules and orders them so that each parent comes
+// before its children, which is also the order they must be loaded in.
+func (sc *SubsetConfig) sort() error {
+	byTable := make(map[string]SubsetRule)
+	for i, r := range sc.Tables {
+		set := 0
+		for _, b := range []bool{r.Where != "", r.Percent != 0, r.Parent != ""} {
+			if b {
+				set++
+			}
+		}
+		switch {
+		case r.Table == "":
+			return fmt.Errorf("rule %d: table is required", i+1)
+		case set != 1:
+			return fmt.Errorf("table %s: exactly one of where, percent or parent is required", r.Table)
+		case r.Percent < 0 || r.Percent > 100:
+			return fmt.Errorf("table %s: percent must be between 0 and 100", r.Table)
+		case r.Parent != "" && r.Column == "":
+			return fmt.Errorf("table %s: column is required with parent", r.Table)
+		}
+		if _, dup := byTable[r.Table]; dup {
+			return fmt.Errorf("table %s: listed more than once", r.Table)
+		}
+		byTable[r.Table] = r
+	}
+
+	var sorted []SubsetRule
+	done := make(map[string]bool)
+	var visit func(r SubsetRule, depth int) error
+	visit = func(r SubsetRule, depth int) error {
+		if done[r.Table] {
+			return nil
+		}
+		if depth > len(sc.Tables) {
+			return fmt.Errorf("table %s: parent cycle", r.Table)
+		}
+		if r.Parent != "" {
+			p, ok := byTable[r.Parent]
+			if !ok {
+				return fmt.Errorf("table %s: parent %s has no subset rule", r.Table, r.Parent)
+			}
+			if err := visit(p, depth+1); err != nil {
+				return err
+			}
+		}
+		done[r.Table] = true
+		sorted = append(sorted, r)
+		return nil
+	}
+	for _, r := range sc.Tables {
+		if err := visit(r, 0); err != nil {
+			return err
+		}
+	}
+	sc.Tables = sorted
+	return nil
+}
+
+func (sc *SubsetConfig) rule(table string) SubsetRule {
+	for _, r := range sc.Tables {
+		if r.Table == table {
+			return r
+		}
+	}
+	return SubsetRule{}
+}
+
+// query returns the SELECT producing the kept rows of r.
+func (sc *SubsetConfig) query(r SubsetRule) string {
+	return "SELECT * FROM " + sc.from(r, r.Table)
+}
+
+// from returns the FROM clause, including any WHERE, selecting the kept
+// rows of r. Parents are resolved recursively.
+func (sc *SubsetConfig) from(r SubsetRule, table string) string {
+	switch {
+	case r.Where != "":
+		return fmt.Sprintf("%s WHERE %s", table, r.Where)
+	case r.Percent != 0:
+		return fmt.Sprintf("%s TABLESAMPLE BERNOULLI (%g) REPEATABLE (%d)", table, r.Percent, subsetSeed)
+	}
+	ref := r.References
+	if ref == "" {
+		ref = "id"
+	}
+	parent := sc.rule(r.Parent)
+	return fmt.Sprintf("%s WHERE %s IN (SELECT %s FROM %s)", table, r.Column, ref, sc.from(parent, r.Parent))
+}
+
+// excludeArgs returns the pg_dump arguments leaving the subset tables'
+// data out of the main dump.
+func (sc *SubsetConfig) excludeArgs() []string {
+	var args []string
+	for _, r := range sc.Tables {
+		args = append(args, "--exclude-table-data="+r.Table)
+	}
+	return args
+}
+
+// script returns a psql script that, run against the source, prints a
+// loadable SQL script with a COPY block per subset table. All tables are
+// read from one snapshot: the exported snapshot if one is given, else a
+// new one.
+func (sc *SubsetConfig) script(snapshot string) string {
+	var b strings.Builder
+	b.WriteString("BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY;\n")
+	if snapshot != "" {
+		fmt.Fprintf(&b, "SET TRANSACTION SNAPSHOT '%s';\n", snapshot)
+	}
+	for _, r := range sc.Tables {
+		// Name the columns, in the order of SELECT *, so that restore
+		// can find the masked ones
+		fmt.Fprintf(&b, "SELECT 'COPY ' || %s || ' (' || string_agg(quote_ident(attname), ', ' ORDER BY attnum) || ') FROM stdin;' "+
+			"FROM pg_catalog.pg_attribute WHERE attrelid = %s::regclass AND attnum > 0 AND NOT attisdropped;\n",
+			quoteLiteral(r.Table), quoteLiteral(r.Table))
+		fmt.Fprintf(&b, "COPY (%s) TO STDOUT;\n", sc.query(r))
+		b.WriteString("\\echo '\\\\.'\n")
+	}
+	b.WriteString("COMMIT;\n")
+	return b.String()
+}
+
+// dumpSubset writes the kept rows of the subset tables to dst as a SQL
+// script of COPY blocks, parents first.
+func dumpSubset(ctx context.Context, cfg Config, dst string, stderr io.Writer) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return ioError(err)
+	}
+	defer out.Close()
+
+	c := Command{
+		Name:   "psql",
+		Args:   append(cfg.connArgs(), "-X", "-q", "-A", "-t", "-v", "ON_ERROR_STOP=1", "-f", "-"),
+		Stdin:  strings.NewReader(cfg.Subset.script(cfg.Snapshot)),
+		Stdout: out,
+	}
+	if err := runCommand(ctx, cfg.runner(), c, stderr); err != nil {
+		return err
+	}
+	return ioError(out.Close())
+}
+
+// restoreSubset loads a subset data file written by dumpSubset, masking
+// its rows first if cfg asks for it.
+func restoreSubset(ctx context.Context, cfg Config, subsetFile string, stderr io.Writer) error {
+	tempFile, err := decompressTemp(ctx, subsetFile)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile)
+	if cfg.Masking != nil {
+		masked := strings.TrimSuffix(tempFile, partialSuffix) + ".masked" + partialSuffix
+		if err := rewriteFile(masked, tempFile, cfg); err != nil {
+			os.Remove(masked)
+			return err
+		}
+		defer os.Remove(masked)
+		tempFile = masked
+	}
+	return runSQLFile(ctx, cfg, tempFile, stderr)
+}
+
+// rewriteFile writes the SQL script src to dst through rewriteScript.
+func rewriteFile(dst, src string, cfg Config) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return ioError(err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return ioError(err)
+	}
+	defer out.Close()
+	if err := rewriteScript(out, in, cfg); err != nil {
+		return ioError(err)
+	}
+	return ioError(out.Close())
+}
@@ -0,0 +1,174 @@
+package pgtool
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSubsetConfigSort(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []SubsetRule
+		want    []string // table order
+		wantErr string
+	}{
+		{"parents first", []SubsetRule{
+			{Table: "order_items", Parent: "orders", Column: "order_id"},
+			{Table: "orders", Parent: "customers", Column: "customer_id"},
+			{Table: "customers", Percent: 10},
+			{Table: "events", Where: "created_at > now() - interval '30 days'"},
+		}, []string{"customers", "orders", "order_items", "events"}, ""},
+		{"no table", []SubsetRule{{Percent: 10}}, nil, "table is required"},
+		{"no filter", []SubsetRule{{Table: "t"}}, nil, "exactly one of"},
+		{"two filters", []SubsetRule{{Table: "t", Where: "true", Percent: 5}}, nil, "exactly one of"},
+		{"bad percent", []SubsetRule{{Table: "t", Percent: 150}}, nil, "between 0 and 100"},
+		{"parent without column", []SubsetRule{{Table: "t", Parent: "p"}, {Table: "p", Percent: 1}}, nil, "column is required"},
+		{"unknown parent", []SubsetRule{{Table: "t", Parent: "p", Column: "p_id"}}, nil, "has no subset rule"},
+		{"duplicate", []SubsetRule{{Table: "t", Percent: 1}, {Table: "t", Percent: 2}}, nil, "more than once"},
+		{"cycle", []SubsetRule{
+			{Table: "a", Parent: "b", Column: "b_id"},
+			{Table: "b", Parent: "a", Column: "a_id"},
+		}, nil, "parent cycle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := &SubsetConfig{Tables: tt.rules}
+			err := sc.sort()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("sort: %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range sc.Tables {
+				got = append(got, r.Table)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("order %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubsetQueries(t *testing.T) {
+	sc := &SubsetConfig{Tables: []SubsetRule{
+		{Table: "customers", Percent: 10},
+		{Table: "orders", Parent: "customers", Column: "customer_id"},
+		{Table: "order_items", Parent: "orders", Column: "order_id", References: "order_no"},
+		{Table: "events", Where: "kind = 'login'"},
+	}}
+	if err := sc.sort(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ table, want string }{
+		{"customers", "SELECT * FROM customers TABLESAMPLE BERNOULLI (10) REPEATABLE (42)"},
+		{"orders", "SELECT * FROM orders WHERE customer_id IN (SELECT id FROM customers TABLESAMPLE BERNOULLI (10) REPEATABLE (42))"},
+		{"order_items", "SELECT * FROM order_items WHERE order_id IN (SELECT order_no FROM orders WHERE customer_id IN (SELECT id FROM customers TABLESAMPLE BERNOULLI (10) REPEATABLE (42)))"},
+		{"events", "SELECT * FROM events WHERE kind = 'login'"},
+	}
+	for _, tt := range tests {
+		if got := sc.query(sc.rule(tt.table)); got != tt.want {
+			t.Errorf("query(%s) = %q, want %q", tt.table, got, tt.want)
+		}
+	}
+	want := []string{"--exclude-table-data=customers", "--exclude-table-data=orders", "--exclude-table-data=order_items", "--exclude-table-data=events"}
+	if got := sc.excludeArgs(); !slices.Equal(got, want) {
+		t.Errorf("excludeArgs() = %q, want %q", got, want)
+	}
+}
+
+func TestDumpSubset(t *testing.T) {
+	var cmds []Command
+	var script string
+	cfg := Config{
+		User: "pgtool", Host: "db1", Database: "app", Snapshot: "00000003-0000001B-1",
+		Subset: &SubsetConfig{Tables: []SubsetRule{{Table: "public.events", Where: "id > 100"}}},
+		Runner: runnerFunc(func(ctx context.Context, c Command) error {
+			data, _ := io.ReadAll(c.Stdin)
+			script = string(data)
+			return recordRunner{&cmds}.Run(ctx, c)
+		}),
+	}
+	if err := dumpSubset(context.Background(), cfg, filepath.Join(t.TempDir(), "subset.sql"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != 1 || cmds[0].Name != "psql" {
+		t.Fatalf("commands %+v, want one psql", cmds)
+	}
+	if want := []string{"-U", "pgtool", "-h", "db1", "-d", "app", "-X", "-q", "-A", "-t", "-v", "ON_ERROR_STOP=1", "-f", "-"}; !slices.Equal(cmds[0].Args, want) {
+		t.Errorf("args %q, want %q", cmds[0].Args, want)
+	}
+	want := "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY;\n" +
+		"SET TRANSACTION SNAPSHOT '00000003-0000001B-1';\n" +
+		"SELECT 'COPY ' || 'public.events' || ' (' || string_agg(quote_ident(attname), ', ' ORDER BY attnum) || ') FROM stdin;' " +
+		"FROM pg_catalog.pg_attribute WHERE attrelid = 'public.events'::regclass AND attnum > 0 AND NOT attisdropped;\n" +
+		"COPY (SELECT * FROM public.events WHERE id > 100) TO STDOUT;\n" +
+		"\\echo '\\\\.'\n" +
+		"COMMIT;\n"
+	if script != want {
+		t.Errorf("script:\n%s\nwant:\n%s", script, want)
+	}
+}
+
+func TestRestoreSubsetMasked(t *testing.T) {
+	mc, err := loadMaskConfig(t, `{"rules": [{"table": "users", "column": "email", "rule": "null"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, data, want string
+		wantErr          error
+	}{
+		{"masked", "COPY public.users (id, email) FROM stdin;\n1\ta@example.com\n\\.\n",
+			"COPY public.users (id, email) FROM stdin;\n1\t\\N\n\\.\n", nil},
+		{"no column list", "COPY public.users FROM stdin;\n1\ta@example.com\n\\.\n", "", ErrUsage},
+		{"unmasked table", "COPY public.orders FROM stdin;\n1\ta@example.com\n\\.\n",
+			"COPY public.orders FROM stdin;\n1\ta@example.com\n\\.\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "app_2026-10-15_020000.subset.sql.gz")
+			f, err := os.Create(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			zw := gzip.NewWriter(f)
+			io.WriteString(zw, tt.data)
+			zw.Close()
+			f.Close()
+
+			var loaded string
+			cfg := Config{User: "pgtool", Host: "db2", Database: "app", Masking: mc,
+				Runner: runnerFunc(func(ctx context.Context, c Command) error {
+					data, _ := io.ReadAll(c.Stdin)
+					loaded = string(data)
+					return nil
+				}),
+			}
+			err = restoreSubset(context.Background(), cfg, file, nil)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("restoreSubset: %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaded != tt.want {
+				t.Errorf("psql read:\n%s\nwant:\n%s", loaded, tt.want)
+			}
+		})
+	}
+}
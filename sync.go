@@ -0,0 +1,39 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+)
+
+// SyncOptions describes an environment refresh: restore the newest backup
+// of Source into Target.Database, run the post-restore scripts configured
+// on Target, then optionally ANALYZE.
+type SyncOptions struct {
+	Source    string // database whose backups are restored
+	BackupDir string // where Source's backups are found
+	Analyze   bool   // overrides Target.AnalyzeAfter
+	Target    Config
+}
+
+// Sync performs one environment refresh.
+func Sync(ctx context.Context, opts SyncOptions) error {
+	if opts.Source == "" || opts.Target.Database == "" {
+		return fmt.Errorf("%w: source and target database are required", ErrUsage)
+	}
+	file, err := latestBackup(opts.BackupDir, opts.Source)
+	if err != nil {
+		return err
+	}
+	opts.Target.AnalyzeAfter = opts.Analyze
+	if err := runRestore(ctx, opts.Target, file); err != nil {
+		return err
+	}
+
+	logF, logger, err := openLog(opts.Target.LogFile)
+	if err != nil {
+		return err
+	}
+	defer logF.Close()
+	logger.Printf("SUCCESS: Sync of '%s' into '%s' completed.", opts.Source, opts.Target.Database)
+	return nil
+}
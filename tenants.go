@@ -0,0 +1,70 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path"
+	"strings"
+	"time"
+)
+
+// tenantSchemas returns the schemas of cfg's database whose names match
+// the glob pattern, e.g. "tenant_*", in name order.
+func tenantSchemas(ctx context.Context, cfg Config, pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("%w: bad schema pattern '%s': %w", ErrUsage, pattern, err)
+	}
+	out, err := queryScalar(ctx, cfg, "SELECT nspname FROM pg_catalog.pg_namespace ORDER BY 1")
+	if err != nil {
+		return nil, fmt.Errorf("cannot list schemas: %w", err)
+	}
+	var schemas []string
+	for _, s := range strings.Split(out, "\n") {
+		if ok, _ := path.Match(pattern, s); ok && s != "" {
+			schemas = append(schemas, s)
+		}
+	}
+	return schemas, nil
+}
+
+// BackupTenants backs up each schema of cfg's database matching pattern
+// on its own, one after another, so that one tenant can later be restored
+// without touching the others. Each dump is labelled with its tenant and
+// forms its own backup set. It returns one result per tenant and an error
+// only if a backup failed or no schema matched.
+func BackupTenants(ctx context.Context, cfg Config, pattern string) ([]FleetResult, error) {
+	schemas, err := tenantSchemas(ctx, cfg, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(schemas) == 0 {
+		return nil, fmt.Errorf("no schema of '%s' matches '%s'", cfg.Database, pattern)
+	}
+	results := make([]FleetResult, len(schemas))
+	var failed int
+	for i, schema := range schemas {
+		tenant := cfg
+		tenant.Tenant = schema
+		tenant.Labels = maps.Clone(cfg.Labels)
+		if tenant.Labels == nil {
+			tenant.Labels = map[string]string{}
+		}
+		tenant.Labels["tenant"] = schema
+		start := time.Now()
+		results[i] = FleetResult{Server: cfg.Database, Database: schema}
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+		} else {
+			results[i].Err = runBackup(ctx, tenant)
+		}
+		results[i].Duration = time.Since(start)
+		if results[i].failed() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d tenant backups failed", failed, len(results))
+	}
+	return results, nil
+}
@@ -0,0 +1,70 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// toolVersion returns the version reported by "name --version", e.g.
+// "16.2" from "pg_dump (PostgreSQL) 16.2 (Debian 16.2-1.pgdg120+2)".
+func toolVersion(ctx context.Context, r Runner, name string) (string, error) {
+	var out strings.Builder
+	if err := runCommand(ctx, r, Command{Name: name, Args: []string{"--version"}, Stdout: &out}, nil); err != nil {
+		return "", err
+	}
+	_, rest, ok := strings.Cut(out.String(), "(PostgreSQL) ")
+	if !ok {
+		return "", fmt.Errorf("unexpected %s --version output %q", name, strings.TrimSpace(out.String()))
+	}
+	return strings.Fields(rest)[0], nil
+}
+
+// serverVersion returns the version of the configured server, e.g. "16.2".
+func serverVersion(ctx context.Context, cfg Config) (string, error) {
+	out, err := queryScalar(ctx, cfg, "SHOW server_version")
+	if err != nil {
+		return "", err
+	}
+	if f := strings.Fields(out); len(f) > 0 {
+		return f[0], nil
+	}
+	return "", fmt.Errorf("unexpected server version %q", out)
+}
+
+// majorVersion returns a comparable major version: 1600 for "16.2" and,
+// since before 10 the major version had two parts, 906 for "9.6.24".
+func majorVersion(v string) int {
+	parts := strings.FieldsFunc(v, func(r rune) bool { return r < '0' || r > '9' })
+	if len(parts) == 0 {
+		return 0
+	}
+	major, _ := strconv.Atoi(parts[0])
+	if major < 10 && len(parts) > 1 {
+		minor, _ := strconv.Atoi(parts[1])
+		return major*100 + minor
+	}
+	return major * 100
+}
+
+// checkDumpVersion refuses to back up with a pg_dump older than the
+// server, which pg_dump would otherwise only report once connected. It
+// returns the versions of pg_dump and the server for the manifest.
+func checkDumpVersion(ctx context.Context, cfg Config, logger *log.Logger) (map[string]string, error) {
+	client, err := toolVersion(ctx, cfg.runner(), "pg_dump")
+	if err != nil {
+		return nil, err
+	}
+	server, err := serverVersion(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get server version: %w", err)
+	}
+	logger.Printf("INFO: Using pg_dump %s against server %s.", client, server)
+	if majorVersion(client) < majorVersion(server) {
+		return nil, fmt.Errorf("%w: pg_dump %s is older than server %s; use -pg-bindir to pick newer client tools",
+			ErrToolMissing, client, server)
+	}
+	return map[string]string{"pg_dump": client, "server": server}, nil
+}
@@ -0,0 +1,344 @@
+package pgtool
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// webdavChunkSize is the default size of the chunks large files are
+// uploaded in on Nextcloud, small enough to pass the usual proxy limits.
+const webdavChunkSize = 50 << 20
+
+// WebDAVStorage is a StorageBackend for WebDAV servers such as Nextcloud,
+// ownCloud or Apache mod_dav. It authenticates with basic or digest auth,
+// whichever the server asks for. On Nextcloud, files larger than
+// ChunkSize are sent with its chunked upload API so server and proxy
+// request size limits don't apply.
+type WebDAVStorage struct {
+	URL       string // collection backups are stored in, ending in "/"
+	User      string
+	Password  string
+	ChunkSize int64 // 0 means webdavChunkSize
+	Client    *http.Client
+
+	mu     sync.Mutex
+	digest map[string]string // the server's digest challenge, once seen
+	nc     int
+}
+
+func (s *WebDAVStorage) Name() string {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return "webdav"
+	}
+	return "webdav:" + u.Host
+}
+
+func (s *WebDAVStorage) client() *http.Client {
+	if s.Client == nil {
+		return http.DefaultClient
+	}
+	return s.Client
+}
+
+// do sends a request built by newReq, answering a digest challenge if the
+// server sends one. newReq is called again for the retry, so that request
+// bodies can be re-read. Of several challenges, the first whose algorithm
+// is supported is answered, as servers list the strongest first.
+func (s *WebDAVStorage) do(newReq func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		s.authorize(req)
+		resp, err := s.client().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		var digest map[string]string
+		var unsupported []string
+		for _, challenge := range resp.Header.Values("WWW-Authenticate") {
+			if !strings.HasPrefix(challenge, "Digest ") {
+				continue
+			}
+			c := parseDigestChallenge(challenge)
+			if digestHash(c["algorithm"]) == nil {
+				unsupported = append(unsupported, c["algorithm"])
+			} else if digest == nil {
+				digest = c
+			}
+		}
+		if digest == nil && unsupported == nil {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if digest == nil {
+			return nil, fmt.Errorf("%w: unsupported digest algorithm %s", ErrAuthFailed, strings.Join(unsupported, ", "))
+		}
+		s.mu.Lock()
+		s.digest, s.nc = digest, 0
+		s.mu.Unlock()
+	}
+}
+
+// authorize adds basic auth, or digest auth once the server has sent a
+// digest challenge.
+func (s *WebDAVStorage) authorize(req *http.Request) {
+	if s.User == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.digest == nil {
+		req.SetBasicAuth(s.User, s.Password)
+		return
+	}
+	s.nc++
+	req.Header.Set("Authorization", digestAuthorization(s.digest, s.User, s.Password, req.Method, req.URL.RequestURI(), s.nc))
+}
+
+// parseDigestChallenge parses the parameters of a "Digest ..." header.
+func parseDigestChallenge(h string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(h, "Digest "), ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok {
+			params[strings.ToLower(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return params
+}
+
+// digestHash returns the hash of an RFC 7616 digest algorithm, MD5 if
+// none is given, or nil if the algorithm is not supported.
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "", "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	}
+	return nil
+}
+
+// digestAuthorization computes an RFC 7616 digest response, with MD5 or
+// SHA-256 and their -sess variants.
+func digestAuthorization(c map[string]string, user, password, method, uri string, nc int) string {
+	newHash := digestHash(c["algorithm"])
+	hexHash := func(s string) string {
+		h := newHash()
+		io.WriteString(h, s)
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	cnonce := hex.EncodeToString(b)
+	ha1 := hexHash(user + ":" + c["realm"] + ":" + password)
+	if strings.HasSuffix(strings.ToLower(c["algorithm"]), "-sess") {
+		ha1 = hexHash(ha1 + ":" + c["nonce"] + ":" + cnonce)
+	}
+	ha2 := hexHash(method + ":" + uri)
+
+	h := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, user, c["realm"], c["nonce"], uri)
+	if strings.Contains(c["qop"], "auth") {
+		ncs := fmt.Sprintf("%08x", nc)
+		h += fmt.Sprintf(`, qop=auth, nc=%s, cnonce="%s", response="%s"`, ncs, cnonce,
+			hexHash(ha1+":"+c["nonce"]+":"+ncs+":"+cnonce+":auth:"+ha2))
+	} else {
+		h += fmt.Sprintf(`, response="%s"`, hexHash(ha1+":"+c["nonce"]+":"+ha2))
+	}
+	if c["opaque"] != "" {
+		h += fmt.Sprintf(`, opaque="%s"`, c["opaque"])
+	}
+	if c["algorithm"] != "" {
+		h += ", algorithm=" + c["algorithm"]
+	}
+	return h
+}
+
+// request sends a request without a body and fails on any status but ok.
+func (s *WebDAVStorage) request(ctx context.Context, method, u string, header map[string]string, ok ...int) (*http.Response, error) {
+	resp, err := s.do(func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, u, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		return req, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	return nil, newStatusError(method+" "+u, resp)
+}
+
+// put uploads size bytes of f at off to u.
+func (s *WebDAVStorage) put(ctx context.Context, u string, f *os.File, off, size int64) error {
+	resp, err := s.do(func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, io.NewSectionReader(f, off, size))
+		if req != nil {
+			req.ContentLength = size
+		}
+		return req, err
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return newStatusError("PUT "+u, resp)
+	}
+	return nil
+}
+
+func (s *WebDAVStorage) fileURL(name string) string {
+	return strings.TrimSuffix(s.URL, "/") + "/" + url.PathEscape(name)
+}
+
+func (s *WebDAVStorage) Upload(ctx context.Context, localPath, name string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Learn the auth scheme with a cheap request rather than a rejected upload
+	resp, err := s.request(ctx, "PROPFIND", s.URL, map[string]string{"Depth": "0"}, http.StatusMultiStatus)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	chunkSize := s.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = webdavChunkSize
+	}
+	if uploads := nextcloudUploadsURL(s.URL); uploads != "" && fi.Size() > chunkSize {
+		return s.uploadChunked(ctx, uploads, f, fi.Size(), chunkSize, name)
+	}
+	return s.put(ctx, s.fileURL(name), f, 0, fi.Size())
+}
+
+// nextcloudUploadsURL returns the chunked upload root for a Nextcloud or
+// ownCloud files URL (.../remote.php/dav/files/USER/...), or "" if u is
+// not one.
+func nextcloudUploadsURL(u string) string {
+	before, after, ok := strings.Cut(u, "/remote.php/dav/files/")
+	if !ok {
+		return ""
+	}
+	user, _, _ := strings.Cut(after, "/")
+	return before + "/remote.php/dav/uploads/" + user
+}
+
+// uploadChunked uses Nextcloud's chunked upload: the chunks go into a
+// temporary upload collection, which is then moved into place as a whole.
+func (s *WebDAVStorage) uploadChunked(ctx context.Context, uploads string, f *os.File, size, chunkSize int64, name string) error {
+	b := make([]byte, 8)
+	rand.Read(b)
+	dir := uploads + "/pgtool-" + hex.EncodeToString(b)
+	resp, err := s.request(ctx, "MKCOL", dir, nil, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	dest := s.fileURL(name)
+	for off, n := int64(0), 1; off < size; off, n = off+chunkSize, n+1 {
+		if err = s.put(ctx, fmt.Sprintf("%s/%05d", dir, n), f, off, min(chunkSize, size-off)); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		resp, err = s.request(ctx, "MOVE", dir+"/.file", map[string]string{"Destination": dest, "Overwrite": "T"},
+			http.StatusCreated, http.StatusNoContent)
+		if err == nil {
+			resp.Body.Close()
+			return nil
+		}
+	}
+	if resp, err := s.request(context.WithoutCancel(ctx), http.MethodDelete, dir, nil, http.StatusNoContent); err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func (s *WebDAVStorage) Download(ctx context.Context, name, localPath string) error {
+	resp, err := s.request(ctx, http.MethodGet, s.fileURL(name), nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	out, err := os.Create(localPath)
+	if err != nil {
+		return ioError(err)
+	}
+	defer out.Close()
+	if _, err := copyContext(ctx, out, resp.Body); err != nil {
+		os.Remove(localPath)
+		return err
+	}
+	return ioError(out.Close())
+}
+
+func (s *WebDAVStorage) List(ctx context.Context) ([]string, error) {
+	resp, err := s.request(ctx, "PROPFIND", s.URL, map[string]string{"Depth": "1"}, http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ms struct {
+		Responses []struct {
+			Href string `xml:"href"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("PROPFIND %s: %w", s.URL, err)
+	}
+	var names []string
+	for _, r := range ms.Responses {
+		if strings.HasSuffix(r.Href, "/") { // the collection itself, or a subdirectory
+			continue
+		}
+		if name, err := url.PathUnescape(path.Base(r.Href)); err == nil {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (s *WebDAVStorage) Delete(ctx context.Context, name string) error {
+	resp, err := s.request(ctx, http.MethodDelete, s.fileURL(name), nil, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
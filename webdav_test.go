@@ -0,0 +1,62 @@
+package pgtool
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebDAVDigest(t *testing.T) {
+	sha256hex := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	tests := []struct {
+		name       string
+		challenges []string
+		wantErr    error
+	}{
+		{"sha-256", []string{`Digest realm="dav", nonce="n1", qop="auth", algorithm=SHA-256`}, nil},
+		{"sha-256 first", []string{
+			`Digest realm="dav", nonce="n1", qop="auth", algorithm=SHA-512-256`,
+			`Digest realm="dav", nonce="n1", qop="auth", algorithm=SHA-256`,
+			`Digest realm="dav", nonce="n1", qop="auth", algorithm=MD5`,
+		}, nil},
+		{"unsupported", []string{`Digest realm="dav", nonce="n1", qop="auth", algorithm=SHA-512-256`}, ErrAuthFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth := r.Header.Get("Authorization")
+				if !strings.HasPrefix(auth, "Digest ") {
+					for _, c := range tt.challenges {
+						w.Header().Add("WWW-Authenticate", c)
+					}
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				p := parseDigestChallenge(auth)
+				ha1 := sha256hex("backup:dav:secret")
+				ha2 := sha256hex(r.Method + ":" + r.URL.RequestURI())
+				want := sha256hex(ha1 + ":n1:" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+				if p["algorithm"] != "SHA-256" || p["response"] != want {
+					t.Errorf("Authorization: %s, want a SHA-256 response %s", auth, want)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+
+			s := &WebDAVStorage{URL: srv.URL + "/backups/", User: "backup", Password: "secret"}
+			if err := s.Delete(context.Background(), "app_2026-10-15_020000.dump.gz"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Delete: %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
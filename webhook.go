@@ -0,0 +1,96 @@
+package pgtool
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+)
+
+// webhookSignatureHeader carries the HMAC-SHA256 of the body, as
+// "sha256=<hex>", when the webhook has a secret.
+const webhookSignatureHeader = "X-Pgtool-Signature"
+
+// WebhookNotifier POSTs every run result to a URL. The body is the
+// RunResult as JSON, or the output of Template executed on it. With a
+// Secret, the body is signed so the receiver can check where it came from.
+type WebhookNotifier struct {
+	URL      string
+	Template *template.Template // nil sends the RunResult as JSON
+	Headers  map[string]string
+	Secret   string
+	Client   *http.Client
+}
+
+// webhookFuncs are available in webhook templates. json quotes a value
+// for the payload, so that error messages cannot break its syntax.
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// parseWebhookTemplate parses a webhook payload template, for example
+//
+//	{"text": {{json (printf "%s of %s: %s" .Op .Database .Status)}}, "bytes": {{.Bytes}}}
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	t, err := template.New("webhook").Funcs(webhookFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: webhook template: %w", ErrUsage, err)
+	}
+	return t, nil
+}
+
+func (n WebhookNotifier) Name() string { return "webhook" }
+
+func (n WebhookNotifier) Notify(ctx context.Context, r RunResult) error {
+	var body []byte
+	if n.Template == nil {
+		var err error
+		if body, err = json.Marshal(r); err != nil {
+			return err
+		}
+	} else {
+		var buf bytes.Buffer
+		if err := n.Template.Execute(&buf, r); err != nil {
+			return fmt.Errorf("webhook template: %w", err)
+		}
+		if !json.Valid(buf.Bytes()) {
+			return fmt.Errorf("webhook template produced invalid JSON: %s", buf.String())
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.Headers {
+		req.Header.Set(k, v)
+	}
+	if n.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.Secret))
+		mac.Write(body)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return newStatusError("webhook "+n.URL, resp)
+	}
+	return nil
+}
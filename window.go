@@ -0,0 +1,79 @@
+package pgtool
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Window is a daily time range, in local time, in which backups may start,
+// such as 22:00-06:00. A window whose end is before its start runs past
+// midnight.
+type Window struct {
+	Start, End time.Duration // since midnight
+}
+
+// ParseWindow parses a window given as HH:MM-HH:MM.
+func ParseWindow(s string) (*Window, error) {
+	var h1, m1, h2, m2 int
+	if _, err := fmt.Sscanf(s, "%d:%d-%d:%d", &h1, &m1, &h2, &m2); err != nil ||
+		h1 > 23 || h2 > 23 || m1 > 59 || m2 > 59 || min(h1, m1, h2, m2) < 0 {
+		return nil, fmt.Errorf("%w: invalid window '%s' (want HH:MM-HH:MM)", ErrUsage, s)
+	}
+	w := &Window{
+		Start: time.Duration(h1)*time.Hour + time.Duration(m1)*time.Minute,
+		End:   time.Duration(h2)*time.Hour + time.Duration(m2)*time.Minute,
+	}
+	if w.Start == w.End {
+		return nil, fmt.Errorf("%w: window '%s' is empty", ErrUsage, s)
+	}
+	return w, nil
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d",
+		int(w.Start.Hours()), int(w.Start.Minutes())%60, int(w.End.Hours()), int(w.End.Minutes())%60)
+}
+
+// at returns the time of day d on t's date.
+func at(t time.Time, d time.Duration) time.Time {
+	y, m, day := t.Date()
+	return time.Date(y, m, day, int(d.Hours()), int(d.Minutes())%60, 0, 0, t.Location())
+}
+
+// Contains reports whether the window is open at t.
+func (w Window) Contains(t time.Time) bool {
+	start, end := at(t, w.Start), at(t, w.End)
+	if w.Start < w.End {
+		return !t.Before(start) && t.Before(end)
+	}
+	return !t.Before(start) || t.Before(end)
+}
+
+// NextOpen returns when the window next opens after t.
+func (w Window) NextOpen(t time.Time) time.Time {
+	start := at(t, w.Start)
+	if !start.After(t) {
+		start = at(t.AddDate(0, 0, 1), w.Start)
+	}
+	return start
+}
+
+// checkWindow returns nil if cfg's backup may start now. Outside its
+// window, it waits for the window to open if cfg.WindowDefer is set, and
+// otherwise returns an ErrSkipped error.
+func (c Config) checkWindow(ctx context.Context, logger *log.Logger) error {
+	if c.Window == nil || c.Window.Contains(time.Now()) {
+		return nil
+	}
+	if !c.WindowDefer {
+		return fmt.Errorf("%w: window closed (%s)", ErrSkipped, c.Window)
+	}
+	next := c.Window.NextOpen(time.Now())
+	logger.Printf("INFO: Outside the backup window %s, waiting until %s", c.Window, next.Format(time.RFC3339))
+	if !sleepUntil(ctx, next) {
+		return ctx.Err()
+	}
+	return nil
+}
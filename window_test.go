@@ -0,0 +1,55 @@
+package pgtool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	for _, s := range []string{"22:00-06:00", "01:30-02:00", "00:00-23:59"} {
+		w, err := ParseWindow(s)
+		if err != nil {
+			t.Errorf("ParseWindow(%q): %v", s, err)
+		} else if w.String() != s {
+			t.Errorf("ParseWindow(%q).String() = %q", s, w)
+		}
+	}
+	for _, s := range []string{"", "22:00", "22-06", "24:00-06:00", "22:60-06:00", "-1:00-06:00", "06:00-06:00"} {
+		if _, err := ParseWindow(s); err == nil {
+			t.Errorf("ParseWindow(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	day := func(h, m int) time.Time { return time.Date(2026, 5, 1, h, m, 0, 0, time.Local) }
+	tests := []struct {
+		window   string
+		t        time.Time
+		open     bool
+		nextOpen time.Time
+	}{
+		{"01:00-05:00", day(0, 59), false, day(1, 0)},
+		{"01:00-05:00", day(1, 0), true, day(25, 0)},
+		{"01:00-05:00", day(4, 59), true, day(25, 0)},
+		{"01:00-05:00", day(5, 0), false, day(25, 0)},
+		{"22:00-06:00", day(21, 59), false, day(22, 0)},
+		{"22:00-06:00", day(22, 0), true, day(46, 0)},
+		{"22:00-06:00", day(23, 30), true, day(46, 0)},
+		{"22:00-06:00", day(3, 0), true, day(22, 0)},
+		{"22:00-06:00", day(6, 0), false, day(22, 0)},
+		{"22:00-06:00", day(12, 0), false, day(22, 0)},
+	}
+	for _, tt := range tests {
+		w, err := ParseWindow(tt.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Contains(tt.t); got != tt.open {
+			t.Errorf("%s.Contains(%s) = %v, want %v", tt.window, tt.t.Format("15:04"), got, tt.open)
+		}
+		if got := w.NextOpen(tt.t); !got.Equal(tt.nextOpen) {
+			t.Errorf("%s.NextOpen(%s) = %s, want %s", tt.window, tt.t.Format("15:04"), got, tt.nextOpen)
+		}
+	}
+}